@@ -0,0 +1,167 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+var (
+	backupFile string
+)
+
+type BackupOptions struct {
+	DryRun      bool
+	FailOnEmpty bool
+	File        string
+	// Logger logs the release versions archived. Defaults to the standard logger.
+	Logger              common.Logger
+	Selector            string
+	StorageType         string
+	TillerLabel         string
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (backupOptions BackupOptions) logger() common.Logger {
+	return common.LoggerOrDefault(backupOptions.Logger)
+}
+
+func newBackupCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "backup Helm v2 release data to an archive",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: runBackup,
+	}
+
+	flags := cmd.Flags()
+	settings.AddFlags(flags)
+	settings.AddFailOnEmptyFlag(flags)
+
+	flags.StringVar(&backupFile, "file", "helm-v2-releases.tar.gz", "path of the archive file the release data is written to")
+
+	return cmd
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	backupOptions := BackupOptions{
+		DryRun:              settings.DryRun,
+		FailOnEmpty:         settings.FailOnEmpty,
+		File:                backupFile,
+		Selector:            settings.Selector,
+		StorageType:         settings.ReleaseStorage,
+		TillerLabel:         settings.Label,
+		TillerNamespace:     settings.TillerNamespace,
+		TillerOutCluster:    settings.TillerOutCluster,
+		TillerSQLConnection: settings.TillerSQLConnection,
+		TillerStorageDir:    settings.TillerStorageDir,
+	}
+	kubeConfig := settings.KubeConfig()
+
+	ctx, cancel := settings.WithTimeout(cmd.Context())
+	defer cancel()
+	if err := settings.CheckTillerNamespace(ctx); err != nil {
+		return err
+	}
+
+	return Backup(ctx, backupOptions, kubeConfig)
+}
+
+// Backup writes all release versions stored for the Tiller namespace and label to a release archive.
+// The archive is written to a temporary file which is renamed once complete, so that a failure
+// does not leave a partial archive behind.
+func Backup(ctx context.Context, backupOptions BackupOptions, kubeConfig common.KubeConfig) error {
+	if err := v2.ValidateSelector(backupOptions.Selector); err != nil {
+		return err
+	}
+	if backupOptions.File == "" {
+		return errors.New("file of the archive has to be defined")
+	}
+	logger := backupOptions.logger()
+	if backupOptions.DryRun {
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         backupOptions.Selector,
+		TillerNamespace:  backupOptions.TillerNamespace,
+		TillerLabel:      backupOptions.TillerLabel,
+		TillerOutCluster: backupOptions.TillerOutCluster,
+		StorageType:      backupOptions.StorageType,
+		SQLConnection:    backupOptions.TillerSQLConnection,
+		StorageDir:       backupOptions.TillerStorageDir,
+		Logger:           logger,
+	}
+	records, err := v2.GetReleaseRecords(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		if backupOptions.FailOnEmpty {
+			return fmt.Errorf("%w: [Helm 2] no deployed releases for namespace: %s, owner: %s. Nothing was backed up", common.ErrNothingFound, backupOptions.TillerNamespace, backupOptions.TillerLabel)
+		}
+		logger.Infof("[Helm 2] no deployed releases for namespace: %s, owner: %s. Nothing was backed up.\n", backupOptions.TillerNamespace, backupOptions.TillerLabel)
+		return nil
+	}
+	for _, record := range records {
+		logger.Infof("[Helm 2] ReleaseVersion \"%s\" will be archived to \"%s\".\n", record.Name, backupOptions.File)
+	}
+	if backupOptions.DryRun {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(backupOptions.File), ".helm-2to3-backup-")
+	if err != nil {
+		return fmt.Errorf("Failed to create archive \"%s\" due to the following error: %s", backupOptions.File, err)
+	}
+	defer os.Remove(file.Name())
+	err = v2.WriteArchive(file, records)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), backupOptions.File)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write archive \"%s\" due to the following error: %s", backupOptions.File, err)
+	}
+
+	logger.Infof("[Helm 2] %d release versions archived to \"%s\".\n", len(records), backupOptions.File)
+	return nil
+}
@@ -17,38 +17,181 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gosuri/uitable"
 	"github.com/spf13/cobra"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
 
 	"github.com/helm/helm-2to3/pkg/common"
+	metrics "github.com/helm/helm-2to3/pkg/metrics"
 	utils "github.com/helm/helm-2to3/pkg/utils"
 	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
 )
 
 var (
-	configCleanup    bool
-	releaseName      string
-	releaseCleanup   bool
-	skipConfirmation bool
-	tillerCleanup    bool
+	backupDir            string
+	configCleanup        bool
+	configCleanupScopes  []string
+	confirmDefault       string
+	confirmFromStdin     bool
+	confirmName          bool
+	confirmTimeout       time.Duration
+	convertedOnly        bool
+	deleteCorrupt        bool
+	deleteBatchInterval  time.Duration
+	deleteBatchSize      int
+	failFast             bool
+	followSymlinks       bool
+	forceCleanup         bool
+	includeDeleted       bool
+	keepVersions         int
+	releaseNames         []string
+	releaseNamespace     string
+	releaseCleanup       bool
+	reportFileCleanup    string
+	skipConfirmation     bool
+	tillerAllNamespaces  bool
+	tillerCleanup        bool
+	tillerDeploymentName string
+	tillerRBACCleanup    bool
+	tillerWait           bool
+	tillerWaitTimeout    time.Duration
+	verbose              bool
 )
 
+// summaryReleaseNames is the number of release names listed by the summary of the cleanup of all
+// releases, unless verbose
+const summaryReleaseNames = 10
+
 type CleanupOptions struct {
-	ConfigCleanup    bool
-	DryRun           bool
-	ReleaseName      string
-	ReleaseCleanup   bool
-	SkipConfirmation bool
-	StorageType      string
-	TillerCleanup    bool
-	TillerLabel      string
-	TillerNamespace  string
-	TillerOutCluster bool
+	BackupDir     string
+	ConfigCleanup bool
+	// ConfigCleanupScopes are the scopes of the configuration removed by configuration cleanup, e.g.
+	// only the cache. Defaults to all the configuration, i.e. the Helm v2 home folder.
+	ConfigCleanupScopes []string
+	// ConfirmDefault is the answer applied when no answer is given to the confirmation within the timeout
+	ConfirmDefault   bool
+	ConfirmFromStdin bool
+	ConfirmName      bool
+	// ConfirmTimeout is how long the answer to the confirmation is waited for. Zero waits for it forever.
+	ConfirmTimeout time.Duration
+	ConvertedOnly  bool
+	// DeleteBatchSize is the number of release versions deleted per batch when the releases are cleaned up in bulk,
+	// and DeleteBatchInterval the delay between batches
+	DeleteBatchInterval time.Duration
+	DeleteBatchSize     int
+	// DeleteCorrupt deletes the Helm v2 storage objects whose release can't be decoded, of the named
+	// releases or of all releases, and nothing else. It is a singular operation.
+	DeleteCorrupt bool
+	DryRun        bool
+	// Exclude are the names of the releases skipped by the cleanup of all releases
+	Exclude     []string
+	FailFast    bool
+	FailOnEmpty bool
+	// FollowSymlinks removes the targets of the configuration folders which are symbolic links, not only the links
+	FollowSymlinks bool
+	// Force removes the Helm v2 home folder even when it doesn't look like one
+	Force          bool
+	IncludeDeleted bool
+	KeepVersions   int
+	// Logger logs the progress of the cleanup. Defaults to the standard logger.
+	Logger common.Logger
+	// Metrics records the Helm v2 release versions deleted, and the releases which failed to be deleted
+	Metrics metrics.Recorder
+	// NamePattern is the glob pattern, or NameRegex the regular expression, the names of the releases
+	// removed have to match
+	NamePattern string
+	NameRegex   string
+	// ObjectDeletion is the propagation policy and grace period of the deletions of the Helm v2 storage
+	// objects and of the Tiller objects
+	ObjectDeletion v2.ObjectDeletion
+	// Out is where the warning and confirmation prompts are written to. Defaults to the standard output.
+	Out io.Writer
+	// Progress is notified after each batch of release versions deleted when the releases are cleaned up in bulk
+	Progress             common.Progress
+	ReleaseNames         []string
+	ReleaseNamespace     string
+	ReleaseCleanup       bool
+	Selector             string
+	SkipConfirmation     bool
+	StorageType          string
+	TillerAllNamespaces  bool
+	TillerCleanup        bool
+	TillerDeploymentName string
+	TillerLabel          string
+	TillerNamespace      string
+	TillerOutCluster     bool
+	TillerRBACCleanup    bool
+	TillerSQLConnection  string
+	TillerStorageDir     string
+	// Tillerless is set when the releases are stored by Tillerless Helm v2. Tiller cleanup is skipped
+	// with a note, as there is no Tiller in the cluster to remove.
+	Tillerless bool
+	// TillerWait waits until the Tiller workloads and their pods are removed, for up to TillerWaitTimeout
+	TillerWait        bool
+	TillerWaitTimeout time.Duration
+	// Verbose lists all the releases in the summary of the cleanup of all releases, not only the first ones
+	Verbose bool
+}
+
+// logger returns the logger of the cleanup: the logger of the options, or the default logger when not set
+func (cleanupOptions CleanupOptions) logger() common.Logger {
+	return common.LoggerOrDefault(cleanupOptions.Logger)
+}
+
+// selectsByName returns true if the releases removed are selected by a name pattern or regex
+func (cleanupOptions CleanupOptions) selectsByName() bool {
+	return cleanupOptions.NamePattern != "" || cleanupOptions.NameRegex != ""
+}
+
+// configScopes returns the scopes of the configuration cleanup, all the configuration when not set
+func (cleanupOptions CleanupOptions) configScopes() []string {
+	if len(cleanupOptions.ConfigCleanupScopes) == 0 {
+		return []string{v2.ConfigScopeAll}
+	}
+	return cleanupOptions.ConfigCleanupScopes
+}
+
+// allConfigScopes returns true if the configuration cleanup removes the whole Helm v2 home folder
+func (cleanupOptions CleanupOptions) allConfigScopes() bool {
+	for _, scope := range cleanupOptions.configScopes() {
+		if scope == v2.ConfigScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// retrieveOptions returns the options the Helm v2 release versions of the cleanup are retrieved with
+func (cleanupOptions CleanupOptions) retrieveOptions() v2.RetrieveOptions {
+	return v2.RetrieveOptions{
+		Selector:         cleanupOptions.Selector,
+		TillerNamespace:  cleanupOptions.TillerNamespace,
+		TillerLabel:      cleanupOptions.TillerLabel,
+		TillerOutCluster: cleanupOptions.TillerOutCluster,
+		StorageType:      cleanupOptions.StorageType,
+		SQLConnection:    cleanupOptions.TillerSQLConnection,
+		StorageDir:       cleanupOptions.TillerStorageDir,
+		Logger:           cleanupOptions.logger(),
+	}
+}
+
+// output returns where the warning and confirmation prompts are written to
+func (cleanupOptions CleanupOptions) output() io.Writer {
+	if cleanupOptions.Out == nil {
+		return os.Stdout
+	}
+	return cleanupOptions.Out
 }
 
 func newCleanupCmd(out io.Writer) *cobra.Command {
@@ -58,176 +201,1417 @@ func newCleanupCmd(out io.Writer) *cobra.Command {
 		Args: func(cmd *cobra.Command, args []string) error {
 			return nil
 		},
-		RunE: runCleanup,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateCleanupFlags()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCleanup(cmd.Context(), out)
+		},
 	}
 
 	flags := cmd.Flags()
 	settings.AddFlags(flags)
+	settings.AddOutputFlag(flags)
+	settings.AddFailOnEmptyFlag(flags)
+	settings.AddProgressFlag(flags)
+	settings.AddRetryFlags(flags)
+	settings.AddAuditLogFlag(flags)
+	settings.AddV2HomeFlag(flags)
+	settings.AddV3StorageFlags(flags)
+	addExcludeFlags(flags, "by the cleanup of all releases")
+	addNamePatternFlags(flags, "removed")
+	addMetricsFlags(flags)
+	addDeletionFlags(flags, "the Helm v2 storage objects and the Tiller objects", "the Tiller workloads are deleted in the background, or in the foreground with the 'wait' flag, and the other objects with the policy of the API server")
 
+	flags.StringVar(&backupDir, "backup-dir", "", "if set, the release versions to remove are backed up to this folder before any of them is removed. The cleanup is aborted if the backup fails")
 	flags.BoolVar(&configCleanup, "config-cleanup", false, "if set, configuration cleanup performed")
-	flags.StringVar(&releaseName, "name", "", "the release name. When it is specified, the named release and its versions will be removed only. Should not be used with other cleanup operations")
+	flags.StringSliceVar(&configCleanupScopes, "config-cleanup-scope", []string{v2.ConfigScopeAll}, "the comma-separated list of the parts of the Helm v2 configuration removed by configuration cleanup: 'cache', 'plugins', 'repositories', 'starters' or 'all' for the whole Helm v2 home folder")
+	flags.StringVar(&confirmDefault, "confirm-default", "no", "answer applied when no answer is given to the confirmation within the 'confirm-timeout'. It can be 'yes' or 'no'. A typed confirmation is never confirmed by the timeout")
+	flags.BoolVar(&confirmFromStdin, "confirm-from-stdin", false, "if set, the confirmation is read from the standard input when it is not a terminal, e.g. a piped \"y\"")
+	flags.BoolVar(&confirmName, "confirm-name", false, "if set, the names of the releases to remove have to be typed to confirm the cleanup. It is the default when the 'name' flag is set and the standard input is a terminal")
+	flags.DurationVar(&confirmTimeout, "confirm-timeout", 0, "how long the answer to the confirmation is waited for, e.g. '60s', before the 'confirm-default' answer is applied. Use 0 to wait forever")
+	flags.BoolVar(&convertedOnly, "converted-only", false, "if set, only the releases which exist in Helm v3 storage are removed. Releases not converted are skipped")
+	flags.DurationVar(&deleteBatchInterval, "delete-batch-interval", 0, "delay between the batches of release versions deleted when the releases are cleaned up in bulk, i.e. without the name flag, e.g. '2s', to spread the load on the Kubernetes API")
+	flags.BoolVar(&deleteCorrupt, "delete-corrupt", false, "if set, only the Helm v2 storage objects whose release can't be decoded are deleted, of the releases of the 'name' flag or of all releases, after confirmation. Should not be used with other cleanup operations")
+	flags.IntVar(&deleteBatchSize, "delete-batch-size", 100, "number of release versions deleted per batch when the releases are cleaned up in bulk, i.e. without the name flag. The versions of a release in a batch are deleted in one request from the ConfigMaps or Secrets storage when allowed. Use 0 to delete all release versions in one batch")
+	flags.BoolVar(&failFast, "fail-fast", false, "if set, cleanup of the named releases stops at the first release which fails to be removed")
+	flags.BoolVar(&followSymlinks, "follow-symlinks", false, "if set, configuration cleanup deletes the targets of the Helm v2 home folder and its folders which are symbolic links. By default, only the links are deleted, and the folders of a home folder which is a symbolic link are skipped")
+	flags.BoolVar(&forceCleanup, "force", false, "if set, configuration cleanup removes the Helm v2 home folder even when it doesn't look like one, i.e. it has no 'repository' or 'plugins' folder")
+	flags.BoolVar(&includeDeleted, "include-deleted", false, "if set, the releases deleted with their history kept are removed as part of release cleanup. By default, they are skipped unless named with the 'name' flag")
+	flags.IntVar(&keepVersions, "keep-versions", 0, "number of the latest versions of each named release which are kept, the older versions being removed. Use 0 to remove all versions. Can only be used with the 'name' flag")
+	flags.StringSliceVar(&releaseNames, "name", []string{}, "the release name or a comma-separated list of release names. When it is specified, the named releases and their versions will be removed only. Should not be used with other cleanup operations")
+	flags.StringVar(&releaseNamespace, "release-namespace", "", "if set, only the releases deployed into this namespace and their versions are removed. Should not be used with other cleanup operations")
 	flags.BoolVar(&releaseCleanup, "release-cleanup", false, "if set, release data cleanup performed")
+	flags.StringVar(&reportFileCleanup, "report", "", "path of the file the report of the cleanup is written to, in JSON or YAML as per its extension (.json, .yaml or .yml)")
 	flags.BoolVar(&skipConfirmation, "skip-confirmation", false, "if set, skips confirmation message before performing cleanup")
+	flags.BoolVar(&tillerAllNamespaces, "tiller-all-namespaces", false, "if set, the Tiller instances found in all namespaces are removed, each after its own confirmation. Can only be used with the 'tiller-cleanup' flag")
 	flags.BoolVar(&tillerCleanup, "tiller-cleanup", false, "if set, Tiller cleanup performed")
+	flags.StringVar(&tillerDeploymentName, "tiller-deployment-name", "tiller-deploy", "name of the Tiller deployment. Tiller workloads with the 'app=helm,name=tiller' labels are also removed as part of Tiller cleanup")
+	flags.BoolVar(&tillerRBACCleanup, "tiller-rbac-cleanup", true, "if set, the Tiller service account and the role bindings which only bind it are removed as part of Tiller cleanup")
+	flags.BoolVar(&tillerWait, "wait", false, "if set, Tiller cleanup waits until the Tiller Deployment, its ReplicaSets and pods are actually removed, not only until their deletion is accepted")
+	flags.BoolVar(&verbose, "verbose", false, "if set, the summary of the cleanup of all releases shown before the confirmation lists all the releases to remove, not only the first 10")
+	flags.DurationVar(&tillerWaitTimeout, "wait-timeout", 5*time.Minute, "how long Tiller cleanup waits for Tiller to be removed with the 'wait' flag, before failing with the objects still present. Use 0 to wait with no limit")
 
 	return cmd
 }
 
-func runCleanup(cmd *cobra.Command, args []string) error {
+// validateCleanupFlags checks the flags of the cleanup command and their combinations, before the
+// cleanup is planned
+func validateCleanupFlags() error {
+	if err := validateReportFile(reportFileCleanup); err != nil {
+		return err
+	}
+	if err := validateMetricsFlags(); err != nil {
+		return err
+	}
+	if err := validateDeletionFlags(); err != nil {
+		return err
+	}
+	if tillerWait && deletePropagation == v2.PropagationOrphan {
+		return errors.New("wait flag cannot be used with the 'orphan' delete-propagation flag, as the pods of the Tiller workloads are left running")
+	}
+	if err := v2.ValidateConfigScopes(configCleanupScopes); err != nil {
+		return err
+	}
+	if _, err := parseConfirmDefault(); err != nil {
+		return err
+	}
+	if deleteBatchSize < 0 {
+		return errors.New("delete-batch-size flag can not be negative")
+	}
+	if deleteBatchInterval < 0 {
+		return errors.New("delete-batch-interval flag can not be negative")
+	}
+	if tillerWaitTimeout < 0 {
+		return errors.New("wait-timeout flag can not be negative. Use 0 to wait with no limit")
+	}
+	exclude, err := excludedReleases()
+	if err != nil {
+		return err
+	}
+	return validateCleanupOperations(CleanupOptions{
+		ConfigCleanup:       configCleanup,
+		ConfirmName:         confirmName,
+		DeleteCorrupt:       deleteCorrupt,
+		Exclude:             exclude,
+		KeepVersions:        keepVersions,
+		NamePattern:         namePattern,
+		NameRegex:           nameRegex,
+		ReleaseCleanup:      releaseCleanup,
+		ReleaseNames:        releaseNames,
+		ReleaseNamespace:    releaseNamespace,
+		TillerAllNamespaces: tillerAllNamespaces,
+		TillerCleanup:       tillerCleanup,
+		TillerOutCluster:    settings.TillerOutCluster,
+		Tillerless:          settings.Tillerless,
+		TillerWait:          tillerWait,
+	})
+}
+
+func runCleanup(ctx context.Context, out io.Writer) error {
+	ctx, cancel := settings.WithTimeout(ctx)
+	defer cancel()
+	confirmDefaultAnswer, err := parseConfirmDefault()
+	if err != nil {
+		return err
+	}
+	exclude, err := excludedReleases()
+	if err != nil {
+		return err
+	}
+	if err := settings.SetV3Storage(); err != nil {
+		return err
+	}
+	settings.SetV2Home()
+	ctx = common.WithRetryOptions(ctx, settings.RetryOptions())
+	// The Tiller namespace is checked before the confirmation, unless it is not cleaned up: with the
+	// Tillers of all namespaces, or the configuration only
+	if !tillerAllNamespaces && !(configCleanup && !releaseCleanup && !tillerCleanup && len(releaseNames) == 0) {
+		if err := settings.CheckTillerNamespace(ctx); err != nil {
+			return err
+		}
+	}
+
+	registry := newMetrics("cleanup")
 	cleanupOptions := CleanupOptions{
-		ConfigCleanup:    configCleanup,
-		DryRun:           settings.DryRun,
-		ReleaseCleanup:   releaseCleanup,
-		ReleaseName:      releaseName,
-		SkipConfirmation: skipConfirmation,
-		StorageType:      settings.ReleaseStorage,
-		TillerCleanup:    tillerCleanup,
-		TillerLabel:      settings.Label,
-		TillerNamespace:  settings.TillerNamespace,
-		TillerOutCluster: settings.TillerOutCluster,
+		BackupDir:            backupDir,
+		ConfigCleanup:        configCleanup,
+		ConfigCleanupScopes:  configCleanupScopes,
+		ConfirmDefault:       confirmDefaultAnswer,
+		ConfirmFromStdin:     confirmFromStdin,
+		ConfirmName:          confirmName,
+		ConfirmTimeout:       confirmTimeout,
+		ConvertedOnly:        convertedOnly,
+		DeleteBatchInterval:  deleteBatchInterval,
+		DeleteBatchSize:      deleteBatchSize,
+		DeleteCorrupt:        deleteCorrupt,
+		DryRun:               settings.DryRun,
+		Exclude:              exclude,
+		FailFast:             failFast,
+		FailOnEmpty:          settings.FailOnEmpty,
+		FollowSymlinks:       followSymlinks,
+		Force:                forceCleanup,
+		IncludeDeleted:       includeDeleted,
+		KeepVersions:         keepVersions,
+		Metrics:              registry,
+		NamePattern:          namePattern,
+		NameRegex:            nameRegex,
+		ObjectDeletion:       objectDeletion(),
+		Out:                  settings.ProgressWriter(out),
+		Progress:             newProgress("release versions processed"),
+		ReleaseCleanup:       releaseCleanup,
+		ReleaseNames:         releaseNames,
+		ReleaseNamespace:     releaseNamespace,
+		Selector:             settings.Selector,
+		SkipConfirmation:     skipConfirmation,
+		StorageType:          settings.ReleaseStorage,
+		TillerAllNamespaces:  tillerAllNamespaces,
+		TillerCleanup:        tillerCleanup,
+		TillerDeploymentName: tillerDeploymentName,
+		TillerLabel:          settings.Label,
+		TillerNamespace:      settings.TillerNamespace,
+		TillerOutCluster:     settings.TillerOutCluster,
+		TillerRBACCleanup:    tillerRBACCleanup,
+		TillerSQLConnection:  settings.TillerSQLConnection,
+		TillerStorageDir:     settings.TillerStorageDir,
+		Tillerless:           settings.Tillerless,
+		TillerWait:           tillerWait,
+		TillerWaitTimeout:    tillerWaitTimeout,
+		Verbose:              verbose,
 	}
+	cleanupOptions.Progress = withMetrics(cleanupOptions.Progress, registry, cleanupOptions.logger())
+
+	kubeConfig := settings.KubeConfig()
 
-	kubeConfig := common.KubeConfig{
-		Context: settings.KubeContext,
-		File:    settings.KubeConfigFile,
+	// In dry-run, the plan is the result, as nothing is removed
+	if settings.DryRun && settings.Output != common.OutputTable {
+		plan, err := PlanCleanup(ctx, cleanupOptions, kubeConfig)
+		if err != nil {
+			return err
+		}
+		return common.PrintOutput(out, settings.Output, plan)
+	}
+
+	report := newReport("cleanup", cleanupOptions.DryRun)
+	result, err := Cleanup(ctx, cleanupOptions, kubeConfig)
+	writeMetrics(ctx, registry, cleanupOptions.logger())
+	if printErr := common.PrintOutput(out, settings.Output, result); printErr != nil && err == nil {
+		err = printErr
+	}
+	if reportFileCleanup == "" {
+		return err
+	}
+	addCleanupReleaseReports(report, result)
+	return finishReport(reportFileCleanup, report, err, cleanupOptions.logger())
+}
+
+// addCleanupReleaseReports adds the releases deleted, or which failed to be deleted, by the cleanup to the report
+func addCleanupReleaseReports(report *Report, result *CleanupResult) {
+	names := append([]string{}, result.DeletedReleases...)
+	for releaseName := range result.FailedReleases {
+		if _, ok := result.DeletedVersions[releaseName]; !ok {
+			names = append(names, releaseName)
+		}
+	}
+	sort.Strings(names)
+	for _, releaseName := range names {
+		releaseReport := ReleaseReport{
+			Name:     releaseName,
+			Result:   ReportDeleted,
+			Versions: result.DeletedVersions[releaseName],
+		}
+		if releaseReport.Versions == nil {
+			releaseReport.Versions = []int32{}
+		}
+		if duration, ok := result.durations[releaseName]; ok {
+			releaseReport.Duration = formatDuration(duration)
+		}
+		if err, ok := result.FailedReleases[releaseName]; ok {
+			releaseReport.Result, releaseReport.Error = ReportFailed, err
+		}
+		if result.hasNoVersions(releaseName) {
+			releaseReport.Reason = "no versions"
+		}
+		report.Releases = append(report.Releases, releaseReport)
+	}
+	for _, releaseName := range result.ExcludedReleases {
+		report.Releases = append(report.Releases, ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "excluded"})
+	}
+	for _, releaseName := range result.NotStartedReleases {
+		report.Releases = append(report.Releases, ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "not started"})
+	}
+}
+
+// CleanupPlan describes the Helm v2 data that a cleanup would remove
+type CleanupPlan struct {
+	Releases          []ReleaseCleanupPlan `json:"releases"`
+	TillerRemoval     bool                 `json:"tillerRemoval"`
+	TillerNamespace   string               `json:"tillerNamespace,omitempty"`
+	TillerNamespaces  []string             `json:"tillerNamespaces,omitempty"`
+	HomeFolderRemoval bool                 `json:"homeFolderRemoval"`
+	HomeFolder        string               `json:"homeFolder,omitempty"`
+	// ConfigPaths are the paths removed by the configuration cleanup of some of its scopes only
+	ConfigPaths []string `json:"configPaths,omitempty"`
+	// ExcludedReleases are the releases skipped as excluded
+	ExcludedReleases []string `json:"excludedReleases,omitempty"`
+	// CorruptRecords are the storage objects which can't be decoded that the 'delete-corrupt' flag deletes
+	CorruptRecords []v2.CorruptRecord `json:"corruptRecords,omitempty"`
+}
+
+// ReleaseCleanupPlan describes the versions of a release that a cleanup would remove
+type ReleaseCleanupPlan struct {
+	Name     string  `json:"name"`
+	Versions []int32 `json:"versions"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// PlanCleanup returns the Helm v2 data that Cleanup would remove for the cleanup options,
+// without removing anything.
+func PlanCleanup(ctx context.Context, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) (*CleanupPlan, error) {
+	if err := v2.ValidateSelector(cleanupOptions.Selector); err != nil {
+		return nil, err
 	}
+	if err := setCleanupOperations(&cleanupOptions); err != nil {
+		return nil, err
+	}
+
+	plan := &CleanupPlan{
+		Releases: []ReleaseCleanupPlan{},
+	}
+	if cleanupOptions.DeleteCorrupt {
+		records, err := getCorruptRecords(ctx, cleanupOptions, kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		plan.CorruptRecords = records
+		return plan, nil
+	}
+	if cleanupOptions.ReleaseCleanup {
+		retrieveOptions := cleanupOptions.retrieveOptions()
+		if len(cleanupOptions.ReleaseNames) == 0 {
+			v2Releases, err := v2.GetAllReleaseVersions(ctx, retrieveOptions, kubeConfig)
+			if err != nil {
+				return nil, err
+			}
+			v2Releases, plan.ExcludedReleases = excludeReleaseVersions(v2Releases, cleanupOptions.Exclude)
+			logExcludedReleases(cleanupOptions.logger(), cleanupOptions.Exclude, plan.ExcludedReleases)
+			v2Releases, err = filterCleanupReleases(v2Releases, cleanupOptions, kubeConfig)
+			if err != nil {
+				return nil, err
+			}
+			names, versions := groupReleaseVersions(v2Releases)
+			for _, name := range names {
+				plan.Releases = append(plan.Releases, ReleaseCleanupPlan{Name: name, Versions: versions[name]})
+			}
+		} else {
+			for _, releaseName := range cleanupOptions.ReleaseNames {
+				releasePlan := ReleaseCleanupPlan{Name: releaseName, Versions: []int32{}}
+				retrieveOptions.ReleaseName = releaseName
+				v2Releases, err := v2.GetReleaseVersions(ctx, retrieveOptions, kubeConfig)
+				if err != nil {
+					releasePlan.Error = strings.TrimSpace(err.Error())
+				}
+				v2Releases, err = filterCleanupReleases(v2Releases, cleanupOptions, kubeConfig)
+				if err != nil {
+					return nil, err
+				}
+				v2Releases = keepLatestVersions(releaseName, v2Releases, cleanupOptions.KeepVersions, cleanupOptions.logger())
+				for _, v2Release := range v2Releases {
+					releasePlan.Versions = append(releasePlan.Versions, v2Release.Version)
+				}
+				plan.Releases = append(plan.Releases, releasePlan)
+			}
+		}
+	}
+	if cleanupOptions.TillerAllNamespaces {
+		namespaces, err := findTillerNamespaces(ctx, kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		plan.TillerRemoval = len(namespaces) > 0
+		plan.TillerNamespaces = namespaces
+	} else if !cleanupOptions.TillerOutCluster && cleanupOptions.TillerCleanup {
+		plan.TillerRemoval = true
+		plan.TillerNamespace = cleanupOptions.TillerNamespace
+	}
+	if cleanupOptions.ConfigCleanup {
+		if cleanupOptions.allConfigScopes() {
+			plan.HomeFolderRemoval = true
+			plan.HomeFolder = v2.HomeDir()
+		} else {
+			plan.ConfigPaths = v2.ConfigScopePaths(cleanupOptions.configScopes())
+		}
+	}
+	return plan, nil
+}
+
+// CleanupResult describes the Helm v2 data removed by a cleanup
+type CleanupResult struct {
+	DeletedReleases         []string           `json:"deletedReleases"`
+	DeletedVersions         map[string][]int32 `json:"deletedVersions"`
+	TillerRemoved           bool               `json:"tillerRemoved"`
+	RemovedTillerNamespaces []string           `json:"removedTillerNamespaces,omitempty"`
+	HomeFolderRemoved       bool               `json:"homeFolderRemoved"`
+	// RemovedConfigPaths are the paths removed by the configuration cleanup of some of its scopes only
+	RemovedConfigPaths []string `json:"removedConfigPaths,omitempty"`
+	// FailedReleases holds the error of each release which failed to be deleted
+	FailedReleases map[string]string `json:"failedReleases,omitempty"`
+	// NoVersionsReleases are the releases of the 'name' flag which failed as no release versions of
+	// theirs were found in Helm v2 storage. They are in the failed releases also.
+	NoVersionsReleases []string `json:"noVersionsReleases,omitempty"`
+	// RemainingVersions holds the versions of the releases which failed to be deleted that are left in storage
+	RemainingVersions map[string][]int32 `json:"remainingVersions,omitempty"`
+	// ExcludedReleases are the releases skipped as excluded
+	ExcludedReleases []string `json:"excludedReleases,omitempty"`
+	// NotStartedReleases are the releases left in storage as the cleanup timed out or was interrupted
+	// before their deletion started
+	NotStartedReleases []string `json:"notStartedReleases,omitempty"`
+	// DeletedCorruptRecords are the storage objects which can't be decoded deleted by the 'delete-corrupt'
+	// flag. It is only set by that cleanup.
+	DeletedCorruptRecords []v2.CorruptRecord `json:"deletedCorruptRecords,omitempty"`
+	// Duration is the time the cleanup took once confirmed. It is not set when nothing was cleaned up
+	// for lack of confirmation, nor in dry-run.
+	Duration string `json:"duration,omitempty"`
 
-	return Cleanup(cleanupOptions, kubeConfig)
+	// durations holds the time taken to delete each release, when deleted on its own
+	durations map[string]time.Duration
+	// metrics records the release versions deleted as they are added
+	metrics metrics.Recorder
+}
+
+// hasNoVersions returns true if no release versions of the release were found in Helm v2 storage
+func (result *CleanupResult) hasNoVersions(releaseName string) bool {
+	for _, name := range result.NoVersionsReleases {
+		if name == releaseName {
+			return true
+		}
+	}
+	return false
+}
+
+func (result *CleanupResult) addDeletedVersions(releaseName string, versions []int32) {
+	if len(versions) == 0 {
+		return
+	}
+	metrics.Add(result.metrics, metrics.VersionsDeleted, float64(len(versions)))
+	if _, ok := result.DeletedVersions[releaseName]; !ok {
+		result.DeletedReleases = append(result.DeletedReleases, releaseName)
+		sort.Strings(result.DeletedReleases)
+	}
+	result.DeletedVersions[releaseName] = append(result.DeletedVersions[releaseName], versions...)
+	sortVersions(result.DeletedVersions[releaseName])
+}
+
+// addRemainingVersions adds the release versions left in storage by the deletion which failed with the error
+func (result *CleanupResult) addRemainingVersions(err error) {
+	var deleteErr *v2.DeleteError
+	if !errors.As(err, &deleteErr) {
+		return
+	}
+	for releaseName, versions := range deleteErr.Remaining {
+		result.RemainingVersions[releaseName] = append(result.RemainingVersions[releaseName], versions...)
+		sortVersions(result.RemainingVersions[releaseName])
+	}
+}
+
+// addBulkFailure records the releases of the bulk deletion which failed: as the releases are deleted
+// in the order of their names, the first release with versions remaining is the one which failed, and
+// the ones after it were not started. When the cleanup timed out or was interrupted, the releases
+// with no versions deleted were not started, and the ones with versions remaining are not counted as
+// deleted. The error is returned, worded as per the cause, out of the total of releases in the scope
+// of the deletion.
+func (result *CleanupResult) addBulkFailure(ctx context.Context, err error, total int) error {
+	remaining := []string{}
+	for releaseName := range result.RemainingVersions {
+		remaining = append(remaining, releaseName)
+	}
+	sort.Strings(remaining)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		completed := len(result.DeletedReleases)
+		for _, releaseName := range remaining {
+			if _, ok := result.DeletedVersions[releaseName]; ok {
+				result.FailedReleases[releaseName] = err.Error()
+				completed--
+			} else {
+				result.NotStartedReleases = append(result.NotStartedReleases, releaseName)
+			}
+		}
+		return fmt.Errorf("[Helm 2] cleanup stopped after %d of %d releases: %w", completed, total, err)
+	}
+	if len(remaining) > 0 {
+		result.FailedReleases[remaining[0]] = err.Error()
+		result.NotStartedReleases = append(result.NotStartedReleases, remaining[1:]...)
+	}
+	return err
+}
+
+// PrintTable prints the summary of what the cleanup removed, and of the release versions left in
+// storage by the releases which failed to be deleted
+func (result *CleanupResult) PrintTable(out io.Writer) error {
+	if result.Duration == "" {
+		return nil
+	}
+	table := uitable.New()
+	table.MaxColWidth = 100
+	table.Wrap = true
+	if result.DeletedCorruptRecords != nil {
+		table.AddRow("Corrupt release records deleted:", len(result.DeletedCorruptRecords))
+		for _, record := range result.DeletedCorruptRecords {
+			table.AddRow("", record.String())
+		}
+		table.AddRow("Elapsed time:", result.Duration)
+		_, err := fmt.Fprintln(out, table)
+		return err
+	}
+	versions := 0
+	for _, releaseVersions := range result.DeletedVersions {
+		versions += len(releaseVersions)
+	}
+	table.AddRow("Releases deleted:", len(result.DeletedReleases))
+	table.AddRow("Release versions deleted:", versions)
+	if len(result.ExcludedReleases) > 0 {
+		table.AddRow("Releases skipped (excluded):", strings.Join(result.ExcludedReleases, ", "))
+	}
+	tillerRemoved := yesNo(result.TillerRemoved)
+	if len(result.RemovedTillerNamespaces) > 0 {
+		tillerRemoved = fmt.Sprintf("yes, in namespaces %s", strings.Join(result.RemovedTillerNamespaces, ", "))
+	}
+	table.AddRow("Tiller removed:", tillerRemoved)
+	if len(result.RemovedConfigPaths) > 0 {
+		table.AddRow("Configuration removed:", strings.Join(result.RemovedConfigPaths, ", "))
+	} else {
+		table.AddRow("Home folder removed:", yesNo(result.HomeFolderRemoved))
+	}
+	names := []string{}
+	for releaseName := range result.RemainingVersions {
+		names = append(names, releaseName)
+	}
+	for releaseName := range result.FailedReleases {
+		if _, ok := result.RemainingVersions[releaseName]; !ok && !result.hasNoVersions(releaseName) {
+			names = append(names, releaseName)
+		}
+	}
+	sort.Strings(names)
+	for _, releaseName := range names {
+		table.AddRow(fmt.Sprintf("Release '%s' not deleted:", releaseName), fmt.Sprintf("versions deleted: %s, versions remaining: %s", formatVersions(result.DeletedVersions[releaseName]), formatVersions(result.RemainingVersions[releaseName])))
+	}
+	if len(result.NoVersionsReleases) > 0 {
+		table.AddRow("Releases with no versions found:", strings.Join(result.NoVersionsReleases, ", "))
+	}
+	if len(result.NotStartedReleases) > 0 {
+		table.AddRow("Releases not started:", strings.Join(result.NotStartedReleases, ", "))
+	}
+	table.AddRow("Elapsed time:", result.Duration)
+	_, err := fmt.Fprintln(out, table)
+	return err
+}
+
+// sortVersions sorts the release versions numerically
+func sortVersions(versions []int32) {
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+}
+
+// formatVersions returns the release versions as a comma-separated list, or "none"
+func formatVersions(versions []int32) string {
+	if len(versions) == 0 {
+		return "none"
+	}
+	formatted := []string{}
+	for _, version := range versions {
+		formatted = append(formatted, fmt.Sprintf("v%d", version))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// yesNo returns "yes" or "no" as per the value
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
 }
 
 // Cleanup will delete all release data for in specified namespace and owner label. It will remove
 // the Tiller server deployed as per namespace and owner label. It is also delete the Helm gv2 home directory
 // which contains the Helm configuration. Helm v2 will be unusable after this operation.
-func Cleanup(cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) error {
+// The result describes what was removed. It is returned also when an error occurs mid-way, so that
+// callers know what was already removed.
+func Cleanup(ctx context.Context, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) (*CleanupResult, error) {
+	logger := cleanupOptions.logger()
 	var message strings.Builder
 
-	if cleanupOptions.ReleaseName != "" {
-		if cleanupOptions.ConfigCleanup || cleanupOptions.TillerCleanup {
-			return errors.New("cleanup of a specific release is a singular operation. Other operations like configuration cleanup or Tiller cleanup are not allowed in conjunction with the operation")
-		}
-		cleanupOptions.ReleaseCleanup = true
-	} else {
-		if !cleanupOptions.ConfigCleanup && !cleanupOptions.ReleaseCleanup && !cleanupOptions.TillerCleanup {
-			cleanupOptions.ConfigCleanup = true
-			cleanupOptions.ReleaseCleanup = true
-			cleanupOptions.TillerCleanup = true
+	result := &CleanupResult{
+		DeletedReleases:   []string{},
+		DeletedVersions:   map[string][]int32{},
+		FailedReleases:    map[string]string{},
+		RemainingVersions: map[string][]int32{},
+		durations:         map[string]time.Duration{},
+		metrics:           cleanupOptions.Metrics,
+	}
+	// The elapsed time is only reported once the cleanup is confirmed
+	var started time.Time
+	defer func() {
+		if !started.IsZero() && !cleanupOptions.DryRun {
+			result.Duration = formatDuration(time.Since(started))
 		}
+		metrics.Add(cleanupOptions.Metrics, metrics.ReleasesFailed, float64(len(result.FailedReleases)))
+	}()
+
+	if err := v2.ValidateSelector(cleanupOptions.Selector); err != nil {
+		return result, err
+	}
+
+	if err := setCleanupOperations(&cleanupOptions); err != nil {
+		return result, err
+	}
+
+	if cleanupOptions.TillerAllNamespaces {
+		started = time.Now()
+		err := cleanupAllTillers(ctx, cleanupOptions, kubeConfig, result)
+		return result, err
+	}
+	if cleanupOptions.DeleteCorrupt {
+		return result, cleanupCorruptRecords(ctx, cleanupOptions, kubeConfig, result, &started)
 	}
 
 	if cleanupOptions.DryRun {
-		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
-		log.Println("Run without --dry-run to take the actions described below:")
-		log.Println()
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	// The releases of the cleanup of all releases are retrieved before the confirmation, for its summary,
+	// and the ones deleted are the ones retrieved, so that the cleanup removes the releases confirmed
+	retrieveOptions := cleanupOptions.retrieveOptions()
+	var v2Releases []*rls.Release
+	if cleanupOptions.ReleaseCleanup && len(cleanupOptions.ReleaseNames) == 0 {
+		var err error
+		v2Releases, err = v2.GetAllReleaseVersions(ctx, retrieveOptions, kubeConfig)
+		if err != nil {
+			return result, err
+		}
+		v2Releases, result.ExcludedReleases = excludeReleaseVersions(v2Releases, cleanupOptions.Exclude)
+		logExcludedReleases(logger, cleanupOptions.Exclude, result.ExcludedReleases)
+		v2Releases, err = filterCleanupReleases(v2Releases, cleanupOptions, kubeConfig)
+		if err != nil {
+			return result, err
+		}
 	}
 
+	if cleanupOptions.DryRun {
+		fmt.Fprint(&message, "[dry-run] ")
+	}
 	fmt.Fprint(&message, "WARNING: ")
 	if cleanupOptions.ConfigCleanup {
 		fmt.Fprint(&message, "\"Helm v2 Configuration\" ")
 	}
 	if cleanupOptions.ReleaseCleanup {
-		if cleanupOptions.ReleaseName == "" {
-			fmt.Fprint(&message, "\"Release Data\" ")
+		if len(cleanupOptions.ReleaseNames) == 0 {
+			if cleanupOptions.selectsByName() {
+				fmt.Fprint(&message, fmt.Sprintf("\"Release Data of releases matching name %s\" ", namePatternDescription(cleanupOptions.NamePattern, cleanupOptions.NameRegex)))
+			} else if cleanupOptions.ReleaseNamespace == "" {
+				fmt.Fprint(&message, "\"Release Data\" ")
+			} else {
+				fmt.Fprint(&message, fmt.Sprintf("\"Release Data of releases deployed into namespace '%s'\" ", cleanupOptions.ReleaseNamespace))
+			}
 		} else {
-			fmt.Fprint(&message, fmt.Sprintf("\"Release '%s' Data\" ", cleanupOptions.ReleaseName))
+			for _, releaseName := range cleanupOptions.ReleaseNames {
+				if cleanupOptions.KeepVersions > 0 {
+					fmt.Fprint(&message, fmt.Sprintf("\"Release '%s' Data except its latest %d versions\" ", releaseName, cleanupOptions.KeepVersions))
+				} else {
+					fmt.Fprint(&message, fmt.Sprintf("\"Release '%s' Data\" ", releaseName))
+				}
+			}
 		}
 	}
-	if cleanupOptions.TillerCleanup {
+	if cleanupOptions.TillerCleanup && !cleanupOptions.TillerOutCluster {
 		fmt.Fprint(&message, "\"Tiller\" ")
 	}
 	fmt.Fprintln(&message, "will be removed. ")
-	if cleanupOptions.ReleaseCleanup && cleanupOptions.ReleaseName == "" {
+	if cleanupOptions.ReleaseCleanup && len(cleanupOptions.ReleaseNames) == 0 && cleanupOptions.ReleaseNamespace == "" && !cleanupOptions.selectsByName() {
 		fmt.Fprintln(&message, "This will clean up all releases managed by Helm v2. It will not be possible to restore them if you haven't made a backup of the releases.")
 	}
-	if cleanupOptions.ReleaseName == "" {
+	if len(cleanupOptions.ReleaseNames) == 0 && cleanupOptions.ReleaseNamespace == "" && !cleanupOptions.selectsByName() {
 		fmt.Fprintln(&message, "Helm v2 may not be usable afterwards.")
 	}
+	if len(v2Releases) > 0 {
+		fmt.Fprint(&message, cleanupSummary(v2Releases, cleanupOptions))
+	}
 
-	fmt.Println(message.String())
+	fmt.Fprintln(cleanupOptions.output(), message.String())
 
 	var doCleanup bool
 	var err error
-	if cleanupOptions.SkipConfirmation {
-		log.Println("Skipping confirmation before performing cleanup.")
+	confirmOptions := utils.ConfirmOptions{
+		Default:   cleanupOptions.ConfirmDefault,
+		FromStdin: cleanupOptions.ConfirmFromStdin,
+		Logger:    cleanupOptions.logger(),
+		Out:       cleanupOptions.Out,
+		Timeout:   cleanupOptions.ConfirmTimeout,
+	}
+	// Nothing is removed in dry-run, so there is nothing to confirm
+	if cleanupOptions.DryRun {
+		logger.Infof("Skipping confirmation in dry-run mode.")
+		doCleanup = true
+	} else if cleanupOptions.SkipConfirmation {
+		logger.Infof("Skipping confirmation before performing cleanup.")
 		doCleanup = true
 		err = nil
+	} else if len(cleanupOptions.ReleaseNames) > 0 && (cleanupOptions.ConfirmName || utils.IsStdinTerminal()) {
+		releaseNames := strings.Join(cleanupOptions.ReleaseNames, ",")
+		doCleanup, err = utils.AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", releaseNames, confirmOptions)
+		if err != nil {
+			return result, err
+		}
+		if !doCleanup {
+			logger.Infof("Cleanup will not proceed as the user didn't type \"%s\" in order to continue.\n", releaseNames)
+			return result, nil
+		}
 	} else {
-		doCleanup, err = utils.AskConfirmation("Cleanup", "cleanup Helm v2 data")
+		doCleanup, err = utils.AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOptions)
 	}
 	if err != nil {
-		return err
+		return result, err
 	}
 	if !doCleanup {
-		log.Println("Cleanup will not proceed as the user didn't answer (Y|y) in order to continue.")
-		return nil
+		logger.Infof("Cleanup will not proceed as the user didn't answer (Y|y) in order to continue.")
+		return result, nil
 	}
 
-	log.Printf("\nHelm v2 data will be cleaned up.\n")
+	logger.Infof("\nHelm v2 data will be cleaned up.\n")
+	started = time.Now()
+
+	// The backup has to complete before anything is removed. The records of the named releases are
+	// retrieved once, so that the release versions deleted are the ones backed up.
+	planned := map[string]releaseCleanupPlan{}
+	if cleanupOptions.ReleaseCleanup && cleanupOptions.BackupDir != "" {
+		if len(cleanupOptions.ReleaseNames) > 0 {
+			backupRecords := []v2.ReleaseRecord{}
+			for _, releaseName := range cleanupOptions.ReleaseNames {
+				plan := getReleaseCleanupPlan(ctx, releaseName, cleanupOptions, kubeConfig)
+				// Named releases which have no release versions are reported by their cleanup
+				if plan.err != nil && !errors.Is(plan.err, v2.ErrNoVersionsFound) {
+					return result, fmt.Errorf("[Helm 2] release versions to back up failed to be retrieved with error: %w. Cleanup was aborted and nothing was removed", plan.err)
+				}
+				planned[releaseName] = plan
+				backupRecords = append(backupRecords, plan.records...)
+			}
+			err = v2.BackupReleaseRecords(backupRecords, cleanupOptions.BackupDir, cleanupOptions.DryRun, logger)
+		} else {
+			err = v2.BackupReleaseVersions(v2Releases, cleanupOptions.BackupDir, cleanupOptions.DryRun, logger)
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s. Cleanup was aborted and nothing was removed", err)
+		}
+	}
 
 	if cleanupOptions.ReleaseCleanup {
-		if cleanupOptions.ReleaseName == "" {
-			log.Println("[Helm 2] Releases will be deleted.")
+		var matched bool
+		if len(cleanupOptions.ReleaseNames) == 0 {
+			matched, err = cleanupAllReleases(ctx, v2Releases, cleanupOptions, kubeConfig, result)
 		} else {
-			log.Printf("[Helm 2] Release '%s' will be deleted.\n", cleanupOptions.ReleaseName)
+			matched, err = cleanupNamedReleases(ctx, planned, cleanupOptions, kubeConfig, result)
 		}
-		retrieveOptions := v2.RetrieveOptions{
-			ReleaseName:      cleanupOptions.ReleaseName,
-			TillerNamespace:  cleanupOptions.TillerNamespace,
-			TillerLabel:      cleanupOptions.TillerLabel,
-			TillerOutCluster: cleanupOptions.TillerOutCluster,
-			StorageType:      cleanupOptions.StorageType,
+		if err != nil {
+			return result, err
 		}
-		if cleanupOptions.ReleaseName == "" {
-			err = v2.DeleteAllReleaseVersions(retrieveOptions, kubeConfig, cleanupOptions.DryRun)
+		if !matched {
+			if cleanupOptions.FailOnEmpty {
+				return result, fmt.Errorf("%w: no releases matching the cleanup options. Nothing was cleaned up", common.ErrNothingFound)
+			}
+			logger.Warnf("No releases matching the cleanup options were found. Nothing was cleaned up.")
+			return result, nil
+		}
+	}
+
+	if cleanupOptions.TillerOutCluster && cleanupOptions.TillerCleanup {
+		logger.Infof("[Helm 2] Tiller cleanup skipped, as Tiller is not running in the cluster.")
+	} else if cleanupOptions.TillerCleanup {
+		logger.Infof("[Helm 2] Tiller in \"%s\" namespace will be removed.\n", cleanupOptions.TillerNamespace)
+		tillerOptions := v2.RemoveTillerOptions{
+			DryRun:               cleanupOptions.DryRun,
+			RBACCleanup:          cleanupOptions.TillerRBACCleanup,
+			TillerDeploymentName: cleanupOptions.TillerDeploymentName,
+			TillerNamespace:      cleanupOptions.TillerNamespace,
+			Wait:                 cleanupOptions.TillerWait,
+			WaitTimeout:          cleanupOptions.TillerWaitTimeout,
+			ObjectDeletion:       cleanupOptions.ObjectDeletion,
+			Logger:               logger,
+		}
+		found, err := v2.RemoveTiller(ctx, tillerOptions, kubeConfig)
+		if err != nil {
+			return result, err
+		}
+		if found && !cleanupOptions.DryRun {
+			result.TillerRemoved = true
+			logger.Infof("[Helm 2] Tiller in \"%s\" namespace was removed.\n", cleanupOptions.TillerNamespace)
+		}
+	}
+
+	if cleanupOptions.ConfigCleanup {
+		removed, err := v2.RemoveConfigScopes(cleanupOptions.configScopes(), cleanupOptions.DryRun, cleanupOptions.FollowSymlinks, logger)
+		if !cleanupOptions.DryRun {
+			if cleanupOptions.allConfigScopes() {
+				result.HomeFolderRemoved = len(removed) > 0
+			} else {
+				result.RemovedConfigPaths = removed
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if !cleanupOptions.DryRun {
+		logger.Infof("Helm v2 data was cleaned up successfully.")
+	}
+	return result, nil
+}
+
+// cleanupAllReleases deletes the release versions of the cleanup of all releases, as retrieved before
+// the confirmation, in batches. It returns false when no releases matched the cleanup options and
+// that is unexpected, as the releases are filtered or the cleanup fails on it.
+func cleanupAllReleases(ctx context.Context, v2Releases []*rls.Release, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig, result *CleanupResult) (bool, error) {
+	logger := cleanupOptions.logger()
+	logger.Infof("[Helm 2] Releases will be deleted.")
+	names, _ := groupReleaseVersions(v2Releases)
+	// Finding no releases is only unexpected when the releases are filtered by namespace, conversion,
+	// name or selector, or when the cleanup is set to fail on it
+	filtered := cleanupOptions.ReleaseNamespace != "" || cleanupOptions.ConvertedOnly || cleanupOptions.selectsByName() || cleanupOptions.Selector != ""
+	matched := len(names) > 0 || (!filtered && !cleanupOptions.FailOnEmpty)
+	if cleanupOptions.Selector != "" {
+		logger.Infof("[Helm 2] Releases matching selector \"%s\": %s\n", cleanupOptions.Selector, strings.Join(names, ", "))
+	} else if len(names) > 0 && (filtered || !cleanupOptions.IncludeDeleted || len(cleanupOptions.Exclude) > 0) {
+		logger.Infof("[Helm 2] Releases matched for deletion: %s\n", strings.Join(names, ", "))
+	}
+	// The release versions deleted are the ones confirmed, in batches, none when none matched
+	deleteOptions := v2.DeleteOptions{
+		BatchInterval:  cleanupOptions.DeleteBatchInterval,
+		BatchSize:      cleanupOptions.DeleteBatchSize,
+		DryRun:         cleanupOptions.DryRun,
+		Logger:         logger,
+		ObjectDeletion: cleanupOptions.ObjectDeletion,
+		Progress:       cleanupOptions.Progress,
+		Releases:       v2Releases,
+		UseReleases:    true,
+	}
+	deleted, err := v2.DeleteAllReleaseVersions(ctx, cleanupOptions.retrieveOptions(), deleteOptions, kubeConfig)
+	for releaseName, versions := range deleted {
+		result.addDeletedVersions(releaseName, versions)
+	}
+	if err != nil {
+		result.addRemainingVersions(err)
+		err = result.addBulkFailure(ctx, err, len(names))
+		if len(result.DeletedReleases) > 0 {
+			return false, &common.PartialError{Succeeded: len(result.DeletedReleases), Failed: len(result.RemainingVersions), Err: err}
+		}
+		return false, err
+	}
+	if !cleanupOptions.DryRun && len(names) > 0 {
+		logger.Infof("[Helm 2] Releases deleted.")
+	}
+	return matched, nil
+}
+
+// cleanupNamedReleases deletes the named releases one by one, as per their cleanup plans when they were
+// planned for the backup. It returns false when none of the releases were found in the namespace of
+// the cleanup options.
+func cleanupNamedReleases(ctx context.Context, planned map[string]releaseCleanupPlan, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig, result *CleanupResult) (bool, error) {
+	logger := cleanupOptions.logger()
+	matched := cleanupOptions.ReleaseNamespace == "" && !cleanupOptions.ConvertedOnly
+	failed := []string{}
+	for i, releaseName := range cleanupOptions.ReleaseNames {
+		// The releases left once the cleanup timed out or was interrupted are not started, as they would fail
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			result.NotStartedReleases = cleanupOptions.ReleaseNames[i:]
+			err := fmt.Errorf("[Helm 2] cleanup stopped after %d of %d releases: %w", i, len(cleanupOptions.ReleaseNames), ctxErr)
+			if i > len(failed) {
+				return false, &common.PartialError{Succeeded: i - len(failed), Failed: len(cleanupOptions.ReleaseNames) - i + len(failed), Err: err}
+			}
+			return false, err
+		}
+		started := time.Now()
+		plan, ok := planned[releaseName]
+		if !ok {
+			plan = getReleaseCleanupPlan(ctx, releaseName, cleanupOptions, kubeConfig)
+		}
+		deleted, inNamespace, err := cleanupRelease(ctx, releaseName, plan, cleanupOptions, kubeConfig)
+		result.addDeletedVersions(releaseName, deleted)
+		result.durations[releaseName] = time.Since(started)
+		matched = matched || inNamespace
+		if err != nil {
+			result.FailedReleases[releaseName] = err.Error()
+			result.addRemainingVersions(err)
+			// The error of a single release is returned as is, so that its cause can be told
+			if cleanupOptions.FailFast || len(cleanupOptions.ReleaseNames) == 1 {
+				return false, err
+			}
+			// No release version being found is told apart, as the release is likely looked up in the wrong storage
+			if !inNamespace && errors.Is(err, v2.ErrNoVersionsFound) {
+				logger.Warnf("[Helm 2] Release '%s' has no release versions in Helm v2 storage: %s. Check the 'tiller-ns', 'label' and 'release-storage' flags.\n", releaseName, err)
+				result.NoVersionsReleases = append(result.NoVersionsReleases, releaseName)
+			} else {
+				logger.Infof("[Helm 2] Release '%s' failed to be deleted with error: %s\n", releaseName, err)
+			}
+			failed = append(failed, releaseName)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("[Helm 2] %d of %d releases failed to be deleted: %s", len(failed), len(cleanupOptions.ReleaseNames), strings.Join(failed, ", "))
+		if len(result.NoVersionsReleases) > 0 {
+			err = fmt.Errorf("%s. No release versions were found of %s", err, strings.Join(result.NoVersionsReleases, ", "))
+		}
+		if len(failed) < len(cleanupOptions.ReleaseNames) {
+			return false, &common.PartialError{Succeeded: len(cleanupOptions.ReleaseNames) - len(failed), Failed: len(failed), Err: err}
+		}
+		return false, err
+	}
+	return matched, nil
+}
+
+// cleanupSummary returns the summary of the cleanup of all releases shown before the confirmation: the
+// number of releases and versions removed, and the names of the first releases, or of all when verbose
+func cleanupSummary(v2Releases []*rls.Release, cleanupOptions CleanupOptions) string {
+	names, _ := groupReleaseVersions(v2Releases)
+	location := fmt.Sprintf("namespace %s", cleanupOptions.TillerNamespace)
+	if cleanupOptions.TillerStorageDir != "" {
+		location = fmt.Sprintf("directory %s", cleanupOptions.TillerStorageDir)
+	}
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "About to delete %s %s totalling %s %s across %s", formatCount(len(names)), plural(len(names), "release", "releases"), formatCount(len(v2Releases)), plural(len(v2Releases), "version", "versions"), location)
+	if cleanupOptions.Verbose || len(names) <= summaryReleaseNames {
+		fmt.Fprintf(&summary, ": %s\n", strings.Join(names, ", "))
+	} else {
+		fmt.Fprintf(&summary, ": %s and %d more. Set the 'verbose' flag to list them all\n", strings.Join(names[:summaryReleaseNames], ", "), len(names)-summaryReleaseNames)
+	}
+	return summary.String()
+}
+
+// formatCount formats the count with a comma between the groups of thousands, e.g. 2,311
+func formatCount(count int) string {
+	digits := strconv.Itoa(count)
+	var formatted strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			formatted.WriteByte(',')
+		}
+		formatted.WriteRune(digit)
+	}
+	return formatted.String()
+}
+
+// plural returns the singular or plural form of the noun as per the count
+func plural(count int, singular, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// cleanupAllTillers removes the Tiller instances found in all namespaces. The removal of each
+// Tiller instance is confirmed separately, unless confirmation is skipped.
+func cleanupAllTillers(ctx context.Context, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig, result *CleanupResult) error {
+	logger := cleanupOptions.logger()
+	if cleanupOptions.DryRun {
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	namespaces, err := findTillerNamespaces(ctx, kubeConfig)
+	if err != nil {
+		return err
+	}
+	if len(namespaces) == 0 {
+		if cleanupOptions.FailOnEmpty {
+			return fmt.Errorf("%w: [Helm 2] no Tiller in any namespace. Nothing was cleaned up", common.ErrNothingFound)
+		}
+		logger.Infof("[Helm 2] No Tiller found in any namespace. Nothing was cleaned up.")
+		return nil
+	}
+	logger.Infof("[Helm 2] Tiller found in namespaces: %s\n", strings.Join(namespaces, ", "))
+
+	confirmOptions := utils.ConfirmOptions{
+		Default:   cleanupOptions.ConfirmDefault,
+		FromStdin: cleanupOptions.ConfirmFromStdin,
+		Logger:    cleanupOptions.logger(),
+		Out:       cleanupOptions.Out,
+		Timeout:   cleanupOptions.ConfirmTimeout,
+	}
+	dryRunNotice := ""
+	if cleanupOptions.DryRun {
+		dryRunNotice = "[dry-run] "
+	}
+	for _, namespace := range namespaces {
+		fmt.Fprintf(cleanupOptions.output(), "%sWARNING: \"Tiller\" in namespace '%s' will be removed. Helm v2 will not be usable with it afterwards.\n", dryRunNotice, namespace)
+		if cleanupOptions.DryRun {
+			logger.Infof("Skipping confirmation in dry-run mode.")
+		} else if cleanupOptions.SkipConfirmation {
+			logger.Infof("Skipping confirmation before performing cleanup.")
 		} else {
-			// Get the releases versions as its the versions that are deleted
-			v2Releases, err := v2.GetReleaseVersions(retrieveOptions, kubeConfig)
+			doCleanup, err := utils.AskConfirmation("Cleanup", fmt.Sprintf("remove Tiller in \"%s\" namespace", namespace), confirmOptions)
 			if err != nil {
 				return err
 			}
-			versions := []int32{}
-			v2RelVerLen := len(v2Releases)
-			for i := 0; i < v2RelVerLen; i++ {
-				v2Release := v2Releases[i]
-				versions = append(versions, v2Release.Version)
-			}
-			deleteOptions := v2.DeleteOptions{
-				DryRun:   cleanupOptions.DryRun,
-				Versions: versions,
+			if !doCleanup {
+				logger.Infof("Tiller in \"%s\" namespace will not be removed as the user didn't answer (Y|y) in order to continue.\n", namespace)
+				continue
 			}
-			err = v2.DeleteReleaseVersions(retrieveOptions, deleteOptions, kubeConfig)
 		}
+
+		logger.Infof("[Helm 2] Tiller in \"%s\" namespace will be removed.\n", namespace)
+		tillerOptions := v2.RemoveTillerOptions{
+			DryRun:               cleanupOptions.DryRun,
+			RBACCleanup:          cleanupOptions.TillerRBACCleanup,
+			TillerDeploymentName: cleanupOptions.TillerDeploymentName,
+			TillerNamespace:      namespace,
+			Wait:                 cleanupOptions.TillerWait,
+			WaitTimeout:          cleanupOptions.TillerWaitTimeout,
+			ObjectDeletion:       cleanupOptions.ObjectDeletion,
+			Logger:               logger,
+		}
+		found, err := v2.RemoveTiller(ctx, tillerOptions, kubeConfig)
 		if err != nil {
 			return err
 		}
-		if !cleanupOptions.DryRun {
-			if cleanupOptions.ReleaseName == "" {
-				log.Println("[Helm 2] Releases deleted.")
-			} else {
-				log.Printf("[Helm 2] Release '%s' deleted.\n", cleanupOptions.ReleaseName)
-			}
+		if found && !cleanupOptions.DryRun {
+			result.TillerRemoved = true
+			result.RemovedTillerNamespaces = append(result.RemovedTillerNamespaces, namespace)
+			logger.Infof("[Helm 2] Tiller in \"%s\" namespace was removed.\n", namespace)
 		}
 	}
+	return nil
+}
+
+// cleanupCorruptRecords deletes the Helm v2 storage objects whose release can't be decoded, after they
+// are listed and their deletion confirmed. The default answer of the confirmation doesn't apply, so that
+// they are only deleted when answered, or when confirmation is skipped. The start of the deletion is set,
+// once confirmed.
+func cleanupCorruptRecords(ctx context.Context, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig, result *CleanupResult, started *time.Time) error {
+	logger := cleanupOptions.logger()
+	if cleanupOptions.DryRun {
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	records, err := getCorruptRecords(ctx, cleanupOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		if cleanupOptions.FailOnEmpty {
+			return fmt.Errorf("%w: [Helm 2] no release records which can't be decoded. Nothing was cleaned up", common.ErrNothingFound)
+		}
+		logger.Infof("[Helm 2] No release records which can't be decoded. Nothing was cleaned up.")
+		return nil
+	}
 
-	if !cleanupOptions.TillerOutCluster && cleanupOptions.TillerCleanup {
-		log.Printf("[Helm 2] Tiller in \"%s\" namespace will be removed.\n", cleanupOptions.TillerNamespace)
-		err = v2.RemoveTiller(cleanupOptions.TillerNamespace, cleanupOptions.DryRun)
+	dryRunNotice := ""
+	if cleanupOptions.DryRun {
+		dryRunNotice = "[dry-run] "
+	}
+	var message strings.Builder
+	fmt.Fprintf(&message, "%sWARNING: the %d Helm v2 release records which can't be decoded will be deleted:\n", dryRunNotice, len(records))
+	for _, record := range records {
+		fmt.Fprintf(&message, "  %s (release \"%s\", version %d): %s\n", record, record.ReleaseName, record.Version, record.Error)
+	}
+	fmt.Fprintln(&message, "The other release versions of their releases are left as they are. The records can't be restored once deleted, so inspect them first if needed.")
+	fmt.Fprintln(cleanupOptions.output(), message.String())
+
+	if cleanupOptions.DryRun {
+		logger.Infof("Skipping confirmation in dry-run mode.")
+	} else if cleanupOptions.SkipConfirmation {
+		logger.Infof("Skipping confirmation before performing cleanup.")
+	} else {
+		confirmOptions := utils.ConfirmOptions{
+			FromStdin: cleanupOptions.ConfirmFromStdin,
+			Logger:    cleanupOptions.logger(),
+			Out:       cleanupOptions.Out,
+			Timeout:   cleanupOptions.ConfirmTimeout,
+		}
+		doCleanup, err := utils.AskConfirmation("Cleanup", fmt.Sprintf("delete the %d release records which can't be decoded", len(records)), confirmOptions)
 		if err != nil {
 			return err
 		}
-		if !cleanupOptions.DryRun {
-			log.Printf("[Helm 2] Tiller in \"%s\" namespace was removed.\n", cleanupOptions.TillerNamespace)
+		if !doCleanup {
+			logger.Infof("Cleanup will not proceed as the user didn't answer (Y|y) in order to continue.")
+			return nil
 		}
 	}
 
-	if cleanupOptions.ConfigCleanup {
-		err = v2.RemoveHomeFolder(cleanupOptions.DryRun)
+	*started = time.Now()
+	deleted, err := v2.DeleteCorruptRecords(ctx, cleanupOptions.retrieveOptions(), records, cleanupOptions.DryRun, kubeConfig)
+	result.DeletedCorruptRecords = deleted
+	return err
+}
+
+// getCorruptRecords returns the Helm v2 storage objects which can't be decoded of the named releases,
+// or of all releases when none are named
+func getCorruptRecords(ctx context.Context, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) ([]v2.CorruptRecord, error) {
+	// The corrupt records are narrowed down to some releases by the 'name' flag only
+	retrieveOptions := cleanupOptions.retrieveOptions()
+	retrieveOptions.Selector = ""
+	if len(cleanupOptions.ReleaseNames) == 0 {
+		return v2.GetCorruptRecords(ctx, retrieveOptions, kubeConfig)
+	}
+	records := []v2.CorruptRecord{}
+	for _, releaseName := range cleanupOptions.ReleaseNames {
+		retrieveOptions.ReleaseName = releaseName
+		releaseRecords, err := v2.GetCorruptRecords(ctx, retrieveOptions, kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, releaseRecords...)
+	}
+	return records, nil
+}
+
+// findTillerNamespaces returns the sorted namespaces that Tiller instances are found in
+func findTillerNamespaces(ctx context.Context, kubeConfig common.KubeConfig) ([]string, error) {
+	tillers, err := v2.FindTillers(ctx, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := []string{}
+	for _, tiller := range tillers {
+		if len(namespaces) == 0 || namespaces[len(namespaces)-1] != tiller.Namespace {
+			namespaces = append(namespaces, tiller.Namespace)
+		}
+	}
+	return namespaces, nil
+}
+
+// releaseCleanupPlan holds the records of the release versions of a named release that its cleanup deletes,
+// as retrieved, filtered and limited to the versions kept. inNamespace is false when the release is
+// skipped as per the release namespace or converted only options, and err is the error of the retrieval.
+type releaseCleanupPlan struct {
+	records     []v2.ReleaseRecord
+	inNamespace bool
+	err         error
+}
+
+// getReleaseCleanupPlan returns the records of the release versions of the release that its cleanup deletes
+func getReleaseCleanupPlan(ctx context.Context, releaseName string, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) releaseCleanupPlan {
+	retrieveOptions := cleanupOptions.retrieveOptions()
+	retrieveOptions.ReleaseName = releaseName
+	// Get the records of the releases versions, so that the storage objects deleted are the ones retrieved
+	records, err := v2.GetReleaseVersionRecords(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return releaseCleanupPlan{err: err}
+	}
+	v2Releases := []*rls.Release{}
+	recordsByVersion := map[int32]v2.ReleaseRecord{}
+	for _, record := range records {
+		v2Releases = append(v2Releases, record.Release)
+		recordsByVersion[record.Release.Version] = record
+	}
+	v2Releases, err = filterCleanupReleases(v2Releases, cleanupOptions, kubeConfig)
+	if err != nil {
+		return releaseCleanupPlan{err: err}
+	}
+	if len(v2Releases) == 0 {
+		return releaseCleanupPlan{}
+	}
+	plan := releaseCleanupPlan{inNamespace: true}
+	for _, v2Release := range keepLatestVersions(releaseName, v2Releases, cleanupOptions.KeepVersions, cleanupOptions.logger()) {
+		plan.records = append(plan.records, recordsByVersion[v2Release.Version])
+	}
+	return plan
+}
+
+// cleanupRelease deletes the release versions of the records of the release cleanup plan. When the release is
+// skipped as per the release namespace or converted only options, false is returned.
+func cleanupRelease(ctx context.Context, releaseName string, plan releaseCleanupPlan, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) ([]int32, bool, error) {
+	logger := cleanupOptions.logger()
+	logger.Infof("[Helm 2] Release '%s' will be deleted.\n", releaseName)
+	if plan.err != nil || len(plan.records) == 0 {
+		return nil, plan.inNamespace, plan.err
+	}
+	deleted, err := deleteReleaseVersions(ctx, releaseName, v2.DeleteOptions{Records: plan.records}, cleanupOptions, kubeConfig)
+	return deleted, true, err
+}
+
+// deleteReleaseVersions deletes the release versions of the delete options, given by version or by the
+// records retrieved
+func deleteReleaseVersions(ctx context.Context, releaseName string, deleteOptions v2.DeleteOptions, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) ([]int32, error) {
+	logger := cleanupOptions.logger()
+	retrieveOptions := cleanupOptions.retrieveOptions()
+	retrieveOptions.ReleaseName = releaseName
+	deleteOptions.DryRun = cleanupOptions.DryRun
+	deleteOptions.Logger = logger
+	deleteOptions.ObjectDeletion = cleanupOptions.ObjectDeletion
+	deleted, err := v2.DeleteReleaseVersions(ctx, retrieveOptions, deleteOptions, kubeConfig)
+	if err != nil {
+		return deleted, err
+	}
+	// Only logged once the release versions were actually deleted, i.e. not in dry-run
+	if len(deleted) > 0 {
+		logger.Infof("[Helm 2] Release '%s' deleted.\n", releaseName)
+	}
+	return deleted, nil
+}
+
+// filterCleanupReleases returns the release versions to clean up as per the name pattern, release
+// namespace, include deleted and converted only options. Releases skipped are logged.
+func filterCleanupReleases(v2Releases []*rls.Release, cleanupOptions CleanupOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
+	logger := cleanupOptions.logger()
+	matcher, err := compileNameMatcher(cleanupOptions.NamePattern, cleanupOptions.NameRegex)
+	if err != nil {
+		return nil, err
+	}
+	if matcher != nil {
+		names, _ := groupReleaseVersions(v2Releases)
+		matchedNames := matchReleaseNames(names, matcher)
+		logger.Infof("[Helm 2] Releases matching name %s: %s\n", namePatternDescription(cleanupOptions.NamePattern, cleanupOptions.NameRegex), strings.Join(matchedNames, ", "))
+		matched := []*rls.Release{}
+		for _, v2Release := range v2Releases {
+			if matcher.MatchString(v2Release.Name) {
+				matched = append(matched, v2Release)
+			}
+		}
+		v2Releases = matched
+	}
+	v2Releases, skipped := filterReleasesByNamespace(v2Releases, cleanupOptions.ReleaseNamespace)
+	if len(skipped) > 0 {
+		logger.Infof("[Helm 2] Releases skipped as not deployed into namespace \"%s\": %s\n", cleanupOptions.ReleaseNamespace, strings.Join(skipped, ", "))
+	}
+	// Named releases are removed whatever their status
+	if len(cleanupOptions.ReleaseNames) == 0 && !cleanupOptions.IncludeDeleted {
+		v2Releases, skipped = filterDeletedReleases(v2Releases)
+		if len(skipped) > 0 {
+			logger.Infof("[Helm 2] Releases skipped as they were deleted with their history kept. Set the 'include-deleted' flag to remove them: %s\n", strings.Join(skipped, ", "))
+		}
+	}
+	if !cleanupOptions.ConvertedOnly {
+		return v2Releases, nil
+	}
+
+	names, _ := groupReleaseVersions(v2Releases)
+	converted := map[string]bool{}
+	for _, name := range names {
+		// The latest version holds the namespace that the release is deployed into
+		var namespace string
+		for _, v2Release := range v2Releases {
+			if v2Release.Name == name {
+				namespace = v2Release.Namespace
+			}
+		}
+		exists, err := v3.ReleaseExists(name, namespace, kubeConfig)
 		if err != nil {
+			return nil, fmt.Errorf("[Helm 3] Failed to check if release \"%s\" exists in namespace \"%s\" with error: %s", name, namespace, err)
+		}
+		if !exists {
+			logger.Warnf("[Helm 2] Release '%s' skipped as it does not exist in Helm v3 storage of namespace \"%s\".\n", name, namespace)
+			continue
+		}
+		converted[name] = true
+	}
+	matched := []*rls.Release{}
+	for _, v2Release := range v2Releases {
+		if converted[v2Release.Name] {
+			matched = append(matched, v2Release)
+		}
+	}
+	return matched, nil
+}
+
+// keepLatestVersions returns the versions of a release to remove so that only its latest versions
+// to keep remain. All versions are returned when none are kept.
+func keepLatestVersions(releaseName string, v2Releases []*rls.Release, keep int, logger common.Logger) []*rls.Release {
+	if keep <= 0 {
+		return v2Releases
+	}
+	if len(v2Releases) <= keep {
+		logger.Infof("[Helm 2] Release '%s' has %d versions, which is not more than the %d versions to keep. No versions will be deleted.\n", releaseName, len(v2Releases), keep)
+		return []*rls.Release{}
+	}
+	sorted := make([]*rls.Release, len(v2Releases))
+	copy(sorted, v2Releases)
+	sort.Sort(v2.ByReleaseVersion(sorted))
+	removed := sorted[:len(sorted)-keep]
+	versions := []string{}
+	for _, v2Release := range removed {
+		versions = append(versions, strconv.Itoa(int(v2Release.Version)))
+	}
+	logger.Infof("[Helm 2] Release '%s' versions %s will be deleted, keeping its latest %d versions.\n", releaseName, strings.Join(versions, ", "), keep)
+	return removed
+}
+
+// filterDeletedReleases returns the release versions of the releases whose latest version is not
+// deleted, and the names of the releases skipped as deleted
+func filterDeletedReleases(v2Releases []*rls.Release) ([]*rls.Release, []string) {
+	names, _ := groupReleaseVersions(v2Releases)
+	latest := map[string]*rls.Release{}
+	for _, v2Release := range v2Releases {
+		if current, ok := latest[v2Release.Name]; !ok || v2Release.Version > current.Version {
+			latest[v2Release.Name] = v2Release
+		}
+	}
+	deleted := map[string]bool{}
+	skipped := []string{}
+	for _, name := range names {
+		info := latest[name].Info
+		if info != nil && info.Status != nil && info.Status.Code == rls.Status_DELETED {
+			deleted[name] = true
+			skipped = append(skipped, name)
+		}
+	}
+	matched := []*rls.Release{}
+	for _, v2Release := range v2Releases {
+		if !deleted[v2Release.Name] {
+			matched = append(matched, v2Release)
+		}
+	}
+	return matched, skipped
+}
+
+// filterReleasesByNamespace returns the release versions deployed into the namespace and the names of the
+// releases skipped as they are deployed into other namespaces. All versions are returned if the namespace is empty.
+func filterReleasesByNamespace(v2Releases []*rls.Release, namespace string) ([]*rls.Release, []string) {
+	if namespace == "" {
+		return v2Releases, nil
+	}
+	matched := []*rls.Release{}
+	skipped := []string{}
+	seen := map[string]bool{}
+	for _, v2Release := range v2Releases {
+		if v2Release.Namespace == namespace {
+			matched = append(matched, v2Release)
+			continue
+		}
+		if !seen[v2Release.Name] {
+			seen[v2Release.Name] = true
+			skipped = append(skipped, v2Release.Name)
+		}
+	}
+	sort.Strings(skipped)
+	return matched, skipped
+}
+
+// groupReleaseVersions returns the sorted names of the releases and the versions of each release
+func groupReleaseVersions(v2Releases []*rls.Release) ([]string, map[string][]int32) {
+	names := []string{}
+	versions := map[string][]int32{}
+	for _, v2Release := range v2Releases {
+		if _, ok := versions[v2Release.Name]; !ok {
+			names = append(names, v2Release.Name)
+		}
+		versions[v2Release.Name] = append(versions[v2Release.Name], v2Release.Version)
+	}
+	sort.Strings(names)
+	return names, versions
+}
+
+// parseConfirmDefault returns the answer of the 'confirm-default' flag, checking the timeout too
+func parseConfirmDefault() (bool, error) {
+	if confirmTimeout < 0 {
+		return false, errors.New("confirm-timeout flag can not be negative")
+	}
+	switch confirmDefault {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("confirm-default flag \"%s\" is not supported. It can be 'yes' or 'no'", confirmDefault)
+}
+
+// validateCleanupOperations checks the combinations of the cleanup operations and of the options
+// which only apply to some of them
+func validateCleanupOperations(cleanupOptions CleanupOptions) error {
+	if cleanupOptions.ConfirmName && len(cleanupOptions.ReleaseNames) == 0 {
+		return errors.New("the 'confirm-name' flag can only be used with the 'name' flag")
+	}
+	if cleanupOptions.DeleteCorrupt {
+		if cleanupOptions.ConfigCleanup || cleanupOptions.ReleaseCleanup || cleanupOptions.TillerCleanup || cleanupOptions.TillerAllNamespaces {
+			return errors.New("the 'delete-corrupt' flag is a singular operation. It can not be used with other cleanup operations. Clean up the configuration, release data or Tiller in a separate cleanup")
+		}
+		if cleanupOptions.ReleaseNamespace != "" || cleanupOptions.selectsByName() || cleanupOptions.KeepVersions > 0 || len(cleanupOptions.Exclude) > 0 || cleanupOptions.ConfirmName {
+			return errors.New("the 'delete-corrupt' flag can only be narrowed down to some releases with the 'name' flag")
+		}
+		return nil
+	}
+	if cleanupOptions.TillerAllNamespaces {
+		if !cleanupOptions.TillerCleanup || cleanupOptions.ConfigCleanup || cleanupOptions.ReleaseCleanup || len(cleanupOptions.ReleaseNames) > 0 || cleanupOptions.ReleaseNamespace != "" {
+			return errors.New("the 'tiller-all-namespaces' flag can only be used with the 'tiller-cleanup' flag, and not with other cleanup operations")
+		}
+		if cleanupOptions.TillerOutCluster {
+			return errors.New("the 'tiller-all-namespaces' flag can not be used with the 'tiller-out-cluster' flag")
+		}
+	}
+	if cleanupOptions.KeepVersions < 0 {
+		return errors.New("the 'keep-versions' flag can not be negative")
+	}
+	if cleanupOptions.KeepVersions > 0 && len(cleanupOptions.ReleaseNames) == 0 {
+		return errors.New("the 'keep-versions' flag can only be used with the 'name' flag")
+	}
+	if len(cleanupOptions.Exclude) > 0 {
+		if len(cleanupOptions.ReleaseNames) > 0 {
+			return errors.New("the 'exclude' and 'exclude-file' flags can not be used with the 'name' flag. Only pass the names of the releases to remove to the 'name' flag")
+		}
+		if !cleanupOptions.ReleaseCleanup && (cleanupOptions.ConfigCleanup || cleanupOptions.TillerCleanup) {
+			return errors.New("the 'exclude' and 'exclude-file' flags only apply to release cleanup. Set the 'release-cleanup' flag too")
+		}
+	}
+	if cleanupOptions.selectsByName() {
+		if len(cleanupOptions.ReleaseNames) > 0 {
+			return errors.New("the 'name-pattern' and 'name-regex' flags can not be used with the 'name' flag. Pass either the names of the releases to remove, or the pattern they match")
+		}
+		if _, err := compileNameMatcher(cleanupOptions.NamePattern, cleanupOptions.NameRegex); err != nil {
 			return err
 		}
 	}
+	// Tiller cleanup is skipped when it is not explicitly set, as there is no Tiller in the cluster, and
+	// skipped with a note for Tillerless Helm v2
+	if cleanupOptions.TillerCleanup && cleanupOptions.TillerOutCluster && !cleanupOptions.Tillerless {
+		return errors.New("the 'tiller-cleanup' flag can not be used with the 'tiller-out-cluster' flag, as there is no Tiller in the cluster to remove. Unset the 'tiller-cleanup' flag, or the 'tiller-out-cluster' flag if Tiller is running in the cluster")
+	}
+	if cleanupOptions.TillerWait {
+		singular := len(cleanupOptions.ReleaseNames) > 0 || cleanupOptions.ReleaseNamespace != "" || cleanupOptions.selectsByName()
+		if singular || cleanupOptions.TillerOutCluster || (!cleanupOptions.TillerCleanup && (cleanupOptions.ConfigCleanup || cleanupOptions.ReleaseCleanup)) {
+			return errors.New("the 'wait' flag only applies to Tiller cleanup, which this cleanup does not perform. Set the 'tiller-cleanup' flag too, or unset the 'wait' flag")
+		}
+	}
+	if len(cleanupOptions.ReleaseNames) > 0 || cleanupOptions.ReleaseNamespace != "" || cleanupOptions.selectsByName() {
+		if cleanupOptions.ConfigCleanup || cleanupOptions.TillerCleanup {
+			return errors.New("cleanup of a specific release is a singular operation. The 'config-cleanup' and 'tiller-cleanup' flags can not be used with the 'name', 'name-pattern', 'name-regex' or 'release-namespace' flag. Clean up the configuration or Tiller in a separate cleanup")
+		}
+	}
+	return nil
+}
 
-	if !cleanupOptions.DryRun {
-		log.Println("Helm v2 data was cleaned up successfully.")
+// setCleanupOperations sets the cleanup operations to perform. Cleanup of named releases is a
+// singular operation and all operations are performed when none are specified.
+func setCleanupOperations(cleanupOptions *CleanupOptions) error {
+	if err := validateCleanupOperations(*cleanupOptions); err != nil {
+		return err
+	}
+	// The named releases are processed in the order of their names, so that the output of two runs can be diffed
+	cleanupOptions.ReleaseNames = append([]string{}, cleanupOptions.ReleaseNames...)
+	sort.Strings(cleanupOptions.ReleaseNames)
+	if cleanupOptions.DeleteCorrupt {
+		return nil
+	}
+	if len(cleanupOptions.ReleaseNames) > 0 || cleanupOptions.ReleaseNamespace != "" || cleanupOptions.selectsByName() {
+		cleanupOptions.ReleaseCleanup = true
+	} else {
+		if !cleanupOptions.ConfigCleanup && !cleanupOptions.ReleaseCleanup && !cleanupOptions.TillerCleanup {
+			cleanupOptions.ConfigCleanup = true
+			cleanupOptions.ReleaseCleanup = true
+			cleanupOptions.TillerCleanup = true
+		}
+	}
+	// The release records would be removed with the configuration without being cleaned up
+	if cleanupOptions.TillerStorageDir != "" && cleanupOptions.ConfigCleanup && !cleanupOptions.ReleaseCleanup && v2.InConfigScopes(cleanupOptions.TillerStorageDir, cleanupOptions.configScopes()) {
+		return fmt.Errorf("the Tiller storage directory \"%s\" is in the Helm v2 configuration which configuration cleanup removes. Clean up the release data too, or move the directory out of the removed folders", cleanupOptions.TillerStorageDir)
+	}
+	// An arbitrary directory set as the home folder by mistake is not removed
+	if cleanupOptions.ConfigCleanup && !cleanupOptions.Force {
+		homeDir := v2.HomeDir()
+		if _, err := os.Stat(homeDir); err == nil && !v2.IsHomeDir(homeDir) {
+			return fmt.Errorf("the Helm v2 home folder \"%s\" doesn't look like one, as it has no 'repository' or 'plugins' folder. Set the 'force' flag to remove it all the same", homeDir)
+		}
 	}
 	return nil
 }
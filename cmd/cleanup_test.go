@@ -0,0 +1,476 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	v2chart "k8s.io/helm/pkg/proto/hapi/chart"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// syncBuffer is a buffer which the loggers of concurrent conversions can write to
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+// captureLog returns what the standard logger, and the loggers writing to its output, log while
+// running the function
+func captureLog(run func()) string {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	run()
+	return buf.buf.String()
+}
+
+// deployedRelease returns the deployed version of the Helm v2 release of the name in namespace "default"
+func deployedRelease(name string, version int32) *rls.Release {
+	return &rls.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   version,
+		Chart:     &v2chart.Chart{Metadata: &v2chart.Metadata{Name: "chart", Version: "1.0.0"}},
+		Info:      &rls.Info{Status: &rls.Status{Code: rls.Status_DEPLOYED}, Description: "Install complete"},
+	}
+}
+
+// v2ConfigMap returns the ConfigMap Tiller stores the release version in, in the "kube-system" namespace
+func v2ConfigMap(t *testing.T, v2Release *rls.Release) *corev1.ConfigMap {
+	t.Helper()
+	data, err := proto.Marshal(v2Release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	version := strconv.Itoa(int(v2Release.Version))
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v2Release.Name + ".v" + version,
+			Namespace: "kube-system",
+			Labels:    map[string]string{"NAME": v2Release.Name, "OWNER": "TILLER", "STATUS": v2Release.GetInfo().GetStatus().GetCode().String(), "VERSION": version},
+		},
+		Data: map[string]string{"release": base64.StdEncoding.EncodeToString(compressed.Bytes())},
+	}
+}
+
+// outClusterCleanupOptions returns the options of the cleanup of the named releases from the ConfigMaps
+// of Tiller out of the cluster, with no confirmation
+func outClusterCleanupOptions(releaseNames ...string) CleanupOptions {
+	return CleanupOptions{
+		ReleaseNames:     releaseNames,
+		SkipConfirmation: true,
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+}
+
+func TestCleanupConvertedOnly(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	v2Releases := []*rls.Release{deployedRelease("rel", 1), deployedRelease("legacy", 1), deployedRelease("other", 1)}
+	v2Releases[0].Namespace, v2Releases[1].Namespace = "apps", "apps"
+	objects := []runtime.Object{}
+	for _, v2Release := range v2Releases {
+		objects = append(objects, v2ConfigMap(t, v2Release))
+	}
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset(objects...)}
+	// "rel" is converted into its namespace, and "other" into another namespace than the one of its v2 release
+	for _, v3Release := range []*release.Release{
+		{Name: "rel", Namespace: "apps", Version: 1, Info: &release.Info{Status: release.StatusDeployed}},
+		{Name: "other", Namespace: "tools", Version: 1, Info: &release.Info{Status: release.StatusDeployed}},
+	} {
+		if err := v3.StoreRelease(context.Background(), v3Release, kubeConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cleanupOptions := outClusterCleanupOptions()
+	cleanupOptions.ConvertedOnly = true
+	cleanupOptions.ReleaseCleanup = true
+
+	var result *CleanupResult
+	var err error
+	logged := captureLog(func() {
+		result, err = Cleanup(context.Background(), cleanupOptions, kubeConfig)
+	})
+	if err != nil {
+		t.Fatalf("cleanup failed with error: %s", err)
+	}
+	if !reflect.DeepEqual(result.DeletedVersions, map[string][]int32{"rel": {1}}) {
+		t.Errorf("expected only the converted release to be deleted, got %v", result.DeletedVersions)
+	}
+	for _, name := range []string{"legacy", "other"} {
+		if !strings.Contains(logged, fmt.Sprintf("Release '%s' skipped as it does not exist in Helm v3 storage", name)) {
+			t.Errorf("expected release '%s' to be skipped, got %s", name, logged)
+		}
+		if _, err := kubeConfig.Client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), name+".v1", metav1.GetOptions{}); err != nil {
+			t.Errorf("expected release '%s' to be left in Helm v2 storage, got %v", name, err)
+		}
+	}
+}
+
+func TestCleanupBackupDeletesVersionsBackedUp(t *testing.T) {
+	backupDir, err := ioutil.TempDir("", "helm-2to3-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), v2ConfigMap(t, deployedRelease("rel", 2)))
+	// A release version written once the release versions are listed, e.g. by an upgrade with Helm v2
+	lists := 0
+	client.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if lists == 2 {
+			if err := client.Tracker().Add(v2ConfigMap(t, deployedRelease("rel", 3))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return false, nil, nil
+	})
+	cleanupOptions := outClusterCleanupOptions("rel")
+	cleanupOptions.BackupDir = backupDir
+	cleanupOptions.ReleaseCleanup = true
+
+	var result *CleanupResult
+	captureLog(func() {
+		result, err = Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client})
+	})
+	if err != nil {
+		t.Fatalf("cleanup failed with error: %s", err)
+	}
+	if lists != 1 {
+		t.Errorf("expected the release versions to be listed once, got %d lists", lists)
+	}
+	if !reflect.DeepEqual(result.DeletedVersions, map[string][]int32{"rel": {1, 2}}) {
+		t.Errorf("expected the versions backed up to be deleted, got %v", result.DeletedVersions)
+	}
+	for _, version := range []string{"1", "2"} {
+		if _, err := os.Stat(filepath.Join(backupDir, "rel.v"+version+".gz")); err != nil {
+			t.Errorf("expected rel.v%s to be backed up, got %v", version, err)
+		}
+	}
+}
+
+func TestCleanupBackupAbortedOnRetrievalError(t *testing.T) {
+	backupDir, err := ioutil.TempDir("", "helm-2to3-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), v2ConfigMap(t, deployedRelease("other", 1)))
+	client.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if strings.Contains(action.(k8stesting.ListAction).GetListRestrictions().Labels.String(), "NAME=other") {
+			return true, nil, errors.New("etcdserver: request timed out")
+		}
+		return false, nil, nil
+	})
+	cleanupOptions := outClusterCleanupOptions("rel", "other")
+	cleanupOptions.BackupDir = backupDir
+	cleanupOptions.ReleaseCleanup = true
+
+	var result *CleanupResult
+	captureLog(func() {
+		result, err = Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client})
+	})
+	if err == nil || !strings.Contains(err.Error(), "Cleanup was aborted and nothing was removed") {
+		t.Fatalf("expected the cleanup to be aborted, got %v", err)
+	}
+	if len(result.DeletedVersions) > 0 {
+		t.Errorf("expected nothing to be deleted, got %v", result.DeletedVersions)
+	}
+	if _, err := client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "rel.v1", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected rel.v1 to be left in Helm v2 storage, got %v", err)
+	}
+}
+
+// TestCleanupBackupNamedReleaseWithoutVersions checks that a named release with no release versions is
+// reported by its cleanup, and doesn't abort the backup of the other releases
+func TestCleanupBackupNamedReleaseWithoutVersions(t *testing.T) {
+	backupDir, err := ioutil.TempDir("", "helm-2to3-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)))
+	cleanupOptions := outClusterCleanupOptions("rel", "missing")
+	cleanupOptions.BackupDir = backupDir
+	cleanupOptions.ReleaseCleanup = true
+
+	var result *CleanupResult
+	captureLog(func() {
+		result, err = Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client})
+	})
+	if err == nil {
+		t.Fatal("expected the cleanup of release 'missing' to fail")
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, "rel.v1.gz")); err != nil {
+		t.Errorf("expected rel.v1 to be backed up, got %v", err)
+	}
+	if expected := map[string][]int32{"rel": {1}}; !reflect.DeepEqual(result.DeletedVersions, expected) {
+		t.Errorf("expected the versions %v to be deleted, got %v", expected, result.DeletedVersions)
+	}
+	if !reflect.DeepEqual(result.NoVersionsReleases, []string{"missing"}) {
+		t.Errorf("expected release 'missing' to have no versions, got %v", result.NoVersionsReleases)
+	}
+}
+
+func TestCleanupErrorIdentity(t *testing.T) {
+	corrupt := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken.v1", Namespace: "kube-system", Labels: map[string]string{"NAME": "broken", "OWNER": "TILLER", "VERSION": "1"}},
+		Data:       map[string]string{"release": "not a release"},
+	}
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset(corrupt)}
+	cleanup := func(releaseNames ...string) (*CleanupResult, error) {
+		cleanupOptions := outClusterCleanupOptions(releaseNames...)
+		cleanupOptions.Logger = &commontest.RecordingLogger{}
+		return Cleanup(context.Background(), cleanupOptions, kubeConfig)
+	}
+
+	_, err := cleanup("missing")
+	if !errors.Is(err, v2.ErrNoVersionsFound) {
+		t.Errorf("expected ErrNoVersionsFound for a release with no versions, got %v", err)
+	}
+
+	_, err = cleanup("broken")
+	var corruptErr *v2.CorruptRecordsError
+	if !errors.As(err, &corruptErr) {
+		t.Fatalf("expected a CorruptRecordsError for a release which can't be decoded, got %v", err)
+	}
+	if corruptErr.ReleaseName != "broken" || len(corruptErr.Records) != 1 || corruptErr.Records[0].Name != "broken.v1" {
+		t.Errorf("expected the corrupt record of broken.v1, got %+v", corruptErr)
+	}
+	if !errors.Is(err, v2.ErrCorruptRecord) {
+		t.Errorf("expected ErrCorruptRecord for a release which can't be decoded, got %v", err)
+	}
+
+	// The error of each release is kept apart when several fail
+	result, err := cleanup("broken", "missing")
+	if err == nil {
+		t.Fatal("expected the cleanup of the releases to fail")
+	}
+	if ExitCode(err) != 1 {
+		t.Errorf("expected exit code 1 when all releases failed, got %d", ExitCode(err))
+	}
+	if !reflect.DeepEqual(result.NoVersionsReleases, []string{"missing"}) {
+		t.Errorf("expected only release 'missing' to have no versions, got %v", result.NoVersionsReleases)
+	}
+}
+
+func TestDeleteReleaseVersionsFailingDelete(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rel.v1", Namespace: "kube-system", Labels: map[string]string{"NAME": "rel", "OWNER": "TILLER", "VERSION": "1"}},
+	})
+	deleteErr := errors.New("etcdserver: request timed out")
+	client.PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, deleteErr
+	})
+	logger := &commontest.RecordingLogger{}
+	cleanupOptions := CleanupOptions{
+		Logger:           logger,
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+
+	deleted, err := deleteReleaseVersions(context.Background(), "rel", v2.DeleteOptions{Versions: []int32{1}}, cleanupOptions, common.KubeConfig{Client: client})
+	if !errors.Is(err, deleteErr) {
+		t.Fatalf("expected the error of the delete to be returned, got %v", err)
+	}
+	var deleteError *v2.DeleteError
+	if !errors.As(err, &deleteError) {
+		t.Fatalf("expected a DeleteError, got %T", err)
+	}
+	if remaining := deleteError.Remaining["rel"]; !reflect.DeepEqual(remaining, []int32{1}) {
+		t.Errorf("expected version 1 to remain, got %v", remaining)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected no versions deleted, got %v", deleted)
+	}
+	if logger.Logged("Release 'rel' deleted") {
+		t.Errorf("expected the release not to be logged as deleted, got %v", logger.Lines())
+	}
+	if _, err := client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "rel.v1", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the release version to be left in storage, got %v", err)
+	}
+}
+
+// withStdin runs the function with the standard input replaced by a pipe holding the input, which is
+// not a terminal, and returns the input left unread
+// TestCleanupBulkTimeout checks that the bulk cleanup timing out between batches reports the releases
+// deleted out of all the releases it had to delete
+func TestCleanupBulkTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		v2ConfigMap(t, deployedRelease("a", 1)),
+		v2ConfigMap(t, deployedRelease("b", 1)),
+		v2ConfigMap(t, deployedRelease("b", 2)),
+		v2ConfigMap(t, deployedRelease("c", 1)),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The cleanup times out once the second release version is deleted, in the middle of release "b"
+	deletes := 0
+	timeout := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletes++
+		if deletes == 2 {
+			cancel()
+		}
+		return false, nil, nil
+	}
+	client.PrependReactor("delete", "configmaps", timeout)
+	client.PrependReactor("delete-collection", "configmaps", timeout)
+	cleanupOptions := outClusterCleanupOptions()
+	cleanupOptions.ReleaseCleanup = true
+	cleanupOptions.DeleteBatchSize = 1
+	cleanupOptions.DeleteBatchInterval = time.Millisecond
+
+	var result *CleanupResult
+	var err error
+	captureLog(func() {
+		result, err = Cleanup(ctx, cleanupOptions, common.KubeConfig{Client: client})
+	})
+	if err == nil || !strings.Contains(err.Error(), "cleanup stopped after 1 of 3 releases") {
+		t.Fatalf("expected the cleanup to stop after 1 of 3 releases, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error to wrap the context error, got %v", err)
+	}
+	if _, ok := result.FailedReleases["b"]; !ok {
+		t.Errorf("expected release b to be failed, got %v", result.FailedReleases)
+	}
+	if !reflect.DeepEqual(result.NotStartedReleases, []string{"c"}) {
+		t.Errorf("expected release c not to be started, got %v", result.NotStartedReleases)
+	}
+}
+
+func withStdin(t *testing.T, input string, run func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := writer.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+	run()
+	unread, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(unread)
+}
+
+func TestCleanupDryRunNoConfirmation(t *testing.T) {
+	corrupt := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken.v1", Namespace: "kube-system", Labels: map[string]string{"NAME": "broken", "OWNER": "TILLER", "VERSION": "1"}},
+		Data:       map[string]string{"release": "not a release"},
+	}
+	tests := []struct {
+		name   string
+		modify func(cleanupOptions *CleanupOptions)
+	}{
+		{name: "named release", modify: func(cleanupOptions *CleanupOptions) { cleanupOptions.ReleaseNames = []string{"rel"} }},
+		{name: "named release confirmed by name", modify: func(cleanupOptions *CleanupOptions) {
+			cleanupOptions.ReleaseNames = []string{"rel"}
+			cleanupOptions.ConfirmName = true
+		}},
+		{name: "all releases", modify: func(cleanupOptions *CleanupOptions) { cleanupOptions.ReleaseCleanup = true }},
+		{name: "corrupt records", modify: func(cleanupOptions *CleanupOptions) { cleanupOptions.DeleteCorrupt = true }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), corrupt)
+			var out strings.Builder
+			cleanupOptions := CleanupOptions{
+				ConfirmFromStdin: true,
+				DryRun:           true,
+				Out:              &out,
+				StorageType:      "configmaps",
+				TillerNamespace:  "kube-system",
+				TillerOutCluster: true,
+			}
+			test.modify(&cleanupOptions)
+
+			// An answer read from the standard input would stop the cleanup
+			unread := withStdin(t, "n\n", func() {
+				if _, err := Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client}); err != nil {
+					t.Fatalf("cleanup failed with error: %s", err)
+				}
+			})
+			if unread != "n\n" {
+				t.Errorf("expected nothing to be read from the standard input in dry-run, %q left unread", unread)
+			}
+			if strings.Contains(out.String(), "/confirm]") {
+				t.Errorf("expected no confirmation prompt in dry-run, got %q", out.String())
+			}
+			if !strings.Contains(out.String(), "[dry-run] WARNING: ") {
+				t.Errorf("expected the warning with the dry-run notice, got %q", out.String())
+			}
+			if _, err := client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "rel.v1", metav1.GetOptions{}); err != nil {
+				t.Errorf("expected nothing to be deleted in dry-run, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+// completionTimeout is the maximum time the release names are retrieved for, so that the completion
+// of a shell doesn't hang on a cluster which can't be reached
+const completionTimeout = 2 * time.Second
+
+// flagValues are the allowed values of the flags completed from an enumeration
+var flagValues = map[string][]string{
+	"delete-propagation": {v2.PropagationBackground, v2.PropagationForeground, v2.PropagationOrphan},
+	"output":             {common.OutputTable, common.OutputJSON, common.OutputYAML},
+	"release-storage":    {v2.StorageAuto, "configmaps", "secrets", "sql"},
+	"v3-storage":         {"configmap", "secret", "sql"},
+}
+
+func newCompletionCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "generate the completion script of the plugin binary for the shell",
+		Long: `Generate the completion script of the plugin binary for the shell, e.g. to load it in the current bash shell:
+
+    source <(2to3 completion bash)
+
+The completion of 'helm 2to3' is provided by the completion of Helm itself instead.`,
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("the shell has to be defined: bash, zsh, fish or powershell")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(out)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return root.GenFishCompletion(out, true)
+			case "powershell":
+				return root.GenPowerShellCompletion(out)
+			}
+			return fmt.Errorf("shell \"%s\" is not supported. It can be bash, zsh, fish or powershell", args[0])
+		},
+	}
+	return cmd
+}
+
+// addCompletions registers the completion of the flag values on the command and its subcommands: the
+// enumerations of the flags which have one, and the Helm v2 release names of the 'name' flag of
+// cleanup and of the RELEASE argument of convert and verify
+func addCompletions(cmd *cobra.Command) {
+	for flag, values := range flagValues {
+		if cmd.Flags().Lookup(flag) == nil {
+			continue
+		}
+		values := values
+		cmd.RegisterFlagCompletionFunc(flag, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return values, cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+	switch cmd.Name() {
+	case "cleanup":
+		cmd.RegisterFlagCompletionFunc("name", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeReleaseNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		})
+	case "convert", "verify":
+		cmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeReleaseNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	for _, subCmd := range cmd.Commands() {
+		addCompletions(subCmd)
+	}
+}
+
+// completeReleaseNames returns the names of the Helm v2 releases which start with the prefix, as per
+// the retrieve flags already on the command line. Nothing is returned when the releases can't be
+// retrieved within the completion timeout, e.g. when the cluster can't be reached, as the completion
+// is best effort.
+func completeReleaseNames(prefix string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	settings.SetTillerlessDefaults()
+	settings.SetLabelDefault()
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         settings.Selector,
+		TillerNamespace:  settings.TillerNamespace,
+		TillerLabel:      settings.Label,
+		TillerOutCluster: settings.TillerOutCluster,
+		StorageType:      settings.ReleaseStorage,
+		SQLConnection:    settings.TillerSQLConnection,
+		StorageDir:       settings.TillerStorageDir,
+	}
+	list, err := v2.ListReleases(ctx, retrieveOptions, settings.KubeConfig())
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, release := range list.Releases {
+		if strings.HasPrefix(release.Name, prefix) {
+			names = append(names, release.Name)
+		}
+	}
+	return names
+}
@@ -17,32 +17,246 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
 	v2rel "k8s.io/helm/pkg/proto/hapi/release"
 
 	common "github.com/helm/helm-2to3/pkg/common"
+	metrics "github.com/helm/helm-2to3/pkg/metrics"
 	v2 "github.com/helm/helm-2to3/pkg/v2"
 	v3 "github.com/helm/helm-2to3/pkg/v3"
 )
 
 var (
-	deletev2Releases   bool
-	maxReleaseVersions int
+	allowSameCluster      bool
+	concurrency           int
+	convertAll            bool
+	convertCRDHooks       bool
+	createNamespace       bool
+	deletev2Releases      bool
+	destKubeConfigFile    string
+	destKubeContext       string
+	dryRunDiff            bool
+	failFastConvert       bool
+	forceConvert          bool
+	forceReconvert        bool
+	fromFile              string
+	includeDeletedConvert bool
+	keepVersionNumbers    bool
+	labelResources        bool
+	maxReleaseVersions    int
+	mergeStrategy         string
+	namespaceMapping      map[string]string
+	newName               string
+	noProvenanceLabels    bool
+	noRollbackOnFailure   bool
+	renameTemplate        string
+	reportFile            string
+	skipCorrupt           bool
+	skipOversized         bool
+	skipPending           bool
+	stateFile             string
+	strict                bool
+	stripManifest         bool
+	targetNamespace       string
+	toDir                 string
 )
 
+// ErrReleasePending is returned when a release whose latest version is pending is not converted
+var ErrReleasePending = errors.New("release is pending")
+
+// ErrReleaseConverted is returned when a release which is already converted is skipped
+var ErrReleaseConverted = errors.New("release is already converted")
+
+// ErrChartWarnings is returned when the chart of a release has warnings and the strict flag is set
+var ErrChartWarnings = errors.New("chart has warnings")
+
+// ErrManifestDiff is returned when the manifest of a release differs from the manifest of its Helm v3
+// release, with the dry-run-diff flag
+var ErrManifestDiff = errors.New("manifest differs")
+
+// ConvertHook mutates a Helm v3 release version as it is converted, e.g. to adjust its values or strip a
+// deprecated annotation. It is called once the release version is mapped from the Helm v2 release version,
+// including in dry-run, and before it is stored. An error aborts the conversion of the release, before any
+// of its release versions is stored.
+type ConvertHook func(rel *release.Release) error
+
 type ConvertOptions struct {
-	DeleteRelease      bool
-	DryRun             bool
+	AllowSameCluster bool
+	Concurrency      int
+	ConvertCRDHooks  bool
+	CreateNamespace  bool
+	DeleteRelease    bool
+	DestKubeConfig   *common.KubeConfig
+	DryRun           bool
+	// DryRunDiff diffs the manifest of the deployed version of the release, as Tiller last applied it,
+	// with the manifest of its Helm v3 release version, in dry-run only
+	DryRunDiff bool
+	// Exclude are the names of the releases skipped when all releases are converted
+	Exclude     []string
+	FailFast    bool
+	FailOnEmpty bool
+	// Force deletes the v2 release versions of a release converted under a new name, merges a Helm v3
+	// release of the same name which already exists, and converts release records of unsupported formats
+	Force          bool
+	ForceReconvert bool
+	FromFile       string
+	// Hooks are called in order on each Helm v3 release version converted, as per ConvertHook.
+	// They are only available to the library consumers, not to the command line.
+	Hooks          []ConvertHook
+	IncludeDeleted bool
+	// KeepVersionNumbers keeps the Helm v2 version numbers as the Helm v3 revisions, instead of
+	// renumbering the release versions converted from 1
+	KeepVersionNumbers bool
+	LabelResources     bool
+	// Logger logs the progress of the conversion. Defaults to the standard logger.
+	Logger             common.Logger
 	MaxReleaseVersions int
-	ReleaseName        string
-	StorageType        string
-	TillerLabel        string
-	TillerNamespace    string
-	TillerOutCluster   bool
+	MergeStrategy      string
+	// Metrics records the releases converted and failed, and the Helm v2 release versions deleted
+	Metrics metrics.Recorder
+	// NamePattern is the glob pattern, or NameRegex the regular expression, the names of the releases
+	// converted when all releases are converted have to match
+	NamePattern         string
+	NameRegex           string
+	NamespaceMapping    map[string]string
+	NewName             string
+	NoProvenanceLabels  bool
+	NoRollbackOnFailure bool
+	// ObjectDeletion is the propagation policy and grace period of the deletions of the Helm v2 storage objects
+	ObjectDeletion v2.ObjectDeletion
+	// Progress is notified of each release processed when all releases are converted
+	Progress       common.Progress
+	ReleaseName    string
+	RenameTemplate string
+	ReportFile     string
+	Selector       string
+	// SkipCorrupt converts the release versions of a release which can be decoded, skipping the storage
+	// objects whose release can't be, instead of failing the conversion of the release
+	SkipCorrupt   bool
+	SkipOversized bool
+	SkipPending   bool
+	StateFile     string
+	StorageType   string
+	// Strict fails the conversion of a release whose chart has warnings, as per v3.CheckChart, instead of
+	// converting it with the warnings logged
+	Strict              bool
+	StripManifest       bool
+	TargetNamespace     string
+	TillerLabel         string
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+	// ValueOverrides are merged into the values of the deployed version of a single release converted, as
+	// with 'helm upgrade --set'. The manifest is not rendered again, only the values stored change.
+	ValueOverrides map[string]interface{}
+	ToDir          string
+
+	// logPrefix prefixes the log lines of the conversion of a release
+	logPrefix string
+	// destChecked is set when the destination cluster has already been checked
+	destChecked bool
+	// tillerChecked is set when the version of the Tiller in the cluster has already been checked
+	tillerChecked bool
+	// indexDeferred is set when the manifest index is written once all releases are converted
+	indexDeferred bool
+	// skipConverted is set when a release already converted with the same release versions is skipped
+	skipConverted bool
+}
+
+// logger returns the logger for the conversion of a release: the logger of the options, or the
+// default logger when not set, with the log prefix
+func (convertOptions ConvertOptions) logger() common.Logger {
+	return common.WithPrefix(common.LoggerOrDefault(convertOptions.Logger), convertOptions.logPrefix)
+}
+
+// v3KubeConfig returns the kube config of the cluster the Helm v3 releases are created in: the
+// destination cluster when set, otherwise the cluster the Helm v2 releases are read from
+func (convertOptions ConvertOptions) v3KubeConfig(kubeConfig common.KubeConfig) common.KubeConfig {
+	if convertOptions.DestKubeConfig != nil {
+		return *convertOptions.DestKubeConfig
+	}
+	return kubeConfig
+}
+
+// v3ReleaseName returns the name of the Helm v3 release of a Helm v2 release: the new name when
+// set, otherwise as per its latest version and the rename template. It is the name of the Helm v2
+// release if neither is set.
+func (convertOptions ConvertOptions) v3ReleaseName(v2Release *v2rel.Release) (string, error) {
+	if convertOptions.NewName != "" {
+		return convertOptions.NewName, nil
+	}
+	tmpl, err := parseRenameTemplate(convertOptions.RenameTemplate)
+	if err != nil || tmpl == nil {
+		return v2Release.Name, err
+	}
+	var name strings.Builder
+	err = tmpl.Execute(&name, struct {
+		Release         string
+		Namespace       string
+		TillerNamespace string
+	}{
+		Release:         v2Release.Name,
+		Namespace:       v2Release.Namespace,
+		TillerNamespace: convertOptions.tillerNamespace(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("rename template failed for release \"%s\" with error: %s", v2Release.Name, err)
+	}
+	if err := validateReleaseName(name.String()); err != nil {
+		return "", fmt.Errorf("rename template gives invalid name \"%s\" for release \"%s\": %s", name.String(), v2Release.Name, err)
+	}
+	return name.String(), nil
+}
+
+// releaseNameMaxLen is the maximum length of a Helm v3 release name
+const releaseNameMaxLen = 53
+
+// validateReleaseName checks that the name is a valid Helm v3 release name, as Helm v3 install does
+func validateReleaseName(name string) error {
+	if name == "" {
+		return errors.New("no name provided")
+	}
+	if len(name) > releaseNameMaxLen {
+		return fmt.Errorf("name exceeds max length of %d", releaseNameMaxLen)
+	}
+	if !action.ValidName.MatchString(name) {
+		return errors.New("name must consist of lower case alphanumeric characters, '-' or '.'")
+	}
+	return nil
+}
+
+// tillerNamespace returns the Tiller namespace the Helm v2 releases are read from
+func (convertOptions ConvertOptions) tillerNamespace() string {
+	if convertOptions.TillerNamespace == "" {
+		return "kube-system"
+	}
+	return convertOptions.TillerNamespace
+}
+
+// targetNamespace returns the namespace the Helm v3 release of a Helm v2 release deployed into the
+// namespace is created in, as per the target namespace and namespace mapping
+func (convertOptions ConvertOptions) targetNamespace(namespace string) string {
+	if convertOptions.TargetNamespace != "" {
+		return convertOptions.TargetNamespace
+	}
+	if mapped, ok := convertOptions.NamespaceMapping[namespace]; ok {
+		return mapped
+	}
+	return namespace
 }
 
 func newConvertCmd(out io.Writer) *cobra.Command {
@@ -50,136 +264,1742 @@ func newConvertCmd(out io.Writer) *cobra.Command {
 		Use:   "convert [flags] RELEASE",
 		Short: "migrate Helm v2 release in-place to Helm v3",
 		Args: func(cmd *cobra.Command, args []string) error {
+			if convertAll {
+				if len(args) > 0 {
+					return errors.New("name of release to be converted cannot be defined when the --all flag is set")
+				}
+				return nil
+			}
 			if len(args) != 1 {
 				return errors.New("name of release to be converted has to be defined")
 			}
 			return nil
 		},
 
-		RunE: runConvert,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateConvertFlags()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConvert(cmd, args, out)
+		},
 	}
 
 	flags := cmd.Flags()
 	settings.AddFlags(flags)
+	settings.AddOutputFlag(flags)
+	settings.AddFailOnEmptyFlag(flags)
+	settings.AddProgressFlag(flags)
+	settings.AddRetryFlags(flags)
+	settings.AddAuditLogFlag(flags)
+	settings.AddV3StorageFlags(flags)
+	addExcludeFlags(flags, "when the --all flag is set")
+	addNamePatternFlags(flags, "converted when the --all flag is set")
+	addValueOverrideFlags(flags)
+	addMetricsFlags(flags)
+	addDeletionFlags(flags, "the Helm v2 storage objects by the 'delete-v2-releases' flag", "the policy of the API server is used")
 
+	flags.BoolVar(&convertAll, "all", false, "if set, all Helm v2 releases are converted. Cannot be used with a release name")
+	flags.BoolVar(&allowSameCluster, "allow-same-cluster", false, "if set, the destination cluster can be the cluster the Helm v2 releases are read from")
+	flags.IntVar(&concurrency, "concurrency", 1, "number of releases converted concurrently when the --all flag is set")
+	flags.BoolVar(&convertCRDHooks, "convert-crd-hooks", false, "if set, the manifests of the crd-install hooks, which Helm v3 does not support, are moved to the manifest of the release. By default, the crd-install hooks are dropped with a warning")
+	flags.BoolVar(&createNamespace, "create-namespace", false, "if set, the namespace the Helm v3 release is created in is created if it does not exist")
 	flags.BoolVar(&deletev2Releases, "delete-v2-releases", false, "v2 release versions are deleted after migration. By default, the v2 release versions are retained")
+	flags.StringVar(&destKubeConfigFile, "dest-kubeconfig", "", "path to the kubeconfig file of the cluster the Helm v3 releases are created in, when it differs from the cluster the Helm v2 releases are read from")
+	flags.BoolVar(&dryRunDiff, "dry-run-diff", false, "if set, the conversion is simulated as with --dry-run, and the manifest of the deployed version of each release, as Tiller last applied it, is diffed with the manifest Helm v3 would track. The command exits with code 4 when a manifest differs")
+	flags.StringVar(&destKubeContext, "dest-kube-context", "", "name of the kubeconfig context of the cluster the Helm v3 releases are created in, when it differs from the cluster the Helm v2 releases are read from")
+	flags.BoolVar(&failFastConvert, "fail-fast", false, "if set, conversion of all releases stops at the first release which fails to convert")
+	flags.BoolVar(&forceConvert, "force", false, "if set, the v2 release versions are deleted after migration even when the release is converted under a new name, a Helm v3 release of the same name which already exists is merged with the release converted as per the merge strategy, and the release records written by a Tiller older than v2.7 are converted")
+	flags.BoolVar(&forceReconvert, "force-reconvert", false, "if set, the releases already converted are converted again, their Helm v3 release being replaced, instead of being skipped as per Helm v3 storage and the state file")
+	flags.StringVar(&fromFile, "from-file", "", "path of a YAML or JSON file of the Helm v2 release ConfigMaps or Secrets exported with 'kubectl get -o yaml', which the releases are read from instead of the cluster")
+	flags.BoolVar(&includeDeletedConvert, "include-deleted", false, "if set, the releases deleted with their history kept are converted into uninstalled Helm v3 releases when the --all flag is set. By default, they are skipped")
+	flags.BoolVar(&keepVersionNumbers, "keep-version-numbers", false, "if set, the Helm v2 version numbers are kept as the Helm v3 revisions, e.g. to match the history audited with Helm v2. By default, the release versions converted are renumbered from 1 in the order they were released, so that the revisions are contiguous when versions were purged or dropped by the --release-versions-max flag")
+	flags.BoolVar(&labelResources, "label-resources", false, "if set, the resources of the deployed release version are labelled and annotated with the Helm v3 ownership metadata, so that Helm v3 adopts them on upgrade. Resources which no longer exist are skipped with a warning")
+	flags.StringVar(&mergeStrategy, "merge-strategy", "append", "how a Helm v3 release of the same name which already exists is merged with the release converted when the force flag is set: 'replace' replaces its release versions, 'append' appends the release versions converted after its latest version")
+	flags.StringToStringVar(&namespaceMapping, "namespace-mapping", map[string]string{}, "namespaces the Helm v3 releases are created in instead of the namespaces the Helm v2 releases are deployed into, as a comma-separated list of old=new pairs (e.g. dev=development,prod=production)")
+	flags.StringVar(&newName, "new-name", "", "name of the Helm v3 release, when it differs from the name of the Helm v2 release. Cannot be used with the --all flag")
+	flags.BoolVar(&noProvenanceLabels, "no-provenance-labels", false, "if set, the Helm v3 release storage objects are not labelled and annotated as converted by the plugin")
+	flags.BoolVar(&noRollbackOnFailure, "no-rollback-on-failure", false, "if set, the Helm v3 release versions created are kept, and the existing release versions replaced or superseded are not restored, when the conversion of a release fails mid-way, e.g. to inspect them. By default, they are rolled back")
 	flags.IntVar(&maxReleaseVersions, "release-versions-max", 10, "limit the maximum number of versions converted per release. Use 0 for no limit")
+	flags.StringVar(&renameTemplate, "rename-template", "", "template of the name of the Helm v3 release, e.g. '{{.Release}}-{{.TillerNamespace}}' to tell apart releases of the same name managed by different Tillers. The fields are .Release, .Namespace and .TillerNamespace")
+	flags.StringVar(&reportFile, "report", "", "path of the file the report of the conversion is written to when the --all flag is set, in JSON or YAML as per its extension (.json, .yaml or .yml)")
+	flags.BoolVar(&skipCorrupt, "skip-corrupt", false, "if set, the Helm v2 storage objects whose release can't be decoded, e.g. truncated by a backup and restore, are skipped with a warning, the other versions of the release being converted. By default, the conversion of the release fails. They can be deleted with 'helm 2to3 cleanup --delete-corrupt'")
+	flags.BoolVar(&skipOversized, "skip-oversized", false, "if set, the release versions which are over the 1MiB size limit of Kubernetes objects once encoded are skipped with a warning, the other versions of the release being converted. The deployed version can't be skipped")
+	flags.BoolVar(&skipPending, "skip-pending", false, "if set, releases whose latest version is pending (PENDING_INSTALL, PENDING_UPGRADE or PENDING_ROLLBACK) are not converted")
+	flags.StringVar(&stateFile, "state-file", "", "path of the file recording the releases converted when the --all flag is set, so that a conversion run again resumes where it left off. It is created if it does not exist")
+	flags.BoolVar(&strict, "strict", false, "if set, the releases whose chart has warnings, e.g. templates using built-in objects Helm v3 removed, fail to convert. By default, the warnings are logged and the releases are converted")
+	flags.BoolVar(&stripManifest, "strip-manifest-from-history", false, "if set, the rendered manifest is dropped from the historical release versions which are not deployed, when they are over the 1MiB size limit of Kubernetes objects once encoded")
+	flags.StringVar(&targetNamespace, "target-namespace", "", "namespace the Helm v3 release is created in instead of the namespace the Helm v2 release is deployed into. Cannot be used with the --all flag")
+	flags.StringVar(&toDir, "to-dir", "", "directory the Helm v3 release storage objects are written to as manifest files, one per namespace and release version, to be applied with 'kubectl apply -R -f', instead of being created in the cluster")
 
 	return cmd
 
 }
 
-func runConvert(cmd *cobra.Command, args []string) error {
-	releaseName := args[0]
-	if settings.ReleaseStorage != "configmaps" && settings.ReleaseStorage != "secrets" {
-		return errors.New("release-storage flag needs to be 'configmaps' or 'secrets'")
+// validateConvertFlags checks the flags of the convert command and their combinations, before any
+// release is converted
+func validateConvertFlags() error {
+	if convertAll && targetNamespace != "" {
+		return errors.New("target-namespace flag cannot be used with the --all flag. Use the namespace-mapping flag instead")
+	}
+	if _, err := parseRenameTemplate(renameTemplate); err != nil {
+		return err
+	}
+	if newName != "" {
+		if convertAll {
+			return errors.New("new-name flag cannot be used with the --all flag. Use the rename-template flag instead")
+		}
+		if renameTemplate != "" {
+			return errors.New("new-name flag cannot be used with the rename-template flag")
+		}
+	}
+	for oldNamespace, newNamespace := range namespaceMapping {
+		if oldNamespace == "" || newNamespace == "" {
+			return fmt.Errorf("invalid namespace mapping \"%s=%s\": namespaces can't be empty", oldNamespace, newNamespace)
+		}
+	}
+	if concurrency < 1 {
+		return errors.New("concurrency flag needs to be at least 1")
+	}
+	if mergeStrategy != "replace" && mergeStrategy != "append" {
+		return errors.New("merge-strategy flag needs to be 'replace' or 'append'")
+	}
+	if reportFile != "" && !convertAll {
+		return errors.New("report flag can only be used with the --all flag")
+	}
+	if stateFile != "" && !convertAll {
+		return errors.New("state-file flag can only be used with the --all flag")
+	}
+	if settings.FailOnEmpty && !convertAll {
+		return errors.New("fail-on-empty flag can only be used with the --all flag")
 	}
+	if (len(excludeReleases) > 0 || excludeFile != "") && !convertAll {
+		return errors.New("exclude and exclude-file flags can only be used with the --all flag. Pass the name of the release to convert instead")
+	}
+	if (len(setValues) > 0 || len(valueFiles) > 0) && convertAll {
+		return errors.New("set and values flags cannot be used with the --all flag, as the values are specific to a release")
+	}
+	if (namePattern != "" || nameRegex != "") && !convertAll {
+		return errors.New("name-pattern and name-regex flags can only be used with the --all flag, which then converts the matching releases only")
+	}
+	if _, err := compileNameMatcher(namePattern, nameRegex); err != nil {
+		return err
+	}
+	if err := validateReportFile(reportFile); err != nil {
+		return err
+	}
+	if err := validateMetricsFlags(); err != nil {
+		return err
+	}
+	if err := validateDeletionFlags(); err != nil {
+		return err
+	}
+	if fromFile != "" && deletev2Releases {
+		return errors.New("delete-v2-releases flag cannot be used with the from-file flag, as release versions can't be deleted from an export file")
+	}
+	if toDir != "" {
+		if deletev2Releases {
+			return errors.New("delete-v2-releases flag cannot be used with the to-dir flag. Remove the v2 releases with cleanup once the manifests are applied")
+		}
+		if createNamespace {
+			return errors.New("create-namespace flag cannot be used with the to-dir flag")
+		}
+		if destKubeConfigFile != "" || destKubeContext != "" {
+			return errors.New("dest-kubeconfig and dest-kube-context flags cannot be used with the to-dir flag")
+		}
+		if labelResources {
+			return errors.New("label-resources flag cannot be used with the to-dir flag")
+		}
+	}
+	return nil
+}
+
+func runConvert(cmd *cobra.Command, args []string, out io.Writer) error {
+	var releaseName string
+	if !convertAll {
+		releaseName = args[0]
+	}
+	if err := settings.SetV3Storage(); err != nil {
+		return err
+	}
+	exclude, err := excludedReleases()
+	if err != nil {
+		return err
+	}
+	overrides, err := valueOverrides()
+	if err != nil {
+		return err
+	}
+	registry := newMetrics("convert")
 	convertOptions := ConvertOptions{
-		DeleteRelease:      deletev2Releases,
-		DryRun:             settings.DryRun,
-		MaxReleaseVersions: maxReleaseVersions,
-		ReleaseName:        releaseName,
-		StorageType:        settings.ReleaseStorage,
-		TillerLabel:        settings.Label,
-		TillerNamespace:    settings.TillerNamespace,
-		TillerOutCluster:   settings.TillerOutCluster,
+		AllowSameCluster:    allowSameCluster,
+		Concurrency:         concurrency,
+		ConvertCRDHooks:     convertCRDHooks,
+		CreateNamespace:     createNamespace,
+		DeleteRelease:       deletev2Releases,
+		DryRun:              settings.DryRun || dryRunDiff,
+		DryRunDiff:          dryRunDiff,
+		Exclude:             exclude,
+		FailFast:            failFastConvert,
+		FailOnEmpty:         settings.FailOnEmpty,
+		Force:               forceConvert,
+		ForceReconvert:      forceReconvert,
+		FromFile:            fromFile,
+		IncludeDeleted:      includeDeletedConvert,
+		KeepVersionNumbers:  keepVersionNumbers,
+		LabelResources:      labelResources,
+		MaxReleaseVersions:  maxReleaseVersions,
+		MergeStrategy:       mergeStrategy,
+		Metrics:             registry,
+		NamePattern:         namePattern,
+		NameRegex:           nameRegex,
+		NamespaceMapping:    namespaceMapping,
+		NewName:             newName,
+		NoProvenanceLabels:  noProvenanceLabels,
+		NoRollbackOnFailure: noRollbackOnFailure,
+		ObjectDeletion:      objectDeletion(),
+		Progress:            newProgress("releases processed"),
+		ReleaseName:         releaseName,
+		RenameTemplate:      renameTemplate,
+		ReportFile:          reportFile,
+		Selector:            settings.Selector,
+		SkipCorrupt:         skipCorrupt,
+		SkipOversized:       skipOversized,
+		SkipPending:         skipPending,
+		StateFile:           stateFile,
+		StorageType:         settings.ReleaseStorage,
+		Strict:              strict,
+		StripManifest:       stripManifest,
+		TargetNamespace:     targetNamespace,
+		TillerLabel:         settings.Label,
+		TillerNamespace:     settings.TillerNamespace,
+		TillerOutCluster:    settings.TillerOutCluster,
+		TillerSQLConnection: settings.TillerSQLConnection,
+		TillerStorageDir:    settings.TillerStorageDir,
+		ToDir:               toDir,
+		ValueOverrides:      overrides,
+	}
+	convertOptions.Progress = withMetrics(convertOptions.Progress, registry, convertOptions.logger())
+	kubeConfig := settings.KubeConfig()
+	if destKubeConfigFile != "" || destKubeContext != "" {
+		// The destination cluster shares the client rate limits, but not the in-cluster configuration,
+		// the API server flags nor the impersonation, which are specific to the source cluster
+		destKubeConfig := common.KubeConfig{
+			Context: destKubeContext,
+			File:    destKubeConfigFile,
+			QPS:     kubeConfig.QPS,
+			Burst:   kubeConfig.Burst,
+		}
+		convertOptions.DestKubeConfig = &destKubeConfig
+	}
+
+	ctx, cancel := settings.WithTimeout(cmd.Context())
+	defer cancel()
+	ctx = common.WithRetryOptions(ctx, settings.RetryOptions())
+	// The releases of an export file are not read from the Tiller namespace
+	if fromFile == "" {
+		if err := settings.CheckTillerNamespace(ctx); err != nil {
+			return err
+		}
+	}
+	defer writeMetrics(ctx, registry, convertOptions.logger())
+
+	// The report is the result of the conversion of all releases
+	if convertAll {
+		report, err := ConvertAllReport(ctx, convertOptions, kubeConfig)
+		if printErr := common.PrintOutput(out, settings.Output, report); printErr != nil && err == nil {
+			err = printErr
+		}
+		return err
+	}
+	result, err := convertRelease(ctx, convertOptions, kubeConfig)
+	recordConvertOutcome(convertOptions.Metrics, err)
+	if err == nil && result.ManifestDiff != "" {
+		err = fmt.Errorf("%w: the manifest of release \"%s\" differs from the manifest of its Helm v3 release", ErrManifestDiff, releaseName)
+	}
+	if result != nil {
+		if printErr := common.PrintOutput(out, settings.Output, result); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// ConvertAll converts all Helm 2 releases stored for the Tiller namespace and label into Helm 3 releases.
+// Each release is converted as per Convert, by a pool of as many workers as the concurrency. A release
+// which fails to convert does not stop the remaining releases from being converted, unless fail fast is
+// set, but an error is returned if any release failed. The report of the conversion is written to the
+// report file when set, also when the conversion ends in error.
+func ConvertAll(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	_, err := ConvertAllReport(ctx, convertOptions, kubeConfig)
+	return err
+}
+
+// ConvertAllReport converts all Helm 2 releases as per ConvertAll, and returns the report of the
+// conversion, also when it ends in error
+func ConvertAllReport(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig) (*Report, error) {
+	report := newReport("convert", convertOptions.DryRun)
+	err := convertAllReleases(ctx, convertOptions, kubeConfig, report)
+	if convertOptions.ReportFile == "" {
+		completeReport(report, err)
+		return report, err
+	}
+	return report, finishReport(convertOptions.ReportFile, report, err, convertOptions.logger())
+}
+
+// convertAllReleases converts all Helm 2 releases as per ConvertAll, and adds their outcome to the report
+func convertAllReleases(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig, report *Report) error {
+	logger := convertOptions.logger()
+	if err := v2.ValidateSelector(convertOptions.Selector); err != nil {
+		return err
+	}
+	if len(convertOptions.ValueOverrides) > 0 {
+		return errors.New("value overrides can only be set when a single release is converted")
+	}
+	matcher, err := compileNameMatcher(convertOptions.NamePattern, convertOptions.NameRegex)
+	if err != nil {
+		return err
+	}
+	if err := checkDestCluster(convertOptions, kubeConfig); err != nil {
+		return err
+	}
+	convertOptions.destChecked = true
+	if err := checkTillerFormat(ctx, convertOptions, kubeConfig); err != nil {
+		return err
+	}
+	convertOptions.tillerChecked = true
+	convertOptions.indexDeferred = true
+	convertOptions.skipConverted = !convertOptions.ForceReconvert
+
+	var state *convertState
+	if convertOptions.StateFile != "" {
+		var err error
+		state, err = loadConvertState(convertOptions.StateFile, convertOptions.tillerNamespace())
+		if err != nil {
+			return err
+		}
+	}
+
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         convertOptions.Selector,
+		TillerNamespace:  convertOptions.TillerNamespace,
+		TillerLabel:      convertOptions.TillerLabel,
+		TillerOutCluster: convertOptions.TillerOutCluster,
+		StorageType:      convertOptions.StorageType,
+		SQLConnection:    convertOptions.TillerSQLConnection,
+		StorageDir:       convertOptions.TillerStorageDir,
+		File:             convertOptions.FromFile,
+		Logger:           convertOptions.logger(),
+	}
+	list, err := v2.ListReleases(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, summary := range list.Releases {
+		names = append(names, summary.Name)
+	}
+	_, excluded := excludeReleaseNames(names, convertOptions.Exclude)
+	logExcludedReleases(logger, convertOptions.Exclude, excluded)
+	isExcluded := map[string]bool{}
+	for _, releaseName := range excluded {
+		isExcluded[releaseName] = true
+	}
+	isMatched := map[string]bool{}
+	for _, releaseName := range matchReleaseNames(names, matcher) {
+		isMatched[releaseName] = true
 	}
-	kubeConfig := common.KubeConfig{
-		Context: settings.KubeContext,
-		File:    settings.KubeConfigFile,
+	releaseNames := []string{}
+	deleted := []string{}
+	for _, summary := range list.Releases {
+		if isExcluded[summary.Name] || !isMatched[summary.Name] {
+			continue
+		}
+		if summary.Status == v2rel.Status_DELETED.String() && !convertOptions.IncludeDeleted {
+			deleted = append(deleted, summary.Name)
+			continue
+		}
+		releaseNames = append(releaseNames, summary.Name)
+	}
+	if len(deleted) > 0 {
+		logger.Infof("[Helm 2] Releases skipped as they were deleted with their history kept. Set the 'include-deleted' flag to convert them: %s\n", strings.Join(deleted, ", "))
+	}
+	if len(releaseNames) <= 0 && matcher != nil {
+		if convertOptions.FailOnEmpty {
+			return fmt.Errorf("%w: [Helm 2] no releases matching name %s", common.ErrNothingFound, namePatternDescription(convertOptions.NamePattern, convertOptions.NameRegex))
+		}
+		logger.Warnf("[Helm 2] no releases matching name %s. Nothing was converted.\n", namePatternDescription(convertOptions.NamePattern, convertOptions.NameRegex))
+		return nil
+	}
+	if len(releaseNames) <= 0 {
+		if convertOptions.FailOnEmpty {
+			return fmt.Errorf("%w: [Helm 2] no deployed releases for namespace: %s, owner: %s", common.ErrNothingFound, convertOptions.TillerNamespace, convertOptions.TillerLabel)
+		}
+		logger.Infof("[Helm 2] no deployed releases for namespace: %s, owner: %s\n", convertOptions.TillerNamespace, convertOptions.TillerLabel)
+		return nil
+	}
+
+	if convertOptions.Selector != "" {
+		logger.Infof("[Helm 2] Releases matching selector \"%s\": %s\n", convertOptions.Selector, strings.Join(releaseNames, ", "))
+	}
+	if matcher != nil {
+		logger.Infof("[Helm 2] Releases matching name %s: %s\n", namePatternDescription(convertOptions.NamePattern, convertOptions.NameRegex), strings.Join(releaseNames, ", "))
+	}
+	logger.Infof("%d releases will be converted from Helm v2 to Helm v3.\n", len(releaseNames))
+
+	concurrency := convertOptions.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// Cancelled on the first failure when fail fast is set, to stop the other workers
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	releaseReports := map[string]ReleaseReport{}
+	converted := map[string]bool{}
+	failed := map[string]error{}
+	pending := map[string]bool{}
+	alreadyConverted := map[string]bool{}
+	chartWarnings := map[string][]string{}
+	corruptRecords := map[string][]v2.CorruptRecord{}
+	manifestDiffs := map[string]bool{}
+	queue := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for releaseName := range queue {
+				releaseOptions := convertOptions
+				releaseOptions.ReleaseName = releaseName
+				if concurrency > 1 {
+					// Prefix the log lines of each release, as the releases are converted concurrently
+					releaseOptions.logPrefix = fmt.Sprintf("[%s] ", releaseName)
+				} else {
+					logger.Infof("")
+				}
+				started := time.Now()
+				result, err := convertRelease(workerCtx, releaseOptions, kubeConfig)
+				recordConvertOutcome(convertOptions.Metrics, err)
+				releaseReport := ReleaseReport{
+					Name:     releaseName,
+					Result:   ReportConverted,
+					Versions: []int32{},
+					Duration: formatDuration(time.Since(started)),
+				}
+				mutex.Lock()
+				converted[releaseName] = true
+				if result != nil && len(result.Warnings) > 0 {
+					releaseReport.Warnings = result.Warnings
+					chartWarnings[releaseName] = result.Warnings
+				}
+				if result != nil {
+					releaseReport.Objects = result.Objects
+					releaseReport.Corrupt = result.Corrupt
+					releaseReport.ManifestDiff = result.ManifestDiff
+					manifestDiffs[releaseName] = result.ManifestDiff != ""
+				}
+				var corruptErr *v2.CorruptRecordsError
+				if errors.As(err, &corruptErr) {
+					releaseReport.Corrupt = corruptErr.Records
+				}
+				if len(releaseReport.Corrupt) > 0 {
+					corruptRecords[releaseName] = releaseReport.Corrupt
+				}
+				if errors.Is(err, ErrReleasePending) {
+					logger.Infof("Release \"%s\" skipped: %s\n", releaseName, err)
+					pending[releaseName] = true
+					releaseReport.Result, releaseReport.Reason, releaseReport.Error = ReportSkipped, "pending", err.Error()
+				} else if errors.Is(err, ErrReleaseConverted) {
+					logger.Infof("Release \"%s\" skipped: %s\n", releaseName, err)
+					alreadyConverted[releaseName] = true
+					releaseReport.Result, releaseReport.Reason = ReportSkipped, "already converted"
+					releaseReport.Versions, releaseReport.Namespace = result.Versions, result.Namespace
+				} else if err != nil {
+					logger.Infof("Release \"%s\" failed to convert with error: %s\n", releaseName, err)
+					failed[releaseName] = err
+					releaseReport.Result, releaseReport.Error = ReportFailed, err.Error()
+					if convertOptions.FailFast {
+						cancel()
+					}
+				} else {
+					releaseReport.Versions = result.Versions
+					releaseReport.Namespace = result.Namespace
+					if result.Name != releaseName {
+						releaseReport.NewName = result.Name
+					}
+				}
+				if state != nil && !convertOptions.DryRun && (err == nil || errors.Is(err, ErrReleaseConverted)) {
+					if err := state.addConverted(releaseName); err != nil {
+						logger.Warnf("Release \"%s\" was not recorded as converted: %s\n", releaseName, err)
+					}
+				}
+				releaseReports[releaseName] = releaseReport
+				common.UpdateProgress(convertOptions.Progress, releaseName, len(releaseReports), len(releaseNames))
+				mutex.Unlock()
+			}
+		}()
+	}
+queueing:
+	for _, releaseName := range releaseNames {
+		// The releases recorded as converted by a previous run are skipped, unless reconverted
+		if state != nil && !convertOptions.ForceReconvert && state.isConverted(releaseName) {
+			logger.Infof("Release \"%s\" skipped: already converted as per state file \"%s\"\n", releaseName, convertOptions.StateFile)
+			mutex.Lock()
+			converted[releaseName] = true
+			alreadyConverted[releaseName] = true
+			releaseReports[releaseName] = ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "already converted as per state file"}
+			mutex.Unlock()
+			continue
+		}
+		select {
+		case queue <- releaseName:
+		case <-workerCtx.Done():
+			break queueing
+		}
+	}
+	close(queue)
+	workers.Wait()
+
+	for _, releaseName := range releaseNames {
+		releaseReport, ok := releaseReports[releaseName]
+		if !ok {
+			releaseReport = ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "not started"}
+		}
+		report.Releases = append(report.Releases, releaseReport)
+	}
+	for _, releaseName := range deleted {
+		report.Releases = append(report.Releases, ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "deleted"})
+	}
+	for _, releaseName := range excluded {
+		report.Releases = append(report.Releases, ReleaseReport{Name: releaseName, Result: ReportSkipped, Versions: []int32{}, Reason: "excluded"})
+	}
+
+	// The index of the manifest files is written once, for the releases written by all the workers
+	if convertOptions.ToDir != "" && !convertOptions.DryRun && len(converted) > len(failed)+len(pending)+len(alreadyConverted) {
+		if err := writeManifestIndex(convertOptions.ToDir, logger); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("")
+	logger.Infof("Conversion summary:")
+	for _, releaseName := range releaseNames {
+		if err, ok := failed[releaseName]; ok {
+			logger.Infof("  %s: failed: %s\n", releaseName, err)
+		} else if pending[releaseName] {
+			logger.Infof("  %s: skipped: pending\n", releaseName)
+		} else if alreadyConverted[releaseName] {
+			logger.Infof("  %s: skipped: already converted\n", releaseName)
+		} else if converted[releaseName] {
+			logger.Infof("  %s: succeeded\n", releaseName)
+		} else if ctx.Err() != nil {
+			logger.Infof("  %s: skipped: not started\n", releaseName)
+		} else {
+			logger.Infof("  %s: skipped\n", releaseName)
+		}
+	}
+	for _, releaseName := range excluded {
+		logger.Infof("  %s: skipped (excluded)\n", releaseName)
+	}
+	if len(chartWarnings) > 0 {
+		logger.Infof("Chart warnings:")
+		for _, releaseName := range releaseNames {
+			for _, warning := range chartWarnings[releaseName] {
+				logger.Infof("  %s: %s\n", releaseName, warning)
+			}
+		}
+	}
+	if len(corruptRecords) > 0 {
+		logger.Infof("Corrupt release records:")
+		for _, releaseName := range releaseNames {
+			for _, record := range corruptRecords[releaseName] {
+				outcome := "skipped"
+				if _, ok := failed[releaseName]; ok {
+					outcome = "failed the release"
+				}
+				logger.Infof("  %s: %s: %s: %s\n", releaseName, record, outcome, record.Error)
+			}
+		}
+	}
+	skipped := len(releaseNames) - len(converted) + len(pending) + len(alreadyConverted) + len(excluded)
+	succeeded := len(converted) - len(failed) - len(pending) - len(alreadyConverted)
+	if skipped > 0 {
+		logger.Infof("%d succeeded, %d failed, %d skipped.\n", succeeded, len(failed), skipped)
+	} else {
+		logger.Infof("%d succeeded, %d failed.\n", succeeded, len(failed))
+	}
+	if len(deleted) > 0 {
+		logger.Infof("Releases not converted as they were deleted: %s\n", strings.Join(deleted, ", "))
+	}
+	noVersions := []string{}
+	for _, releaseName := range releaseNames {
+		if err, ok := failed[releaseName]; ok && errors.Is(err, v2.ErrNoVersionsFound) {
+			noVersions = append(noVersions, releaseName)
+		}
+	}
+	if len(noVersions) > 0 {
+		logger.Infof("Releases not converted as no release versions of theirs were found: %s\n", strings.Join(noVersions, ", "))
+	}
+	if len(pending) > 0 {
+		names := []string{}
+		for _, releaseName := range releaseNames {
+			if pending[releaseName] {
+				names = append(names, releaseName)
+			}
+		}
+		logger.Infof("Releases not converted as they are pending: %s\n", strings.Join(names, ", "))
+	}
+	diffNames := []string{}
+	for _, releaseName := range releaseNames {
+		if manifestDiffs[releaseName] {
+			diffNames = append(diffNames, releaseName)
+		}
+	}
+	if len(diffNames) > 0 {
+		logger.Infof("Releases whose manifest differs from the manifest of their Helm v3 release: %s\n", strings.Join(diffNames, ", "))
 	}
 
-	return Convert(convertOptions, kubeConfig)
+	// The conversion timed out or was interrupted: the releases not started are left on Helm v2
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err := fmt.Errorf("conversion stopped after %d of %d releases: %w", len(converted), len(releaseNames), ctxErr)
+		if succeeded > 0 {
+			return &common.PartialError{Succeeded: succeeded, Failed: len(failed) + len(releaseNames) - len(converted), Err: err}
+		}
+		return err
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("%d of %d releases failed to convert", len(failed), len(releaseNames))
+		if succeeded > 0 {
+			return &common.PartialError{Succeeded: succeeded, Failed: len(failed), Err: err}
+		}
+		return err
+	}
+	if len(diffNames) > 0 {
+		return fmt.Errorf("%w: the manifest of %d of %d releases differs from the manifest of their Helm v3 release", ErrManifestDiff, len(diffNames), len(releaseNames))
+	}
+	return nil
 }
 
 // Convert converts Helm 2 release into Helm 3 release. It maps the Helm v2 release versions
 // of the release into Helm v3 equivalent and stores the release versions. The underlying Kubernetes resources
 // are untouched. Note: The namespaces of each release version need to exist in the Kubernetes  cluster.
 // The Helm 2 release is retained by default, unless the '--delete-v2-releases' flag is set.
-func Convert(convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+func Convert(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	_, err := convertRelease(ctx, convertOptions, kubeConfig)
+	return err
+}
+
+// ConvertResult describes the Helm v3 release a Helm v2 release was converted into, and the Helm v2
+// release versions converted
+type ConvertResult struct {
+	Name      string  `json:"name"`
+	Namespace string  `json:"namespace"`
+	Versions  []int32 `json:"versions"`
+	// Warnings are the warnings about the chart of the release, as per v3.CheckChart
+	Warnings []string `json:"warnings,omitempty"`
+	// Objects are the Helm v3 storage objects the conversion would create, in dry-run only
+	Objects []PlannedObject `json:"objects,omitempty"`
+	// ValuesDiff are the values changed by the value overrides in the deployed version, one per key
+	ValuesDiff []string `json:"valuesDiff,omitempty"`
+	// Corrupt are the Helm v2 storage objects of the release skipped as their release can't be decoded
+	Corrupt []v2.CorruptRecord `json:"corrupt,omitempty"`
+	// ManifestDiff is the unified diff of the manifest of the deployed version and the manifest of its
+	// Helm v3 release version, in dry-run with DryRunDiff only. It is empty when they are the same.
+	ManifestDiff string `json:"manifestDiff,omitempty"`
+}
+
+// PlannedObject is a Helm v3 storage object the dry-run of a conversion would create, with the Helm v2
+// release version it is converted from
+type PlannedObject struct {
+	v3.StorageObject
+	V2Version int32  `json:"v2Version"`
+	V2Status  string `json:"v2Status"`
+	Status    string `json:"status"`
+	// CreateNamespace is set when the namespace would be created
+	CreateNamespace bool `json:"createNamespace,omitempty"`
+	// Conflict is 'exists' when an object of the same name already exists in Helm v3 storage, failing the
+	// conversion, or 'replaced' when it would be deleted first
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// convertRelease converts the Helm v2 release as per Convert, and returns the result of the conversion
+func convertRelease(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig) (*ConvertResult, error) {
+	logger := convertOptions.logger()
+
+	if err := v2.ValidateSelector(convertOptions.Selector); err != nil {
+		return nil, err
+	}
+	if err := checkNewName(convertOptions); err != nil {
+		return nil, err
+	}
+
 	if convertOptions.DryRun {
-		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
-		log.Println("Run without --dry-run to take the actions described below:")
-		log.Println()
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	if !convertOptions.destChecked {
+		if err := checkDestCluster(convertOptions, kubeConfig); err != nil {
+			return nil, err
+		}
+	}
+	if !convertOptions.tillerChecked {
+		if err := checkTillerFormat(ctx, convertOptions, kubeConfig); err != nil {
+			return nil, err
+		}
 	}
 
-	log.Printf("Release \"%s\" will be converted from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
+	logger.Infof("Release \"%s\" will be converted from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
 
-	log.Printf("[Helm 3] Release \"%s\" will be created.\n", convertOptions.ReleaseName)
+	logger.Infof("[Helm 3] Release \"%s\" will be created.\n", convertOptions.ReleaseName)
 
 	retrieveOptions := v2.RetrieveOptions{
 		ReleaseName:      convertOptions.ReleaseName,
+		Selector:         convertOptions.Selector,
 		TillerNamespace:  convertOptions.TillerNamespace,
 		TillerLabel:      convertOptions.TillerLabel,
 		TillerOutCluster: convertOptions.TillerOutCluster,
 		StorageType:      convertOptions.StorageType,
+		SQLConnection:    convertOptions.TillerSQLConnection,
+		StorageDir:       convertOptions.TillerStorageDir,
+		File:             convertOptions.FromFile,
+		Logger:           convertOptions.logger(),
 	}
-	v2Releases, err := v2.GetReleaseVersions(retrieveOptions, kubeConfig)
+	v2Releases, corrupt, err := getV2ReleaseVersions(ctx, retrieveOptions, convertOptions, kubeConfig)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := checkReleaseSources(convertOptions.ReleaseName, v2Releases); err != nil {
+		return nil, err
+	}
+	if err := forceStorageFormat(v2.CheckReleaseFormat(convertOptions.ReleaseName, v2Releases), convertOptions); err != nil {
+		return nil, err
+	}
+	v3Name, err := convertOptions.v3ReleaseName(v2Releases[len(v2Releases)-1])
+	if err != nil {
+		return nil, err
+	}
+	if v3Name != convertOptions.ReleaseName {
+		logger.Infof("[Helm 3] Release \"%s\" will be created as \"%s\".\n", convertOptions.ReleaseName, v3Name)
+	}
+
+	selected, deployedVersion, err := selectConvertedVersions(v2Releases, convertOptions)
+	if err != nil {
+		return nil, err
+	}
+	plan := &convertPlan{v3Name: v3Name}
+	if plan.createdNamespaces, plan.missingNamespaces, err = checkTargetNamespaces(ctx, selected, convertOptions, kubeConfig); err != nil {
+		return nil, err
+	}
+	var valuesDiff []string
+	if plan.selected, plan.v3Releases, valuesDiff, err = createV3ReleaseVersions(selected, v3Name, deployedVersion, convertOptions); err != nil {
+		return nil, err
+	}
+
+	versions := []int32{}
+	for _, v2Release := range plan.selected {
+		versions = append(versions, v2Release.Version)
+	}
+	result := &ConvertResult{
+		Name:       v3Name,
+		Namespace:  convertOptions.targetNamespace(plan.selected[len(plan.selected)-1].Namespace),
+		Versions:   versions,
+		ValuesDiff: valuesDiff,
+		Corrupt:    corrupt,
+	}
+	if convertOptions.DryRun && convertOptions.DryRunDiff {
+		result.ManifestDiff = diffDeployedManifest(plan.selected, plan.v3Releases, logger)
+	}
+
+	result.Warnings = checkChartWarnings(plan.v3Releases, convertOptions)
+	if convertOptions.Strict && len(result.Warnings) > 0 {
+		return result, fmt.Errorf("%w: release \"%s\" is not converted as the strict flag is set and its chart has %d warnings. Fix the chart with Helm v2 first, or convert the release without the strict flag", ErrChartWarnings, convertOptions.ReleaseName, len(result.Warnings))
+	}
+
+	if err := reconcileV3Release(ctx, plan, result, retrieveOptions, convertOptions, kubeConfig); err != nil {
+		// The result is returned along with the release already converted, and with the objects which
+		// conflict in dry-run
+		if errors.Is(err, ErrReleaseConverted) || result.Objects != nil {
+			return result, err
+		}
+		return nil, err
+	}
+	// The dry-run maps and encodes every release version as the conversion stores them, without writing,
+	// so that it catches the release versions which can't be stored
+	if convertOptions.DryRun {
+		if result.Objects, err = planV3Objects(plan.selected, plan.v3Releases, plan.existing, plan.replaced, plan.createdNamespaces, convertOptions); err != nil {
+			return nil, err
+		}
+		auditPlannedObjects(result.Objects, v3Name)
+	}
+
+	if err := storeV3Release(ctx, plan, convertOptions, kubeConfig); err != nil {
+		return nil, err
+	}
+	if convertOptions.LabelResources {
+		if err := adoptV3Resources(ctx, plan.v3Releases, convertOptions, kubeConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if convertOptions.DeleteRelease {
+		if err := deleteV2ReleaseVersions(ctx, convertOptions, retrieveOptions, versions, kubeConfig); err != nil {
+			return nil, err
+		}
+		if !convertOptions.DryRun {
+			logger.Infof("Release \"%s\" was converted successfully from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
+		}
+	} else {
+		if !convertOptions.DryRun {
+			logger.Infof("Release \"%s\" was converted successfully from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
+			logger.Infof("Note: The v2 release information still remains and should be removed to avoid conflicts with the migrated v3 release.")
+			logger.Infof("v2 release information should only be removed using `helm 2to3` cleanup and when all releases have been migrated over.")
+		}
+	}
+
+	return result, nil
+}
+
+// convertPlan is the conversion of a Helm v2 release into the Helm v3 release of the name: the Helm v2
+// release versions selected and the Helm v3 release versions they are converted into, in the same order,
+// and the existing Helm v3 release of the same name, replaced or superseded by them
+type convertPlan struct {
+	v3Name            string
+	selected          []*v2rel.Release
+	v3Releases        []*release.Release
+	createdNamespaces map[string]bool
+	missingNamespaces []string
+	existing          []*release.Release
+	replaced          bool
+	superseded        []*release.Release
+	rollback          convertRollback
+}
+
+// getV2ReleaseVersions returns the Helm v2 release versions of the release, and the storage objects
+// of theirs which can't be decoded. The release fails to be converted when it has such objects, unless
+// they are skipped, or when none of its release versions can be decoded.
+func getV2ReleaseVersions(ctx context.Context, retrieveOptions v2.RetrieveOptions, convertOptions ConvertOptions, kubeConfig common.KubeConfig) ([]*v2rel.Release, []v2.CorruptRecord, error) {
+	v2Releases, corrupt, err := v2.GetReleaseVersionsWithCorrupt(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(corrupt) > 0 {
+		corruptErr := &v2.CorruptRecordsError{ReleaseName: convertOptions.ReleaseName, Records: corrupt}
+		if len(v2Releases) == 0 {
+			return nil, nil, fmt.Errorf("%w. None of its release versions can be decoded, so that it can't be converted", corruptErr)
+		}
+		if !convertOptions.SkipCorrupt {
+			return nil, nil, corruptErr
+		}
+		for _, record := range corrupt {
+			convertOptions.logger().Warnf("[Helm 2] %s of release \"%s\" can't be decoded and is skipped: %s. It remains in Helm v2 storage.\n", record, convertOptions.ReleaseName, record.Error)
+		}
+	}
+	return v2Releases, corrupt, nil
+}
+
+// selectConvertedVersions returns the Helm v2 release versions converted, up to the max release versions,
+// and the version converted as the deployed one. The release fails to be converted when its latest
+// version is pending and pending releases are skipped.
+func selectConvertedVersions(v2Releases []*v2rel.Release, convertOptions ConvertOptions) ([]*v2rel.Release, int32, error) {
+	logger := convertOptions.logger()
+	latestStatus := releaseStatus(v2Releases[len(v2Releases)-1])
+	deployedVersion := deployedReleaseVersion(v2Releases)
+	if deployedVersion > 0 && releaseStatus(v2Releases[indexOfVersion(v2Releases, deployedVersion)]) != v2rel.Status_DEPLOYED {
+		logger.Infof("NOTE: Release \"%s\" has no deployed version. Version \"%d\", its latest superseded version, is converted as the deployed version.\n", convertOptions.ReleaseName, deployedVersion)
+	}
+	switch latestStatus {
+	case v2rel.Status_PENDING_INSTALL, v2rel.Status_PENDING_UPGRADE, v2rel.Status_PENDING_ROLLBACK:
+		if convertOptions.SkipPending {
+			return nil, 0, fmt.Errorf("%w: release \"%s\" is in %s state as of its latest version. Wait for the operation in progress to complete or roll the release back with Helm v2, then convert it", ErrReleasePending, convertOptions.ReleaseName, latestStatus)
+		}
+		logger.Warnf("Release \"%s\" is in %s state as of its latest version. The Helm v3 release will be in pending state too, and Helm v3 will refuse to upgrade it until it is rolled back.\n", convertOptions.ReleaseName, latestStatus)
+	case v2rel.Status_FAILED:
+		if deployedVersion > 0 {
+			logger.Infof("NOTE: The latest version of release \"%s\" failed. It is converted as failed, and version \"%d\" is converted as the deployed version.\n", convertOptions.ReleaseName, deployedVersion)
+		}
 	}
 
 	// Limit release versions to migrate.
 	// Limit is based on newest versions.
 	v2RelVerLen := len(v2Releases)
-	startIndex := 0
+	selected := v2Releases
 	if convertOptions.MaxReleaseVersions > 0 && convertOptions.MaxReleaseVersions < v2RelVerLen {
-		log.Println()
-		log.Printf("NOTE: The max release versions \"%d\" is less than the actual release versions \"%d\".", convertOptions.MaxReleaseVersions, v2RelVerLen)
-		log.Printf("This means only \"%d\" of the latest release versions will be converted.", convertOptions.MaxReleaseVersions)
+		logger.Infof("")
+		logger.Infof("NOTE: The max release versions \"%d\" is less than the actual release versions \"%d\".", convertOptions.MaxReleaseVersions, v2RelVerLen)
+		logger.Infof("This means only \"%d\" of the latest release versions will be converted.", convertOptions.MaxReleaseVersions)
 		if convertOptions.DeleteRelease {
-			log.Println("This also means some versions will remain in Helm v2 storage that will no longer be visible to Helm v2 commands like 'helm list'. Plugin 'cleanup' command will remove them from storage.")
+			logger.Infof("This also means some versions will remain in Helm v2 storage that will no longer be visible to Helm v2 commands like 'helm list'. Plugin 'cleanup' command will remove them from storage.")
+		}
+		selected = selectReleaseVersions(v2Releases, convertOptions.MaxReleaseVersions, deployedVersion)
+		if selected[0] != v2Releases[v2RelVerLen-convertOptions.MaxReleaseVersions] {
+			logger.Infof("The deployed release version \"%d\" is older than the latest release versions, so it is converted in place of release version \"%d\".", selected[0].Version, v2Releases[v2RelVerLen-convertOptions.MaxReleaseVersions].Version)
 		}
-		log.Println()
-		startIndex = v2RelVerLen - convertOptions.MaxReleaseVersions
+		logger.Infof("")
 	}
+	return selected, deployedVersion, nil
+}
 
-	versions := []int32{}
-	for i := startIndex; i < v2RelVerLen; i++ {
-		v2Release := v2Releases[i]
-		relVerName := v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version)
-		log.Printf("[Helm 3] ReleaseVersion \"%s\" will be created.\n", relVerName)
-		if !convertOptions.DryRun {
-			if err := createV3ReleaseVersion(v2Release, kubeConfig); err != nil {
-				return err
+// checkTargetNamespaces checks the namespaces the release versions are created in, when they differ from
+// the namespaces they are deployed into. It returns the namespaces which do not exist, as a set and in
+// order, which are only created once the release versions are converted.
+func checkTargetNamespaces(ctx context.Context, selected []*v2rel.Release, convertOptions ConvertOptions, kubeConfig common.KubeConfig) (map[string]bool, []string, error) {
+	checked := map[string]bool{}
+	createdNamespaces := map[string]bool{}
+	var missingNamespaces []string
+	for _, v2Release := range selected {
+		namespace := convertOptions.targetNamespace(v2Release.Namespace)
+		if checked[namespace] || (namespace == v2Release.Namespace && !convertOptions.CreateNamespace) {
+			continue
+		}
+		checked[namespace] = true
+		if namespace != v2Release.Namespace {
+			convertOptions.logger().Infof("[Helm 3] Release \"%s\" will be created in \"%s\" namespace instead of \"%s\" namespace.\n", convertOptions.ReleaseName, namespace, v2Release.Namespace)
+		}
+		// The cluster is not accessed when the release versions are written to manifest files
+		if convertOptions.ToDir != "" {
+			continue
+		}
+		missing, err := checkNamespace(ctx, namespace, convertOptions, kubeConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		if missing {
+			createdNamespaces[namespace] = true
+			missingNamespaces = append(missingNamespaces, namespace)
+		}
+	}
+	return createdNamespaces, missingNamespaces, nil
+}
+
+// createV3ReleaseVersions converts the release versions selected into Helm v3 release versions of the
+// name, before anything is created, skipping the ones over the size limit of Kubernetes objects when set.
+// It returns the release versions selected which fit and their Helm v3 release versions, and the values
+// changed by the value overrides in the deployed version.
+func createV3ReleaseVersions(selected []*v2rel.Release, v3Name string, deployedVersion int32, convertOptions ConvertOptions) ([]*v2rel.Release, []*release.Release, []string, error) {
+	logger := convertOptions.logger()
+	v3Releases := []*release.Release{}
+	fitting := []*v2rel.Release{}
+	var valuesDiff []string
+	for i, v2Release := range selected {
+		v3Release, err := v3.CreateRelease(v2Release)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		v3Release.Name = v3Name
+		v3Release.Namespace = convertOptions.targetNamespace(v2Release.Namespace)
+		// Exactly one version is deployed in Helm v3, the other versions left as deployed by Helm v2 being
+		// superseded by it
+		if status := v3ReleaseStatus(v2Release, deployedVersion); status != "" {
+			v3Release.Info.Status = status
+		}
+		if crdHooks := v3.ConvertCRDHooks(v3Release, convertOptions.ConvertCRDHooks); len(crdHooks) > 0 {
+			relVerName := v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version)
+			if convertOptions.ConvertCRDHooks {
+				logger.Infof("[Helm 3] ReleaseVersion \"%s\": the manifests of the crd-install hooks are moved to the release manifest: %s\n", relVerName, strings.Join(crdHooks, ", "))
+			} else {
+				logger.Warnf("ReleaseVersion \"%s\": the crd-install hooks are dropped, as Helm v3 does not support them. Set the 'convert-crd-hooks' flag to move them to the release manifest: %s\n", relVerName, strings.Join(crdHooks, ", "))
 			}
-			log.Printf("[Helm 3] ReleaseVersion \"%s\" created.\n", relVerName)
 		}
-		versions = append(versions, v2Release.Version)
+		// Only the values of the deployed version are overridden, the history being kept as released
+		if len(convertOptions.ValueOverrides) > 0 && v2Release.Version == deployedVersion {
+			valuesDiff = overrideValues(v3Release, convertOptions.ValueOverrides)
+			relVerName := v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version)
+			if len(valuesDiff) == 0 {
+				logger.Warnf("[Helm 3] ReleaseVersion \"%s\": the value overrides change none of its values.\n", relVerName)
+			} else {
+				logger.Infof("[Helm 3] ReleaseVersion \"%s\": values modified, the manifest being converted as rendered by Helm v2:\n  %s\n", relVerName, strings.Join(valuesDiff, "\n  "))
+			}
+		}
+		// The hooks run before the size check, as they can change the size of the release version
+		for j, hook := range convertOptions.Hooks {
+			if err := hook(v3Release); err != nil {
+				return nil, nil, nil, fmt.Errorf("[Helm 3] ReleaseVersion \"%s\" failed to be converted by conversion hook %d with error: %w. Nothing was stored", v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version), j+1, err)
+			}
+		}
+		historical := i < len(selected)-1 && v3Release.Info.Status != release.StatusDeployed
+		skip, err := checkReleaseSize(v3Release, v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version), historical, convertOptions)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if skip {
+			continue
+		}
+		v3Releases = append(v3Releases, v3Release)
+		fitting = append(fitting, v2Release)
 	}
-	if !convertOptions.DryRun {
-		log.Printf("[Helm 3] Release \"%s\" created.\n", convertOptions.ReleaseName)
+	if len(fitting) == 0 {
+		return nil, nil, nil, fmt.Errorf("[Helm 3] Release \"%s\" can't be converted as all its release versions are over the size limit of Kubernetes objects", convertOptions.ReleaseName)
+	}
+	if len(convertOptions.ValueOverrides) > 0 && deployedVersion == 0 {
+		return nil, nil, nil, fmt.Errorf("[Helm 3] Release \"%s\" has no deployed version whose values can be overridden", convertOptions.ReleaseName)
+	}
+	// The release versions are renumbered from 1 in the order they were released, so that the revisions
+	// are contiguous when versions were purged, dropped by the max or skipped, unless the numbers are kept
+	if !convertOptions.KeepVersionNumbers {
+		for i, v3Release := range v3Releases {
+			v3Release.Version = i + 1
+		}
 	}
+	return fitting, v3Releases, valuesDiff, nil
+}
 
-	if convertOptions.DeleteRelease {
-		log.Printf("[Helm 2] Release \"%s\" will be deleted.\n", convertOptions.ReleaseName)
-		deleteOptions := v2.DeleteOptions{
-			DryRun:   convertOptions.DryRun,
-			Versions: versions,
+// checkChartWarnings returns and logs the warnings about the charts of the release versions, checked for
+// what may break the first Helm v3 upgrade or rollback. The release versions usually share the same warnings.
+func checkChartWarnings(v3Releases []*release.Release, convertOptions ConvertOptions) []string {
+	var warnings []string
+	seen := map[string]bool{}
+	for _, v3Release := range v3Releases {
+		for _, warning := range v3.CheckChart(v3Release.Chart) {
+			if !seen[warning] {
+				seen[warning] = true
+				warnings = append(warnings, warning)
+			}
 		}
-		if err := v2.DeleteReleaseVersions(retrieveOptions, deleteOptions, kubeConfig); err != nil {
-			return err
+	}
+	for _, warning := range warnings {
+		convertOptions.logger().Warnf("[Helm 3] Release \"%s\": %s\n", convertOptions.ReleaseName, warning)
+	}
+	return warnings
+}
+
+// reconcileV3Release reconciles the release converted with the release of the same name in the Helm v3
+// storage of the namespace, which can be a different release, e.g. converted from another Tiller, whose
+// history would be merged with this one. The existing release is replaced or superseded as per the
+// options, the release versions replaced being recorded for the rollback of the conversion. It fails
+// with ErrReleaseConverted when the release was already converted, and with v3.ErrReleaseAlreadyExists
+// when the existing release is kept, setting the objects which conflict in dry-run.
+func reconcileV3Release(ctx context.Context, plan *convertPlan, result *ConvertResult, retrieveOptions v2.RetrieveOptions, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	if convertOptions.ToDir != "" {
+		return nil
+	}
+	existing, err := v3.GetReleaseHistory(plan.v3Name, result.Namespace, convertOptions.v3KubeConfig(kubeConfig))
+	if err != nil && !errors.Is(err, v3.ErrReleaseNotFound) {
+		return fmt.Errorf("[Helm 3] Failed to check if release \"%s\" exists in namespace \"%s\" with error: %s", plan.v3Name, result.Namespace, err)
+	}
+	plan.existing = existing
+	switch {
+	case len(existing) == 0:
+	case convertOptions.ForceReconvert:
+		if err := replaceV3Release(ctx, existing, convertOptions, kubeConfig); err != nil {
+			return rollbackV3Release(ctx, convertRollback{replaced: existing}, err, convertOptions, kubeConfig)
+		}
+		plan.replaced = true
+		plan.rollback.replaced = existing
+	case convertOptions.skipConverted && sameReleaseVersions(existing, plan.v3Releases):
+		// A previous conversion which was interrupted may not have deleted the Helm v2 release yet
+		convertOptions.logger().Infof("[Helm 3] Release \"%s\" already converted in namespace \"%s\".\n", plan.v3Name, result.Namespace)
+		if convertOptions.DeleteRelease {
+			if err := deleteV2ReleaseVersions(ctx, convertOptions, retrieveOptions, result.Versions, kubeConfig); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("%w: release \"%s\" already exists in Helm v3 storage with the same release versions", ErrReleaseConverted, convertOptions.ReleaseName)
+	case convertOptions.Force && convertOptions.MergeStrategy == "replace":
+		if err := replaceV3Release(ctx, existing, convertOptions, kubeConfig); err != nil {
+			return rollbackV3Release(ctx, convertRollback{replaced: existing}, err, convertOptions, kubeConfig)
+		}
+		plan.replaced = true
+		plan.rollback.replaced = existing
+	case convertOptions.Force:
+		plan.superseded = appendV3Release(existing, plan.v3Releases, convertOptions)
+	default:
+		err := fmt.Errorf("%w: [Helm 3] Release \"%s\" already exists in namespace \"%s\" with %d release versions. If it is a different release of the same name, e.g. managed by another Tiller, set the 'rename-template' flag to convert the release under another name. Set the 'force' flag to replace it or append the release versions converted to it, as per the 'merge-strategy' flag", v3.ErrReleaseAlreadyExists, plan.v3Name, result.Namespace, len(existing))
+		// The dry-run lists the objects which conflict, for the review of the conversion
+		if convertOptions.DryRun {
+			objects, planErr := planV3Objects(plan.selected, plan.v3Releases, existing, false, plan.createdNamespaces, convertOptions)
+			if planErr != nil {
+				return planErr
+			}
+			result.Objects = objects
+		}
+		return err
+	}
+	return nil
+}
+
+// storeV3Release creates the namespaces which do not exist and stores the Helm v3 release versions, or
+// writes them to manifest files, then supersedes the existing deployed release versions. What the
+// conversion created, replaced or superseded is rolled back if it fails mid-way, the namespaces
+// created being deleted last. Nothing is stored in dry-run.
+func storeV3Release(ctx context.Context, plan *convertPlan, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	logger := convertOptions.logger()
+	// The storage objects of the release versions are labelled as converted by the plugin, unless disabled.
+	// The conversion time is left out of the manifest files, so that converting again writes the same content.
+	var provenance *v3.Provenance
+	if !convertOptions.NoProvenanceLabels {
+		provenance = &v3.Provenance{
+			TillerNamespace: convertOptions.tillerNamespace(),
+		}
+		if convertOptions.ToDir == "" {
+			provenance.ConvertedAt = time.Now()
+		}
+	}
+
+	rollback := &plan.rollback
+	for _, namespace := range plan.missingNamespaces {
+		if err := createMissingNamespace(ctx, namespace, convertOptions, kubeConfig); err != nil {
+			return rollbackV3Release(ctx, *rollback, err, convertOptions, kubeConfig)
 		}
 		if !convertOptions.DryRun {
-			log.Printf("[Helm 2] Release \"%s\" deleted.\n", convertOptions.ReleaseName)
+			rollback.namespaces = append(rollback.namespaces, namespace)
+		}
+	}
+	if convertOptions.DryRun {
+		// The objects were logged as planned
+		return nil
+	}
 
-			log.Printf("Release \"%s\" was converted successfully from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
+	for i, v2Release := range plan.selected {
+		v3Release := plan.v3Releases[i]
+		relVerName := v2.GetReleaseVersionName(plan.v3Name, int32(v3Release.Version))
+		if relVerName != v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version) {
+			logger.Infof("[Helm 3] ReleaseVersion \"%s\" will be created from ReleaseVersion \"%s\".\n", relVerName, v2.GetReleaseVersionName(convertOptions.ReleaseName, v2Release.Version))
+		} else {
+			logger.Infof("[Helm 3] ReleaseVersion \"%s\" will be created.\n", relVerName)
 		}
+		if convertOptions.ToDir != "" {
+			file, err := v3.WriteReleaseManifest(convertOptions.ToDir, v3Release, provenance)
+			if err != nil {
+				return fmt.Errorf("[Helm 3] ReleaseVersion \"%s\" failed to be written with error: %w", relVerName, err)
+			}
+			logger.Infof("[Helm 3] ReleaseVersion \"%s\" written to \"%s\".\n", relVerName, file)
+		} else {
+			stored, err := storeV3ReleaseVersion(ctx, v3Release, provenance, convertOptions.v3KubeConfig(kubeConfig))
+			if stored {
+				rollback.created = append(rollback.created, v3Release)
+			}
+			if err != nil {
+				return rollbackV3Release(ctx, *rollback, err, convertOptions, kubeConfig)
+			}
+			logger.Infof("[Helm 3] ReleaseVersion \"%s\" created.\n", relVerName)
+		}
+	}
+	// The existing deployed release versions are only superseded once all the release versions were created
+	if len(plan.superseded) > 0 {
+		rollback.superseded = plan.superseded
+		if err := v3.SupersedeReleaseVersions(ctx, plan.superseded, convertOptions.v3KubeConfig(kubeConfig)); err != nil {
+			err = fmt.Errorf("[Helm 3] Release \"%s\" failed to be superseded with error: %w", plan.v3Name, err)
+			return rollbackV3Release(ctx, *rollback, err, convertOptions, kubeConfig)
+		}
+	}
+	if convertOptions.ToDir != "" {
+		if !convertOptions.indexDeferred {
+			if err := writeManifestIndex(convertOptions.ToDir, logger); err != nil {
+				return err
+			}
+		}
+		logger.Infof("[Helm 3] Release \"%s\" written to \"%s\". Apply it with 'kubectl apply -R -f %s'.\n", plan.v3Name, convertOptions.ToDir, convertOptions.ToDir)
 	} else {
-		if !convertOptions.DryRun {
-			log.Printf("Release \"%s\" was converted successfully from Helm v2 to Helm v3.\n", convertOptions.ReleaseName)
-			log.Println("Note: The v2 release information still remains and should be removed to avoid conflicts with the migrated v3 release.")
-			log.Println("v2 release information should only be removed using `helm 2to3` cleanup and when all releases have been migrated over.")
+		logger.Infof("[Helm 3] Release \"%s\" created.\n", plan.v3Name)
+	}
+	return nil
+}
+
+// diffDeployedManifest returns the unified diff of the manifest of the Helm v2 release version converted
+// as the deployed one, the latest one when none is, and the manifest of its Helm v3 release version, as
+// changed by the conversion, e.g. by moving the crd-install hooks or by the conversion hooks. The diff
+// is logged, and is empty when the manifests are the same.
+func diffDeployedManifest(v2Releases []*v2rel.Release, v3Releases []*release.Release, logger common.Logger) string {
+	i := len(v3Releases) - 1
+	for j, v3Release := range v3Releases {
+		if v3Release.Info.Status == release.StatusDeployed {
+			i = j
+		}
+	}
+	v2Name := v2.GetReleaseVersionName(v2Releases[i].Name, v2Releases[i].Version)
+	v3Name := v2.GetReleaseVersionName(v3Releases[i].Name, int32(v3Releases[i].Version))
+	if v2Releases[i].Manifest == v3Releases[i].Manifest {
+		logger.Infof("[Helm 3] ReleaseVersion \"%s\": the manifest is the same as the manifest of ReleaseVersion \"%s\" in Helm v2.\n", v3Name, v2Name)
+		return ""
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(v2Releases[i].Manifest),
+		B:        difflib.SplitLines(v3Releases[i].Manifest),
+		FromFile: "Helm v2 " + v2Name,
+		ToFile:   "Helm v3 " + v3Name,
+		Context:  3,
+	})
+	logger.Warnf("[Helm 3] ReleaseVersion \"%s\": the manifest differs from the manifest of ReleaseVersion \"%s\" in Helm v2:\n%s", v3Name, v2Name, diff)
+	return diff
+}
+
+// adoptV3Resources labels and annotates the resources of the deployed release version of the Helm v3
+// release with the Helm v3 ownership metadata. The resources which fail to be patched are warned about,
+// the others being patched all the same. The patches are only logged in dry-run.
+func adoptV3Resources(ctx context.Context, v3Releases []*release.Release, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	logger := convertOptions.logger()
+	latest := v3Releases[len(v3Releases)-1]
+	for _, v3Release := range v3Releases {
+		if v3Release.Info.Status == release.StatusDeployed {
+			latest = v3Release
+		}
+	}
+	if latest.Info.Status == release.StatusUninstalled {
+		logger.Infof("[Helm 3] Release \"%s\" is uninstalled, so its resources are not labelled.\n", latest.Name)
+		return nil
+	}
+
+	relVerName := v2.GetReleaseVersionName(latest.Name, int32(latest.Version))
+	logger.Infof("[Helm 3] Resources of ReleaseVersion \"%s\" will be labelled.\n", relVerName)
+	adopted, err := v3.AdoptResources(ctx, latest, convertOptions.DryRun, convertOptions.v3KubeConfig(kubeConfig))
+	if err != nil {
+		return fmt.Errorf("[Helm 3] Resources of ReleaseVersion \"%s\" failed to be labelled with error: %s", relVerName, err)
+	}
+	for _, resource := range adopted {
+		switch {
+		case resource.Missing:
+			logger.Warnf("[Helm 3] %s is skipped, as it was not found: %s\n", resource, resource.Err)
+		case resource.Err != nil:
+			logger.Warnf("[Helm 3] %s failed to be labelled with error: %s\n", resource, resource.Err)
+		case convertOptions.DryRun:
+			logger.Infof("[Helm 3] %s will be patched with: %s\n", resource, resource.Patch)
+		default:
+			logger.Infof("[Helm 3] %s labelled.\n", resource)
+		}
+	}
+	return nil
+}
+
+// replaceV3Release deletes the existing release versions of the Helm v3 release, which are replaced
+// by the release versions converted
+func replaceV3Release(ctx context.Context, existing []*release.Release, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	logger := convertOptions.logger()
+	name, namespace := existing[0].Name, existing[0].Namespace
+	logger.Infof("[Helm 3] Release \"%s\" already exists in namespace \"%s\" and its %d release versions will be replaced.\n", name, namespace, len(existing))
+	if convertOptions.DryRun {
+		return nil
+	}
+	if err := v3.DeleteReleaseHistory(ctx, existing, convertOptions.v3KubeConfig(kubeConfig)); err != nil {
+		return fmt.Errorf("[Helm 3] Release \"%s\" failed to be replaced with error: %w", name, err)
+	}
+	logger.Infof("[Helm 3] Release \"%s\" deleted.\n", name)
+	return nil
+}
+
+// appendV3Release numbers the release versions converted after the latest existing release version
+// of the Helm v3 release. The existing deployed release versions to supersede once the release versions
+// converted are created are returned when a release version converted is deployed, so that the release
+// has one deployed release version.
+func appendV3Release(existing, v3Releases []*release.Release, convertOptions ConvertOptions) []*release.Release {
+	logger := convertOptions.logger()
+	name, namespace := existing[0].Name, existing[0].Namespace
+	latest := existing[len(existing)-1].Version
+	logger.Infof("[Helm 3] Release \"%s\" already exists in namespace \"%s\". The release versions converted will be appended after its latest version \"%d\".\n", name, namespace, latest)
+	deployed := false
+	for i, v3Release := range v3Releases {
+		v3Release.Version = latest + i + 1
+		deployed = deployed || v3Release.Info.Status == release.StatusDeployed
+	}
+	if !deployed {
+		return nil
+	}
+	superseded := []*release.Release{}
+	for _, rel := range existing {
+		if rel.Info != nil && rel.Info.Status == release.StatusDeployed {
+			logger.Infof("[Helm 3] ReleaseVersion \"%s\" will be superseded.\n", v2.GetReleaseVersionName(rel.Name, int32(rel.Version)))
+			superseded = append(superseded, rel)
+		}
+	}
+	return superseded
+}
+
+// checkReleaseSize checks that the Helm v3 release version fits in the Kubernetes object storing it
+// once encoded, and returns true if it doesn't and is skipped as set. The manifest of a historical
+// release version which doesn't fit is stripped first, when set. The deployed release version is
+// never skipped.
+func checkReleaseSize(v3Release *release.Release, relVerName string, historical bool, convertOptions ConvertOptions) (bool, error) {
+	logger := convertOptions.logger()
+	size, oversized, err := v3.OversizedRelease(v3Release)
+	if err != nil || !oversized {
+		return false, err
+	}
+	if historical && convertOptions.StripManifest {
+		v3Release.Manifest = ""
+		strippedSize, stillOversized, err := v3.OversizedRelease(v3Release)
+		if err != nil {
+			return false, err
+		}
+		logger.Infof("[Helm 3] ReleaseVersion \"%s\" is %s once encoded, over the %s limit of Kubernetes objects. Its manifest is dropped, making it %s.\n", relVerName, formatSize(size), formatSize(v3.MaxReleaseSize), formatSize(strippedSize))
+		if !stillOversized {
+			return false, nil
+		}
+		size = strippedSize
+	}
+	if convertOptions.SkipOversized && v3Release.Info.Status != release.StatusDeployed {
+		logger.Warnf("ReleaseVersion \"%s\" is %s once encoded, over the %s limit of Kubernetes objects. It is skipped, and will be missing from the history of the Helm v3 release.\n", relVerName, formatSize(size), formatSize(v3.MaxReleaseSize))
+		return true, nil
+	}
+	return false, fmt.Errorf("[Helm 3] ReleaseVersion \"%s\" is %s once encoded, over the %s limit of Kubernetes objects, and can't be stored. Set the 'skip-oversized' flag to skip the historical release versions which are too large, or the 'strip-manifest-from-history' flag to drop their manifest", relVerName, formatSize(size), formatSize(v3.MaxReleaseSize))
+}
+
+// formatSize formats a size in bytes in MiB
+func formatSize(size int) string {
+	return fmt.Sprintf("%.2fMiB", float64(size)/(1024*1024))
+}
+
+// deleteV2ReleaseVersions deletes the versions of the Helm v2 release which were converted
+func deleteV2ReleaseVersions(ctx context.Context, convertOptions ConvertOptions, retrieveOptions v2.RetrieveOptions, versions []int32, kubeConfig common.KubeConfig) error {
+	logger := convertOptions.logger()
+	logger.Infof("[Helm 2] Release \"%s\" will be deleted.\n", convertOptions.ReleaseName)
+	deleteOptions := v2.DeleteOptions{
+		DryRun:         convertOptions.DryRun,
+		ObjectDeletion: convertOptions.ObjectDeletion,
+		Versions:       versions,
+		Logger:         logger,
+	}
+	deleted, err := v2.DeleteReleaseVersions(ctx, retrieveOptions, deleteOptions, kubeConfig)
+	metrics.Add(convertOptions.Metrics, metrics.VersionsDeleted, float64(len(deleted)))
+	if err != nil {
+		return err
+	}
+	if !convertOptions.DryRun {
+		logger.Infof("[Helm 2] Release \"%s\" deleted.\n", convertOptions.ReleaseName)
+	}
+	return nil
+}
+
+// recordConvertOutcome records the release converted, or failed, as per the error of its conversion. The
+// releases skipped as pending or already converted are not recorded.
+func recordConvertOutcome(recorder metrics.Recorder, err error) {
+	switch {
+	case err == nil:
+		metrics.Add(recorder, metrics.ReleasesConverted, 1)
+	case errors.Is(err, ErrReleasePending), errors.Is(err, ErrReleaseConverted):
+	default:
+		metrics.Add(recorder, metrics.ReleasesFailed, 1)
+	}
+}
+
+// sameReleaseVersions returns true if the Helm v3 release versions stored are the release versions
+// the conversion creates, as per their checksums
+func sameReleaseVersions(stored, converted []*release.Release) bool {
+	if len(stored) != len(converted) {
+		return false
+	}
+	for i := range stored {
+		storedChecksum, err := v3.ReleaseChecksum(stored[i])
+		if err != nil {
+			return false
+		}
+		convertedChecksum, err := v3.ReleaseChecksum(converted[i])
+		if err != nil || storedChecksum != convertedChecksum {
+			return false
 		}
 	}
+	return true
+}
 
+// writeManifestIndex writes the index file of the manifest files written to the directory
+func writeManifestIndex(dir string, logger common.Logger) error {
+	file, err := v3.WriteManifestIndex(dir)
+	if err != nil {
+		return fmt.Errorf("[Helm 3] Index of the manifest files of \"%s\" failed to be written with error: %s", dir, err)
+	}
+	logger.Infof("[Helm 3] Index of the manifest files written to \"%s\".\n", file)
 	return nil
 }
 
-func createV3ReleaseVersion(v2Release *v2rel.Release, kubeConfig common.KubeConfig) error {
-	v3Release, err := v3.CreateRelease(v2Release)
+// checkDestCluster checks that the destination cluster, when set, differs from the source cluster
+// unless allowed, and logs both clusters
+func checkDestCluster(convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	if convertOptions.DestKubeConfig == nil {
+		return nil
+	}
+	logger := convertOptions.logger()
+	source, err := kubeConfig.Server()
+	if err != nil {
+		return fmt.Errorf("source cluster failed to be resolved with error: %s", err)
+	}
+	dest, err := convertOptions.DestKubeConfig.Server()
 	if err != nil {
+		return fmt.Errorf("destination cluster failed to be resolved with error: %s", err)
+	}
+	if source == dest && !convertOptions.AllowSameCluster {
+		return fmt.Errorf("the destination cluster is the source cluster \"%s\". Set the 'allow-same-cluster' flag to convert the releases in the same cluster", source)
+	}
+	logger.Infof("[Helm 2] Releases are read from cluster \"%s\".\n", source)
+	logger.Infof("[Helm 3] Releases are created in cluster \"%s\".\n", dest)
+	return nil
+}
+
+// checkTillerFormat checks that the Tiller running in the cluster writes release records which are
+// supported, as per its version. It is not checked when Tiller is not running in the cluster, nor found.
+func checkTillerFormat(ctx context.Context, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	if convertOptions.TillerOutCluster || convertOptions.FromFile != "" {
+		return nil
+	}
+	tiller, err := v2.GetTiller(ctx, convertOptions.tillerNamespace(), kubeConfig)
+	if err != nil || tiller == nil {
+		return nil
+	}
+	return forceStorageFormat(v2.CheckTillerVersion(tiller.Version), convertOptions)
+}
+
+// forceStorageFormat returns the error of an unsupported storage format, unless the conversion is forced,
+// in which case it is only warned about
+func forceStorageFormat(err error, convertOptions ConvertOptions) error {
+	if err == nil || !convertOptions.Force {
 		return err
 	}
-	return v3.StoreRelease(v3Release, kubeConfig)
+	convertOptions.logger().Warnf("%s. The conversion proceeds as the 'force' flag is set, and may be incorrect.\n", err)
+	return nil
+}
+
+// checkNamespace checks that the namespace exists, and returns true if it does not and is to be created
+// as namespace creation is set
+func checkNamespace(ctx context.Context, namespace string, convertOptions ConvertOptions, kubeConfig common.KubeConfig) (bool, error) {
+	exists, err := v3.NamespaceExists(ctx, namespace, convertOptions.v3KubeConfig(kubeConfig))
+	if err != nil {
+		return false, fmt.Errorf("namespace \"%s\" failed to be checked with error: %s", namespace, err)
+	}
+	if exists {
+		return false, nil
+	}
+	if !convertOptions.CreateNamespace {
+		return false, fmt.Errorf("namespace \"%s\" does not exist. Set the 'create-namespace' flag to create it", namespace)
+	}
+	return true, nil
+}
+
+// createMissingNamespace creates the namespace checked as missing, or only records it for the dry-run
+func createMissingNamespace(ctx context.Context, namespace string, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	logger := convertOptions.logger()
+	logger.Infof("Namespace \"%s\" will be created.\n", namespace)
+	if convertOptions.DryRun {
+		common.RecordAudit(common.AuditEntry{Verb: "create", Version: "v1", Kind: "Namespace", Name: namespace, DryRun: true}, nil)
+		return nil
+	}
+	if err := v3.CreateNamespace(ctx, namespace, convertOptions.v3KubeConfig(kubeConfig)); err != nil {
+		return fmt.Errorf("namespace \"%s\" failed to be created with error: %s", namespace, err)
+	}
+	logger.Infof("Namespace \"%s\" created.\n", namespace)
+	return nil
+}
+
+// planV3Objects returns the Helm v3 storage objects of the release versions converted, and logs them for
+// the dry-run: their name, namespace, size and status, whether their namespace would be created, and
+// whether an object of the same name exists in the existing Helm v3 release, replaced or not
+func planV3Objects(selected []*v2rel.Release, v3Releases, existing []*release.Release, replaced bool, createdNamespaces map[string]bool, convertOptions ConvertOptions) ([]PlannedObject, error) {
+	logger := convertOptions.logger()
+	existingVersions := map[int]bool{}
+	for _, rel := range existing {
+		existingVersions[rel.Version] = true
+	}
+	objects := []PlannedObject{}
+	for i, v2Release := range selected {
+		v3Release := v3Releases[i]
+		relVerName := v2.GetReleaseVersionName(v3Release.Name, int32(v3Release.Version))
+		storageObject, err := v3.ReleaseStorageObject(v3Release)
+		if err != nil {
+			return nil, fmt.Errorf("[Helm 3] ReleaseVersion \"%s\" failed to be encoded with error: %s", relVerName, err)
+		}
+		object := PlannedObject{
+			StorageObject:   storageObject,
+			V2Version:       v2Release.Version,
+			V2Status:        releaseStatus(v2Release).String(),
+			Status:          v3Release.Info.Status.String(),
+			CreateNamespace: createdNamespaces[v3Release.Namespace],
+		}
+		switch {
+		case existingVersions[v3Release.Version] && replaced:
+			object.Conflict = "replaced"
+		case existingVersions[v3Release.Version]:
+			object.Conflict = "exists"
+		}
+		objects = append(objects, object)
+
+		kind := object.Kind
+		if kind == "" {
+			kind = "SQL record"
+		}
+		line := fmt.Sprintf("[Helm 3] ReleaseVersion \"%s\" will be created from ReleaseVersion \"%s\" as %s \"%s\" in namespace \"%s\": %s, status %s -> %s", relVerName, v2.GetReleaseVersionName(v2Release.Name, v2Release.Version), kind, object.Name, object.Namespace, formatSize(object.Size), object.V2Status, object.Status)
+		if object.CreateNamespace {
+			line += ", namespace created"
+		}
+		switch object.Conflict {
+		case "replaced":
+			line += ", replacing the existing object"
+		case "exists":
+			line += ", CONFLICT: the object already exists"
+		}
+		logger.Infof("%s.\n", line)
+	}
+	return objects, nil
+}
+
+// auditPlannedObjects records the creates of the Helm v3 storage objects planned in dry-run in the audit log
+func auditPlannedObjects(objects []PlannedObject, releaseName string) {
+	for _, object := range objects {
+		entry := common.AuditEntry{
+			Verb:      "create",
+			Kind:      "SQL record",
+			Namespace: object.Namespace,
+			Name:      object.Name,
+			Release:   releaseName,
+			DryRun:    true,
+		}
+		if object.Kind != "" {
+			entry.Version, entry.Kind = "v1", object.Kind
+		}
+		common.RecordAudit(entry, nil)
+	}
+}
+
+// selectReleaseVersions returns the latest release versions up to the max, sorted by version. The
+// version converted as the deployed one is always selected, in place of the oldest of the latest
+// versions when it is older than them, so that the Helm v3 release has a deployed release version.
+func selectReleaseVersions(v2Releases []*v2rel.Release, max int, deployedVersion int32) []*v2rel.Release {
+	if max <= 0 || max >= len(v2Releases) {
+		return v2Releases
+	}
+	startIndex := len(v2Releases) - max
+	if i := indexOfVersion(v2Releases, deployedVersion); i >= 0 && i < startIndex {
+		selected := []*v2rel.Release{v2Releases[i]}
+		return append(selected, v2Releases[startIndex+1:]...)
+	}
+	return v2Releases[startIndex:]
+}
+
+// checkNewName checks that the new name of the release is a valid release name, and that the
+// release is not deleted from Helm v2 storage when converted under a new name, unless forced
+func checkNewName(convertOptions ConvertOptions) error {
+	if convertOptions.NewName == "" {
+		return nil
+	}
+	if err := validateReleaseName(convertOptions.NewName); err != nil {
+		return fmt.Errorf("invalid new name \"%s\" for release \"%s\": %s", convertOptions.NewName, convertOptions.ReleaseName, err)
+	}
+	// The Helm v2 release can't be found by its old name anymore once deleted, so the release
+	// converted under the new name should be checked first
+	if convertOptions.DeleteRelease && !convertOptions.Force {
+		return errors.New("new-name flag cannot be used with the delete-v2-releases flag, unless the force flag is set. Check the release converted under the new name, then remove the v2 release with cleanup")
+	}
+	return nil
+}
+
+// parseRenameTemplate parses the rename template. No template is returned if it is empty.
+func parseRenameTemplate(renameTemplate string) (*template.Template, error) {
+	if renameTemplate == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("rename").Option("missingkey=error").Parse(renameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rename template \"%s\": %s", renameTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// checkReleaseSources checks that the release versions of a release are all deployed into the same
+// namespace. Versions deployed into different namespaces are releases of the same name from the
+// storage of different Tillers, whose histories would be merged if converted together.
+func checkReleaseSources(releaseName string, v2Releases []*v2rel.Release) error {
+	namespaces := []string{}
+	versions := map[string][]string{}
+	for _, v2Release := range v2Releases {
+		if _, ok := versions[v2Release.Namespace]; !ok {
+			namespaces = append(namespaces, v2Release.Namespace)
+		}
+		versions[v2Release.Namespace] = append(versions[v2Release.Namespace], strconv.Itoa(int(v2Release.Version)))
+	}
+	if len(namespaces) <= 1 {
+		return nil
+	}
+	sources := []string{}
+	for _, namespace := range namespaces {
+		sources = append(sources, fmt.Sprintf("namespace \"%s\" (versions %s)", namespace, strings.Join(versions[namespace], ", ")))
+	}
+	return fmt.Errorf("[Helm 2] Release \"%s\" has versions deployed into different namespaces, which are different releases of the same name, e.g. managed by different Tillers: %s. Narrow down the release versions with the 'selector' or 'label' flag", releaseName, strings.Join(sources, "; "))
+}
+
+// releaseStatus returns the status code of a release version
+func releaseStatus(v2Release *v2rel.Release) v2rel.Status_Code {
+	if v2Release.Info == nil || v2Release.Info.Status == nil {
+		return v2rel.Status_UNKNOWN
+	}
+	return v2Release.Info.Status.Code
+}
+
+// deployedReleaseVersion returns the version of the release versions sorted by version which is
+// converted as the deployed one: the latest deployed version, or the latest superseded version when
+// none is deployed, e.g. as its upgrade failed. 0 is returned when there is neither, or when the
+// latest version is deleted, as the release is then uninstalled.
+func deployedReleaseVersion(v2Releases []*v2rel.Release) int32 {
+	if len(v2Releases) == 0 || releaseStatus(v2Releases[len(v2Releases)-1]) == v2rel.Status_DELETED {
+		return 0
+	}
+	var superseded int32
+	for i := len(v2Releases) - 1; i >= 0; i-- {
+		switch releaseStatus(v2Releases[i]) {
+		case v2rel.Status_DEPLOYED:
+			return v2Releases[i].Version
+		case v2rel.Status_SUPERSEDED:
+			if superseded == 0 {
+				superseded = v2Releases[i].Version
+			}
+		}
+	}
+	return superseded
+}
+
+// indexOfVersion returns the index of the release version of the version, or -1 if there is none
+func indexOfVersion(v2Releases []*v2rel.Release, version int32) int {
+	for i, v2Release := range v2Releases {
+		if version > 0 && v2Release.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// v3ReleaseStatus returns the status the Helm v2 release version is converted with as per the deployed
+// version: deployed for the deployed version, and superseded for the other versions left as deployed by
+// Helm v2. None is returned for the other versions, which keep their status.
+func v3ReleaseStatus(v2Release *v2rel.Release, deployedVersion int32) release.Status {
+	switch {
+	case v2Release.Version == deployedVersion:
+		return release.StatusDeployed
+	case releaseStatus(v2Release) == v2rel.Status_DEPLOYED:
+		return release.StatusSuperseded
+	}
+	return ""
+}
+
+// storeV3ReleaseVersion stores the Helm v3 release version, and labels it with the provenance when set.
+// It returns true if the release version was stored, also when it failed to be labelled.
+func storeV3ReleaseVersion(ctx context.Context, v3Release *release.Release, provenance *v3.Provenance, kubeConfig common.KubeConfig) (bool, error) {
+	if err := v3.StoreRelease(ctx, v3Release, kubeConfig); err != nil {
+		return false, err
+	}
+	if provenance == nil {
+		return true, nil
+	}
+	if err := v3.SetProvenance(ctx, v3Release, *provenance, kubeConfig); err != nil {
+		return true, fmt.Errorf("[Helm 3] ReleaseVersion \"%s\" was created but failed to be labelled as converted with error: %s", v2.GetReleaseVersionName(v3Release.Name, int32(v3Release.Version)), err)
+	}
+	return true, nil
+}
+
+// RollbackError is returned when the conversion of a release failed mid-way and the Helm v3 release
+// versions or namespaces it created failed to be deleted, or the existing release versions it replaced
+// or superseded failed to be restored. It wraps the error the conversion failed with.
+type RollbackError struct {
+	// Err is the error the conversion failed with
+	Err error
+	// RollbackErr is the error the rollback of the conversion failed with
+	RollbackErr error
+	// Remaining are the names of the release versions created which are left in Helm v3 storage
+	Remaining []string
+	// Unrestored are the names of the existing release versions replaced or superseded which failed to
+	// be restored
+	Unrestored []string
+	// Namespaces are the names of the namespaces created which are left in the cluster
+	Namespaces []string
+}
+
+func (e *RollbackError) Error() string {
+	msg := fmt.Sprintf("%s. The rollback of the conversion failed too, with error: %s", e.Err, e.RollbackErr)
+	if len(e.Remaining) > 0 {
+		msg += fmt.Sprintf(". The release versions left in Helm v3 storage need to be deleted: %s", strings.Join(e.Remaining, ", "))
+	}
+	if len(e.Unrestored) > 0 {
+		msg += fmt.Sprintf(". The existing release versions need to be restored: %s", strings.Join(e.Unrestored, ", "))
+	}
+	if len(e.Namespaces) > 0 {
+		msg += fmt.Sprintf(". The namespaces created need to be deleted: %s", strings.Join(e.Namespaces, ", "))
+	}
+	return msg
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// convertRollback is what the conversion of a release changed in Helm v3 storage, to be rolled back if
+// it fails mid-way: the release versions created, the existing release versions replaced, i.e.
+// deleted, or superseded, and the namespaces created
+type convertRollback struct {
+	created    []*release.Release
+	replaced   []*release.Release
+	superseded []*release.Release
+	namespaces []string
+}
+
+// releaseVersionNames returns the names of the release versions
+func releaseVersionNames(releases []*release.Release) []string {
+	names := []string{}
+	for _, rel := range releases {
+		names = append(names, v2.GetReleaseVersionName(rel.Name, int32(rel.Version)))
+	}
+	return names
+}
+
+// rollbackV3Release rolls back the conversion of a release which failed with the error, unless disabled,
+// so that Helm v3 storage is left as before the conversion: the release versions created are deleted,
+// then the existing release versions replaced are re-created and the ones superseded deployed again, and
+// the namespaces created deleted. The error of the conversion is returned, or a RollbackError if the
+// rollback failed.
+func rollbackV3Release(ctx context.Context, rollback convertRollback, convertErr error, convertOptions ConvertOptions, kubeConfig common.KubeConfig) error {
+	if len(rollback.created) == 0 && len(rollback.replaced) == 0 && len(rollback.superseded) == 0 && len(rollback.namespaces) == 0 {
+		return convertErr
+	}
+	logger := convertOptions.logger()
+	names := releaseVersionNames(rollback.created)
+	if convertOptions.NoRollbackOnFailure {
+		if len(names) > 0 {
+			logger.Infof("[Helm 3] ReleaseVersions created are kept as the 'no-rollback-on-failure' flag is set: %s\n", strings.Join(names, ", "))
+		}
+		if restored := releaseVersionNames(append(rollback.replaced, rollback.superseded...)); len(restored) > 0 {
+			logger.Infof("[Helm 3] ReleaseVersions replaced or superseded are not restored as the 'no-rollback-on-failure' flag is set: %s\n", strings.Join(restored, ", "))
+		}
+		if len(rollback.namespaces) > 0 {
+			logger.Infof("Namespaces created are kept as the 'no-rollback-on-failure' flag is set: %s\n", strings.Join(rollback.namespaces, ", "))
+		}
+		return convertErr
+	}
+
+	// The rollback has to complete even when the conversion was cancelled
+	rollbackCtx := common.DetachedContext(ctx)
+	v3KubeConfig := convertOptions.v3KubeConfig(kubeConfig)
+	if len(names) > 0 {
+		logger.Infof("[Helm 3] Conversion failed, ReleaseVersions created will be deleted: %s\n", strings.Join(names, ", "))
+		for i, rel := range rollback.created {
+			if err := v3.DeleteReleaseHistory(rollbackCtx, []*release.Release{rel}, v3KubeConfig); err != nil {
+				return &RollbackError{Err: convertErr, RollbackErr: err, Remaining: names[i:], Unrestored: releaseVersionNames(append(rollback.replaced, rollback.superseded...)), Namespaces: rollback.namespaces}
+			}
+		}
+		logger.Infof("[Helm 3] ReleaseVersions created deleted.\n")
+	}
+	// The release versions replaced are re-created once the release versions created, which can have
+	// the same versions, are deleted
+	if len(rollback.replaced) > 0 {
+		replaced := releaseVersionNames(rollback.replaced)
+		logger.Infof("[Helm 3] Conversion failed, ReleaseVersions replaced will be restored: %s\n", strings.Join(replaced, ", "))
+		if err := v3.RestoreReleaseHistory(rollbackCtx, rollback.replaced, v3KubeConfig); err != nil {
+			return &RollbackError{Err: convertErr, RollbackErr: err, Unrestored: replaced, Namespaces: rollback.namespaces}
+		}
+		logger.Infof("[Helm 3] ReleaseVersions replaced restored.\n")
+	}
+	if len(rollback.superseded) > 0 {
+		superseded := releaseVersionNames(rollback.superseded)
+		logger.Infof("[Helm 3] Conversion failed, ReleaseVersions superseded will be deployed again: %s\n", strings.Join(superseded, ", "))
+		if err := v3.DeployReleaseVersions(rollbackCtx, rollback.superseded, v3KubeConfig); err != nil {
+			return &RollbackError{Err: convertErr, RollbackErr: err, Unrestored: superseded, Namespaces: rollback.namespaces}
+		}
+		logger.Infof("[Helm 3] ReleaseVersions superseded deployed again.\n")
+	}
+	// The namespaces created are deleted last, once nothing else of the conversion is left in them
+	if len(rollback.namespaces) > 0 {
+		logger.Infof("Conversion failed, namespaces created will be deleted: %s\n", strings.Join(rollback.namespaces, ", "))
+		for i, namespace := range rollback.namespaces {
+			if err := v3.DeleteNamespace(rollbackCtx, namespace, v3KubeConfig); err != nil {
+				return &RollbackError{Err: convertErr, RollbackErr: err, Namespaces: rollback.namespaces[i:]}
+			}
+		}
+		logger.Infof("Namespaces created deleted.\n")
+	}
+	return convertErr
 }
@@ -0,0 +1,550 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	v2rel "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+func TestConvertCreatedNamespaceDeletedOnFailure(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	errStore := errors.New("etcdserver: request timed out")
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), v2ConfigMap(t, deployedRelease("rel", 2)))
+	var namespaceCreated bool
+	client.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespaceCreated = true
+		return false, nil, nil
+	})
+	// The first version is stored, the second fails
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.CreateAction).GetObject().(*corev1.Secret).Name == "sh.helm.release.v1.rel.v2" {
+			return true, nil, errStore
+		}
+		return false, nil, nil
+	})
+	convertOptions := ConvertOptions{
+		CreateNamespace:    true,
+		MaxReleaseVersions: 10,
+		ReleaseName:        "rel",
+		StorageType:        "configmaps",
+		TargetNamespace:    "apps",
+		TillerNamespace:    "kube-system",
+		TillerOutCluster:   true,
+	}
+
+	var err error
+	captureLog(func() {
+		err = Convert(context.Background(), convertOptions, common.KubeConfig{Client: client})
+	})
+	if !errors.Is(err, errStore) {
+		t.Fatalf("expected the error %q, got %v", errStore, err)
+	}
+	if !namespaceCreated {
+		t.Fatal("expected namespace apps to be created")
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "apps", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected namespace apps not to be left in the cluster, got %v", err)
+	}
+}
+
+// TestConvertAllConcurrent converts the releases with several workers. Run it with -race to detect the
+// workers sharing state.
+func TestConvertAllConcurrent(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	const releases = 8
+	var objects []runtime.Object
+	for i := 0; i < releases; i++ {
+		name := fmt.Sprintf("rel-%d", i)
+		objects = append(objects, v2ConfigMap(t, deployedRelease(name, 1)), v2ConfigMap(t, deployedRelease(name, 2)))
+	}
+	client := fake.NewSimpleClientset(objects...)
+	convertOptions := ConvertOptions{
+		Concurrency:        4,
+		MaxReleaseVersions: 10,
+		StorageType:        "configmaps",
+		TillerNamespace:    "kube-system",
+		TillerOutCluster:   true,
+	}
+
+	var err error
+	output := captureLog(func() {
+		err = ConvertAll(context.Background(), convertOptions, common.KubeConfig{Client: client})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, fmt.Sprintf("%d succeeded, 0 failed.", releases)) {
+		t.Errorf("expected the summary of %d releases converted, got:\n%s", releases, output)
+	}
+	secrets, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 2*releases {
+		t.Errorf("expected %d Helm v3 release versions, got %d", 2*releases, len(secrets.Items))
+	}
+}
+
+// v2History returns the versions of the Helm v2 release "rel" with the statuses given in order
+func v2History(versions []int32, statuses ...v2rel.Status_Code) []*v2rel.Release {
+	var v2Releases []*v2rel.Release
+	for i, version := range versions {
+		v2Release := deployedRelease("rel", version)
+		v2Release.Info.Status.Code = statuses[i]
+		v2Releases = append(v2Releases, v2Release)
+	}
+	return v2Releases
+}
+
+func TestDeployedReleaseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		releases []*v2rel.Release
+		expected int32
+	}{
+		{
+			name:     "no versions",
+			releases: nil,
+			expected: 0,
+		},
+		{
+			name:     "single revision",
+			releases: v2History([]int32{1}, v2rel.Status_DEPLOYED),
+			expected: 1,
+		},
+		{
+			name:     "gapped history",
+			releases: v2History([]int32{2, 5, 9}, v2rel.Status_SUPERSEDED, v2rel.Status_SUPERSEDED, v2rel.Status_DEPLOYED),
+			expected: 9,
+		},
+		{
+			name:     "deployed not latest",
+			releases: v2History([]int32{1, 2, 3}, v2rel.Status_SUPERSEDED, v2rel.Status_DEPLOYED, v2rel.Status_FAILED),
+			expected: 2,
+		},
+		{
+			name:     "latest superseded when none deployed",
+			releases: v2History([]int32{1, 2, 3}, v2rel.Status_SUPERSEDED, v2rel.Status_SUPERSEDED, v2rel.Status_FAILED),
+			expected: 2,
+		},
+		{
+			name:     "all failed",
+			releases: v2History([]int32{1, 2}, v2rel.Status_FAILED, v2rel.Status_FAILED),
+			expected: 0,
+		},
+		{
+			name:     "latest deleted",
+			releases: v2History([]int32{1, 2}, v2rel.Status_SUPERSEDED, v2rel.Status_DELETED),
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if deployed := deployedReleaseVersion(tt.releases); deployed != tt.expected {
+				t.Errorf("expected deployed version %d, got %d", tt.expected, deployed)
+			}
+		})
+	}
+}
+
+func TestConvertReleaseVersionsRenumbered(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		versions    []int32
+		max         int
+		keepNumbers bool
+		expected    []string
+	}{
+		{
+			name:     "gapped history",
+			versions: []int32{2, 5, 9},
+			max:      10,
+			expected: []string{"sh.helm.release.v1.rel.v1", "sh.helm.release.v1.rel.v2", "sh.helm.release.v1.rel.v3"},
+		},
+		{
+			name:        "gapped history with the numbers kept",
+			versions:    []int32{2, 5, 9},
+			max:         10,
+			keepNumbers: true,
+			expected:    []string{"sh.helm.release.v1.rel.v2", "sh.helm.release.v1.rel.v5", "sh.helm.release.v1.rel.v9"},
+		},
+		{
+			name:     "max drops the oldest versions",
+			versions: []int32{1, 2, 3, 4},
+			max:      2,
+			expected: []string{"sh.helm.release.v1.rel.v1", "sh.helm.release.v1.rel.v2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for i, version := range tt.versions {
+				v2Release := deployedRelease("rel", version)
+				if i < len(tt.versions)-1 {
+					v2Release.Info.Status.Code = v2rel.Status_SUPERSEDED
+				}
+				objects = append(objects, v2ConfigMap(t, v2Release))
+			}
+			client := fake.NewSimpleClientset(objects...)
+			convertOptions := ConvertOptions{
+				KeepVersionNumbers: tt.keepNumbers,
+				MaxReleaseVersions: tt.max,
+				ReleaseName:        "rel",
+				StorageType:        "configmaps",
+				TillerNamespace:    "kube-system",
+				TillerOutCluster:   true,
+			}
+
+			var err error
+			captureLog(func() {
+				err = Convert(context.Background(), convertOptions, common.KubeConfig{Client: client})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			secrets, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, secret := range secrets.Items {
+				names = append(names, secret.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected the Helm v3 release versions %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestConvertReleaseVersionStatuses(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		releases []*v2rel.Release
+		expected []string
+	}{
+		{
+			name:     "single revision",
+			releases: v2History([]int32{4}, v2rel.Status_DEPLOYED),
+			expected: []string{"1:deployed"},
+		},
+		{
+			name:     "deployed not latest",
+			releases: v2History([]int32{1, 2, 3}, v2rel.Status_SUPERSEDED, v2rel.Status_DEPLOYED, v2rel.Status_FAILED),
+			expected: []string{"1:superseded", "2:deployed", "3:failed"},
+		},
+		{
+			name:     "latest superseded converted as deployed",
+			releases: v2History([]int32{1, 3}, v2rel.Status_SUPERSEDED, v2rel.Status_FAILED),
+			expected: []string{"1:deployed", "2:failed"},
+		},
+		{
+			name:     "all failed",
+			releases: v2History([]int32{1, 2, 3}, v2rel.Status_FAILED, v2rel.Status_FAILED, v2rel.Status_FAILED),
+			expected: []string{"1:failed", "2:failed", "3:failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, v2Release := range tt.releases {
+				objects = append(objects, v2ConfigMap(t, v2Release))
+			}
+			kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset(objects...)}
+			convertOptions := ConvertOptions{
+				MaxReleaseVersions: 10,
+				ReleaseName:        "rel",
+				StorageType:        "configmaps",
+				TillerNamespace:    "kube-system",
+				TillerOutCluster:   true,
+			}
+
+			var err error
+			captureLog(func() {
+				err = Convert(context.Background(), convertOptions, kubeConfig)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			history, err := v3.GetReleaseHistory("rel", "default", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+			revisions := []string{}
+			for _, v3Release := range history {
+				revisions = append(revisions, fmt.Sprintf("%d:%s", v3Release.Version, v3Release.Info.Status))
+			}
+			if !reflect.DeepEqual(revisions, tt.expected) {
+				t.Errorf("expected the Helm v3 revisions %v, got %v", tt.expected, revisions)
+			}
+		})
+	}
+}
+
+// hookedConversion returns the options of the conversion of the release "rel" from the ConfigMaps of
+// Tiller out of the cluster with the hooks, and the kube config of the cluster storing its superseded and
+// deployed versions
+func hookedConversion(t *testing.T, hooks ...ConvertHook) (ConvertOptions, common.KubeConfig) {
+	t.Helper()
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset()
+	for _, v2Release := range []*v2rel.Release{deployedRelease("rel", 1), deployedRelease("rel", 2)} {
+		if v2Release.Version == 1 {
+			v2Release.Info.Status.Code = v2rel.Status_SUPERSEDED
+		}
+		if _, err := client.CoreV1().ConfigMaps("kube-system").Create(context.Background(), v2ConfigMap(t, v2Release), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	convertOptions := ConvertOptions{
+		DeleteRelease:    true,
+		Hooks:            hooks,
+		ReleaseName:      "rel",
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+	return convertOptions, common.KubeConfig{Client: client}
+}
+
+func TestConvertHooksOrder(t *testing.T) {
+	var calls []string
+	hook := func(name string) ConvertHook {
+		return func(rel *release.Release) error {
+			calls = append(calls, fmt.Sprintf("%s:%s.v%d", name, rel.Name, rel.Version))
+			if rel.Config == nil {
+				rel.Config = map[string]interface{}{}
+			}
+			rel.Config["hooked"] = name
+			return nil
+		}
+	}
+	convertOptions, kubeConfig := hookedConversion(t, hook("first"), hook("second"))
+
+	var err error
+	captureLog(func() {
+		err = Convert(context.Background(), convertOptions, kubeConfig)
+	})
+	if err != nil {
+		t.Fatalf("conversion failed with error: %s", err)
+	}
+	expected := []string{"first:rel.v1", "second:rel.v1", "first:rel.v2", "second:rel.v2"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the hooks to be called in order on each version, as %v, got %v", expected, calls)
+	}
+	history, err := v3.GetReleaseHistory("rel", "default", kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected the 2 versions of the release in Helm v3 storage, got %d", len(history))
+	}
+	for _, v3Release := range history {
+		if v3Release.Config["hooked"] != "second" {
+			t.Errorf("expected version %d to be stored as mutated by the last hook, got %v", v3Release.Version, v3Release.Config)
+		}
+	}
+}
+
+func TestConvertHookError(t *testing.T) {
+	errHook := errors.New("hook failed")
+	var calls []string
+	convertOptions, kubeConfig := hookedConversion(t,
+		func(rel *release.Release) error {
+			calls = append(calls, fmt.Sprintf("first:%s.v%d", rel.Name, rel.Version))
+			return nil
+		},
+		func(rel *release.Release) error {
+			calls = append(calls, fmt.Sprintf("second:%s.v%d", rel.Name, rel.Version))
+			if rel.Version == 2 {
+				return errHook
+			}
+			return nil
+		},
+		func(rel *release.Release) error {
+			calls = append(calls, fmt.Sprintf("third:%s.v%d", rel.Name, rel.Version))
+			return nil
+		},
+	)
+
+	var err error
+	captureLog(func() {
+		err = Convert(context.Background(), convertOptions, kubeConfig)
+	})
+	if !errors.Is(err, errHook) {
+		t.Fatalf("expected the error of the hook, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "conversion hook 2") || !strings.Contains(err.Error(), "Nothing was stored") {
+		t.Errorf("expected the error to name the failed hook, got %q", err)
+	}
+	expected := []string{"first:rel.v1", "second:rel.v1", "third:rel.v1", "first:rel.v2", "second:rel.v2"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the hooks to stop at the failed hook, as %v, got %v", expected, calls)
+	}
+	if history, err := v3.GetReleaseHistory("rel", "default", kubeConfig); err == nil && len(history) > 0 {
+		t.Errorf("expected nothing to be stored in Helm v3 storage, got %d versions", len(history))
+	}
+	secrets, err := kubeConfig.Client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) > 0 {
+		t.Errorf("expected no Helm v3 storage objects, got %d", len(secrets.Items))
+	}
+	for _, name := range []string{"rel.v1", "rel.v2"} {
+		if _, err := kubeConfig.Client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s to be left in Helm v2 storage, got %v", name, err)
+		}
+	}
+}
+
+func TestConvertErrorIdentity(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken.v1", Namespace: "kube-system", Labels: map[string]string{"NAME": "broken", "OWNER": "TILLER", "VERSION": "1"}},
+		Data:       map[string]string{"release": "not a release"},
+	}
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), corrupt)}
+	convertOptions := ConvertOptions{
+		Logger:             &commontest.RecordingLogger{},
+		MaxReleaseVersions: 10,
+		StorageType:        "configmaps",
+		TillerNamespace:    "kube-system",
+		TillerOutCluster:   true,
+	}
+
+	convertOptions.ReleaseName = "missing"
+	err := Convert(context.Background(), convertOptions, kubeConfig)
+	if !errors.Is(err, v2.ErrNoVersionsFound) {
+		t.Errorf("expected ErrNoVersionsFound for a release with no versions, got %v", err)
+	}
+
+	convertOptions.ReleaseName = "broken"
+	err = Convert(context.Background(), convertOptions, kubeConfig)
+	var corruptErr *v2.CorruptRecordsError
+	if !errors.As(err, &corruptErr) {
+		t.Fatalf("expected a CorruptRecordsError for a release which can't be decoded, got %v", err)
+	}
+	if corruptErr.ReleaseName != "broken" || len(corruptErr.Records) != 1 {
+		t.Errorf("expected the corrupt record of broken.v1, got %+v", corruptErr)
+	}
+	if !errors.Is(err, v2.ErrCorruptRecord) {
+		t.Errorf("expected ErrCorruptRecord for a release which can't be decoded, got %v", err)
+	}
+
+	convertOptions.ReleaseName = "rel"
+	if err := Convert(context.Background(), convertOptions, kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+	err = Convert(context.Background(), convertOptions, kubeConfig)
+	if !errors.Is(err, v3.ErrReleaseAlreadyExists) {
+		t.Errorf("expected ErrReleaseAlreadyExists for a release already converted, got %v", err)
+	}
+}
+
+// writeExportFile writes the export file of the ConfigMaps storing the release versions, as output by
+// 'kubectl get configmaps -o json', and returns its path
+func writeExportFile(t *testing.T, dir string, v2Releases []*v2rel.Release) string {
+	t.Helper()
+	list := corev1.ConfigMapList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+	for _, v2Release := range v2Releases {
+		configMap := v2ConfigMap(t, v2Release)
+		configMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		list.Items = append(list.Items, *configMap)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "releases.json")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConvertFromFileWithoutCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-from-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Neither a kubeconfig nor the in-cluster configuration is available
+	defer commontest.SetEnv(map[string]string{"HOME": dir, "KUBECONFIG": "", "KUBERNETES_SERVICE_HOST": "", "KUBERNETES_SERVICE_PORT": ""})()
+	if _, err := (common.KubeConfig{}).ClientSet(); err == nil {
+		t.Fatal("expected no cluster to be configured")
+	}
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	toDir := filepath.Join(dir, "manifests")
+	convertOptions := ConvertOptions{
+		FromFile:    writeExportFile(t, dir, v2History([]int32{1, 2}, v2rel.Status_SUPERSEDED, v2rel.Status_DEPLOYED)),
+		Logger:      &commontest.RecordingLogger{},
+		ReleaseName: "rel",
+		ToDir:       toDir,
+	}
+
+	if err := Convert(context.Background(), convertOptions, common.KubeConfig{}); err != nil {
+		t.Fatalf("conversion from the export file failed with error: %s", err)
+	}
+	for _, name := range []string{"sh.helm.release.v1.rel.v1.yaml", "sh.helm.release.v1.rel.v2.yaml"} {
+		if _, err := os.Stat(filepath.Join(toDir, "default", name)); err != nil {
+			t.Errorf("expected the manifest file %s to be written, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+var (
+	deletePropagation string
+	gracePeriod       int64
+)
+
+// addDeletionFlags binds the flags of the propagation policy and grace period of the deletions of the
+// Kubernetes objects, the default policy of which is described by defaults
+func addDeletionFlags(fs *pflag.FlagSet, usage, defaults string) {
+	fs.StringVar(&deletePropagation, "delete-propagation", "", "propagation policy of the deletions of "+usage+": 'background', 'foreground' or 'orphan', e.g. for the admission webhooks which require foreground deletion. By default, "+defaults)
+	fs.Int64Var(&gracePeriod, "grace-period", -1, "number of seconds the objects deleted are given to terminate. Use -1 for the default of the objects, and 0 to delete them immediately")
+}
+
+// objectDeletion returns the propagation policy and grace period of the deletion flags
+func objectDeletion() v2.ObjectDeletion {
+	deletion := v2.ObjectDeletion{Propagation: deletePropagation}
+	if gracePeriod >= 0 {
+		period := gracePeriod
+		deletion.GracePeriod = &period
+	}
+	return deletion
+}
+
+// validateDeletionFlags checks the deletion flags
+func validateDeletionFlags() error {
+	if gracePeriod < -1 {
+		return errors.New("grace-period flag can not be lower than -1. Use -1 for the default grace period of the objects")
+	}
+	if err := v2.ValidateObjectDeletion(objectDeletion()); err != nil {
+		return fmt.Errorf("delete-propagation flag is not valid: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/gosuri/uitable"
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// The results of the checks of the doctor command. Only failed checks make the command fail.
+const (
+	DoctorPass = "PASS"
+	DoctorWarn = "WARN"
+	DoctorFail = "FAIL"
+)
+
+// DoctorCheck describes the result of a check of the environment of the plugin
+type DoctorCheck struct {
+	Check   string `json:"check"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
+}
+
+func newDoctorCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "check the environment of the plugin before migrating: cluster access, Helm v2 storage and Helm directories",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctor(cmd.Context(), out)
+		},
+	}
+
+	flags := cmd.Flags()
+	settings.AddRetrieveFlags(flags)
+	settings.AddOutputFlag(flags)
+
+	return cmd
+}
+
+func runDoctor(ctx context.Context, out io.Writer) error {
+	ctx, cancel := settings.WithTimeout(ctx)
+	defer cancel()
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         settings.Selector,
+		TillerNamespace:  settings.TillerNamespace,
+		TillerLabel:      settings.Label,
+		TillerOutCluster: settings.TillerOutCluster,
+		StorageType:      settings.ReleaseStorage,
+		SQLConnection:    settings.TillerSQLConnection,
+		StorageDir:       settings.TillerStorageDir,
+	}
+	checks := Doctor(ctx, retrieveOptions, settings.KubeConfig())
+
+	if err := common.PrintOutput(out, settings.Output, doctorTable(checks)); err != nil {
+		return err
+	}
+	failed := 0
+	for _, check := range checks {
+		if check.Result == DoctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// doctorTable prints the results of the checks as a table
+type doctorTable []DoctorCheck
+
+func (checks doctorTable) PrintTable(out io.Writer) error {
+	table := uitable.New()
+	table.MaxColWidth = 100
+	table.Wrap = true
+	table.AddRow("CHECK", "RESULT", "MESSAGE")
+	for _, check := range checks {
+		table.AddRow(check.Check, check.Result, check.Message)
+	}
+	_, err := fmt.Fprintln(out, table)
+	return err
+}
+
+// Doctor checks the environment the plugin migrates from and to: the access to the cluster, Tiller and
+// the storage format of its version, the Tiller namespace and the permissions on the Helm v2 storage in it, the Helm v2 releases found, and the
+// Helm v2 and v3 directories. The checks which need the cluster are warned about as not checked when
+// it can't be reached.
+func Doctor(ctx context.Context, retrieveOptions v2.RetrieveOptions, kubeConfig common.KubeConfig) []DoctorCheck {
+	checks := []DoctorCheck{}
+	add := func(check, result, format string, a ...interface{}) {
+		checks = append(checks, DoctorCheck{Check: check, Result: result, Message: fmt.Sprintf(format, a...)})
+	}
+
+	reachable := false
+	if clientSet, err := kubeConfig.ClientSet(); err != nil {
+		add("Kubernetes cluster", DoctorFail, "%s", err)
+	} else if serverVersion, err := clientSet.Discovery().ServerVersion(); err != nil {
+		add("Kubernetes cluster", DoctorFail, "cluster can't be reached: %s", err)
+	} else {
+		reachable = true
+		server, _ := kubeConfig.Server()
+		add("Kubernetes cluster", DoctorPass, "Kubernetes %s at %s", serverVersion.GitVersion, server)
+	}
+
+	namespace := retrieveOptions.TillerNamespace
+	if reachable {
+		if err := v2.CheckTillerNamespace(ctx, retrieveOptions, kubeConfig); errors.Is(err, v2.ErrTillerNamespaceNotFound) {
+			add("Tiller namespace", DoctorFail, "namespace \"%s\" not found. Set the 'tiller-ns' flag to the namespace of Tiller", namespace)
+		} else if err != nil {
+			add("Tiller namespace", DoctorWarn, "namespace \"%s\" failed to be checked: %s", namespace, err)
+		}
+	}
+	var tiller *v2.TillerInstance
+	switch {
+	case retrieveOptions.TillerOutCluster:
+		add("Tiller", DoctorPass, "not checked, as Tiller is not running in the cluster (--tiller-out-cluster)")
+	case !reachable:
+		add("Tiller", DoctorWarn, "not checked, as the cluster can't be reached")
+	default:
+		var err error
+		tiller, err = v2.GetTiller(ctx, namespace, kubeConfig)
+		switch {
+		case err != nil:
+			add("Tiller", DoctorWarn, "Tiller Deployment failed to be read in namespace \"%s\": %s", namespace, err)
+		case tiller == nil:
+			// The releases of Tillerless Helm v2 are stored with no Tiller running in the cluster
+			if storageType, err := v2.GetStorageType(ctx, retrieveOptions, kubeConfig); err == nil {
+				add("Tiller", DoctorPass, "no Tiller Deployment found in namespace \"%s\", the releases being stored in %s by Tillerless Helm v2", namespace, storageType)
+				break
+			}
+			add("Tiller", DoctorFail, "no Tiller Deployment found in namespace \"%s\". Set the 'tiller-ns' flag to its namespace, or the 'tiller-out-cluster' flag when Tiller is not running in the cluster", namespace)
+		case tiller.ReadyReplicas == 0:
+			add("Tiller", DoctorWarn, "Tiller %s \"%s\" has no ready replicas in namespace \"%s\". The releases can be migrated without it", tiller.Version, tiller.Name, namespace)
+		default:
+			add("Tiller", DoctorPass, "Tiller %s \"%s\" is running in namespace \"%s\" (%d/%d ready)", tiller.Version, tiller.Name, namespace, tiller.ReadyReplicas, tiller.Replicas)
+		}
+	}
+
+	// The release records written by a Tiller older than v2.7 are mis-converted
+	switch {
+	case retrieveOptions.TillerOutCluster:
+		add("Helm v2 storage format", DoctorPass, "not checked, as Tiller is not running in the cluster. The release records are checked as they are converted")
+	case tiller != nil:
+		if err := v2.CheckTillerVersion(tiller.Version); err != nil {
+			add("Helm v2 storage format", DoctorFail, "%s. Set the 'force' flag of convert to convert them anyway", err)
+		} else {
+			add("Helm v2 storage format", DoctorPass, "release records of Tiller %s are supported", tiller.Version)
+		}
+	}
+
+	storageType := ""
+	clusterStorage := false
+	switch {
+	case retrieveOptions.TillerOutCluster && retrieveOptions.StorageDir != "":
+		storageType = "dir"
+	case retrieveOptions.TillerOutCluster && retrieveOptions.StorageType != v2.StorageAuto:
+		storageType = retrieveOptions.StorageType
+	case reachable:
+		var err error
+		storageType, err = v2.GetStorageType(ctx, retrieveOptions, kubeConfig)
+		if err != nil {
+			add("Helm v2 storage", DoctorFail, "%s", err)
+		}
+	}
+	switch storageType {
+	case "":
+		if !reachable {
+			add("Helm v2 storage", DoctorWarn, "not checked, as the cluster can't be reached")
+		}
+	case "dir":
+		add("Helm v2 storage", DoctorPass, "release records read from directory \"%s\"", retrieveOptions.StorageDir)
+	case "sql":
+		add("Helm v2 storage", DoctorPass, "releases stored in SQL")
+	default:
+		clusterStorage = true
+		if retrieveOptions.TillerOutCluster && retrieveOptions.StorageType == v2.StorageAuto {
+			add("Helm v2 storage", DoctorPass, "releases stored in %s in namespace \"%s\", as detected with the 'release-storage' flag set to 'auto'", storageType, namespace)
+			break
+		}
+		add("Helm v2 storage", DoctorPass, "releases stored in %s in namespace \"%s\"", storageType, namespace)
+	}
+
+	if clusterStorage {
+		// Getting and listing the release versions is needed to migrate them, deleting them only to clean them up
+		for _, verb := range []string{"get", "list", "delete"} {
+			check := fmt.Sprintf("Permission to %s %s", verb, storageType)
+			if !reachable {
+				add(check, DoctorWarn, "not checked, as the cluster can't be reached")
+				continue
+			}
+			allowed, reason, err := kubeConfig.CanI(ctx, verb, storageType, namespace)
+			switch {
+			case err != nil:
+				add(check, DoctorWarn, "access review failed: %s", err)
+			case allowed:
+				add(check, DoctorPass, "allowed in namespace \"%s\"", namespace)
+			case verb == "delete":
+				add(check, DoctorWarn, "not allowed in namespace \"%s\", so the Helm v2 releases can't be cleaned up. %s", namespace, reason)
+			default:
+				add(check, DoctorFail, "not allowed in namespace \"%s\". Permission needs to be granted by RBAC. %s", namespace, reason)
+			}
+		}
+	}
+
+	if storageType != "" && (reachable || !clusterStorage) {
+		list, err := v2.ListReleases(ctx, retrieveOptions, kubeConfig)
+		switch {
+		case err != nil:
+			add("Helm v2 releases", DoctorFail, "releases failed to be listed: %s", err)
+		case len(list.Releases) == 0:
+			add("Helm v2 releases", DoctorWarn, "no releases found for the Tiller namespace and label")
+		default:
+			add("Helm v2 releases", DoctorPass, "%d releases found", len(list.Releases))
+		}
+	}
+
+	// The Helm v2 home is only needed to move the configuration, and the Helm v3 directories are created
+	// by Helm v3 when missing, so only directories which can't be written to fail
+	for _, dir := range []struct {
+		check string
+		path  string
+	}{
+		{"Helm v2 home", v2.HomeDir()},
+		{"Helm v3 config", v3.ConfigDir()},
+		{"Helm v3 data", v3.DataDir()},
+		{"Helm v3 cache", v3.CacheDir()},
+	} {
+		info, err := os.Stat(dir.path)
+		switch {
+		case os.IsNotExist(err):
+			add(dir.check, DoctorWarn, "\"%s\" does not exist", dir.path)
+		case err != nil:
+			add(dir.check, DoctorFail, "\"%s\" can't be read: %s", dir.path, err)
+		case !info.IsDir():
+			add(dir.check, DoctorFail, "\"%s\" is not a directory", dir.path)
+		default:
+			if err := checkWritable(dir.path); err != nil {
+				add(dir.check, DoctorFail, "\"%s\" is not writable: %s", dir.path, err)
+			} else {
+				add(dir.check, DoctorPass, "\"%s\" exists and is writable", dir.path)
+			}
+		}
+	}
+	return checks
+}
+
+// checkWritable checks that a file can be created in the directory, by creating and removing one
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".2to3-doctor-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// envFlagPrefix is the prefix of the environment variables setting the boolean flags, for the CI
+// systems which can't pass flags to the plugin, e.g. HELM_2TO3_SKIP_CONFIRMATION for 'skip-confirmation'
+const envFlagPrefix = "HELM_2TO3_"
+
+// helmV2EnvFlags are the environment variables of the Helm v2 CLI which set the default of the flags
+// of the same meaning, e.g. TILLER_NAMESPACE for 'tiller-ns'
+var helmV2EnvFlags = map[string]string{
+	"tiller-ns": "TILLER_NAMESPACE",
+}
+
+// envFlagName returns the environment variable setting the flag
+func envFlagName(flag string) string {
+	return envFlagPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// setFlagsFromEnv sets the boolean flags of the flagset which are not set on the command line from
+// their environment variables, the flags set on the command line taking precedence over them. The
+// source of each flag whose environment variable is set is returned, to be logged in debug once the
+// log level is set.
+func setFlagsFromEnv(fs *pflag.FlagSet) ([]string, error) {
+	sources := []string{}
+	var err error
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Value.Type() != "bool" || flag.Name == "help" {
+			return
+		}
+		env := envFlagName(flag.Name)
+		value, exists := os.LookupEnv(env)
+		if !exists {
+			return
+		}
+		if flag.Changed {
+			sources = append(sources, fmt.Sprintf("flag '%s' is set to %s by the command line, environment variable %s being ignored", flag.Name, flag.Value, env))
+			return
+		}
+		if setErr := fs.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s of flag '%s' needs to be 'true' or 'false': %s", env, flag.Name, setErr)
+			return
+		}
+		sources = append(sources, fmt.Sprintf("flag '%s' is set to %s by environment variable %s", flag.Name, flag.Value, env))
+	})
+	return sources, err
+}
+
+// setFlagsFromHelmV2Env sets the flags of the flagset which are not set on the command line from the
+// environment variables of the Helm v2 CLI, as per helmV2EnvFlags, so that the flag takes precedence over
+// the environment variable, and the environment variable over the default of the flag. The source of
+// each flag whose environment variable is set is returned, to be logged in debug.
+func setFlagsFromHelmV2Env(fs *pflag.FlagSet) ([]string, error) {
+	sources := []string{}
+	for name, env := range helmV2EnvFlags {
+		flag := fs.Lookup(name)
+		value := os.Getenv(env)
+		if flag == nil || value == "" {
+			continue
+		}
+		if flag.Changed {
+			sources = append(sources, fmt.Sprintf("flag '%s' is set to %s by the command line, environment variable %s being ignored", flag.Name, flag.Value, env))
+			continue
+		}
+		if err := fs.Set(flag.Name, value); err != nil {
+			return nil, fmt.Errorf("environment variable %s of flag '%s' is not valid: %s", env, flag.Name, err)
+		}
+		sources = append(sources, fmt.Sprintf("flag '%s' is set to %s by environment variable %s", flag.Name, flag.Value, env))
+	}
+	return sources, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      string
+		expected bool
+		source   string
+		err      string
+	}{
+		{"default", nil, "", false, "", ""},
+		{"environment", nil, "true", true, "set to true by environment variable HELM_2TO3_TILLERLESS", ""},
+		{"environment false", nil, "false", false, "set to false by environment variable HELM_2TO3_TILLERLESS", ""},
+		{"flag over environment", []string{"--tillerless=false"}, "true", false, "set to false by the command line, environment variable HELM_2TO3_TILLERLESS being ignored", ""},
+		{"flag without environment", []string{"--tillerless"}, "", true, "", ""},
+		{"invalid environment", nil, "yes", false, "", "environment variable HELM_2TO3_TILLERLESS of flag 'tillerless' needs to be 'true' or 'false'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer commontest.SetEnv(map[string]string{"HELM_2TO3_TILLERLESS": test.env, "HELM_2TO3_TILLER_NS": "ignored"})()
+			s := New()
+			fs := validatedFlags(s)
+			if err := fs.Parse(test.args); err != nil {
+				t.Fatal(err)
+			}
+			sources, err := setFlagsFromEnv(fs)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Tillerless != test.expected {
+				t.Errorf("expected tillerless to be %t, got %t", test.expected, s.Tillerless)
+			}
+			if s.TillerNamespace != "kube-system" {
+				t.Errorf("expected the environment variable of the string flag tiller-ns to be ignored, got %q", s.TillerNamespace)
+			}
+			if test.source == "" {
+				if len(sources) != 0 {
+					t.Errorf("expected no source, got %q", sources)
+				}
+				return
+			}
+			if len(sources) != 1 || !strings.Contains(sources[0], test.source) {
+				t.Errorf("expected the source %q, got %q", test.source, sources)
+			}
+		})
+	}
+}
+
+func TestSetFlagsFromHelmV2Env(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      string
+		expected string
+		source   string
+	}{
+		{"default", nil, "", "kube-system", ""},
+		{"environment", nil, "tiller", "tiller", "flag 'tiller-ns' is set to tiller by environment variable TILLER_NAMESPACE"},
+		{"flag over environment", []string{"--tiller-ns", "other"}, "tiller", "other", "flag 'tiller-ns' is set to other by the command line, environment variable TILLER_NAMESPACE being ignored"},
+		{"flag without environment", []string{"-t", "other"}, "", "other", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer commontest.SetEnv(map[string]string{"TILLER_NAMESPACE": test.env})()
+			s := New()
+			fs := validatedFlags(s)
+			if err := fs.Parse(test.args); err != nil {
+				t.Fatal(err)
+			}
+			sources, err := setFlagsFromHelmV2Env(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.TillerNamespace != test.expected {
+				t.Errorf("expected tiller-ns to be %q, got %q", test.expected, s.TillerNamespace)
+			}
+			if test.source == "" {
+				if len(sources) != 0 {
+					t.Errorf("expected no source, got %q", sources)
+				}
+				return
+			}
+			if len(sources) != 1 || sources[0] != test.source {
+				t.Errorf("expected the source %q, got %q", test.source, sources)
+			}
+		})
+	}
+}
+
+func TestSetFlagsFromEnvCommandWithoutFlag(t *testing.T) {
+	defer commontest.SetEnv(map[string]string{"TILLER_NAMESPACE": "tiller", "HELM_2TO3_TILLERLESS": "true"})()
+	s := New()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	s.AddBaseFlags(fs)
+	if _, err := setFlagsFromEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setFlagsFromHelmV2Env(fs); err != nil {
+		t.Fatal(err)
+	}
+	if s.TillerNamespace != "" || s.Tillerless {
+		t.Errorf("expected the flags the command does not have to be left unset, got tiller-ns %q and tillerless %t", s.TillerNamespace, s.Tillerless)
+	}
+}
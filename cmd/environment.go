@@ -16,16 +16,59 @@ limitations under the License.
 
 package cmd
 
-import "github.com/spf13/pflag"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
 
 type EnvSettings struct {
-	DryRun           bool
-	KubeConfigFile   string
-	KubeContext      string
-	Label            string
-	ReleaseStorage   string
-	TillerNamespace  string
-	TillerOutCluster bool
+	AuditLog            string
+	Debug               bool
+	DryRun              bool
+	FailOnEmpty         bool
+	Impersonate         string
+	KubeAPIServer       string
+	KubeCAFile          string
+	KubeInsecure        bool
+	KubeToken           string
+	ImpersonateGroups   []string
+	InCluster           bool
+	KubeAPIBurst        int
+	KubeAPIQPS          float32
+	KubeConfigFile      string
+	KubeContext         string
+	Label               string
+	NoProgress          bool
+	Output              string
+	Quiet               bool
+	ReleaseStorage      string
+	RequestTimeout      time.Duration
+	Retries             int
+	RetryBackoff        time.Duration
+	Selector            string
+	SkipNamespaceCheck  bool
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+	Tillerless          bool
+	Timeout             time.Duration
+	V2Home              string
+	V3CacheDir          string
+	V3ConfigDir         string
+	V3DataDir           string
+	V3SQLConnection     string
+	V3Storage           string
 }
 
 func New() *EnvSettings {
@@ -41,11 +84,290 @@ func (s *EnvSettings) AddBaseFlags(fs *pflag.FlagSet) {
 // AddFlags binds flags to the given flagset.
 func (s *EnvSettings) AddFlags(fs *pflag.FlagSet) {
 	s.AddBaseFlags(fs)
+	s.AddRetrieveFlags(fs)
+}
+
+// AddRetrieveFlags binds the flags used to retrieve Helm v2 release data to the given flagset.
+func (s *EnvSettings) AddRetrieveFlags(fs *pflag.FlagSet) {
+	s.AddKubeFlags(fs)
+	fs.StringVarP(&s.TillerNamespace, "tiller-ns", "t", "kube-system", "namespace of Tiller. By default, the TILLER_NAMESPACE environment variable is used as by the Helm v2 CLI, or 'kube-system' when it is not set")
+	fs.StringVarP(&s.Label, "label", "l", "OWNER=TILLER", "label selector to select Tiller resources by, e.g. 'OWNER=TILLER' or 'OWNER in (TILLER,tiller)'. Set it to \"\" for the release storage objects with any OWNER label or none, which are then selected by the NAME and VERSION labels of Tiller")
+	fs.StringVar(&s.Selector, "selector", "", "label selector to filter Helm v2 release storage objects by, in addition to the Tiller label (e.g. team=a,tier!=db)")
+	fs.BoolVar(&s.SkipNamespaceCheck, "skip-namespace-check", false, "if set, the Tiller namespace is not checked to exist before the releases are read, e.g. for Tillerless or out-of-cluster setups whose namespace may not exist. By default, a Tiller namespace which does not exist fails the command, as it is likely a typo of the tiller-ns flag")
+	fs.BoolVar(&s.TillerOutCluster, "tiller-out-cluster", false, "when  Tiller is not running in the cluster e.g. Tillerless")
+	fs.BoolVar(&s.Tillerless, "tillerless", false, "if set, the releases are read as stored by Tillerless Helm v2, e.g. the helm-tiller plugin: in the Secrets of the Tiller namespace, with no Tiller running in the cluster. It sets the 'tiller-out-cluster' flag, and the 'release-storage' flag to 'secrets' unless set")
+	fs.StringVarP(&s.ReleaseStorage, "release-storage", "s", v2.StorageAuto, "v2 release storage type/object. It can be 'auto', 'secrets', 'configmaps' or 'sql'. 'auto' detects whether the release storage objects with the Tiller label are in the ConfigMaps or Secrets of the Tiller namespace, failing when they are in both. It is only used when Tiller is not running in the cluster, the storage of a running Tiller being used otherwise")
+	fs.StringVar(&s.TillerStorageDir, "tiller-storage-dir", "", "local directory of the v2 release records exported from Tiller (one <release>.v<version> file per release version), which are used in place of the release storage. Can only be used with the 'tiller-out-cluster' flag")
+	fs.StringVar(&s.TillerSQLConnection, "tiller-sql-connection", "", "connection string (DSN) of the PostgreSQL database of Tiller, when it stores the releases in SQL (--storage=sql)")
+}
+
+// AddKubeFlags binds the flags used to access the cluster to the given flagset.
+func (s *EnvSettings) AddKubeFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.KubeConfigFile, "kubeconfig", "", "path to the kubeconfig file")
 	fs.StringVar(&s.KubeContext, "kube-context", s.KubeContext, "name of the kubeconfig context to use")
-	fs.StringVarP(&s.TillerNamespace, "tiller-ns", "t", "kube-system", "namespace of Tiller")
-	fs.StringVarP(&s.Label, "label", "l", "OWNER=TILLER", "label to select Tiller resources by")
-	fs.BoolVar(&s.TillerOutCluster, "tiller-out-cluster", false, "when  Tiller is not running in the cluster e.g. Tillerless")
-	fs.StringVarP(&s.ReleaseStorage, "release-storage", "s", "secrets", "v2 release storage type/object. It can be 'secrets' or 'configmaps'. This is only used with the 'tiller-out-cluster' flag")
+	fs.BoolVar(&s.InCluster, "in-cluster", false, "if set, the in-cluster configuration of the pod the plugin runs in is used. It is used by default when no kubeconfig file is found. Cannot be used with the 'kube-context' flag")
+	fs.StringVar(&s.KubeAPIServer, "kube-apiserver", "", "address of the Kubernetes API server, e.g. https://10.0.0.1:6443, for the environments which have no kubeconfig file. When set, the cluster is accessed with it and the kube-token and kube-ca-file flags, in place of the kubeconfig file and the in-cluster configuration")
+	fs.StringVar(&s.KubeToken, "kube-token", "", "bearer token of the Kubernetes API requests. Can only be used with the kube-apiserver flag")
+	fs.StringVar(&s.KubeCAFile, "kube-ca-file", "", "path to the CA bundle the certificate of the Kubernetes API server is verified with. Can only be used with the kube-apiserver flag")
+	fs.BoolVar(&s.KubeInsecure, "kube-insecure-skip-tls-verify", false, "if set, the certificate of the Kubernetes API server is not verified. It makes the connection insecure, and is only meant for testing. Can only be used with the kube-apiserver flag")
+	fs.StringVar(&s.Impersonate, "as", "", "username to impersonate for the Kubernetes API requests. There is no --as-uid flag, as the UID can't be impersonated")
+	fs.StringArrayVar(&s.ImpersonateGroups, "as-group", []string{}, "group to impersonate for the Kubernetes API requests. This flag can be repeated to specify multiple groups")
+	fs.Float32Var(&s.KubeAPIQPS, "kube-api-qps", 0, "queries per second allowed to the Kubernetes API. Use 0 for the client-go default (5)")
+	fs.IntVar(&s.KubeAPIBurst, "kube-api-burst", 0, "burst of queries allowed to the Kubernetes API. Use 0 for the client-go default (10)")
+	fs.DurationVar(&s.Timeout, "timeout", 0, "maximum time of the whole command, e.g. 30m. The command stops at the next Kubernetes API request once it is reached. Use 0 for no limit")
+	fs.DurationVar(&s.RequestTimeout, "request-timeout", 300*time.Second, "maximum time of a single Kubernetes API request, so that a hung API server fails the request instead of blocking the command. Use 0 for no limit")
+}
+
+// WithTimeout returns the context of the command, canceled once the timeout flag is reached when set.
+func (s *EnvSettings) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.Timeout)
+}
+
+// AddV3StorageFlags binds the flags selecting the Helm v3 storage driver to the given flagset.
+func (s *EnvSettings) AddV3StorageFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.V3Storage, "v3-storage", "", "Helm v3 storage driver the releases are stored in. It can be 'secret', 'configmap' or 'sql'. By default, the HELM_DRIVER environment variable is used")
+	fs.StringVar(&s.V3SQLConnection, "v3-sql-connection", "", "connection string (DSN) of the database of the 'sql' Helm v3 storage driver")
+}
+
+// AddOutputFlag binds the flag selecting the output format of the result of the command to the given flagset.
+func (s *EnvSettings) AddOutputFlag(fs *pflag.FlagSet) {
+	fs.StringVarP(&s.Output, "output", "o", common.OutputTable, "output format of the result. Allowed values: table, json, yaml. The log lines are written to the standard error in json and yaml")
+}
+
+// AddRetryFlags binds the flags for retrying Kubernetes API calls to the given flagset.
+func (s *EnvSettings) AddRetryFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&s.Retries, "retries", 3, "maximum number of retries of a Helm storage object create or delete which fails with a transient Kubernetes API error (throttling, conflict or server timeout)")
+	fs.DurationVar(&s.RetryBackoff, "retry-backoff", time.Second, "delay before the first retry, doubled for each retry after it")
+}
+
+// AddAuditLogFlag binds the flag of the audit log of the mutating operations to the given flagset.
+func (s *EnvSettings) AddAuditLogFlag(fs *pflag.FlagSet) {
+	fs.StringVar(&s.AuditLog, "audit-log", "", "path of a file the mutating operations are appended to, one JSON line per create, update, patch or delete of an object (Helm storage objects, Tiller objects, namespaces), as they happen. In dry-run, the operations which would be run are written with \"dryRun\": true")
+}
+
+// SetTillerlessDefaults sets the flags implied by the tillerless flag: Tiller is not running in the
+// cluster, and the releases are stored in Secrets unless the release storage is set to another storage
+// than 'auto'.
+func (s *EnvSettings) SetTillerlessDefaults() {
+	if !s.Tillerless {
+		return
+	}
+	s.TillerOutCluster = true
+	if s.ReleaseStorage == "" || s.ReleaseStorage == v2.StorageAuto {
+		s.ReleaseStorage = "secrets"
+	}
+}
+
+// SetLabelDefault sets the Tiller label to select the release storage objects with any OWNER label, or
+// with none, when the label flag is set to empty
+func (s *EnvSettings) SetLabelDefault() {
+	if s.Label == "" {
+		s.Label = v2.AnyOwnerLabel
+	}
+}
+
+// CheckTillerNamespace checks that the Tiller namespace exists in the cluster, as per v2.CheckTillerNamespace,
+// unless the namespace check is skipped.
+func (s *EnvSettings) CheckTillerNamespace(ctx context.Context) error {
+	if s.SkipNamespaceCheck {
+		return nil
+	}
+	retrieveOptions := v2.RetrieveOptions{
+		TillerNamespace:  s.TillerNamespace,
+		TillerOutCluster: s.TillerOutCluster,
+		StorageType:      s.ReleaseStorage,
+		StorageDir:       s.TillerStorageDir,
+	}
+	return v2.CheckTillerNamespace(ctx, retrieveOptions, s.KubeConfig())
+}
+
+// KubeConfig returns the kube config as per the kubeconfig, in-cluster, Kubernetes API, impersonation and request timeout flags.
+func (s *EnvSettings) KubeConfig() common.KubeConfig {
+	return common.KubeConfig{
+		Context:               s.KubeContext,
+		File:                  s.KubeConfigFile,
+		APIServer:             s.KubeAPIServer,
+		Token:                 s.KubeToken,
+		CAFile:                s.KubeCAFile,
+		InsecureSkipTLSVerify: s.KubeInsecure,
+		InCluster:             s.InCluster,
+		QPS:                   s.KubeAPIQPS,
+		Burst:                 s.KubeAPIBurst,
+		Impersonate:           s.Impersonate,
+		ImpersonateGroups:     s.ImpersonateGroups,
+		RequestTimeout:        s.RequestTimeout,
+	}
+}
+
+// Validate checks the settings of the flags the command has, so that an invalid flag or combination
+// of flags fails the command before anything is printed, prompted or run. It is run for all commands
+// once the flags are set from the command line and the environment.
+func (s *EnvSettings) Validate(fs *pflag.FlagSet) error {
+	has := func(flag string) bool {
+		return fs.Lookup(flag) != nil
+	}
+	if has("output") {
+		if err := common.ValidateOutputFormat(s.Output); err != nil {
+			return err
+		}
+	}
+	if has("tiller-ns") {
+		if s.TillerNamespace == "" {
+			return errors.New("tiller-ns flag can not be empty. Set it to the namespace Tiller is deployed into, 'kube-system' by default")
+		}
+		if s.Tillerless && s.TillerStorageDir != "" {
+			return errors.New("tillerless flag cannot be used with the tiller-storage-dir flag. Set the 'tiller-out-cluster' flag instead to read the release records of the directory")
+		}
+		if err := s.ValidateStorageFlags(); err != nil {
+			return err
+		}
+	}
+	if has("kube-apiserver") && s.KubeAPIServer == "" && (s.KubeToken != "" || s.KubeCAFile != "" || s.KubeInsecure) {
+		return errors.New("kube-token, kube-ca-file and kube-insecure-skip-tls-verify flags can only be used with the kube-apiserver flag. Set the kube-apiserver flag to the address of the API server")
+	}
+	if has("kube-apiserver") && s.KubeInsecure && s.KubeCAFile != "" {
+		return errors.New("kube-insecure-skip-tls-verify flag cannot be used with the kube-ca-file flag. Unset the kube-insecure-skip-tls-verify flag to verify the certificate of the API server with the CA bundle")
+	}
+	if has("in-cluster") && s.InCluster && s.KubeContext != "" && s.KubeAPIServer == "" {
+		return errors.New("in-cluster flag cannot be used with the kube-context flag. Unset the kube-context flag to use the in-cluster configuration, or the in-cluster flag to use the kubeconfig context")
+	}
+	if has("kube-api-qps") && (s.KubeAPIQPS < 0 || s.KubeAPIBurst < 0) {
+		return errors.New("kube-api-qps and kube-api-burst flags can not be negative. Use 0 for the client-go defaults")
+	}
+	if has("timeout") && (s.Timeout < 0 || s.RequestTimeout < 0) {
+		return errors.New("timeout and request-timeout flags can not be negative. Use 0 for no limit")
+	}
+	if has("retries") && (s.Retries < 0 || s.RetryBackoff < 0) {
+		return errors.New("retries and retry-backoff flags can not be negative. Set the retries flag to 0 to disable the retries")
+	}
+	if has("v3-storage") {
+		if err := s.ValidateV3StorageFlags(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateStorageFlags checks the flags setting the storage of the Helm v2 release data.
+func (s *EnvSettings) ValidateStorageFlags() error {
+	if s.TillerStorageDir != "" {
+		if !s.TillerOutCluster {
+			return errors.New("tiller-storage-dir flag can only be used with the 'tiller-out-cluster' flag. Set the 'tiller-out-cluster' flag to read the release records of the directory")
+		}
+		if s.ReleaseStorage != "" && s.ReleaseStorage != v2.StorageAuto {
+			return errors.New("tiller-storage-dir flag cannot be used with the release-storage flag. Unset the release-storage flag to read the release records of the directory")
+		}
+		return nil
+	}
+	if s.TillerOutCluster && s.ReleaseStorage == "" {
+		return errors.New("release-storage flag can not be empty when the 'tiller-out-cluster' flag is set. Set it to 'auto' to detect the storage, or set the tiller-storage-dir flag for release records exported to local files")
+	}
+	if s.ReleaseStorage != "" && s.ReleaseStorage != v2.StorageAuto && s.ReleaseStorage != "configmaps" && s.ReleaseStorage != "secrets" && s.ReleaseStorage != "sql" {
+		return fmt.Errorf("release-storage flag \"%s\" is not supported. It needs to be 'auto', 'configmaps', 'secrets' or 'sql'", s.ReleaseStorage)
+	}
+	if s.TillerOutCluster && s.ReleaseStorage == "sql" && s.TillerSQLConnection == "" {
+		return errors.New("tiller-sql-connection flag needs to be set when the release-storage flag is 'sql'")
+	}
+	return nil
+}
+
+// ValidateV3StorageFlags checks the flags setting the Helm v3 storage driver.
+func (s *EnvSettings) ValidateV3StorageFlags() error {
+	switch s.V3Storage {
+	case "", "secret", "configmap", "sql":
+	default:
+		return fmt.Errorf("v3-storage flag \"%s\" is not supported. It needs to be 'secret', 'configmap' or 'sql'", s.V3Storage)
+	}
+	if s.V3Storage == "sql" && s.V3SQLConnection == "" {
+		return errors.New("v3-sql-connection flag needs to be set when the v3-storage flag is 'sql'")
+	}
+	if s.V3Storage != "sql" && s.V3SQLConnection != "" {
+		return errors.New("v3-sql-connection flag can only be set when the v3-storage flag is 'sql'. Set the v3-storage flag to 'sql', or unset the v3-sql-connection flag")
+	}
+	return nil
+}
+
+// SetV3Storage selects the Helm v3 storage driver as per the v3 storage flags.
+func (s *EnvSettings) SetV3Storage() error {
+	if err := s.ValidateV3StorageFlags(); err != nil {
+		return err
+	}
+	return v3.SetStorage(s.V3Storage, s.V3SQLConnection)
+}
+
+// ProgressWriter returns where the warnings and confirmation prompts of the command are written to: the
+// output of the command in table format, otherwise the standard error, so that the output only holds
+// the result.
+func (s *EnvSettings) ProgressWriter(out io.Writer) io.Writer {
+	if s.Output == common.OutputTable || s.Output == "" {
+		return out
+	}
+	return os.Stderr
+}
+
+// AddFailOnEmptyFlag binds the flag making the command fail when nothing is found to process to the given flagset.
+func (s *EnvSettings) AddFailOnEmptyFlag(fs *pflag.FlagSet) {
+	fs.BoolVar(&s.FailOnEmpty, "fail-on-empty", false, "if set, the command fails with exit code 2 when nothing is found to process, e.g. no Helm v2 releases. By default, it succeeds with a warning")
+}
+
+// AddProgressFlag binds the flag disabling the progress of the bulk operations to the given flagset.
+func (s *EnvSettings) AddProgressFlag(fs *pflag.FlagSet) {
+	fs.BoolVar(&s.NoProgress, "no-progress", false, "if set, the progress of the bulk operations is not reported. By default, it is a counter refreshed in place when the standard error is a terminal, otherwise a log line every 30 seconds or 100 items")
+}
+
+// AddV2HomeFlag binds the flag setting the Helm v2 home folder to the given flagset.
+func (s *EnvSettings) AddV2HomeFlag(fs *pflag.FlagSet) {
+	fs.StringVar(&s.V2Home, "v2-home", "", "Helm v2 home folder. By default, the HELM_V2_HOME or HELM_HOME environment variables are used, or '~/.helm' when they are not set")
+}
+
+// SetV2Home sets the Helm v2 home folder as per the v2 home flag. The environment variable it is set by
+// otherwise, as by the Helm v2 CLI, is logged in debug.
+func (s *EnvSettings) SetV2Home() {
+	v2.SetHomeDir(s.V2Home)
+	if env := v2.HomeDirEnv(); s.V2Home == "" && env != "" {
+		common.Debugf("Helm v2 home folder is set to \"%s\" by environment variable %s", v2.HomeDir(), env)
+	}
+}
+
+// AddV3DirFlags binds the flags setting the Helm v3 directories to the given flagset.
+func (s *EnvSettings) AddV3DirFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.V3ConfigDir, "v3-config-dir", "", "Helm v3 config directory. By default, it is resolved as by Helm v3, e.g. from the HELM_CONFIG_HOME environment variable")
+	fs.StringVar(&s.V3DataDir, "v3-data-dir", "", "Helm v3 data directory. By default, it is resolved as by Helm v3, e.g. from the HELM_DATA_HOME environment variable")
+	fs.StringVar(&s.V3CacheDir, "v3-cache-dir", "", "Helm v3 cache directory. By default, it is resolved as by Helm v3, e.g. from the HELM_CACHE_HOME environment variable")
+}
+
+// SetV3Dirs sets the Helm v3 directories as per the v3 directory flags.
+func (s *EnvSettings) SetV3Dirs() {
+	v3.SetDirs(s.V3ConfigDir, s.V3DataDir, s.V3CacheDir)
+}
+
+// AddLogFlags binds the flags setting the verbosity of the logs to the given flagset.
+func (s *EnvSettings) AddLogFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&s.Debug, "debug", false, "if set, the operations on each object are logged too, e.g. the Kubernetes API calls retried. It is also set by the HELM_DEBUG environment variable")
+	fs.BoolVarP(&s.Quiet, "quiet", "q", false, "if set, nothing is logged but the errors")
+}
+
+// SetLogLevel sets the level of the logs as per the log flags.
+func (s *EnvSettings) SetLogLevel() error {
+	if s.Debug && s.Quiet {
+		return errors.New("debug flag cannot be used with the quiet flag")
+	}
+	switch {
+	case s.Quiet:
+		common.SetLogLevel(common.LogQuiet)
+	case s.Debug:
+		common.SetLogLevel(common.LogDebug)
+	}
+	return nil
+}
 
+// RetryOptions returns the options for retrying Kubernetes API calls as per the retry flags.
+func (s *EnvSettings) RetryOptions() common.RetryOptions {
+	return common.RetryOptions{
+		Backoff: s.RetryBackoff,
+		Retries: s.Retries,
+	}
 }
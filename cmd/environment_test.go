@@ -0,0 +1,145 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// validatedFlags returns the flagset of a command with all the flags checked by Validate, bound to the settings
+func validatedFlags(s *EnvSettings) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	s.AddFlags(fs)
+	s.AddV3StorageFlags(fs)
+	s.AddOutputFlag(fs)
+	s.AddRetryFlags(fs)
+	return fs
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(s *EnvSettings)
+		err  string
+	}{
+		{"defaults", func(s *EnvSettings) {}, ""},
+		{"json output", func(s *EnvSettings) { s.Output = "json" }, ""},
+		{"unsupported output", func(s *EnvSettings) { s.Output = "xml" }, "output format \"xml\" is not supported"},
+		{"empty tiller namespace", func(s *EnvSettings) { s.TillerNamespace = "" }, "tiller-ns flag can not be empty"},
+		{"tillerless with storage dir", func(s *EnvSettings) {
+			s.Tillerless = true
+			s.TillerOutCluster = true
+			s.TillerStorageDir = "/records"
+		}, "tillerless flag cannot be used with the tiller-storage-dir flag"},
+		{"storage dir out of cluster", func(s *EnvSettings) {
+			s.TillerOutCluster = true
+			s.TillerStorageDir = "/records"
+		}, ""},
+		{"storage dir in cluster", func(s *EnvSettings) { s.TillerStorageDir = "/records" }, "tiller-storage-dir flag can only be used with the 'tiller-out-cluster' flag"},
+		{"storage dir with release storage", func(s *EnvSettings) {
+			s.TillerOutCluster = true
+			s.TillerStorageDir = "/records"
+			s.ReleaseStorage = "secrets"
+		}, "tiller-storage-dir flag cannot be used with the release-storage flag"},
+		{"empty release storage out of cluster", func(s *EnvSettings) {
+			s.TillerOutCluster = true
+			s.ReleaseStorage = ""
+		}, "release-storage flag can not be empty"},
+		{"unsupported release storage", func(s *EnvSettings) { s.ReleaseStorage = "etcd" }, "release-storage flag \"etcd\" is not supported"},
+		{"sql release storage without connection", func(s *EnvSettings) {
+			s.TillerOutCluster = true
+			s.ReleaseStorage = "sql"
+		}, "tiller-sql-connection flag needs to be set"},
+		{"sql release storage with connection", func(s *EnvSettings) {
+			s.TillerOutCluster = true
+			s.ReleaseStorage = "sql"
+			s.TillerSQLConnection = "postgres://tiller@db/tiller"
+		}, ""},
+		{"kube token without API server", func(s *EnvSettings) { s.KubeToken = "token" }, "can only be used with the kube-apiserver flag"},
+		{"kube CA file without API server", func(s *EnvSettings) { s.KubeCAFile = "/ca.crt" }, "can only be used with the kube-apiserver flag"},
+		{"kube insecure without API server", func(s *EnvSettings) { s.KubeInsecure = true }, "can only be used with the kube-apiserver flag"},
+		{"kube token with API server", func(s *EnvSettings) {
+			s.KubeAPIServer = "https://10.0.0.1:6443"
+			s.KubeToken = "token"
+			s.KubeCAFile = "/ca.crt"
+		}, ""},
+		{"kube insecure with CA file", func(s *EnvSettings) {
+			s.KubeAPIServer = "https://10.0.0.1:6443"
+			s.KubeInsecure = true
+			s.KubeCAFile = "/ca.crt"
+		}, "kube-insecure-skip-tls-verify flag cannot be used with the kube-ca-file flag"},
+		{"in cluster with kube context", func(s *EnvSettings) {
+			s.InCluster = true
+			s.KubeContext = "prod"
+		}, "in-cluster flag cannot be used with the kube-context flag"},
+		{"in cluster with kube context and API server", func(s *EnvSettings) {
+			s.InCluster = true
+			s.KubeContext = "prod"
+			s.KubeAPIServer = "https://10.0.0.1:6443"
+		}, ""},
+		{"negative kube API QPS", func(s *EnvSettings) { s.KubeAPIQPS = -1 }, "kube-api-qps and kube-api-burst flags can not be negative"},
+		{"negative kube API burst", func(s *EnvSettings) { s.KubeAPIBurst = -1 }, "kube-api-qps and kube-api-burst flags can not be negative"},
+		{"negative timeout", func(s *EnvSettings) { s.Timeout = -time.Second }, "timeout and request-timeout flags can not be negative"},
+		{"negative request timeout", func(s *EnvSettings) { s.RequestTimeout = -time.Second }, "timeout and request-timeout flags can not be negative"},
+		{"no retries", func(s *EnvSettings) { s.Retries = 0 }, ""},
+		{"negative retries", func(s *EnvSettings) { s.Retries = -1 }, "retries and retry-backoff flags can not be negative"},
+		{"negative retry backoff", func(s *EnvSettings) { s.RetryBackoff = -time.Second }, "retries and retry-backoff flags can not be negative"},
+		{"unsupported v3 storage", func(s *EnvSettings) { s.V3Storage = "memory" }, "v3-storage flag \"memory\" is not supported"},
+		{"sql v3 storage without connection", func(s *EnvSettings) { s.V3Storage = "sql" }, "v3-sql-connection flag needs to be set"},
+		{"v3 connection without sql storage", func(s *EnvSettings) {
+			s.V3Storage = "secret"
+			s.V3SQLConnection = "postgres://helm@db/helm"
+		}, "v3-sql-connection flag can only be set when the v3-storage flag is 'sql'"},
+		{"sql v3 storage with connection", func(s *EnvSettings) {
+			s.V3Storage = "sql"
+			s.V3SQLConnection = "postgres://helm@db/helm"
+		}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New()
+			fs := validatedFlags(s)
+			test.set(s)
+			err := s.Validate(fs)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected the settings to be valid, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsOfCommand(t *testing.T) {
+	s := New()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	s.AddBaseFlags(fs)
+	s.Output = "xml"
+	s.Retries = -1
+	s.V3Storage = "memory"
+	if err := s.Validate(fs); err != nil {
+		t.Fatalf("expected the settings of the flags the command does not have to be ignored, got %s", err)
+	}
+}
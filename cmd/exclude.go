@@ -0,0 +1,118 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/pflag"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+var (
+	excludeFile     string
+	excludeReleases []string
+)
+
+// addExcludeFlags binds the flags of the releases excluded from the bulk conversion or cleanup
+func addExcludeFlags(fs *pflag.FlagSet, usage string) {
+	fs.StringSliceVar(&excludeReleases, "exclude", []string{}, "the comma-separated list of the names of the releases skipped "+usage+", e.g. the releases which stay on Helm v2")
+	fs.StringVar(&excludeFile, "exclude-file", "", "path of a file of the names of the releases skipped "+usage+", one per line, in addition to the 'exclude' flag. Blank lines and lines starting with '#' are ignored")
+}
+
+// excludedReleases returns the names of the releases excluded by the exclude flags
+func excludedReleases() ([]string, error) {
+	names := append([]string{}, excludeReleases...)
+	if excludeFile == "" {
+		return names, nil
+	}
+	data, err := ioutil.ReadFile(excludeFile)
+	if err != nil {
+		return nil, fmt.Errorf("exclude file \"%s\" failed to be read with error: %s", excludeFile, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// excludeReleaseNames filters the excluded releases out of the release names, returning the names
+// kept and the names excluded
+func excludeReleaseNames(names, exclude []string) ([]string, []string) {
+	if len(exclude) == 0 {
+		return names, nil
+	}
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+	kept, skipped := []string{}, []string{}
+	for _, name := range names {
+		if excluded[name] {
+			skipped = append(skipped, name)
+		} else {
+			kept = append(kept, name)
+		}
+	}
+	return kept, skipped
+}
+
+// excludeReleaseVersions filters the versions of the excluded releases out, returning the versions
+// kept and the names of the releases excluded
+func excludeReleaseVersions(v2Releases []*rls.Release, exclude []string) ([]*rls.Release, []string) {
+	if len(exclude) == 0 {
+		return v2Releases, nil
+	}
+	names, _ := groupReleaseVersions(v2Releases)
+	_, skipped := excludeReleaseNames(names, exclude)
+	excluded := map[string]bool{}
+	for _, name := range skipped {
+		excluded[name] = true
+	}
+	kept := []*rls.Release{}
+	for _, v2Release := range v2Releases {
+		if !excluded[v2Release.Name] {
+			kept = append(kept, v2Release)
+		}
+	}
+	return kept, skipped
+}
+
+// logExcludedReleases logs the releases skipped as excluded, and warns about the exclusions which match
+// no release, as they are likely typos
+func logExcludedReleases(logger common.Logger, exclude, excluded []string) {
+	if len(excluded) > 0 {
+		logger.Infof("[Helm 2] Releases skipped (excluded): %s\n", strings.Join(excluded, ", "))
+	}
+	matched := map[string]bool{}
+	for _, name := range excluded {
+		matched[name] = true
+	}
+	for _, name := range exclude {
+		if !matched[name] {
+			logger.Warnf("[Helm 2] Excluded release '%s' matches no Helm v2 release. Check its name.\n", name)
+			matched[name] = true
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// The exit codes of the plugin, besides 0 on success and 1 on failure
+const (
+	// exitCodeNothingFound is the exit code when nothing was found to process, with the 'fail-on-empty' flag
+	exitCodeNothingFound = 2
+	// exitCodePartialFailure is the exit code when some releases were processed and others failed
+	exitCodePartialFailure = 3
+	// exitCodeManifestDiff is the exit code of convert when the manifest of a release differs from the
+	// manifest of its Helm v3 release, with the 'dry-run-diff' flag
+	exitCodeManifestDiff = 4
+)
+
+// ExitError is an error for which the plugin exits with a specific exit code, instead of 1
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the plugin for the error returned by a command: the code of an
+// ExitError, 3 for a partial failure, 2 when nothing was found, 4 when a manifest differs, and 1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var partialErr *common.PartialError
+	if errors.As(err, &partialErr) {
+		return exitCodePartialFailure
+	}
+	if errors.Is(err, common.ErrNothingFound) {
+		return exitCodeNothingFound
+	}
+	if errors.Is(err, ErrManifestDiff) {
+		return exitCodeManifestDiff
+	}
+	return 1
+}
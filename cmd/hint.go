@@ -0,0 +1,87 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// hintError is an error of a common failure mode, with a hint on how to solve it
+type hintError struct {
+	err  error
+	hint string
+}
+
+func (e hintError) Error() string {
+	return strings.TrimSpace(e.err.Error()) + "\nHint: " + e.hint
+}
+
+func (e hintError) Unwrap() error {
+	return e.err
+}
+
+// withHint returns the error with a hint when it is of a common failure mode: a release not found in
+// Helm v2 or v3 storage, a Helm v3 release which already exists, a Kubernetes API request forbidden, or
+// a timeout, or a corrupt release record. Other errors are returned as is.
+func withHint(err error) error {
+	var status apierrors.APIStatus
+	var netErr net.Error
+	hint := ""
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, v2.ErrNoVersionsFound):
+		hint = "check that the release is managed by the Tiller of the 'tiller-ns' flag, that its storage objects have the label of the 'label' flag and are of the 'release-storage' flag, and the 'selector' flag if set. The release versions may also have been deleted meanwhile, e.g. by another cleanup. 'helm 2to3 list' lists the Helm v2 releases found"
+	case errors.Is(err, v2.ErrCorruptRecord):
+		hint = "inspect the storage objects named, e.g. with 'kubectl get configmap NAME -n TILLER_NAMESPACE -o yaml'. 'helm 2to3 convert --skip-corrupt' converts the other release versions, and 'helm 2to3 cleanup --delete-corrupt' deletes the storage objects which can't be decoded"
+	case errors.Is(err, v3.ErrReleaseNotFound):
+		hint = "check that the release was converted, and that the 'v3-storage' flag is the storage it was converted to"
+	case errors.Is(err, v3.ErrReleaseAlreadyExists):
+		hint = "'helm 2to3 verify RELEASE' compares the Helm v2 release with the Helm v3 release of the same name"
+	case errors.As(err, &status) && status.Status().Reason == metav1.StatusReasonForbidden:
+		hint = "permission needs to be granted by RBAC. 'helm 2to3 doctor' checks the permissions on the Helm v2 storage"
+	case errors.Is(err, context.DeadlineExceeded):
+		hint = "the 'timeout' flag was reached. Rerun the command to process what is left, with a longer timeout if needed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		hint = "a Kubernetes API request took longer than the 'request-timeout' flag. Check that the API server is reachable and responsive, or set a longer request timeout"
+	default:
+		return err
+	}
+	return hintError{err: err, hint: hint}
+}
+
+// addErrorHints makes the command and its subcommands return their errors with a hint, as per withHint
+func addErrorHints(cmd *cobra.Command) {
+	if runE := cmd.RunE; runE != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			return withHint(runE(cmd, args))
+		}
+	}
+	for _, subCmd := range cmd.Commands() {
+		addErrorHints(subCmd)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v2rel "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// impersonatingServer is an API server storing the release versions of release "rel" in the ConfigMaps
+// of Tiller, which records the user and groups impersonated by each request
+type impersonatingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	users    map[string]bool
+	groups   map[string]bool
+}
+
+func newImpersonatingServer(t *testing.T) *impersonatingServer {
+	t.Helper()
+	configMaps := corev1.ConfigMapList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMapList"}}
+	for _, v2Release := range v2History([]int32{1, 2}, v2rel.Status_SUPERSEDED, v2rel.Status_DEPLOYED) {
+		configMaps.Items = append(configMaps.Items, *v2ConfigMap(t, v2Release))
+	}
+	server := &impersonatingServer{users: map[string]bool{}, groups: map[string]bool{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests = append(server.requests, r.Method+" "+r.URL.Path)
+		server.users[r.Header.Get("Impersonate-User")] = true
+		for _, group := range r.Header["Impersonate-Group"] {
+			server.groups[group] = true
+		}
+		server.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/namespaces/kube-system/configmaps"):
+			json.NewEncoder(w).Encode(configMaps)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/secrets"):
+			json.NewEncoder(w).Encode(corev1.SecretList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "SecretList"}})
+		case r.Method == http.MethodPost || r.Method == http.MethodPatch:
+			// The object created or patched is returned as stored
+			body, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		case r.Method == http.MethodDelete:
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}, Status: metav1.StatusSuccess})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		}
+	}))
+	return server
+}
+
+// checkImpersonated checks all the requests impersonated the user and groups of the kube config
+func (server *impersonatingServer) checkImpersonated(t *testing.T, kubeConfig common.KubeConfig) {
+	t.Helper()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.requests) == 0 {
+		t.Fatal("expected requests to the API server")
+	}
+	if !reflect.DeepEqual(server.users, map[string]bool{kubeConfig.Impersonate: true}) {
+		t.Errorf("expected all the requests to impersonate %q, got the users %v for the requests %q", kubeConfig.Impersonate, server.users, server.requests)
+	}
+	for _, group := range kubeConfig.ImpersonateGroups {
+		if !server.groups[group] {
+			t.Errorf("expected the requests to impersonate group %q, got %v", group, server.groups)
+		}
+	}
+}
+
+func impersonatingKubeConfig(server *impersonatingServer) common.KubeConfig {
+	return common.KubeConfig{
+		APIServer:         server.URL,
+		Impersonate:       "system:serviceaccount:kube-system:migrator",
+		ImpersonateGroups: []string{"system:serviceaccounts", "migrators"},
+	}
+}
+
+func TestConvertImpersonation(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	server := newImpersonatingServer(t)
+	defer server.Close()
+	kubeConfig := impersonatingKubeConfig(server)
+	convertOptions := ConvertOptions{
+		DeleteRelease:      true,
+		Logger:             &commontest.RecordingLogger{},
+		MaxReleaseVersions: 10,
+		ReleaseName:        "rel",
+		StorageType:        "configmaps",
+		TillerNamespace:    "kube-system",
+		TillerOutCluster:   true,
+	}
+
+	if err := Convert(context.Background(), convertOptions, kubeConfig); err != nil {
+		t.Fatalf("conversion failed with error: %s", err)
+	}
+	server.checkImpersonated(t, kubeConfig)
+	var stored, deleted bool
+	for _, request := range server.requests {
+		stored = stored || request == "POST /api/v1/namespaces/default/secrets"
+		deleted = deleted || strings.HasPrefix(request, "DELETE /api/v1/namespaces/kube-system/configmaps/")
+	}
+	if !stored || !deleted {
+		t.Errorf("expected the Helm v3 storage to be written and the Helm v2 storage to be deleted from, got the requests %q", server.requests)
+	}
+}
+
+func TestCleanupImpersonation(t *testing.T) {
+	server := newImpersonatingServer(t)
+	defer server.Close()
+	kubeConfig := impersonatingKubeConfig(server)
+	cleanupOptions := outClusterCleanupOptions("rel")
+	cleanupOptions.Logger = &commontest.RecordingLogger{}
+	cleanupOptions.Out = ioutil.Discard
+	cleanupOptions.ReleaseCleanup = true
+
+	result, err := Cleanup(context.Background(), cleanupOptions, kubeConfig)
+	if err != nil {
+		t.Fatalf("cleanup failed with error: %s", err)
+	}
+	if !reflect.DeepEqual(result.DeletedVersions, map[string][]int32{"rel": {1, 2}}) {
+		t.Errorf("expected the versions of release 'rel' to be deleted, got %v", result.DeletedVersions)
+	}
+	server.checkImpersonated(t, kubeConfig)
+}
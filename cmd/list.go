@@ -0,0 +1,200 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gosuri/uitable"
+	"github.com/spf13/cobra"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+var (
+	deployedOnly  bool
+	hideConverted bool
+	listMax       int
+)
+
+type ListOptions struct {
+	DeployedOnly        bool
+	HideConverted       bool
+	Max                 int
+	Selector            string
+	StorageType         string
+	TillerLabel         string
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+}
+
+// ReleaseListing describes a Helm v2 release as per its latest version
+type ReleaseListing struct {
+	Name      string `json:"name"`
+	Revision  int32  `json:"revision"`
+	Versions  int    `json:"versions"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Chart     string `json:"chart"`
+	// Corrupt is the number of the storage objects of the release which can't be decoded
+	Corrupt int `json:"corrupt,omitempty"`
+}
+
+func newListCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the Helm v2 releases found for the Tiller namespace and label",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cmd.Context(), out)
+		},
+	}
+
+	cmd.AddCommand(newListTillersCmd(out))
+
+	flags := cmd.Flags()
+	settings.AddRetrieveFlags(flags)
+	settings.AddV3StorageFlags(flags)
+
+	flags.BoolVar(&deployedOnly, "deployed-only", false, "if set, only the releases whose latest version is deployed are listed")
+	flags.BoolVar(&hideConverted, "hide-converted", false, "if set, the releases whose Helm v3 release of the same name and namespace is labelled as converted by the plugin are not listed")
+	flags.IntVar(&listMax, "max", 0, "maximum number of releases listed. Use 0 for no limit")
+	settings.AddOutputFlag(flags)
+
+	return cmd
+}
+
+func runList(ctx context.Context, out io.Writer) error {
+	ctx, cancel := settings.WithTimeout(ctx)
+	defer cancel()
+	if listMax < 0 {
+		return errors.New("max flag can not be negative")
+	}
+	if err := settings.SetV3Storage(); err != nil {
+		return err
+	}
+	listOptions := ListOptions{
+		DeployedOnly:        deployedOnly,
+		HideConverted:       hideConverted,
+		Max:                 listMax,
+		Selector:            settings.Selector,
+		StorageType:         settings.ReleaseStorage,
+		TillerLabel:         settings.Label,
+		TillerNamespace:     settings.TillerNamespace,
+		TillerOutCluster:    settings.TillerOutCluster,
+		TillerSQLConnection: settings.TillerSQLConnection,
+		TillerStorageDir:    settings.TillerStorageDir,
+	}
+	kubeConfig := settings.KubeConfig()
+	if err := settings.CheckTillerNamespace(ctx); err != nil {
+		return err
+	}
+
+	releases, err := ListReleases(ctx, listOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	return common.PrintOutput(out, settings.Output, releaseTable(releases))
+}
+
+// releaseTable prints the Helm v2 releases listed as a table
+type releaseTable []ReleaseListing
+
+func (releases releaseTable) PrintTable(out io.Writer) error {
+	table := uitable.New()
+	table.AddRow("NAME", "REVISION", "VERSIONS", "NAMESPACE", "STATUS", "CHART")
+	for _, release := range releases {
+		versions := strconv.Itoa(release.Versions)
+		if release.Corrupt > 0 {
+			versions += fmt.Sprintf(" (%d corrupt)", release.Corrupt)
+		}
+		table.AddRow(release.Name, release.Revision, versions, release.Namespace, release.Status, release.Chart)
+	}
+	_, err := fmt.Fprintln(out, table)
+	return err
+}
+
+// ListReleases returns the Helm v2 releases stored for the Tiller namespace and label, sorted by name.
+// Each release is described as per its latest version.
+func ListReleases(ctx context.Context, listOptions ListOptions, kubeConfig common.KubeConfig) ([]ReleaseListing, error) {
+	if err := v2.ValidateSelector(listOptions.Selector); err != nil {
+		return nil, err
+	}
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         listOptions.Selector,
+		TillerNamespace:  listOptions.TillerNamespace,
+		TillerLabel:      listOptions.TillerLabel,
+		TillerOutCluster: listOptions.TillerOutCluster,
+		StorageType:      listOptions.StorageType,
+		SQLConnection:    listOptions.TillerSQLConnection,
+		StorageDir:       listOptions.TillerStorageDir,
+	}
+	list, err := v2.ListReleases(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := map[v3.ConvertedRelease]bool{}
+	if listOptions.HideConverted {
+		convertedReleases, err := v3.ListConvertedReleases(ctx, kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, convertedRelease := range convertedReleases {
+			converted[convertedRelease] = true
+		}
+	}
+
+	releases := []ReleaseListing{}
+	for _, summary := range list.Releases {
+		if listOptions.DeployedOnly && summary.Status != rls.Status_DEPLOYED.String() {
+			continue
+		}
+		if converted[v3.ConvertedRelease{Name: summary.Name, Namespace: summary.Namespace}] {
+			continue
+		}
+		releases = append(releases, ReleaseListing{
+			Name:      summary.Name,
+			Revision:  summary.Version,
+			Versions:  summary.Versions,
+			Namespace: summary.Namespace,
+			Status:    summary.Status,
+			Chart:     summary.Chart,
+			Corrupt:   summary.Corrupt,
+		})
+	}
+	if listOptions.Max > 0 && len(releases) > listOptions.Max {
+		releases = releases[:listOptions.Max]
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	v2chart "k8s.io/helm/pkg/proto/hapi/chart"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+)
+
+// shuffledReleases returns the ConfigMaps of the versions of 40 releases, in an order as per the seed,
+// so that the storage objects are listed in another order for each seed
+func shuffledReleases(t *testing.T, seed int64) []runtime.Object {
+	t.Helper()
+	objects := []runtime.Object{}
+	for i := 0; i < 40; i++ {
+		versions := int32(i%3 + 1)
+		for version := int32(1); version <= versions; version++ {
+			status := rls.Status_SUPERSEDED
+			if version == versions {
+				status = rls.Status_DEPLOYED
+			}
+			objects = append(objects, v2ConfigMap(t, &rls.Release{
+				Name:      fmt.Sprintf("rel-%02d", i),
+				Namespace: fmt.Sprintf("ns-%d", i%4),
+				Version:   version,
+				Chart:     &v2chart.Chart{Metadata: &v2chart.Metadata{Name: "chart", Version: "1.0.0"}},
+				Info:      &rls.Info{Status: &rls.Status{Code: status}, Description: "Install complete"},
+			}))
+		}
+	}
+	random := rand.New(rand.NewSource(seed))
+	random.Shuffle(len(objects), func(i, j int) { objects[i], objects[j] = objects[j], objects[i] })
+	return objects
+}
+
+// listOutput returns the output of the listing of the releases in the format
+func listOutput(t *testing.T, objects []runtime.Object, format string) string {
+	t.Helper()
+	listOptions := ListOptions{
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+	releases, err := ListReleases(context.Background(), listOptions, common.KubeConfig{Client: fake.NewSimpleClientset(objects...)})
+	if err != nil {
+		t.Fatalf("releases failed to be listed with error: %s", err)
+	}
+	if len(releases) != 40 {
+		t.Fatalf("expected 40 releases listed, got %d", len(releases))
+	}
+	var out bytes.Buffer
+	if err := common.PrintOutput(&out, format, releaseTable(releases)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestListReleasesStableOutput(t *testing.T) {
+	for _, format := range []string{common.OutputTable, common.OutputJSON, common.OutputYAML} {
+		t.Run(format, func(t *testing.T) {
+			first := listOutput(t, shuffledReleases(t, 1), format)
+			second := listOutput(t, shuffledReleases(t, 2), format)
+			if first != second {
+				t.Errorf("expected the same output of two listings, got:\n%s\nand:\n%s", first, second)
+			}
+		})
+	}
+}
+
+// cleanupOutput returns the summary and the log of the dry-run of the cleanup of all releases
+func cleanupOutput(t *testing.T, objects []runtime.Object) string {
+	t.Helper()
+	var out bytes.Buffer
+	logger := &commontest.RecordingLogger{}
+	cleanupOptions := CleanupOptions{
+		DryRun:           true,
+		Logger:           logger,
+		Out:              &out,
+		ReleaseCleanup:   true,
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+		Verbose:          true,
+	}
+	if _, err := Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: fake.NewSimpleClientset(objects...)}); err != nil {
+		t.Fatalf("cleanup failed with error: %s", err)
+	}
+	return out.String() + strings.Join(logger.Lines(), "")
+}
+
+func TestCleanupStableOutput(t *testing.T) {
+	first := cleanupOutput(t, shuffledReleases(t, 1))
+	second := cleanupOutput(t, shuffledReleases(t, 2))
+	if first != second {
+		t.Errorf("expected the same output of two cleanups, got:\n%s\nand:\n%s", first, second)
+	}
+	if !strings.Contains(first, "rel-00, rel-01, rel-02") {
+		t.Errorf("expected the releases in the order of their names, got:\n%s", first)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gosuri/uitable"
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+func newListTillersCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tillers",
+		Short: "list the Tiller instances running in all namespaces of the cluster",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runListTillers(cmd.Context(), out)
+		},
+	}
+
+	flags := cmd.Flags()
+	settings.AddKubeFlags(flags)
+	settings.AddOutputFlag(flags)
+
+	return cmd
+}
+
+func runListTillers(ctx context.Context, out io.Writer) error {
+	ctx, cancel := settings.WithTimeout(ctx)
+	defer cancel()
+	tillers, err := v2.FindTillers(ctx, settings.KubeConfig())
+	if err != nil {
+		return err
+	}
+
+	return common.PrintOutput(out, settings.Output, tillerTable(tillers))
+}
+
+// tillerTable prints the Tiller instances found as a table
+type tillerTable []v2.TillerInstance
+
+func (tillers tillerTable) PrintTable(out io.Writer) error {
+	table := uitable.New()
+	table.AddRow("NAMESPACE", "NAME", "VERSION", "READY", "STORAGE", "IMAGE")
+	for _, tiller := range tillers {
+		table.AddRow(tiller.Namespace, tiller.Name, tiller.Version, fmt.Sprintf("%d/%d", tiller.ReadyReplicas, tiller.Replicas), tiller.Storage, tiller.Image)
+	}
+	_, err := fmt.Fprintln(out, table)
+	return err
+}
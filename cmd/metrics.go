@@ -0,0 +1,101 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	metrics "github.com/helm/helm-2to3/pkg/metrics"
+)
+
+var (
+	metricsFile    string
+	metricsPushURL string
+)
+
+// addMetricsFlags binds the flags of the metrics of the command, for the migrations run as scheduled jobs
+func addMetricsFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&metricsFile, "metrics-file", "", "path of the file the metrics of the run are written to in the Prometheus text format, e.g. for the textfile collector of the node exporter. It is written at the end of the run, and periodically as the releases are processed")
+	fs.StringVar(&metricsPushURL, "metrics-push-url", "", "URL of a Prometheus Pushgateway the metrics of the run are pushed to at its end, e.g. 'http://pushgateway:9091'")
+}
+
+// validateMetricsFlags checks the metrics flags
+func validateMetricsFlags() error {
+	if metricsPushURL == "" {
+		return nil
+	}
+	return metrics.ValidatePushURL(metricsPushURL)
+}
+
+// newMetrics returns the registry of the metrics of the command, nil when neither metrics flag is set
+func newMetrics(command string) *metrics.Registry {
+	if metricsFile == "" && metricsPushURL == "" {
+		return nil
+	}
+	return metrics.NewRegistry(command)
+}
+
+// withMetrics returns the progress notifying the progress, if any, which also writes the metrics file
+// every progressLogInterval and once all items are processed, so that long runs can be graphed
+func withMetrics(progress common.Progress, registry *metrics.Registry, logger common.Logger) common.Progress {
+	if registry == nil || metricsFile == "" {
+		return progress
+	}
+	var mutex sync.Mutex
+	written := time.Now()
+	return common.ProgressFunc(func(item string, index, total int) {
+		common.UpdateProgress(progress, item, index, total)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if index < total && time.Since(written) < progressLogInterval {
+			return
+		}
+		written = time.Now()
+		if err := registry.WriteFile(metricsFile); err != nil {
+			logger.Warnf("%s\n", err)
+		}
+	})
+}
+
+// writeMetrics writes the metrics file and pushes the metrics as per the metrics flags. The failures
+// are warned about, as the metrics don't fail the command.
+func writeMetrics(ctx context.Context, registry *metrics.Registry, logger common.Logger) {
+	if registry == nil {
+		return
+	}
+	if metricsFile != "" {
+		if err := registry.WriteFile(metricsFile); err != nil {
+			logger.Warnf("%s\n", err)
+		}
+	}
+	if metricsPushURL != "" {
+		// The metrics are pushed also when the command timed out, as they record how far it got
+		if ctx.Err() != nil {
+			ctx = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := registry.Push(ctx, metricsPushURL); err != nil {
+			logger.Warnf("%s\n", err)
+		}
+	}
+}
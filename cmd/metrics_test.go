@@ -0,0 +1,105 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	metrics "github.com/helm/helm-2to3/pkg/metrics"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+func TestCleanupMetrics(t *testing.T) {
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), v2ConfigMap(t, deployedRelease("rel", 2)))
+	registry := metrics.NewRegistry("cleanup")
+	cleanupOptions := outClusterCleanupOptions("missing", "rel")
+	cleanupOptions.Metrics = registry
+
+	if _, err := Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client}); err == nil {
+		t.Fatal("expected the cleanup of the release with no versions to fail")
+	}
+	if value := registry.Value(metrics.VersionsDeleted); value != 2 {
+		t.Errorf("expected 2 versions deleted, got %g", value)
+	}
+	if value := registry.Value(metrics.ReleasesFailed); value != 1 {
+		t.Errorf("expected 1 release failed, got %g", value)
+	}
+}
+
+func TestCleanupMetricsDryRun(t *testing.T) {
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)))
+	registry := metrics.NewRegistry("cleanup")
+	cleanupOptions := outClusterCleanupOptions("rel")
+	cleanupOptions.DryRun = true
+	cleanupOptions.Metrics = registry
+
+	if _, err := Cleanup(context.Background(), cleanupOptions, common.KubeConfig{Client: client}); err != nil {
+		t.Fatalf("cleanup failed with error: %s", err)
+	}
+	if value := registry.Value(metrics.VersionsDeleted); value != 0 {
+		t.Errorf("expected no versions deleted in dry-run, got %g", value)
+	}
+}
+
+func TestConvertMetrics(t *testing.T) {
+	tests := []struct {
+		err       error
+		converted float64
+		failed    float64
+	}{
+		{err: nil, converted: 1},
+		{err: fmt.Errorf("%w: release \"rel\" is pending", ErrReleasePending)},
+		{err: fmt.Errorf("%w: release \"rel\" is converted", ErrReleaseConverted)},
+		{err: v2.ErrNoVersionsFound, failed: 1},
+		{err: errors.New("the server is currently unable to handle the request"), failed: 1},
+	}
+	for _, test := range tests {
+		registry := metrics.NewRegistry("convert")
+		recordConvertOutcome(registry, test.err)
+		if value := registry.Value(metrics.ReleasesConverted); value != test.converted {
+			t.Errorf("expected %g releases converted for error %v, got %g", test.converted, test.err, value)
+		}
+		if value := registry.Value(metrics.ReleasesFailed); value != test.failed {
+			t.Errorf("expected %g releases failed for error %v, got %g", test.failed, test.err, value)
+		}
+	}
+}
+
+func TestConvertMetricsVersionsDeleted(t *testing.T) {
+	client := fake.NewSimpleClientset(v2ConfigMap(t, deployedRelease("rel", 1)), v2ConfigMap(t, deployedRelease("rel", 2)))
+	registry := metrics.NewRegistry("convert")
+	convertOptions := ConvertOptions{Metrics: registry, ReleaseName: "rel"}
+	retrieveOptions := v2.RetrieveOptions{
+		ReleaseName:      "rel",
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+
+	if err := deleteV2ReleaseVersions(context.Background(), convertOptions, retrieveOptions, []int32{1, 2}, common.KubeConfig{Client: client}); err != nil {
+		t.Fatalf("release versions failed to be deleted with error: %s", err)
+	}
+	if value := registry.Value(metrics.VersionsDeleted); value != 2 {
+		t.Errorf("expected 2 versions deleted, got %g", value)
+	}
+}
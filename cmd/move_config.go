@@ -19,77 +19,145 @@ package cmd
 import (
 	"errors"
 	"io"
-	"log"
 
 	"github.com/spf13/cobra"
 
+	common "github.com/helm/helm-2to3/pkg/common"
 	utils "github.com/helm/helm-2to3/pkg/utils"
 )
 
+var (
+	moveBackup    bool
+	moveMove      bool
+	movePreferV2  bool
+	moveScopes    []string
+	moveSkipCache bool
+)
+
 func newMoveConfigCmd(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "move config",
 		Short: "migrate Helm v2 configuration in-place to Helm v3",
 		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) != 1 {
+			if len(args) != 1 || args[0] != "config" {
 				return errors.New("config argument has to be specified")
 			}
 			return nil
 		},
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateMoveFlags()
+		},
 		RunE: runMove,
 	}
 
 	flags := cmd.Flags()
 	settings.AddBaseFlags(flags)
+	settings.AddProgressFlag(flags)
+	settings.AddV2HomeFlag(flags)
+	settings.AddV3DirFlags(flags)
+	flags.BoolVar(&moveBackup, "backup", false, "if set, the existing Helm v3 repository file is backed up with a timestamp suffix before the Helm v2 repositories are merged into it")
+	flags.BoolVar(&moveMove, "move", false, "if set, the Helm v2 configuration components are removed once copied to Helm v3. By default, they are copied and the Helm v2 configuration is left intact")
+	flags.BoolVar(&movePreferV2, "prefer-v2", false, "if set, a Helm v2 repository replaces the Helm v3 repository of the same name. By default, the Helm v3 repository is kept")
+	flags.BoolVar(&moveSkipCache, "skip-cache", false, "if set, the chart cache of the Helm v2 repositories is not copied, Helm v3 populating its cache on demand")
+	flags.StringSliceVar(&moveScopes, "scope", []string{utils.MoveScopeAll}, "the comma-separated list of the components of the Helm v2 configuration moved: 'repositories', 'plugins', 'starters' or 'all'")
+	flags.StringVar(&confirmDefault, "confirm-default", "no", "answer applied when no answer is given to the confirmation within the 'confirm-timeout'. It can be 'yes' or 'no'")
+	flags.BoolVar(&confirmFromStdin, "confirm-from-stdin", false, "if set, the confirmation is read from the standard input when it is not a terminal, e.g. a piped \"y\"")
+	flags.DurationVar(&confirmTimeout, "confirm-timeout", 0, "how long the answer to the confirmation is waited for, e.g. '60s', before the 'confirm-default' answer is applied. Use 0 to wait forever")
 	flags.BoolVar(&skipConfirmation, "skip-confirmation", false, "if set, skips confirmation message before performing move")
 	return cmd
 }
 
-func runMove(cmd *cobra.Command, args []string) error {
-	moveArgName := args[0]
-
-	if moveArgName != "config" {
-		return errors.New("config argument has to be specified")
+// validateMoveFlags checks the flags of the move config command, before anything is moved
+func validateMoveFlags() error {
+	if err := utils.ValidateMoveScopes(moveScopes); err != nil {
+		return err
 	}
+	_, err := parseConfirmDefault()
+	return err
+}
 
-	return Move(settings.DryRun)
+func runMove(cmd *cobra.Command, args []string) error {
+	settings.SetV2Home()
+	settings.SetV3Dirs()
+	return Move(utils.MoveOptions{
+		Backup:        moveBackup,
+		CacheProgress: newProgress("MiB of the chart cache copied"),
+		DryRun:        settings.DryRun,
+		Move:          moveMove,
+		PreferV2:      movePreferV2,
+		Progress:      newProgress("files copied"),
+		Scopes:        moveScopes,
+		SkipCache:     moveSkipCache,
+	})
 }
 
 // Moves/copies v2 configuration to v2 configuration. It copies repository config,
-// plugins and starters. It does not copy cache.
-func Move(dryRun bool) error {
+// plugins and starters, or only those of the scopes. The chart cache is copied with the repositories,
+// unless skipped.
+func Move(moveOptions utils.MoveOptions) error {
+	dryRun := moveOptions.DryRun
+	logger := common.LoggerOrDefault(moveOptions.Logger)
 	var err error
 	var doConfig bool
 	if dryRun {
-		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
-		log.Println("Run without --dry-run to take the actions described below:")
-		log.Println()
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
 	}
 
-	log.Println("WARNING: Helm v3 configuration may be overwritten during this operation.")
-	log.Println()
-	if skipConfirmation {
+	if dryRun {
+		logger.Warnf("[dry-run] Helm v3 configuration may be overwritten during this operation.")
+		if moveOptions.Move {
+			logger.Warnf("[dry-run] Helm v2 configuration copied will be removed during this operation.")
+		}
+	} else {
+		logger.Warnf("Helm v3 configuration may be overwritten during this operation.")
+		if moveOptions.Move {
+			logger.Warnf("Helm v2 configuration copied will be removed during this operation.")
+		}
+	}
+	logger.Infof("")
+
+	// Nothing is moved in dry-run, so there is nothing to confirm
+	if dryRun {
+		logger.Infof("Skipping confirmation in dry-run mode.")
+		doConfig = true
+	} else if skipConfirmation {
 
-		log.Println("Skipping confirmation before performing move configuration.")
+		logger.Infof("Skipping confirmation before performing move configuration.")
 		doConfig = true
 	} else {
-		doConfig, err = utils.AskConfirmation("Move config", "move the v2 configuration")
+		confirmDefaultAnswer, err := parseConfirmDefault()
+		if err != nil {
+			return err
+		}
+		confirmOptions := utils.ConfirmOptions{
+			Default:   confirmDefaultAnswer,
+			FromStdin: confirmFromStdin,
+			Logger:    logger,
+			Timeout:   confirmTimeout,
+		}
+		doConfig, err = utils.AskConfirmation("Move config", "move the v2 configuration", confirmOptions)
 		if err != nil {
 			return err
 		}
 	}
 	if !doConfig {
-		log.Println("Move will not proceed as the user didn't answer (Y|y) in order to continue.")
+		logger.Infof("Move will not proceed as the user didn't answer (Y|y) in order to continue.")
 		return nil
 	}
 
-	log.Println("\nHelm v2 configuration will be moved to Helm v3 configuration.")
-	err = utils.Copyv2HomeTov3(dryRun)
+	logger.Infof("\nHelm v2 configuration will be moved to Helm v3 configuration.")
+	err = utils.Copyv2HomeTov3(moveOptions)
 	if err != nil {
 		return err
 	}
 	if !dryRun {
-		log.Println("Helm v2 configuration was moved successfully to Helm v3 configuration.")
+		if moveOptions.Move {
+			logger.Infof("Helm v2 configuration was moved successfully to Helm v3 configuration. The Helm v2 configuration copied was removed.")
+		} else {
+			logger.Infof("Helm v2 configuration was copied successfully to Helm v3 configuration. The Helm v2 configuration was left intact.")
+		}
 	}
 	return nil
 }
@@ -0,0 +1,64 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	utils "github.com/helm/helm-2to3/pkg/utils"
+)
+
+func TestMoveDryRunNoConfirmation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for env, subDir := range map[string]string{"HELM_V2_HOME": "v2", "HELM_V3_CONFIG": "config", "HELM_V3_DATA": "data", "HELM_V3_CACHE": "cache"} {
+		value, set := os.LookupEnv(env)
+		os.Setenv(env, filepath.Join(dir, subDir))
+		if set {
+			defer os.Setenv(env, value)
+		} else {
+			defer os.Unsetenv(env)
+		}
+	}
+	defer func(fromStdin bool) { confirmFromStdin = fromStdin }(confirmFromStdin)
+	confirmFromStdin = true
+
+	var logged string
+	unread := withStdin(t, "n\n", func() {
+		logged = captureLog(func() {
+			if err := Move(utils.MoveOptions{DryRun: true, Scopes: []string{utils.MoveScopeStarters}}); err != nil {
+				t.Fatalf("move failed with error: %s", err)
+			}
+		})
+	})
+	if unread != "n\n" {
+		t.Errorf("expected nothing to be read from the standard input in dry-run, %q left unread", unread)
+	}
+	if !strings.Contains(logged, "Skipping confirmation in dry-run mode.") {
+		t.Errorf("expected the confirmation to be skipped, got %q", logged)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created in dry-run, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// outputFields returns the fields of the result as printed in the output format, decoded
+func outputFields(t *testing.T, format string, result interface{}) map[string]interface{} {
+	t.Helper()
+	var out bytes.Buffer
+	if err := common.PrintOutput(&out, format, result); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	var err error
+	if format == common.OutputJSON {
+		err = json.Unmarshal(out.Bytes(), &fields)
+	} else {
+		err = yaml.Unmarshal(out.Bytes(), &fields)
+	}
+	if err != nil {
+		t.Fatalf("%s output failed to be decoded with error: %s\n%s", format, err, out.String())
+	}
+	return fields
+}
+
+// fieldNames returns the names of the fields, sorted
+func fieldNames(fields interface{}) []string {
+	names := []string{}
+	for name := range fields.(map[string]interface{}) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkFieldNames checks the names of the fields of the output, and of the first item of the nested lists
+func checkFieldNames(t *testing.T, format string, fields map[string]interface{}, expected []string, nested map[string][]string) {
+	t.Helper()
+	if names := fieldNames(fields); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the %s fields %q, got %q", format, expected, names)
+	}
+	for field, expectedNested := range nested {
+		items, ok := fields[field].([]interface{})
+		if !ok || len(items) == 0 {
+			t.Errorf("expected the %s field %q to be a list, got %v", format, field, fields[field])
+			continue
+		}
+		if names := fieldNames(items[0]); !reflect.DeepEqual(names, expectedNested) {
+			t.Errorf("expected the %s fields %q of %q, got %q", format, expectedNested, field, names)
+		}
+	}
+}
+
+var corruptRecordFields = []string{"error", "name", "namespace", "releaseName", "storage", "version"}
+
+func corruptRecord() v2.CorruptRecord {
+	return v2.CorruptRecord{Name: "rel.v3", Namespace: "kube-system", Storage: "configmaps", ReleaseName: "rel", Version: 3, Error: "invalid payload"}
+}
+
+func TestCleanupResultOutputFields(t *testing.T) {
+	result := &CleanupResult{
+		DeletedReleases:         []string{"rel"},
+		DeletedVersions:         map[string][]int32{"rel": {1, 2}},
+		TillerRemoved:           true,
+		RemovedTillerNamespaces: []string{"kube-system"},
+		HomeFolderRemoved:       true,
+		RemovedConfigPaths:      []string{"/home/user/.helm/cache"},
+		FailedReleases:          map[string]string{"broken": "no release versions found"},
+		NoVersionsReleases:      []string{"broken"},
+		RemainingVersions:       map[string][]int32{"stuck": {4}},
+		ExcludedReleases:        []string{"kept"},
+		NotStartedReleases:      []string{"late"},
+		DeletedCorruptRecords:   []v2.CorruptRecord{corruptRecord()},
+		Duration:                "1.5s",
+		durations:               map[string]time.Duration{"rel": time.Second},
+	}
+	expected := []string{"deletedCorruptRecords", "deletedReleases", "deletedVersions", "duration", "excludedReleases", "failedReleases", "homeFolderRemoved", "noVersionsReleases", "notStartedReleases", "remainingVersions", "removedConfigPaths", "removedTillerNamespaces", "tillerRemoved"}
+	// The fields which are not omitted when empty
+	required := []string{"deletedReleases", "deletedVersions", "homeFolderRemoved", "tillerRemoved"}
+	for _, format := range []string{common.OutputJSON, common.OutputYAML} {
+		checkFieldNames(t, format, outputFields(t, format, result), expected, map[string][]string{"deletedCorruptRecords": corruptRecordFields})
+		if names := fieldNames(outputFields(t, format, &CleanupResult{})); !reflect.DeepEqual(names, required) {
+			t.Errorf("expected the %s fields %q of an empty result, got %q", format, required, names)
+		}
+	}
+}
+
+func TestCleanupPlanOutputFields(t *testing.T) {
+	plan := &CleanupPlan{
+		Releases:          []ReleaseCleanupPlan{{Name: "rel", Versions: []int32{1, 2}, Error: "failed"}},
+		TillerRemoval:     true,
+		TillerNamespace:   "kube-system",
+		TillerNamespaces:  []string{"kube-system", "team"},
+		HomeFolderRemoval: true,
+		HomeFolder:        "/home/user/.helm",
+		ConfigPaths:       []string{"/home/user/.helm/cache"},
+		ExcludedReleases:  []string{"kept"},
+		CorruptRecords:    []v2.CorruptRecord{corruptRecord()},
+	}
+	expected := []string{"configPaths", "corruptRecords", "excludedReleases", "homeFolder", "homeFolderRemoval", "releases", "tillerNamespace", "tillerNamespaces", "tillerRemoval"}
+	nested := map[string][]string{
+		"releases":       {"error", "name", "versions"},
+		"corruptRecords": corruptRecordFields,
+	}
+	for _, format := range []string{common.OutputJSON, common.OutputYAML} {
+		checkFieldNames(t, format, outputFields(t, format, plan), expected, nested)
+	}
+}
+
+func TestConvertResultOutputFields(t *testing.T) {
+	result := &ConvertResult{
+		Name:      "rel",
+		Namespace: "default",
+		Versions:  []int32{1, 2},
+		Warnings:  []string{"chart \"chart\" has apiVersion v1"},
+		Objects: []PlannedObject{{
+			StorageObject:   v3.StorageObject{Kind: "Secret", Name: "sh.helm.release.v1.rel.v2", Namespace: "default", Size: 1024},
+			V2Version:       2,
+			V2Status:        "DEPLOYED",
+			Status:          "deployed",
+			CreateNamespace: true,
+			Conflict:        "replaced",
+		}},
+		ValuesDiff:   []string{"replicas: 1 -> 2"},
+		Corrupt:      []v2.CorruptRecord{corruptRecord()},
+		ManifestDiff: "--- v2\n+++ v3\n",
+	}
+	expected := []string{"corrupt", "manifestDiff", "name", "namespace", "objects", "valuesDiff", "versions", "warnings"}
+	nested := map[string][]string{
+		// The fields of the storage object are inlined in the planned object
+		"objects": {"conflict", "createNamespace", "kind", "name", "namespace", "size", "status", "v2Status", "v2Version"},
+		"corrupt": corruptRecordFields,
+	}
+	required := []string{"name", "namespace", "versions"}
+	for _, format := range []string{common.OutputJSON, common.OutputYAML} {
+		checkFieldNames(t, format, outputFields(t, format, result), expected, nested)
+		if names := fieldNames(outputFields(t, format, &ConvertResult{})); !reflect.DeepEqual(names, required) {
+			t.Errorf("expected the %s fields %q of an empty result, got %q", format, required, names)
+		}
+	}
+}
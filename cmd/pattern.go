@@ -0,0 +1,123 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	namePattern string
+	nameRegex   string
+)
+
+// addNamePatternFlags binds the flags of the glob pattern and regular expression selecting the
+// releases by name
+func addNamePatternFlags(fs *pflag.FlagSet, usage string) {
+	fs.StringVar(&namePattern, "name-pattern", "", "glob pattern the names of the releases "+usage+" have to match, e.g. 'team-a-*'. '*' matches any characters, '?' any character and '[...]' a character class")
+	fs.StringVar(&nameRegex, "name-regex", "", "regular expression the names of the releases "+usage+" have to match, e.g. '^team-(a|b)-'. Cannot be used with the name-pattern flag")
+}
+
+// compileNameMatcher returns the regular expression matching the release names as per the glob pattern
+// or the regular expression, nil when neither is set. The glob pattern matches the whole name,
+// the regular expression any part of it unless anchored.
+func compileNameMatcher(pattern, regex string) (*regexp.Regexp, error) {
+	if pattern != "" && regex != "" {
+		return nil, errors.New("name-pattern and name-regex flags cannot be used together. Write the glob pattern as a regular expression instead")
+	}
+	if pattern != "" {
+		expr, err := globToRegex(pattern)
+		var matcher *regexp.Regexp
+		if err == nil {
+			matcher, err = regexp.Compile(expr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("name-pattern flag \"%s\" is not a valid glob pattern: %s", pattern, err)
+		}
+		return matcher, nil
+	}
+	if regex != "" {
+		matcher, err := regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("name-regex flag \"%s\" is not a valid regular expression: %s", regex, err)
+		}
+		return matcher, nil
+	}
+	return nil, nil
+}
+
+// globToRegex returns the regular expression of the glob pattern, anchored to match whole names
+func globToRegex(pattern string) (string, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", errors.New("trailing backslash")
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", errors.New("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String(), nil
+}
+
+// namePatternDescription describes the glob pattern or regular expression in the log lines
+func namePatternDescription(pattern, regex string) string {
+	if pattern != "" {
+		return fmt.Sprintf("pattern \"%s\"", pattern)
+	}
+	return fmt.Sprintf("regex \"%s\"", regex)
+}
+
+// matchReleaseNames returns the release names which match the matcher, all names when it is nil
+func matchReleaseNames(names []string, matcher *regexp.Regexp) []string {
+	if matcher == nil {
+		return names
+	}
+	matched := []string{}
+	for _, name := range names {
+		if matcher.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,90 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	utils "github.com/helm/helm-2to3/pkg/utils"
+)
+
+// When the standard error is not a terminal, the progress is logged every progressLogInterval or
+// progressLogItems items, whichever comes first
+const (
+	progressLogInterval = 30 * time.Second
+	progressLogItems    = 100
+)
+
+// newProgress returns the progress of a bulk operation, counting the items as described, e.g.
+// "releases converted": a counter refreshed in place when the standard error is a terminal, otherwise
+// periodic log lines. Nil is returned when the progress is disabled, by the 'no-progress' flag or
+// the quiet log level.
+func newProgress(description string) common.Progress {
+	if settings.NoProgress || settings.Quiet {
+		return nil
+	}
+	if utils.IsTerminal(os.Stderr) {
+		return &counterProgress{out: os.Stderr, description: description}
+	}
+	return &logProgress{logger: common.NewLogger(""), description: description}
+}
+
+// counterProgress renders the progress as a counter refreshed in place on a terminal. The cursor is
+// left at the start of the line, so that a log line overwrites the counter until it is refreshed.
+type counterProgress struct {
+	out         io.Writer
+	description string
+	mutex       sync.Mutex
+}
+
+func (progress *counterProgress) Update(item string, index, total int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	if index >= total {
+		fmt.Fprintf(progress.out, "\r\033[K%d/%d %s\n", index, total, progress.description)
+		return
+	}
+	fmt.Fprintf(progress.out, "\r\033[K%d/%d %s\r", index, total, progress.description)
+}
+
+// logProgress logs the progress periodically, and once all items are processed
+type logProgress struct {
+	logger      common.Logger
+	description string
+	mutex       sync.Mutex
+	logged      time.Time
+	loggedIndex int
+}
+
+func (progress *logProgress) Update(item string, index, total int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	now := time.Now()
+	if progress.logged.IsZero() {
+		progress.logged = now
+	}
+	if index < total && index-progress.loggedIndex < progressLogItems && now.Sub(progress.logged) < progressLogInterval {
+		return
+	}
+	progress.logged, progress.loggedIndex = now, index
+	progress.logger.Infof("Progress: %d/%d %s (%d%%)\n", index, total, progress.description, index*100/total)
+}
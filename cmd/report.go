@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	"github.com/helm/helm-2to3/pkg/version"
+)
+
+// The results of the releases of a report
+const (
+	ReportConverted = "converted"
+	ReportDeleted   = "deleted"
+	ReportSkipped   = "skipped"
+	ReportFailed    = "failed"
+)
+
+// Report describes the outcome of a bulk conversion or cleanup, per release and overall
+type Report struct {
+	Command       string          `json:"command"`
+	PluginVersion string          `json:"pluginVersion"`
+	DryRun        bool            `json:"dryRun"`
+	StartedAt     time.Time       `json:"startedAt"`
+	FinishedAt    time.Time       `json:"finishedAt"`
+	Releases      []ReleaseReport `json:"releases"`
+	Totals        ReportTotals    `json:"totals"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// ReleaseReport describes the outcome for a release. The new name is set when the release is
+// converted under another name, and the reason when the release is skipped.
+type ReleaseReport struct {
+	Name      string  `json:"name"`
+	NewName   string  `json:"newName,omitempty"`
+	Result    string  `json:"result"`
+	Versions  []int32 `json:"versions"`
+	Namespace string  `json:"namespace,omitempty"`
+	Duration  string  `json:"duration,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	// Warnings are the warnings about the chart of the release
+	Warnings []string `json:"warnings,omitempty"`
+	// Objects are the Helm v3 storage objects the conversion would create, in dry-run only
+	Objects []PlannedObject `json:"objects,omitempty"`
+	// Corrupt are the Helm v2 storage objects of the release whose release can't be decoded
+	Corrupt []v2.CorruptRecord `json:"corrupt,omitempty"`
+	// ManifestDiff is the unified diff of the deployed manifest and its Helm v3 manifest, with dry-run-diff only
+	ManifestDiff string `json:"manifestDiff,omitempty"`
+}
+
+// ReportTotals counts the releases of a report per result. Succeeded counts the releases converted or deleted.
+type ReportTotals struct {
+	Releases  int `json:"releases"`
+	Succeeded int `json:"succeeded"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+// newReport returns the report of the command, started now
+func newReport(command string, dryRun bool) *Report {
+	return &Report{
+		Command:       command,
+		PluginVersion: version.Version,
+		DryRun:        dryRun,
+		StartedAt:     time.Now(),
+		Releases:      []ReleaseReport{},
+	}
+}
+
+// validateReportFile checks that the format of the report file can be told from its extension
+func validateReportFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	switch filepath.Ext(file) {
+	case ".json", ".yaml", ".yml":
+		return nil
+	}
+	return fmt.Errorf("report file \"%s\" needs the '.json', '.yaml' or '.yml' extension, which sets its format", file)
+}
+
+// finishReport completes the report as per completeReport, and writes it to the file in JSON or YAML
+// as per its extension. The error of the command is returned, or the error writing the report if the
+// command succeeded. The report written, or its error when the command failed too, is logged.
+func finishReport(file string, report *Report, cmdErr error, logger common.Logger) error {
+	completeReport(report, cmdErr)
+
+	var data []byte
+	var err error
+	if filepath.Ext(file) == ".json" {
+		data, err = json.MarshalIndent(report, "", "  ")
+	} else {
+		data, err = yaml.Marshal(report)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(file, data, 0600)
+	}
+	if err != nil {
+		err = fmt.Errorf("report failed to be written to \"%s\" with error: %s", file, err)
+		if cmdErr != nil {
+			logger.Warnf("%s\n", err)
+			return cmdErr
+		}
+		return err
+	}
+	logger.Infof("Report written to \"%s\".\n", file)
+	return cmdErr
+}
+
+// completeReport sets the finish time and totals of the report, and the error the command ended in, if any
+func completeReport(report *Report, cmdErr error) {
+	report.FinishedAt = time.Now()
+	// The releases are sorted by name, so that the reports of two runs can be diffed
+	sort.SliceStable(report.Releases, func(i, j int) bool {
+		return report.Releases[i].Name < report.Releases[j].Name
+	})
+	report.Totals = ReportTotals{Releases: len(report.Releases)}
+	for _, release := range report.Releases {
+		switch release.Result {
+		case ReportConverted, ReportDeleted:
+			report.Totals.Succeeded++
+		case ReportSkipped:
+			report.Totals.Skipped++
+		case ReportFailed:
+			report.Totals.Failed++
+		}
+	}
+	if cmdErr != nil {
+		report.Error = cmdErr.Error()
+	}
+}
+
+// formatDuration formats the duration of the operation on a release in a report
+func formatDuration(duration time.Duration) string {
+	return duration.Round(time.Millisecond).String()
+}
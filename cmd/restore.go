@@ -0,0 +1,179 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+)
+
+var (
+	restoreFile  string
+	restoreForce bool
+)
+
+type RestoreOptions struct {
+	DryRun bool
+	File   string
+	Force  bool
+	// Logger logs the release versions restored. Defaults to the standard logger.
+	Logger              common.Logger
+	StorageType         string
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (restoreOptions RestoreOptions) logger() common.Logger {
+	return common.LoggerOrDefault(restoreOptions.Logger)
+}
+
+func newRestoreCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "restore Helm v2 release data from an archive created by the backup command",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: runRestore,
+	}
+
+	flags := cmd.Flags()
+	settings.AddFlags(flags)
+	settings.AddRetryFlags(flags)
+	settings.AddAuditLogFlag(flags)
+
+	flags.StringVar(&restoreFile, "file", "helm-v2-releases.tar.gz", "path of the archive file the release data is read from")
+	flags.BoolVar(&restoreForce, "force", false, "if set, existing Helm v2 storage objects of the release versions restored are overwritten")
+
+	return cmd
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	restoreOptions := RestoreOptions{
+		DryRun:              settings.DryRun,
+		File:                restoreFile,
+		Force:               restoreForce,
+		StorageType:         settings.ReleaseStorage,
+		TillerNamespace:     settings.TillerNamespace,
+		TillerOutCluster:    settings.TillerOutCluster,
+		TillerSQLConnection: settings.TillerSQLConnection,
+		TillerStorageDir:    settings.TillerStorageDir,
+	}
+	kubeConfig := settings.KubeConfig()
+
+	ctx, cancel := settings.WithTimeout(cmd.Context())
+	defer cancel()
+	ctx = common.WithRetryOptions(ctx, settings.RetryOptions())
+	if err := settings.CheckTillerNamespace(ctx); err != nil {
+		return err
+	}
+
+	return Restore(ctx, restoreOptions, kubeConfig)
+}
+
+// Restore creates the Helm v2 storage objects of the release versions in a release archive, in the
+// Tiller namespace. The release versions are stored as per the storage type of Tiller, so release
+// versions archived from ConfigMaps can be restored to Secrets and vice versa.
+// A release version which fails to be restored does not stop the remaining release versions from
+// being restored, but an error is returned if any failed.
+func Restore(ctx context.Context, restoreOptions RestoreOptions, kubeConfig common.KubeConfig) error {
+	if restoreOptions.File == "" {
+		return errors.New("file of the archive has to be defined")
+	}
+	logger := restoreOptions.logger()
+	if restoreOptions.DryRun {
+		logger.Infof("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		logger.Infof("Run without --dry-run to take the actions described below:")
+		logger.Infof("")
+	}
+
+	file, err := os.Open(restoreOptions.File)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive \"%s\" due to the following error: %s", restoreOptions.File, err)
+	}
+	records, err := v2.ReadArchive(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to read archive \"%s\" due to the following error: %s", restoreOptions.File, err)
+	}
+
+	retrieveOptions := v2.RetrieveOptions{
+		TillerNamespace:  restoreOptions.TillerNamespace,
+		TillerOutCluster: restoreOptions.TillerOutCluster,
+		StorageType:      restoreOptions.StorageType,
+		SQLConnection:    restoreOptions.TillerSQLConnection,
+		StorageDir:       restoreOptions.TillerStorageDir,
+		Logger:           logger,
+	}
+	storage, err := v2.GetStorageType(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	restored := []string{}
+	failed := []string{}
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logger.Infof("[Helm 2] ReleaseVersion \"%s\" will be restored to %s in \"%s\" namespace.\n", record.Name, storage, restoreOptions.TillerNamespace)
+		if record.Storage != "" && record.Storage != storage {
+			logger.Infof("[Helm 2] ReleaseVersion \"%s\" was archived from %s and will be converted to %s.\n", record.Name, record.Storage, storage)
+		}
+		if restoreOptions.DryRun {
+			continue
+		}
+		err := v2.CreateReleaseRecord(ctx, retrieveOptions, record, restoreOptions.Force, kubeConfig)
+		if errors.Is(err, v2.ErrReleaseRecordExists) {
+			err = fmt.Errorf("%s. Set the 'force' flag to overwrite it", err)
+		}
+		if err != nil {
+			logger.Infof("[Helm 2] ReleaseVersion \"%s\" failed to restore with error: %s.\n", record.Name, err)
+			failed = append(failed, record.Name)
+			continue
+		}
+		logger.Infof("[Helm 2] ReleaseVersion \"%s\" restored.\n", record.Name)
+		restored = append(restored, record.Name)
+	}
+	if restoreOptions.DryRun {
+		return nil
+	}
+
+	logger.Infof("[Helm 2] %d of %d release versions restored.\n", len(restored), len(records))
+	if len(restored) > 0 {
+		logger.Infof("[Helm 2] Restored: %s\n", strings.Join(restored, ", "))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("[Helm 2] %d of %d release versions failed to restore: %s", len(failed), len(records), strings.Join(failed, ", "))
+	}
+	return nil
+}
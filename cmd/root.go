@@ -22,6 +22,9 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/version"
 )
 
 var (
@@ -34,17 +37,45 @@ func NewRootCmd(out io.Writer, args []string) *cobra.Command {
 		Short:        "Migrate and Cleanup Helm v2 configuration and releases in-place to Helm v3",
 		Long:         "Migrate and Cleanup Helm v2 configuration and releases in-place to Helm v3",
 		SilenceUsage: true,
+		Version:      version.Get().String(),
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 0 {
 				return errors.New("no arguments accepted")
 			}
 			return nil
 		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			sources, err := setFlagsFromEnv(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			helmV2Sources, err := setFlagsFromHelmV2Env(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			sources = append(sources, helmV2Sources...)
+			if err := settings.SetLogLevel(); err != nil {
+				return err
+			}
+			for _, source := range sources {
+				common.Debugf("%s", source)
+			}
+			settings.SetTillerlessDefaults()
+			settings.SetLabelDefault()
+			if err := settings.Validate(cmd.Flags()); err != nil {
+				return err
+			}
+			if settings.AuditLog != "" {
+				return common.OpenAuditLog(settings.AuditLog)
+			}
+			return nil
+		},
 	}
 
 	flags := cmd.PersistentFlags()
 	flags.Parse(args)
 	settings = new(EnvSettings)
+	settings.AddLogFlags(flags)
 
 	// When run with the Helm plugin framework, Helm plugins are not passed the
 	// plugin flags that correspond to Helm global flags e.g. helm 2to3 convert --kube-context ...
@@ -57,14 +88,23 @@ func NewRootCmd(out io.Writer, args []string) *cobra.Command {
 
 	// Note that the plugin's --kubeconfig flag is set by the Helm plugin framework to
 	// the KUBECONFIG environment variable instead of being passed into the plugin.
-	// That variable is transparently handled by the helm-plugin-utils package so does not
+	// That variable is transparently handled by the client-go loading rules so does not
 	// need to be explicitely handled here.
 
 	cmd.AddCommand(
+		newBackupCmd(out),
 		newCleanupCmd(out),
+		newCompletionCmd(out),
 		newConvertCmd(out),
+		newDoctorCmd(out),
+		newListCmd(out),
 		newMoveConfigCmd(out),
+		newRestoreCmd(out),
+		newVerifyCmd(out),
+		newVersionCmd(out),
 	)
+	addErrorHints(cmd)
+	addCompletions(cmd)
 
 	return cmd
 }
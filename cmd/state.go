@@ -0,0 +1,99 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// convertState records the releases converted by a conversion of all releases, so that the
+// conversion resumes where it left off when run again. It is saved to its file as each release is
+// converted, and is safe for use by the workers converting the releases.
+type convertState struct {
+	TillerNamespace string   `json:"tillerNamespace"`
+	Converted       []string `json:"converted"`
+
+	file      string
+	converted map[string]bool
+	mutex     sync.Mutex
+}
+
+// loadConvertState loads the state of the conversion of the releases of the Tiller namespace from
+// the file. An empty state is returned if the file does not exist.
+func loadConvertState(file, tillerNamespace string) (*convertState, error) {
+	state := &convertState{
+		TillerNamespace: tillerNamespace,
+		Converted:       []string{},
+		file:            file,
+		converted:       map[string]bool{},
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("state file \"%s\" failed to be read with error: %s", file, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("state file \"%s\" failed to be parsed with error: %s", file, err)
+	}
+	if state.TillerNamespace != tillerNamespace {
+		return nil, fmt.Errorf("state file \"%s\" records the conversion of the releases of Tiller namespace \"%s\", not \"%s\"", file, state.TillerNamespace, tillerNamespace)
+	}
+	for _, releaseName := range state.Converted {
+		state.converted[releaseName] = true
+	}
+	return state, nil
+}
+
+// isConverted returns true if the release is recorded as converted
+func (state *convertState) isConverted(releaseName string) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.converted[releaseName]
+}
+
+// addConverted records the release as converted, and saves the state to its file. The file is
+// replaced as a whole, so that it is not left truncated if the conversion is interrupted.
+func (state *convertState) addConverted(releaseName string) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.converted[releaseName] {
+		return nil
+	}
+	state.converted[releaseName] = true
+	state.Converted = append(state.Converted, releaseName)
+	sort.Strings(state.Converted)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := state.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("state file \"%s\" failed to be written with error: %s", state.file, err)
+	}
+	if err := os.Rename(tmpFile, state.file); err != nil {
+		return fmt.Errorf("state file \"%s\" failed to be written with error: %s", state.file, err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	v2chart "k8s.io/helm/pkg/proto/hapi/chart"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// storageMode is a way Helm v2 releases are stored when Tiller is not running in the cluster
+type storageMode struct {
+	name string
+	// tillerOutCluster, storageType, storageDir and tillerless are the options of the mode
+	tillerOutCluster bool
+	storageType      string
+	storageDir       string
+	tillerless       bool
+	// remaining returns the names of the release versions left in the storage of the mode
+	remaining func(t *testing.T) []string
+}
+
+// tillerlessReleases returns the fixture releases: "app", with a superseded and a deployed version, in
+// the "default" namespace, and "db", with a deployed version, in the "data" namespace
+func tillerlessReleases() []*rls.Release {
+	app1, app2, db1 := deployedRelease("app", 1), deployedRelease("app", 2), deployedRelease("db", 1)
+	app1.Info.Status.Code = rls.Status_SUPERSEDED
+	db1.Namespace = "data"
+	db1.Chart = &v2chart.Chart{Metadata: &v2chart.Metadata{Name: "postgres", Version: "2.1.0"}}
+	return []*rls.Release{app1, app2, db1}
+}
+
+// v2Secret returns the Secret Tillerless Helm v2 stores the release version in, in the "kube-system"
+// namespace
+func v2Secret(t *testing.T, v2Release *rls.Release) *corev1.Secret {
+	t.Helper()
+	configMap := v2ConfigMap(t, v2Release)
+	return &corev1.Secret{
+		ObjectMeta: configMap.ObjectMeta,
+		Data:       map[string][]byte{"release": []byte(configMap.Data["release"])},
+	}
+}
+
+// storageModes returns the storage modes with the fixture releases stored, and the kube config of the
+// cluster. The cluster has no Tiller Deployment.
+func storageModes(t *testing.T) ([]storageMode, []common.KubeConfig, func()) {
+	t.Helper()
+	storageDir, err := ioutil.TempDir("", "helm-2to3-storage-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modes []storageMode
+	var kubeConfigs []common.KubeConfig
+	for _, mode := range []storageMode{
+		{name: "tillerless secrets detected", storageType: v2.StorageAuto},
+		{name: "tillerless flag", tillerOutCluster: true, storageType: "secrets", tillerless: true},
+		{name: "storage dir", tillerOutCluster: true, storageType: "configmaps", storageDir: storageDir},
+	} {
+		var objects []runtime.Object
+		for _, v2Release := range tillerlessReleases() {
+			if mode.storageDir == "" {
+				objects = append(objects, v2Secret(t, v2Release))
+				continue
+			}
+			configMap := v2ConfigMap(t, v2Release)
+			if err := ioutil.WriteFile(filepath.Join(storageDir, configMap.Name), []byte(configMap.Data["release"]), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		client := fake.NewSimpleClientset(objects...)
+		client.PrependReactor("delete-collection", "secrets", deleteSecretCollection(client))
+		if mode.storageDir == "" {
+			mode.remaining = func(t *testing.T) []string {
+				secrets, err := client.CoreV1().Secrets("kube-system").List(context.Background(), metav1.ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, secret := range secrets.Items {
+					names = append(names, secret.Name)
+				}
+				return names
+			}
+		} else {
+			mode.remaining = func(t *testing.T) []string {
+				files, err := ioutil.ReadDir(storageDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, file := range files {
+					names = append(names, file.Name())
+				}
+				return names
+			}
+		}
+		modes = append(modes, mode)
+		kubeConfigs = append(kubeConfigs, common.KubeConfig{Client: client})
+	}
+	return modes, kubeConfigs, func() { os.RemoveAll(storageDir) }
+}
+
+// deleteSecretCollection returns a reactor deleting the Secrets selected by the delete of a collection,
+// which the fake clientset doesn't delete
+func deleteSecretCollection(client *fake.Clientset) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.DeleteCollectionAction).GetListRestrictions().Labels
+		gvr := corev1.SchemeGroupVersion.WithResource("secrets")
+		list, err := client.Tracker().List(gvr, corev1.SchemeGroupVersion.WithKind("Secret"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		for _, secret := range list.(*corev1.SecretList).Items {
+			if !selector.Matches(labels.Set(secret.Labels)) {
+				continue
+			}
+			if err := client.Tracker().Delete(gvr, secret.Namespace, secret.Name); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, nil
+	}
+}
+
+func TestListStorageModes(t *testing.T) {
+	modes, kubeConfigs, cleanup := storageModes(t)
+	defer cleanup()
+	expected := []ReleaseListing{
+		{Name: "app", Revision: 2, Versions: 2, Namespace: "default", Status: "DEPLOYED", Chart: "chart-1.0.0"},
+		{Name: "db", Revision: 1, Versions: 1, Namespace: "data", Status: "DEPLOYED", Chart: "postgres-2.1.0"},
+	}
+	for i, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			listOptions := ListOptions{
+				StorageType:      mode.storageType,
+				TillerLabel:      "OWNER=TILLER",
+				TillerNamespace:  "kube-system",
+				TillerOutCluster: mode.tillerOutCluster,
+				TillerStorageDir: mode.storageDir,
+			}
+			listings, err := ListReleases(context.Background(), listOptions, kubeConfigs[i])
+			if err != nil {
+				t.Fatalf("list failed with error: %s", err)
+			}
+			if !reflect.DeepEqual(listings, expected) {
+				t.Errorf("expected the releases %+v, got %+v", expected, listings)
+			}
+		})
+	}
+}
+
+func TestCleanupStorageModes(t *testing.T) {
+	modes, kubeConfigs, cleanup := storageModes(t)
+	defer cleanup()
+	for i, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			logger := &commontest.RecordingLogger{}
+			cleanupOptions := CleanupOptions{
+				Logger:           logger,
+				Out:              ioutil.Discard,
+				ReleaseCleanup:   true,
+				SkipConfirmation: true,
+				StorageType:      mode.storageType,
+				TillerCleanup:    mode.tillerless,
+				TillerLabel:      "OWNER=TILLER",
+				TillerNamespace:  "kube-system",
+				TillerOutCluster: mode.tillerOutCluster,
+				TillerStorageDir: mode.storageDir,
+				Tillerless:       mode.tillerless,
+			}
+			result, err := Cleanup(context.Background(), cleanupOptions, kubeConfigs[i])
+			if err != nil {
+				t.Fatalf("cleanup failed with error: %s", err)
+			}
+			expected := map[string][]int32{"app": {1, 2}, "db": {1}}
+			if !reflect.DeepEqual(result.DeletedVersions, expected) {
+				t.Errorf("expected the versions %v to be deleted, got %v", expected, result.DeletedVersions)
+			}
+			if remaining := mode.remaining(t); len(remaining) > 0 {
+				t.Errorf("expected the Helm v2 storage to be empty, got %v", remaining)
+			}
+			if mode.tillerless {
+				if result.TillerRemoved || !logger.Logged("Tiller cleanup skipped") {
+					t.Errorf("expected the Tiller cleanup to be skipped, got %v", logger.Lines())
+				}
+			}
+		})
+	}
+}
+
+func TestConvertStorageModes(t *testing.T) {
+	if err := v3.SetStorage("secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	modes, kubeConfigs, cleanup := storageModes(t)
+	defer cleanup()
+	for i, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			convertOptions := ConvertOptions{
+				DeleteRelease:    true,
+				Logger:           &commontest.RecordingLogger{},
+				ReleaseName:      "app",
+				StorageType:      mode.storageType,
+				TillerLabel:      "OWNER=TILLER",
+				TillerNamespace:  "kube-system",
+				TillerOutCluster: mode.tillerOutCluster,
+				TillerStorageDir: mode.storageDir,
+			}
+			if err := Convert(context.Background(), convertOptions, kubeConfigs[i]); err != nil {
+				t.Fatalf("conversion failed with error: %s", err)
+			}
+			history, err := v3.GetReleaseHistory("app", "default", kubeConfigs[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 2 {
+				t.Fatalf("expected the 2 versions of the release in Helm v3 storage, got %d", len(history))
+			}
+			for _, v3Release := range history {
+				if v3Release.Version == 2 && v3Release.Info.Status != release.StatusDeployed {
+					t.Errorf("expected the latest version to be deployed, got %s", v3Release.Info.Status)
+				}
+			}
+			if remaining := mode.remaining(t); !reflect.DeepEqual(remaining, []string{"db.v1"}) {
+				t.Errorf("expected only the release not converted to be left in Helm v2 storage, got %v", remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// valuesModifiedDescription is appended to the description of the release version whose values are
+// overridden by the conversion
+const valuesModifiedDescription = "Values modified during conversion by helm 2to3"
+
+var (
+	setValues  []string
+	valueFiles []string
+)
+
+// addValueOverrideFlags binds the flags of the values overriding the values of the release converted
+func addValueOverrideFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&setValues, "set", []string{}, "value set in the values of the deployed version of the release converted, e.g. rbac.create=true, as with 'helm upgrade --set'. The manifest is not rendered again. This flag can be repeated, and cannot be used with the --all flag")
+	fs.StringArrayVarP(&valueFiles, "values", "f", []string{}, "YAML file of the values merged into the values of the deployed version of the release converted, as with 'helm upgrade --values'. The 'set' flag takes precedence over it. This flag can be repeated, and cannot be used with the --all flag")
+}
+
+// valueOverrides returns the values of the value override flags, merged as by Helm v3: the files in order,
+// then the values set. Nil is returned when none is set.
+func valueOverrides() (map[string]interface{}, error) {
+	if len(setValues) == 0 && len(valueFiles) == 0 {
+		return nil, nil
+	}
+	options := values.Options{
+		ValueFiles: valueFiles,
+		Values:     setValues,
+	}
+	// Only local files are read, as no getter is provided for the URLs
+	overrides, err := options.MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, fmt.Errorf("values of the 'set' and 'values' flags failed to be read with error: %s", err)
+	}
+	return overrides, nil
+}
+
+// overrideValues merges the overrides into the user supplied values of the release version, the
+// overrides taking precedence, and marks its description as modified. The differences of the values
+// are returned, one per key changed.
+func overrideValues(rel *release.Release, overrides map[string]interface{}) []string {
+	before := copyValues(rel.Config)
+	rel.Config = chartutil.CoalesceTables(copyValues(overrides), copyValues(rel.Config))
+	if rel.Info != nil {
+		rel.Info.Description = strings.TrimSpace(strings.TrimSuffix(rel.Info.Description, ".") + ". " + valuesModifiedDescription)
+	}
+	return diffValues("", before, rel.Config)
+}
+
+// copyValues returns a deep copy of the values, so that merging into it leaves the values as they are
+func copyValues(vals map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for key, value := range vals {
+		if table, ok := value.(map[string]interface{}); ok {
+			value = copyValues(table)
+		}
+		copied[key] = value
+	}
+	return copied
+}
+
+// diffValues returns the differences between the values before and after, as one line per key added,
+// changed or removed, the keys of nested tables being joined with dots
+func diffValues(prefix string, before, after map[string]interface{}) []string {
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	sorted := []string{}
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	diff := []string{}
+	for _, key := range sorted {
+		oldValue, inBefore := before[key]
+		newValue, inAfter := after[key]
+		oldTable, oldIsTable := oldValue.(map[string]interface{})
+		newTable, newIsTable := newValue.(map[string]interface{})
+		switch {
+		case oldIsTable && newIsTable:
+			diff = append(diff, diffValues(prefix+key+".", oldTable, newTable)...)
+		case !inBefore:
+			diff = append(diff, fmt.Sprintf("%s%s: added %v", prefix, key, newValue))
+		case !inAfter:
+			diff = append(diff, fmt.Sprintf("%s%s: removed %v", prefix, key, oldValue))
+		case !reflect.DeepEqual(oldValue, newValue):
+			diff = append(diff, fmt.Sprintf("%s%s: %v -> %v", prefix, key, oldValue, newValue))
+		}
+	}
+	return diff
+}
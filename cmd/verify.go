@@ -0,0 +1,504 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// exitCodeDifferences is the exit code of verify when a release differs from its Helm v3 release
+const exitCodeDifferences = 3
+
+var (
+	convertedOnlyVerify bool
+	verifyAll           bool
+)
+
+// ErrNotConverted is returned when the Helm v3 release of a release is not labelled as converted by the plugin
+var ErrNotConverted = errors.New("release is not labelled as converted")
+
+type VerifyOptions struct {
+	ConvertedOnly bool
+	FailOnEmpty   bool
+	// Logger logs the releases skipped or failing to verify. Defaults to the standard logger.
+	Logger              common.Logger
+	ReleaseName         string
+	Selector            string
+	StorageType         string
+	TillerLabel         string
+	TillerNamespace     string
+	TillerOutCluster    bool
+	TillerSQLConnection string
+	TillerStorageDir    string
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (verifyOptions VerifyOptions) logger() common.Logger {
+	return common.LoggerOrDefault(verifyOptions.Logger)
+}
+
+// VerifyResult describes the differences between a Helm v2 release and its converted Helm v3 release,
+// and the constructs of its chart templates which break under Helm v3
+type VerifyResult struct {
+	ReleaseName string `json:"releaseName"`
+	// Converted is set when the Helm v3 release version is labelled as converted by the plugin
+	Converted   bool     `json:"converted"`
+	Differences []string `json:"differences"`
+	// Findings are the constructs of the chart templates of the release which break under Helm v3. They
+	// are not differences, as the conversion keeps the templates as is.
+	Findings []v3.TemplateFinding `json:"findings,omitempty"`
+}
+
+// VerifyReport is the result of the verification of all releases, as printed with the json and yaml
+// output formats
+type VerifyReport struct {
+	Releases []*VerifyResult `json:"releases"`
+	// Failed are the errors of the releases which failed to verify, by release name
+	Failed  map[string]string `json:"failed,omitempty"`
+	Skipped []string          `json:"skipped,omitempty"`
+	// FindingTotals count the template findings of all releases by severity
+	FindingTotals map[string]int `json:"findingTotals"`
+}
+
+func newVerifyCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [flags] RELEASE",
+		Short: "verify a Helm v2 release against its converted Helm v3 release",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if verifyAll {
+				if len(args) > 0 {
+					return errors.New("name of release to be verified cannot be defined when the --all flag is set")
+				}
+				return nil
+			}
+			if len(args) != 1 {
+				return errors.New("name of release to be verified has to be defined")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerify(cmd.Context(), out, args)
+		},
+	}
+
+	flags := cmd.Flags()
+	settings.AddRetrieveFlags(flags)
+	settings.AddV3StorageFlags(flags)
+	settings.AddFailOnEmptyFlag(flags)
+	settings.AddOutputFlag(flags)
+
+	flags.BoolVar(&convertedOnlyVerify, "converted-only", false, "if set, only the releases whose Helm v3 release is labelled as converted by the plugin are verified. The others are skipped with the --all flag, and fail otherwise")
+	flags.BoolVar(&verifyAll, "all", false, "if set, all Helm v2 releases are verified. Cannot be used with a release name")
+
+	return cmd
+}
+
+func runVerify(ctx context.Context, out io.Writer, args []string) error {
+	ctx, cancel := settings.WithTimeout(ctx)
+	defer cancel()
+	var releaseName string
+	if !verifyAll {
+		releaseName = args[0]
+	}
+	if settings.FailOnEmpty && !verifyAll {
+		return errors.New("fail-on-empty flag can only be used with the --all flag")
+	}
+	if err := settings.SetV3Storage(); err != nil {
+		return err
+	}
+	if err := settings.CheckTillerNamespace(ctx); err != nil {
+		return err
+	}
+	verifyOptions := VerifyOptions{
+		ConvertedOnly:       convertedOnlyVerify,
+		FailOnEmpty:         settings.FailOnEmpty,
+		ReleaseName:         releaseName,
+		Selector:            settings.Selector,
+		StorageType:         settings.ReleaseStorage,
+		TillerLabel:         settings.Label,
+		TillerNamespace:     settings.TillerNamespace,
+		TillerOutCluster:    settings.TillerOutCluster,
+		TillerSQLConnection: settings.TillerSQLConnection,
+		TillerStorageDir:    settings.TillerStorageDir,
+	}
+	kubeConfig := settings.KubeConfig()
+
+	// The result is printed in the output format to the standard output, and the verification to the
+	// standard error as the log lines
+	textOut := out
+	if settings.Output != common.OutputTable {
+		textOut = os.Stderr
+	}
+	if verifyAll {
+		report, err := VerifyAllReport(ctx, textOut, verifyOptions, kubeConfig)
+		if settings.Output != common.OutputTable && report != nil {
+			if printErr := common.PrintOutput(out, settings.Output, report); printErr != nil && err == nil {
+				err = printErr
+			}
+		}
+		return err
+	}
+	result, err := Verify(ctx, verifyOptions, kubeConfig)
+	if err != nil {
+		return err
+	}
+	printVerifyResult(textOut, result)
+	if settings.Output != common.OutputTable {
+		if err := common.PrintOutput(out, settings.Output, result); err != nil {
+			return err
+		}
+	}
+	if len(result.Differences) > 0 {
+		return ExitError{
+			Code: exitCodeDifferences,
+			Err:  fmt.Errorf("release \"%s\" differs from its Helm v3 release", releaseName),
+		}
+	}
+	return nil
+}
+
+// VerifyAll verifies all Helm v2 releases stored for the Tiller namespace and label against their
+// Helm v3 releases, as per Verify, and prints a report for each release and a summary.
+// An error is returned if any release failed to be verified, otherwise an ExitError if any release differs.
+func VerifyAll(ctx context.Context, out io.Writer, verifyOptions VerifyOptions, kubeConfig common.KubeConfig) error {
+	_, err := VerifyAllReport(ctx, out, verifyOptions, kubeConfig)
+	return err
+}
+
+// VerifyAllReport verifies all Helm v2 releases as per VerifyAll, and returns the report of the releases
+// verified along with the error. The report is nil when the releases can't be listed.
+func VerifyAllReport(ctx context.Context, out io.Writer, verifyOptions VerifyOptions, kubeConfig common.KubeConfig) (*VerifyReport, error) {
+	if err := v2.ValidateSelector(verifyOptions.Selector); err != nil {
+		return nil, err
+	}
+
+	logger := verifyOptions.logger()
+	retrieveOptions := v2.RetrieveOptions{
+		Selector:         verifyOptions.Selector,
+		TillerNamespace:  verifyOptions.TillerNamespace,
+		TillerLabel:      verifyOptions.TillerLabel,
+		TillerOutCluster: verifyOptions.TillerOutCluster,
+		StorageType:      verifyOptions.StorageType,
+		SQLConnection:    verifyOptions.TillerSQLConnection,
+		StorageDir:       verifyOptions.TillerStorageDir,
+		Logger:           verifyOptions.logger(),
+	}
+	releaseNames, err := v2.GetReleaseNames(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{
+		Releases:      []*VerifyResult{},
+		FindingTotals: map[string]int{v3.FindingWillError: 0, v3.FindingBehavesDifferently: 0},
+	}
+	if len(releaseNames) <= 0 {
+		if verifyOptions.FailOnEmpty {
+			return report, fmt.Errorf("%w: [Helm 2] no deployed releases for namespace: %s, owner: %s", common.ErrNothingFound, verifyOptions.TillerNamespace, verifyOptions.TillerLabel)
+		}
+		logger.Infof("[Helm 2] no deployed releases for namespace: %s, owner: %s\n", verifyOptions.TillerNamespace, verifyOptions.TillerLabel)
+		return report, nil
+	}
+
+	failed := map[string]error{}
+	differ := map[string]bool{}
+	skipped := map[string]bool{}
+	findings := map[string]int{}
+	for _, releaseName := range releaseNames {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		releaseOptions := verifyOptions
+		releaseOptions.ReleaseName = releaseName
+		result, err := Verify(ctx, releaseOptions, kubeConfig)
+		if errors.Is(err, ErrNotConverted) {
+			logger.Infof("Release \"%s\" is skipped as its Helm v3 release is not labelled as converted.\n", releaseName)
+			skipped[releaseName] = true
+			report.Skipped = append(report.Skipped, releaseName)
+			continue
+		}
+		if err != nil {
+			logger.Infof("Release \"%s\" failed to verify with error: %s\n", releaseName, err)
+			failed[releaseName] = err
+			if report.Failed == nil {
+				report.Failed = map[string]string{}
+			}
+			report.Failed[releaseName] = err.Error()
+			continue
+		}
+		printVerifyResult(out, result)
+		differ[releaseName] = len(result.Differences) > 0
+		findings[releaseName] = len(result.Findings)
+		for _, finding := range result.Findings {
+			report.FindingTotals[finding.Severity]++
+		}
+		report.Releases = append(report.Releases, result)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Verification summary:")
+	for _, releaseName := range releaseNames {
+		if err, ok := failed[releaseName]; ok {
+			fmt.Fprintf(out, "  %s: failed: %s\n", releaseName, err)
+		} else if skipped[releaseName] {
+			fmt.Fprintf(out, "  %s: skipped, not converted\n", releaseName)
+		} else if differ[releaseName] {
+			fmt.Fprintf(out, "  %s: differences found%s\n", releaseName, findingsSummary(findings[releaseName]))
+		} else {
+			fmt.Fprintf(out, "  %s: passed%s\n", releaseName, findingsSummary(findings[releaseName]))
+		}
+	}
+	differences := 0
+	for _, d := range differ {
+		if d {
+			differences++
+		}
+	}
+	fmt.Fprintf(out, "%d passed, %d with differences, %d failed", len(releaseNames)-differences-len(failed)-len(skipped), differences, len(failed))
+	if len(skipped) > 0 {
+		fmt.Fprintf(out, ", %d skipped", len(skipped))
+	}
+	fmt.Fprintln(out, ".")
+	if willError, different := report.FindingTotals[v3.FindingWillError], report.FindingTotals[v3.FindingBehavesDifferently]; willError+different > 0 {
+		fmt.Fprintf(out, "Template findings: %d will error, %d behave differently under Helm v3.\n", willError, different)
+	}
+
+	if len(failed) > 0 {
+		err := fmt.Errorf("%d of %d releases failed to verify", len(failed), len(releaseNames))
+		if len(failed) < len(releaseNames)-len(skipped) {
+			return report, &common.PartialError{Succeeded: len(releaseNames) - len(failed) - len(skipped), Failed: len(failed), Err: err}
+		}
+		return report, err
+	}
+	if differences > 0 {
+		return report, ExitError{
+			Code: exitCodeDifferences,
+			Err:  fmt.Errorf("%d of %d releases differ from their Helm v3 release", differences, len(releaseNames)),
+		}
+	}
+	return report, nil
+}
+
+// findingsSummary returns the count of the template findings of a release in the verification summary
+func findingsSummary(findings int) string {
+	if findings == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d template findings", findings)
+}
+
+// Verify compares the latest version of a Helm v2 release with the latest version of its Helm v3
+// release, in the namespace the release is deployed into. The Helm v2 release version is mapped as
+// per conversion, and compared with the Helm v3 release version on chart name and version, computed
+// values, manifest, hooks, description, notes, namespace, revision and the number of revisions.
+// The templates of its chart are scanned for the constructs which break under Helm v3, as per
+// v3.LintTemplates. An error is returned if either release is not found.
+func Verify(ctx context.Context, verifyOptions VerifyOptions, kubeConfig common.KubeConfig) (*VerifyResult, error) {
+	if err := v2.ValidateSelector(verifyOptions.Selector); err != nil {
+		return nil, err
+	}
+
+	retrieveOptions := v2.RetrieveOptions{
+		ReleaseName:      verifyOptions.ReleaseName,
+		Selector:         verifyOptions.Selector,
+		TillerNamespace:  verifyOptions.TillerNamespace,
+		TillerLabel:      verifyOptions.TillerLabel,
+		TillerOutCluster: verifyOptions.TillerOutCluster,
+		StorageType:      verifyOptions.StorageType,
+		SQLConnection:    verifyOptions.TillerSQLConnection,
+		StorageDir:       verifyOptions.TillerStorageDir,
+		Logger:           verifyOptions.logger(),
+	}
+	v2Releases, err := v2.GetReleaseVersions(ctx, retrieveOptions, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	v2Release := v2Releases[len(v2Releases)-1]
+
+	v3Releases, err := v3.GetReleaseHistory(verifyOptions.ReleaseName, v2Release.Namespace, kubeConfig)
+	if errors.Is(err, v3.ErrReleaseNotFound) {
+		return nil, fmt.Errorf("[Helm 3] release \"%s\" not found in \"%s\" namespace: %w", verifyOptions.ReleaseName, v2Release.Namespace, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v3Release := v3Releases[len(v3Releases)-1]
+
+	converted, err := v3.IsConverted(ctx, v3Release, kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("[Helm 3] failed to check if release \"%s\" is labelled as converted with error: %s", verifyOptions.ReleaseName, err)
+	}
+	if verifyOptions.ConvertedOnly && !converted {
+		return nil, fmt.Errorf("%w: [Helm 3] release \"%s\" in \"%s\" namespace is not labelled as converted by the plugin", ErrNotConverted, verifyOptions.ReleaseName, v2Release.Namespace)
+	}
+
+	expected, err := v3.CreateRelease(v2Release)
+	if err != nil {
+		return nil, fmt.Errorf("[Helm 2] ReleaseVersion \"%s\" failed to be mapped to Helm v3 with error: %s", v2.GetReleaseVersionName(v2Release.Name, v2Release.Version), err)
+	}
+	// The templates are scanned before the crd-install hooks are dropped, as per the conversion by default
+	findings := v3.LintTemplates(expected.Chart)
+	v3.ConvertCRDHooks(expected, false)
+
+	result := &VerifyResult{
+		ReleaseName: verifyOptions.ReleaseName,
+		Converted:   converted,
+		Differences: []string{},
+		Findings:    findings,
+	}
+	addDifference := func(field, v2Value, v3Value string) {
+		result.Differences = append(result.Differences, fmt.Sprintf("%s: Helm v2 \"%s\", Helm v3 \"%s\"", field, v2Value, v3Value))
+	}
+	addDiff := func(field, v2Text, v3Text string) {
+		if v2Text == v3Text {
+			return
+		}
+		diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(v2Text),
+			B:        difflib.SplitLines(v3Text),
+			FromFile: "Helm v2",
+			ToFile:   "Helm v3",
+			Context:  3,
+		})
+		result.Differences = append(result.Differences, fmt.Sprintf("%s:\n%s", field, diff))
+	}
+
+	if expected.Namespace != v3Release.Namespace {
+		addDifference("namespace", expected.Namespace, v3Release.Namespace)
+	}
+	// The revision is the Helm v2 version number, or the number of versions once renumbered from 1
+	if expected.Version != v3Release.Version && len(v2Releases) != v3Release.Version {
+		addDifference("revision", fmt.Sprint(expected.Version), fmt.Sprint(v3Release.Version))
+	}
+	if len(v2Releases) != len(v3Releases) {
+		addDifference("revision count", fmt.Sprint(len(v2Releases)), fmt.Sprint(len(v3Releases)))
+	}
+	v2ChartName, v2ChartVersion := chartNameVersion(expected)
+	v3ChartName, v3ChartVersion := chartNameVersion(v3Release)
+	if v2ChartName != v3ChartName {
+		addDifference("chart name", v2ChartName, v3ChartName)
+	}
+	if v2ChartVersion != v3ChartVersion {
+		addDifference("chart version", v2ChartVersion, v3ChartVersion)
+	}
+
+	v2Values, err := computedValues(expected)
+	if err != nil {
+		return nil, fmt.Errorf("[Helm 2] values of release \"%s\" can't be computed: %s", verifyOptions.ReleaseName, err)
+	}
+	v3Values, err := computedValues(v3Release)
+	if err != nil {
+		return nil, fmt.Errorf("[Helm 3] values of release \"%s\" can't be computed: %s", verifyOptions.ReleaseName, err)
+	}
+	addDiff("computed values", v2Values, v3Values)
+	addDiff("manifest", expected.Manifest, v3Release.Manifest)
+
+	v2Hooks, err := hooksYAML(expected.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	v3Hooks, err := hooksYAML(v3Release.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	addDiff("hooks", v2Hooks, v3Hooks)
+
+	var v3Description, v3Notes string
+	if v3Release.Info != nil {
+		v3Description, v3Notes = v3Release.Info.Description, v3Release.Info.Notes
+	}
+	if expected.Info.Description != v3Description {
+		addDifference("description", expected.Info.Description, v3Description)
+	}
+	addDiff("notes", expected.Info.Notes, v3Notes)
+
+	return result, nil
+}
+
+func printVerifyResult(out io.Writer, result *VerifyResult) {
+	if !result.Converted {
+		fmt.Fprintf(out, "NOTE: The Helm v3 release of release \"%s\" is not labelled as converted by the plugin.\n", result.ReleaseName)
+	}
+	if len(result.Differences) == 0 {
+		fmt.Fprintf(out, "Release \"%s\" matches its Helm v3 release.\n", result.ReleaseName)
+	} else {
+		fmt.Fprintf(out, "Release \"%s\" differs from its Helm v3 release, %d differences found:\n", result.ReleaseName, len(result.Differences))
+		for _, difference := range result.Differences {
+			fmt.Fprintf(out, "- %s\n", difference)
+		}
+	}
+	if len(result.Findings) > 0 {
+		fmt.Fprintf(out, "Release \"%s\" has %d template findings which break under Helm v3:\n", result.ReleaseName, len(result.Findings))
+		for _, finding := range result.Findings {
+			fmt.Fprintf(out, "- %s\n", finding)
+		}
+	}
+}
+
+func chartNameVersion(rel *release.Release) (string, string) {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return "", ""
+	}
+	return rel.Chart.Metadata.Name, rel.Chart.Metadata.Version
+}
+
+// computedValues returns the values of the release, which are its user supplied values coalesced
+// with the chart values, as YAML
+func computedValues(rel *release.Release) (string, error) {
+	values := chartutil.Values(rel.Config)
+	if rel.Chart != nil {
+		var err error
+		values, err = chartutil.CoalesceValues(rel.Chart, rel.Config)
+		if err != nil {
+			return "", err
+		}
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hooksYAML returns the hooks of the release as YAML. The last run of the hooks is not included,
+// as test hooks run with Helm v3 after conversion update it.
+func hooksYAML(hooks []*release.Hook) (string, error) {
+	hookDefinitions := []release.Hook{}
+	for _, hook := range hooks {
+		hookDefinition := *hook
+		hookDefinition.LastRun = release.HookExecution{}
+		hookDefinitions = append(hookDefinitions, hookDefinition)
+	}
+	data, err := yaml.Marshal(hookDefinitions)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gosuri/uitable"
+	"github.com/spf13/cobra"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/version"
+)
+
+func newVersionCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "print the version of the plugin, its build info and the versions of the Helm libraries compiled in",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("no arguments accepted")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVersion(out)
+		},
+	}
+
+	flags := cmd.Flags()
+	settings.AddOutputFlag(flags)
+
+	return cmd
+}
+
+func runVersion(out io.Writer) error {
+	return common.PrintOutput(out, settings.Output, versionTable(version.Get()))
+}
+
+// versionTable prints the build info as a table of its values
+type versionTable version.BuildInfo
+
+func (info versionTable) PrintTable(out io.Writer) error {
+	table := uitable.New()
+	table.AddRow("Version:", info.Version)
+	table.AddRow("Git commit:", orUnknown(info.GitCommit))
+	table.AddRow("Build date:", orUnknown(info.BuildDate))
+	table.AddRow("Go version:", info.GoVersion)
+	table.AddRow("Platform:", info.Platform)
+	table.AddRow("Helm v2 library:", info.HelmV2Version)
+	table.AddRow("Helm v3 library:", info.HelmV3Version)
+	_, err := fmt.Fprintln(out, table)
+	return err
+}
+
+// orUnknown returns the value, or "unknown" when it is not set
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
@@ -5,15 +5,23 @@ go 1.13
 require (
 	github.com/Masterminds/semver v1.5.0 // indirect
 	github.com/golang/protobuf v1.4.2
-	github.com/maorfr/helm-plugin-utils v0.0.0-20200827170302-51b70049c73f
+	github.com/gosuri/uitable v0.0.4
+	github.com/lib/pq v1.7.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
 	helm.sh/helm/v3 v3.3.0
+	k8s.io/api v0.18.8
 	k8s.io/apimachinery v0.18.8
+	k8s.io/cli-runtime v0.18.4
+	k8s.io/client-go v0.18.4
 	k8s.io/helm v2.16.10+incompatible
+	k8s.io/utils v0.0.0-20200731180307-f00132d28269 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
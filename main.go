@@ -17,7 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/helm/helm-2to3/cmd"
 )
@@ -25,7 +28,17 @@ import (
 func main() {
 	migrateCmd := cmd.NewRootCmd(os.Stdout, os.Args[1:])
 
-	if err := migrateCmd.Execute(); err != nil {
-		os.Exit(1)
+	// Cancel in-flight operations when interrupted or terminated
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := migrateCmd.ExecuteContext(ctx); err != nil {
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -0,0 +1,106 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The results of the operations of the audit log
+const (
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+	// AuditPlanned is the result of the operations which are not run, in dry-run
+	AuditPlanned = "planned"
+)
+
+// AuditEntry is a line of the audit log, recording a mutating operation on an object: a Helm storage
+// object, or a Kubernetes object such as a Tiller Deployment or a namespace
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Verb is the operation: create, update, patch or delete
+	Verb      string `json:"verb"`
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Release is the Helm release the object belongs to, if any
+	Release string `json:"release,omitempty"`
+	Result  string `json:"result"`
+	Error   string `json:"error,omitempty"`
+	DryRun  bool   `json:"dryRun,omitempty"`
+}
+
+// auditLog is the file the audit entries are appended to, nil when there is no audit log
+var auditLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log file the mutating operations are recorded to, by RecordAudit. The
+// file is created if it does not exist, and appended to otherwise. It is left open until the plugin
+// exits, the entries being written to it unbuffered.
+func OpenAuditLog(file string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("audit log \"%s\" failed to be opened with error: %s", file, err)
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.file = f
+	return nil
+}
+
+// AuditEnabled returns true if the mutating operations are recorded to an audit log
+func AuditEnabled() bool {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	return auditLog.file != nil
+}
+
+// RecordAudit appends the entry of the operation to the audit log, if any, with the result of the
+// operation as per its error, or planned in dry-run. The entry is written as the operation completes,
+// so that a run which crashes leaves the trail of the operations it ran. A failure to write it is
+// logged, as the operation has already run.
+func RecordAudit(entry AuditEntry, err error) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.file == nil {
+		return
+	}
+	entry.Timestamp = time.Now().UTC()
+	switch {
+	case entry.DryRun:
+		entry.Result = AuditPlanned
+	case err != nil:
+		entry.Result, entry.Error = AuditFailed, err.Error()
+	default:
+		entry.Result = AuditSucceeded
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr == nil {
+		_, marshalErr = auditLog.file.Write(append(data, '\n'))
+	}
+	if marshalErr != nil {
+		NewLogger("").Warnf("Audit log entry of the %s of %s \"%s\" failed to be written with error: %s\n", entry.Verb, entry.Kind, entry.Name, marshalErr)
+	}
+}
@@ -16,7 +16,36 @@ limitations under the License.
 
 package common
 
+import (
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
 type KubeConfig struct {
 	Context string
 	File    string
+	// APIServer, Token and CAFile are the address of the API server, the bearer token and the CA bundle
+	// of the cluster, for the environments which have no kubeconfig file. When the API server is set,
+	// they take precedence over the kubeconfig file and the in-cluster configuration.
+	APIServer string
+	Token     string
+	CAFile    string
+	// InsecureSkipTLSVerify disables the verification of the certificate of the API server
+	InsecureSkipTLSVerify bool
+	// Client, when set, is the clientset returned by ClientSet in place of one for the cluster, which
+	// the Helm v3 storage Secrets or ConfigMaps are accessed through too, e.g. a fake clientset in tests
+	Client kubernetes.Interface
+	// InCluster forces the in-cluster configuration of the pod the plugin runs in to be used. It is
+	// also used when no kubeconfig file is set and none exists in the default locations.
+	InCluster bool
+	// QPS and Burst are the client rate limits of the Kubernetes API clients.
+	// The client-go defaults are used when they are not set.
+	QPS   float32
+	Burst int
+	// Impersonate and ImpersonateGroups are the user and groups the Kubernetes API requests are made as
+	Impersonate       string
+	ImpersonateGroups []string
+	// RequestTimeout is the maximum time of a single Kubernetes API request, no limit when not set
+	RequestTimeout time.Duration
 }
@@ -0,0 +1,122 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package commontest provides the helpers shared by the tests of the plugin packages.
+package commontest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordingLogger is a logger recording the lines logged, which the concurrent operations can log to
+type RecordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// Debugf records the debug line
+func (l *RecordingLogger) Debugf(format string, v ...interface{}) {
+	l.record(format, v...)
+}
+
+// Infof records the info line
+func (l *RecordingLogger) Infof(format string, v ...interface{}) {
+	l.record(format, v...)
+}
+
+// Warnf records the warning line, prefixed as by the default logger
+func (l *RecordingLogger) Warnf(format string, v ...interface{}) {
+	l.record("WARNING: "+format, v...)
+}
+
+func (l *RecordingLogger) record(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// Lines returns the lines logged
+func (l *RecordingLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// Logged returns true if a line logged contains the text
+func (l *RecordingLogger) Logged(text string) bool {
+	for _, line := range l.Lines() {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnv sets the environment variables, unsetting those set to empty, and returns the function
+// restoring them
+func SetEnv(env map[string]string) func() {
+	restore := map[string]*string{}
+	for name, value := range env {
+		if previous, set := os.LookupEnv(name); set {
+			restore[name] = &previous
+		} else {
+			restore[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	return func() {
+		for name, value := range restore {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+// WriteKubeConfig writes a kubeconfig file in the directory with a context of each API server, named
+// after the server index, e.g. "cluster-0", the first one being the current context
+func WriteKubeConfig(t *testing.T, dir string, servers ...string) string {
+	t.Helper()
+	data := "apiVersion: v1\nkind: Config\ncurrent-context: cluster-0\nclusters:\n"
+	for i, server := range servers {
+		data += fmt.Sprintf("- name: cluster-%d\n  cluster:\n    server: %s\n", i, server)
+	}
+	data += "contexts:\n"
+	for i := range servers {
+		data += fmt.Sprintf("- name: cluster-%[1]d\n  context:\n    cluster: cluster-%[1]d\n    user: user-%[1]d\n", i)
+	}
+	data += "users:\n"
+	for i := range servers {
+		data += fmt.Sprintf("- name: user-%[1]d\n  user:\n    token: token-%[1]d\n", i)
+	}
+	file := filepath.Join(dir, "kubeconfig.yaml")
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
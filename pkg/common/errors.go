@@ -0,0 +1,39 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import "errors"
+
+// ErrNothingFound is returned by the operations which found nothing to process, e.g. no Helm v2
+// releases, when they are set to fail on it
+var ErrNothingFound = errors.New("nothing found")
+
+// PartialError is returned by the operations on several releases when some releases were processed
+// and others failed
+type PartialError struct {
+	Succeeded int
+	Failed    int
+	Err       error
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
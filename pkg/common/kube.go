@@ -0,0 +1,206 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	logInClusterOnce  sync.Once
+	logExplicitOnce   sync.Once
+	logRateLimitsOnce sync.Once
+)
+
+// RESTConfig returns the REST config of the cluster as per the API server of the kube config when set,
+// otherwise as per the kubeconfig file and context, or the in-cluster configuration. The client rate
+// limits, impersonation and request timeout of the kube config are applied to it.
+func (kubeConfig KubeConfig) RESTConfig() (*rest.Config, error) {
+	if kubeConfig.InCluster && kubeConfig.Context != "" && !kubeConfig.UsesAPIServer() {
+		return nil, fmt.Errorf("the in-cluster configuration can not be used with the kubeconfig context \"%s\". Unset the kube-context flag or the in-cluster flag", kubeConfig.Context)
+	}
+	var config *rest.Config
+	var err error
+	if kubeConfig.UsesAPIServer() {
+		config, err = kubeConfig.apiServerConfig()
+		if err != nil {
+			return nil, err
+		}
+	} else if kubeConfig.UsesInClusterConfig() {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the in-cluster configuration: %s", err)
+		}
+		logInClusterOnce.Do(func() {
+			Debugf("Using the in-cluster configuration")
+		})
+	} else {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = kubeConfig.File
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeConfig.Context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+	kubeConfig.Apply(config)
+	return config, nil
+}
+
+// UsesAPIServer returns true if the REST config is built from the API server, token and CA bundle of
+// the kube config, in place of the kubeconfig file and the in-cluster configuration
+func (kubeConfig KubeConfig) UsesAPIServer() bool {
+	return kubeConfig.APIServer != ""
+}
+
+// apiServerConfig returns the REST config of the API server, token and CA bundle of the kube config.
+// The certificate of the API server is not verified when set so, which is warned about, once.
+func (kubeConfig KubeConfig) apiServerConfig() (*rest.Config, error) {
+	if kubeConfig.InsecureSkipTLSVerify && kubeConfig.CAFile != "" {
+		return nil, errors.New("the certificate of the API server can not be both verified with a CA bundle and not verified. Unset the kube-ca-file flag or the kube-insecure-skip-tls-verify flag")
+	}
+	if kubeConfig.CAFile != "" {
+		if _, err := os.Stat(kubeConfig.CAFile); err != nil {
+			return nil, fmt.Errorf("CA bundle \"%s\" of the API server failed to be read with error: %s", kubeConfig.CAFile, err)
+		}
+	}
+	logExplicitOnce.Do(func() {
+		Debugf("Using the API server \"%s\" in place of the kubeconfig file", kubeConfig.APIServer)
+		if kubeConfig.InsecureSkipTLSVerify {
+			NewLogger("").Warnf("!!! The certificate of the API server \"%s\" is NOT verified, so that the connection is open to man-in-the-middle attacks and the token can be stolen. Only use the kube-insecure-skip-tls-verify flag with a trusted network, e.g. for testing !!!\n", kubeConfig.APIServer)
+		}
+	})
+	return &rest.Config{
+		Host:        kubeConfig.APIServer,
+		BearerToken: kubeConfig.Token,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:   kubeConfig.CAFile,
+			Insecure: kubeConfig.InsecureSkipTLSVerify,
+		},
+	}, nil
+}
+
+// UsesInClusterConfig returns true if the in-cluster configuration is used: when forced, or when
+// no API server nor kubeconfig file is set, and no kubeconfig file is found in the default locations
+// (KUBECONFIG or ~/.kube/config)
+func (kubeConfig KubeConfig) UsesInClusterConfig() bool {
+	if kubeConfig.UsesAPIServer() {
+		return false
+	}
+	if kubeConfig.InCluster {
+		return true
+	}
+	if kubeConfig.File != "" || kubeConfig.Context != "" {
+		return false
+	}
+	for _, file := range clientcmd.NewDefaultClientConfigLoadingRules().GetLoadingPrecedence() {
+		if _, err := os.Stat(file); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Server returns the address of the API server of the cluster as per the kubeconfig file and context
+func (kubeConfig KubeConfig) Server() (string, error) {
+	config, err := kubeConfig.RESTConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Host, nil
+}
+
+// Apply sets the client rate limits, impersonation and request timeout of the kube config on the REST
+// config, when set. The client-go defaults are kept for the rate limits which are not set.
+func (kubeConfig KubeConfig) Apply(config *rest.Config) {
+	if kubeConfig.Impersonate != "" || len(kubeConfig.ImpersonateGroups) > 0 {
+		config.Impersonate.UserName = kubeConfig.Impersonate
+		config.Impersonate.Groups = kubeConfig.ImpersonateGroups
+	}
+	if kubeConfig.RequestTimeout > 0 {
+		config.Timeout = kubeConfig.RequestTimeout
+	}
+	kubeConfig.applyRateLimits(config)
+}
+
+func (kubeConfig KubeConfig) applyRateLimits(config *rest.Config) {
+	if kubeConfig.QPS > 0 {
+		config.QPS = kubeConfig.QPS
+	}
+	if kubeConfig.Burst > 0 {
+		config.Burst = kubeConfig.Burst
+	}
+	if kubeConfig.QPS <= 0 && kubeConfig.Burst <= 0 {
+		return
+	}
+	// client-go uses its defaults for the rate limits left unset
+	if config.QPS == 0 {
+		config.QPS = rest.DefaultQPS
+	}
+	if config.Burst == 0 {
+		config.Burst = rest.DefaultBurst
+	}
+	logRateLimitsOnce.Do(func() {
+		Debugf("Kubernetes API client rate limits overridden: QPS %v, burst %d", config.QPS, config.Burst)
+	})
+}
+
+// ClientSet returns a clientset for the cluster as per the kubeconfig file and context, or the client
+// of the kube config when set
+func (kubeConfig KubeConfig) ClientSet() (kubernetes.Interface, error) {
+	if kubeConfig.Client != nil {
+		return kubeConfig.Client, nil
+	}
+	config, err := kubeConfig.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// CanI returns true if the user of the kube config is allowed the verb on the resource in the namespace,
+// as per a SelfSubjectAccessReview. The reason given by the authorizer, if any, is returned too.
+func (kubeConfig KubeConfig) CanI(ctx context.Context, verb, resource, namespace string) (bool, string, error) {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return false, "", err
+	}
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Resource:  resource,
+			},
+		},
+	}
+	review, err = clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+)
+
+// TestRESTConfigImpersonation checks that the impersonation of the kube config is set on the REST config
+// of the Helm v2 clients
+func TestRESTConfigImpersonation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, kubeConfig := range []KubeConfig{
+		{File: commontest.WriteKubeConfig(t, dir, "https://cluster.example.com")},
+		{APIServer: "https://api.example.com", Token: "token"},
+	} {
+		kubeConfig.Impersonate = "system:serviceaccount:kube-system:migrator"
+		kubeConfig.ImpersonateGroups = []string{"system:masters", "migrators"}
+		config, err := kubeConfig.RESTConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Impersonate.UserName != kubeConfig.Impersonate || !reflect.DeepEqual(config.Impersonate.Groups, kubeConfig.ImpersonateGroups) {
+			t.Errorf("expected the REST config of %s to impersonate %s %v, got %+v", config.Host, kubeConfig.Impersonate, kubeConfig.ImpersonateGroups, config.Impersonate)
+		}
+		if _, err := kubeConfig.ClientSet(); err != nil {
+			t.Errorf("expected a clientset of the impersonating REST config, got %v", err)
+		}
+	}
+
+	// Nothing is impersonated unless set
+	config, err := KubeConfig{APIServer: "https://api.example.com"}.RESTConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Impersonate.UserName != "" || len(config.Impersonate.Groups) > 0 {
+		t.Errorf("expected no impersonation, got %+v", config.Impersonate)
+	}
+}
+
+func TestRESTConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := commontest.WriteKubeConfig(t, dir, "https://cluster-0.example.com", "https://cluster-1.example.com")
+	// No kubeconfig file in the default locations, and a pod environment with no service account token
+	defer commontest.SetEnv(map[string]string{
+		"KUBECONFIG":              filepath.Join(dir, "missing", "config"),
+		"HOME":                    dir,
+		"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+		"KUBERNETES_SERVICE_PORT": "443",
+	})()
+
+	tests := []struct {
+		name       string
+		kubeConfig KubeConfig
+		host       string
+		inCluster  bool
+		err        string
+	}{
+		{
+			name:       "API server over kubeconfig and in-cluster",
+			kubeConfig: KubeConfig{APIServer: "https://api.example.com", Token: "token", File: file, Context: "cluster-1", InCluster: true},
+			host:       "https://api.example.com",
+		},
+		{
+			name:       "kubeconfig file over in-cluster",
+			kubeConfig: KubeConfig{File: file},
+			host:       "https://cluster-0.example.com",
+		},
+		{
+			name:       "kubeconfig context",
+			kubeConfig: KubeConfig{File: file, Context: "cluster-1"},
+			host:       "https://cluster-1.example.com",
+		},
+		{
+			name:       "in-cluster when no kubeconfig file is found",
+			kubeConfig: KubeConfig{},
+			host:       "https://10.0.0.1:443",
+			inCluster:  true,
+		},
+		{
+			name:       "in-cluster forced over kubeconfig file",
+			kubeConfig: KubeConfig{File: file, InCluster: true},
+			host:       "https://10.0.0.1:443",
+			inCluster:  true,
+		},
+		{
+			name:       "in-cluster forced with a context",
+			kubeConfig: KubeConfig{File: file, Context: "cluster-1", InCluster: true},
+			inCluster:  true,
+			err:        "can not be used with the kubeconfig context",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if inCluster := test.kubeConfig.UsesInClusterConfig(); inCluster != test.inCluster {
+				t.Errorf("expected the in-cluster configuration to be used: %t, got %t", test.inCluster, inCluster)
+			}
+			config, err := test.kubeConfig.RESTConfig()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			// The in-cluster configuration fails out of a pod, as there is no service account token
+			if err != nil && test.inCluster && strings.Contains(err.Error(), "failed to load the in-cluster configuration") {
+				return
+			}
+			if err != nil {
+				t.Fatalf("REST config failed to be built with error: %s", err)
+			}
+			if config.Host != test.host {
+				t.Errorf("expected the API server %s, got %s", test.host, config.Host)
+			}
+		})
+	}
+
+	// The kubeconfig file of the KUBECONFIG environment variable is used when it exists
+	defer commontest.SetEnv(map[string]string{"KUBECONFIG": file})()
+	if (KubeConfig{}).UsesInClusterConfig() {
+		t.Error("expected the kubeconfig file of KUBECONFIG to be used over the in-cluster configuration")
+	}
+	config, err := KubeConfig{Context: "cluster-1"}.RESTConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://cluster-1.example.com" {
+		t.Errorf("expected the API server of the context of the KUBECONFIG file, got %s", config.Host)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+)
+
+// LogLevel is the verbosity of the logs of the plugin
+type LogLevel int
+
+// The log levels, from the least to the most verbose
+const (
+	// LogQuiet logs nothing, the errors being returned by the commands rather than logged
+	LogQuiet LogLevel = iota
+	// LogInfo logs the progress of the commands and their warnings
+	LogInfo
+	// LogDebug also logs the operations on each object, e.g. the Kubernetes API calls retried
+	LogDebug
+)
+
+// logLevel is the level of the loggers of the plugin. It is debug when the HELM_DEBUG environment
+// variable is set, which Helm sets for plugins when run with the --debug flag.
+var logLevel = defaultLogLevel()
+
+func defaultLogLevel() LogLevel {
+	if debug, _ := strconv.ParseBool(os.Getenv("HELM_DEBUG")); debug {
+		return LogDebug
+	}
+	return LogInfo
+}
+
+// SetLogLevel sets the level of the loggers of the plugin. In quiet level, the standard logger is
+// silenced too, as some packages of the plugin still log to it.
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+	if level == LogQuiet {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// Logger logs the progress of the operations of the plugin
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+}
+
+// NewLogger returns the default logger, which logs to the standard logger, i.e. to the standard error,
+// as per the log level. The lines are prefixed with the prefix, if any.
+func NewLogger(prefix string) Logger {
+	return stdLogger{prefix: prefix}
+}
+
+// LoggerOrDefault returns the logger, or the default logger when it is nil, for the options of the
+// operations of the plugin which are logged to the logger of the options, if any
+func LoggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return NewLogger("")
+	}
+	return logger
+}
+
+// stdLogger is the default logger, logging to the standard logger
+type stdLogger struct {
+	prefix string
+}
+
+func (logger stdLogger) Debugf(format string, v ...interface{}) {
+	if logLevel >= LogDebug {
+		log.Output(2, logger.prefix+"[debug] "+fmt.Sprintf(format, v...))
+	}
+}
+
+func (logger stdLogger) Infof(format string, v ...interface{}) {
+	if logLevel >= LogInfo {
+		log.Output(2, logger.prefix+fmt.Sprintf(format, v...))
+	}
+}
+
+func (logger stdLogger) Warnf(format string, v ...interface{}) {
+	if logLevel >= LogInfo {
+		log.Output(2, logger.prefix+"WARNING: "+fmt.Sprintf(format, v...))
+	}
+}
+
+// prefixLogger prefixes the lines of a logger
+type prefixLogger struct {
+	logger Logger
+	prefix string
+}
+
+// WithPrefix returns a logger prefixing the lines of the logger with the prefix
+func WithPrefix(logger Logger, prefix string) Logger {
+	if prefix == "" {
+		return logger
+	}
+	return prefixLogger{logger: logger, prefix: prefix}
+}
+
+func (logger prefixLogger) Debugf(format string, v ...interface{}) {
+	logger.logger.Debugf("%s%s", logger.prefix, fmt.Sprintf(format, v...))
+}
+
+func (logger prefixLogger) Infof(format string, v ...interface{}) {
+	logger.logger.Infof("%s%s", logger.prefix, fmt.Sprintf(format, v...))
+}
+
+func (logger prefixLogger) Warnf(format string, v ...interface{}) {
+	logger.logger.Warnf("%s%s", logger.prefix, fmt.Sprintf(format, v...))
+}
+
+// Debugf logs the message to the standard logger at debug level
+func Debugf(format string, v ...interface{}) {
+	if logLevel < LogDebug {
+		return
+	}
+	log.Output(2, fmt.Sprintf("[debug] "+format, v...))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The output formats of the results of the commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// TablePrinter is a result which renders itself as a table, for the table output format
+type TablePrinter interface {
+	PrintTable(out io.Writer) error
+}
+
+// ValidateOutputFormat checks that the output format is supported
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("output format \"%s\" is not supported. It can be 'table', 'json' or 'yaml'", format)
+}
+
+// PrintOutput writes the result to out in the output format. The result is encoded as is in JSON and
+// YAML, its fields being named by their JSON tags in both. In table format, it is only printed if it
+// is a TablePrinter, the log lines of the command being its human-readable output otherwise.
+func PrintOutput(out io.Writer, format string, result interface{}) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case OutputYAML:
+		data, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	if printer, ok := result.(TablePrinter); ok {
+		return printer.PrintTable(out)
+	}
+	return nil
+}
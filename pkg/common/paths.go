@@ -0,0 +1,150 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// The kinds of Helm v3 directories
+const (
+	HelmConfigDir = "config"
+	HelmDataDir   = "data"
+	HelmCacheDir  = "cache"
+)
+
+// windowsMaxPath is the length over which the Windows paths need the extended-length prefix
+const windowsMaxPath = 260
+
+// HelmDir returns the Helm v3 directory of the kind as the Helm v3 helmpath package resolves it on the
+// platform: the HELM_CONFIG_HOME, HELM_DATA_HOME or HELM_CACHE_HOME environment variable as is when set,
+// otherwise the 'helm' folder of the XDG base directory, the XDG environment variable taking precedence
+// over the default of the platform. The platform and environment are passed in, so that the directories
+// of all platforms can be resolved from any of them.
+func HelmDir(kind, goos string, getenv func(string) string, home string) string {
+	helmEnv, xdgEnv := "", ""
+	switch kind {
+	case HelmConfigDir:
+		helmEnv, xdgEnv = "HELM_CONFIG_HOME", "XDG_CONFIG_HOME"
+	case HelmDataDir:
+		helmEnv, xdgEnv = "HELM_DATA_HOME", "XDG_DATA_HOME"
+	default:
+		helmEnv, xdgEnv = "HELM_CACHE_HOME", "XDG_CACHE_HOME"
+	}
+	if dir := getenv(helmEnv); dir != "" {
+		return dir
+	}
+	base := getenv(xdgEnv)
+	if base == "" {
+		base = platformBaseDir(kind, goos, getenv, home)
+	}
+	return joinPath(goos, base, "helm")
+}
+
+// platformBaseDir returns the default base directory of the kind on the platform, as per Helm v3:
+// '%APPDATA%' and '%TEMP%' on Windows, '~/Library' on macOS, and the XDG defaults otherwise. On
+// Windows, '%LOCALAPPDATA%' and the profile folders are fallen back to when the variables are not set.
+func platformBaseDir(kind, goos string, getenv func(string) string, home string) string {
+	switch goos {
+	case "windows":
+		if kind == HelmCacheDir {
+			if dir := getenv("TEMP"); dir != "" {
+				return dir
+			}
+			if dir := getenv("LOCALAPPDATA"); dir != "" {
+				return joinPath(goos, dir, "Temp")
+			}
+			return joinPath(goos, home, "AppData", "Local", "Temp")
+		}
+		if dir := getenv("APPDATA"); dir != "" {
+			return dir
+		}
+		if dir := getenv("LOCALAPPDATA"); dir != "" {
+			return dir
+		}
+		return joinPath(goos, home, "AppData", "Roaming")
+	case "darwin":
+		switch kind {
+		case HelmConfigDir:
+			return joinPath(goos, home, "Library", "Preferences")
+		case HelmDataDir:
+			return joinPath(goos, home, "Library")
+		default:
+			return joinPath(goos, home, "Library", "Caches")
+		}
+	}
+	switch kind {
+	case HelmConfigDir:
+		return joinPath(goos, home, ".config")
+	case HelmDataDir:
+		return joinPath(goos, home, ".local", "share")
+	default:
+		return joinPath(goos, home, ".cache")
+	}
+}
+
+// InDir returns true if the path is the directory or is inside it. The paths are compared case
+// insensitively on Windows, as its file systems are.
+func InDir(dir, path, goos string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if goos == "windows" {
+		dir, path = strings.ToLower(dir), strings.ToLower(path)
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// LongPath returns the path to pass to the file system operations: on Windows, an absolute path over
+// the maximum length gets the extended-length prefix, so that the deep folders of plugins and caches
+// can be copied and removed. The path is returned as is otherwise.
+func LongPath(path, goos string) string {
+	if goos != "windows" || len(path) < windowsMaxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + strings.TrimPrefix(path, `\\`)
+	}
+	// Only the paths of a drive are absolute, not the ones relative to the current drive, e.g. '\foo'
+	if len(path) < 3 || path[1] != ':' || (path[2] != '\\' && path[2] != '/') {
+		return path
+	}
+	return `\\?\` + path
+}
+
+// joinPath joins the path elements with the separator of the platform
+func joinPath(goos string, elem ...string) string {
+	sep := "/"
+	if goos == "windows" {
+		sep = `\`
+	}
+	path := elem[0]
+	for _, e := range elem[1:] {
+		if path != "" && !strings.HasSuffix(path, sep) {
+			path += sep
+		}
+		path += e
+	}
+	return path
+}
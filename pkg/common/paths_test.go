@@ -0,0 +1,188 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// getenvOf returns the function getting the environment variables of the map
+func getenvOf(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+// platformHomes are the home folders the paths of each platform are resolved with
+var platformHomes = map[string]string{
+	"linux":   "/home/user",
+	"darwin":  "/Users/user",
+	"windows": `C:\Users\user`,
+}
+
+func TestHelmDir(t *testing.T) {
+	windowsEnv := map[string]string{
+		"APPDATA":      `C:\Users\user\AppData\Roaming`,
+		"LOCALAPPDATA": `C:\Users\user\AppData\Local`,
+		"TEMP":         `C:\Users\user\AppData\Local\Temp`,
+	}
+	tests := []struct {
+		goos     string
+		kind     string
+		env      map[string]string
+		expected string
+	}{
+		{"linux", HelmConfigDir, nil, "/home/user/.config/helm"},
+		{"linux", HelmDataDir, nil, "/home/user/.local/share/helm"},
+		{"linux", HelmCacheDir, nil, "/home/user/.cache/helm"},
+		{"linux", HelmConfigDir, map[string]string{"XDG_CONFIG_HOME": "/xdg/config"}, "/xdg/config/helm"},
+		{"linux", HelmDataDir, map[string]string{"XDG_DATA_HOME": "/xdg/data"}, "/xdg/data/helm"},
+		{"linux", HelmCacheDir, map[string]string{"XDG_CACHE_HOME": "/xdg/cache"}, "/xdg/cache/helm"},
+		{"linux", HelmConfigDir, map[string]string{"HELM_CONFIG_HOME": "/helm/config", "XDG_CONFIG_HOME": "/xdg/config"}, "/helm/config"},
+		{"linux", HelmDataDir, map[string]string{"HELM_DATA_HOME": "/helm/data", "XDG_DATA_HOME": "/xdg/data"}, "/helm/data"},
+		{"linux", HelmCacheDir, map[string]string{"HELM_CACHE_HOME": "/helm/cache", "XDG_CACHE_HOME": "/xdg/cache"}, "/helm/cache"},
+		{"darwin", HelmConfigDir, nil, "/Users/user/Library/Preferences/helm"},
+		{"darwin", HelmDataDir, nil, "/Users/user/Library/helm"},
+		{"darwin", HelmCacheDir, nil, "/Users/user/Library/Caches/helm"},
+		{"darwin", HelmConfigDir, map[string]string{"XDG_CONFIG_HOME": "/xdg/config"}, "/xdg/config/helm"},
+		{"darwin", HelmDataDir, map[string]string{"HELM_DATA_HOME": "/helm/data"}, "/helm/data"},
+		{"darwin", HelmCacheDir, map[string]string{"XDG_CACHE_HOME": "/xdg/cache", "HELM_CACHE_HOME": "/helm/cache"}, "/helm/cache"},
+		{"windows", HelmConfigDir, windowsEnv, `C:\Users\user\AppData\Roaming\helm`},
+		{"windows", HelmDataDir, windowsEnv, `C:\Users\user\AppData\Roaming\helm`},
+		{"windows", HelmCacheDir, windowsEnv, `C:\Users\user\AppData\Local\Temp\helm`},
+		{"windows", HelmConfigDir, map[string]string{"APPDATA": `C:\Users\user\AppData\Roaming`, "XDG_CONFIG_HOME": `D:\xdg\config`}, `D:\xdg\config\helm`},
+		{"windows", HelmDataDir, map[string]string{"APPDATA": `C:\Users\user\AppData\Roaming`, "HELM_DATA_HOME": `D:\helm\data`}, `D:\helm\data`},
+		{"windows", HelmCacheDir, map[string]string{"TEMP": `C:\Temp`, "HELM_CACHE_HOME": `D:\helm\cache`}, `D:\helm\cache`},
+	}
+	for _, test := range tests {
+		t.Run(test.goos+"/"+test.kind, func(t *testing.T) {
+			dir := HelmDir(test.kind, test.goos, getenvOf(test.env), platformHomes[test.goos])
+			if dir != test.expected {
+				t.Errorf("expected %q, got %q with environment %v", test.expected, dir, test.env)
+			}
+		})
+	}
+}
+
+func TestHelmDirWindowsFallbacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		env      map[string]string
+		expected string
+	}{
+		{"config from local app data", HelmConfigDir, map[string]string{"LOCALAPPDATA": `C:\Users\user\AppData\Local`}, `C:\Users\user\AppData\Local\helm`},
+		{"config from profile", HelmConfigDir, nil, `C:\Users\user\AppData\Roaming\helm`},
+		{"data from profile", HelmDataDir, nil, `C:\Users\user\AppData\Roaming\helm`},
+		{"cache from local app data", HelmCacheDir, map[string]string{"LOCALAPPDATA": `D:\Local`}, `D:\Local\Temp\helm`},
+		{"cache from profile", HelmCacheDir, map[string]string{"APPDATA": `C:\Users\user\AppData\Roaming`}, `C:\Users\user\AppData\Local\Temp\helm`},
+		{"base with trailing separator", HelmConfigDir, map[string]string{"APPDATA": `D:\`}, `D:\helm`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := HelmDir(test.kind, "windows", getenvOf(test.env), platformHomes["windows"])
+			if dir != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, dir)
+			}
+		})
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	longDrivePath := `C:\Users\user\AppData\Roaming\helm\` + strings.Repeat(`plugins\`, 40) + "plugin.yaml"
+	longUNCPath := `\\server\share\helm\` + strings.Repeat(`cache\`, 50) + "index.yaml"
+	longRelativePath := strings.Repeat(`plugins\`, 40) + "plugin.yaml"
+	longRootPath := `\` + strings.Repeat(`plugins\`, 40) + "plugin.yaml"
+	longUnixPath := "/home/user/.helm/" + strings.Repeat("plugins/", 40) + "plugin.yaml"
+	tests := []struct {
+		name     string
+		goos     string
+		path     string
+		expected string
+	}{
+		{"short windows path", "windows", `C:\Users\user\.helm`, `C:\Users\user\.helm`},
+		{"long drive path", "windows", longDrivePath, `\\?\` + longDrivePath},
+		{"long drive path with slashes", "windows", strings.ReplaceAll(longDrivePath, `\`, "/"), `\\?\` + strings.ReplaceAll(longDrivePath, `\`, "/")},
+		{"long UNC path", "windows", longUNCPath, `\\?\UNC\` + strings.TrimPrefix(longUNCPath, `\\`)},
+		{"long extended-length path", "windows", `\\?\` + longDrivePath, `\\?\` + longDrivePath},
+		{"long relative path", "windows", longRelativePath, longRelativePath},
+		{"long path of the current drive", "windows", longRootPath, longRootPath},
+		{"long unix path on linux", "linux", longUnixPath, longUnixPath},
+		{"long drive path on darwin", "darwin", longDrivePath, longDrivePath},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if path := LongPath(test.path, test.goos); path != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, path)
+			}
+		})
+	}
+}
+
+func TestInDir(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "home", "user")
+	tests := []struct {
+		name     string
+		goos     string
+		dir      string
+		path     string
+		expected bool
+	}{
+		{"same directory", "linux", filepath.Join(root, ".helm"), filepath.Join(root, ".helm"), true},
+		{"sub path", "linux", filepath.Join(root, ".helm"), filepath.Join(root, ".helm", "plugins"), true},
+		{"sibling with the same prefix", "linux", filepath.Join(root, ".helm"), filepath.Join(root, ".helm-backup"), false},
+		{"parent", "linux", filepath.Join(root, ".helm"), root, false},
+		{"sub path not cleaned", "linux", filepath.Join(root, ".helm"), root + "/.helm/plugins/../../.kube", false},
+		{"sub path of another case on linux", "linux", filepath.Join(root, ".Helm"), filepath.Join(root, ".helm", "plugins"), false},
+		{"sub path of another case on darwin", "darwin", filepath.Join(root, ".Helm"), filepath.Join(root, ".helm", "plugins"), false},
+		{"sub path of another case on windows", "windows", filepath.Join(root, ".Helm"), filepath.Join(root, ".helm", "plugins"), true},
+		{"sibling of another case on windows", "windows", filepath.Join(root, ".Helm"), filepath.Join(root, ".kube"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && test.goos != "windows" && !test.expected {
+				t.Skip("the paths are compared case insensitively by the file system of windows")
+			}
+			if inDir := InDir(test.dir, test.path, test.goos); inDir != test.expected {
+				t.Errorf("expected InDir(%q, %q) to be %t on %s", test.dir, test.path, test.expected, test.goos)
+			}
+		})
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		goos     string
+		elem     []string
+		expected string
+	}{
+		{"linux", []string{"/home/user", ".config", "helm"}, "/home/user/.config/helm"},
+		{"linux", []string{"/", "helm"}, "/helm"},
+		{"darwin", []string{"/Users/user/", "Library", "helm"}, "/Users/user/Library/helm"},
+		{"windows", []string{`C:\Users\user`, "AppData", "Roaming"}, `C:\Users\user\AppData\Roaming`},
+		{"windows", []string{`C:\`, "helm"}, `C:\helm`},
+		{"windows", []string{"", "helm"}, "helm"},
+	}
+	for _, test := range tests {
+		if path := joinPath(test.goos, test.elem...); path != test.expected {
+			t.Errorf("expected %q on %s, got %q", test.expected, test.goos, path)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+// Progress is notified of the progress of a bulk operation, e.g. the deletion of all release versions,
+// as each of its items is processed: the item, e.g. a release name, its index from 1, and the total of
+// items. It is notified by the goroutine which processed the item, so it needs to be safe for concurrent
+// use when the items are processed concurrently.
+type Progress interface {
+	Update(item string, index, total int)
+}
+
+// ProgressFunc is a function notified of the progress of a bulk operation, as a Progress
+type ProgressFunc func(item string, index, total int)
+
+// Update calls the function
+func (f ProgressFunc) Update(item string, index, total int) {
+	f(item, index, total)
+}
+
+// UpdateProgress notifies the progress of a bulk operation, if any
+func UpdateProgress(progress Progress, item string, index, total int) {
+	if progress != nil {
+		progress.Update(item, index, total)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// maxRetryDelay caps the delay between retries
+const maxRetryDelay = 30 * time.Second
+
+// RetryOptions are the options for retrying Kubernetes API calls which fail with a transient error
+type RetryOptions struct {
+	// Backoff is the delay before the first retry. It is doubled for each retry after it.
+	Backoff time.Duration
+	// Retries is the maximum number of retries of a call
+	Retries int
+}
+
+type retryOptionsKey struct{}
+
+// WithRetryOptions returns a copy of the context carrying the retry options used by Retry
+func WithRetryOptions(ctx context.Context, retryOptions RetryOptions) context.Context {
+	return context.WithValue(ctx, retryOptionsKey{}, retryOptions)
+}
+
+// DetachedContext returns a context carrying the retry options of the context, which is not cancelled
+// with it, for the operations which have to complete when the context is cancelled, e.g. rollbacks
+func DetachedContext(ctx context.Context) context.Context {
+	retryOptions, _ := ctx.Value(retryOptionsKey{}).(RetryOptions)
+	return WithRetryOptions(context.Background(), retryOptions)
+}
+
+// Retry calls fn until it succeeds or fails with an error which is not retriable, as per the retry
+// options carried by the context. The delay between retries grows exponentially, with jitter.
+// When the retries are exhausted, the last error of the operation is returned.
+func Retry(ctx context.Context, operation string, fn func() error) error {
+	retryOptions, _ := ctx.Value(retryOptionsKey{}).(RetryOptions)
+	for retry := 0; ; retry++ {
+		Debugf("%s", operation)
+		err := fn()
+		if err == nil || !IsRetriable(err) {
+			return err
+		}
+		if retry >= retryOptions.Retries {
+			if retryOptions.Retries == 0 {
+				return err
+			}
+			return fmt.Errorf("%s failed after %d retries: %w", operation, retryOptions.Retries, err)
+		}
+
+		delay := retryDelay(retryOptions.Backoff, retry)
+		Debugf("%s failed with error: %s. Retry %d of %d in %s", operation, err, retry+1, retryOptions.Retries, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// IsRetriable returns true if the error, or an error it wraps, is a transient Kubernetes API error:
+// throttling, a conflict, or a server timeout
+func IsRetriable(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if apierrors.IsTooManyRequests(err) || apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryDelay(backoff time.Duration, retry int) time.Duration {
+	delay := backoff
+	for i := 0; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Add up to 50% of jitter, so that concurrent calls do not retry at the same time
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
@@ -0,0 +1,194 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics recorded by the commands
+const (
+	// ReleasesConverted counts the releases converted, or which would be in dry-run
+	ReleasesConverted = "releases_converted_total"
+	// ReleasesFailed counts the releases which failed to be converted or cleaned up
+	ReleasesFailed = "releases_failed_total"
+	// VersionsDeleted counts the Helm v2 release versions deleted, none being deleted in dry-run
+	VersionsDeleted = "versions_deleted_total"
+	// Duration is the time the command has been running for, set when the metrics are written
+	Duration = "duration_seconds"
+)
+
+// pushJob is the job of the metrics pushed to a Pushgateway
+const pushJob = "helm_2to3"
+
+// metricHelp are the descriptions of the metrics, written with them
+var metricHelp = map[string]string{
+	ReleasesConverted: "Number of Helm v2 releases converted to Helm v3.",
+	ReleasesFailed:    "Number of Helm v2 releases which failed to be processed.",
+	VersionsDeleted:   "Number of Helm v2 release versions deleted.",
+	Duration:          "Time the command has been running for, in seconds.",
+}
+
+// Recorder records the counters of a command as it processes the releases. It needs to be safe for
+// concurrent use, as the releases can be processed concurrently.
+type Recorder interface {
+	Add(name string, value float64)
+}
+
+// Add adds the value to the counter of the name of the recorder, if any
+func Add(recorder Recorder, name string, value float64) {
+	if recorder != nil {
+		recorder.Add(name, value)
+	}
+}
+
+// Registry records the counters of a command, and writes them in the Prometheus text format, labelled
+// with the command. A nil registry records nothing, so that the commands record their counters whether
+// the metrics are enabled or not.
+type Registry struct {
+	command string
+	started time.Time
+	mutex   sync.Mutex
+	values  map[string]float64
+}
+
+// NewRegistry returns the registry of the counters of the command, started now. The counters start
+// at 0, so that they are written also when nothing is recorded.
+func NewRegistry(command string) *Registry {
+	return &Registry{
+		command: command,
+		started: time.Now(),
+		values: map[string]float64{
+			ReleasesConverted: 0,
+			ReleasesFailed:    0,
+			VersionsDeleted:   0,
+		},
+	}
+}
+
+// Add adds the value to the counter of the name
+func (registry *Registry) Add(name string, value float64) {
+	if registry == nil {
+		return
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.values[name] += value
+}
+
+// Value returns the value of the counter of the name, 0 if it was not recorded
+func (registry *Registry) Value(name string) float64 {
+	if registry == nil {
+		return 0
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.values[name]
+}
+
+// Write writes the counters, and the duration of the command so far, in the Prometheus text format
+func (registry *Registry) Write(out io.Writer) error {
+	registry.mutex.Lock()
+	values := map[string]float64{Duration: time.Since(registry.started).Seconds()}
+	for name, value := range registry.values {
+		values[name] = value
+	}
+	registry.mutex.Unlock()
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metricType := "counter"
+		if !strings.HasSuffix(name, "_total") {
+			metricType = "gauge"
+		}
+		if help, ok := metricHelp[name]; ok {
+			if _, err := fmt.Fprintf(out, "# HELP %s %s\n", name, help); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(out, "# TYPE %s %s\n%s{command=%q} %g\n", name, metricType, name, registry.command, values[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the metrics to the file, for the textfile collector of the node exporter. The file
+// is replaced at once, so that the collector never reads it half written.
+func (registry *Registry) WriteFile(file string) error {
+	var data bytes.Buffer
+	if err := registry.Write(&data); err != nil {
+		return err
+	}
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("metrics file \"%s\" failed to be written with error: %s", file, err)
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		return fmt.Errorf("metrics file \"%s\" failed to be written with error: %s", file, err)
+	}
+	return nil
+}
+
+// Push pushes the metrics to the Pushgateway of the URL, e.g. 'http://pushgateway:9091', grouped by
+// the job and the command. The metrics of the previous run of the command are replaced.
+func (registry *Registry) Push(ctx context.Context, pushURL string) error {
+	var data bytes.Buffer
+	if err := registry.Write(&data); err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(pushURL, "/") + "/metrics/job/" + pushJob + "/command/" + url.PathEscape(registry.command)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &data)
+	if err != nil {
+		return fmt.Errorf("metrics failed to be pushed to \"%s\" with error: %s", pushURL, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("metrics failed to be pushed to \"%s\" with error: %s", pushURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("metrics failed to be pushed to \"%s\": %s %s", pushURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// ValidatePushURL checks that the URL of the Pushgateway is an absolute HTTP or HTTPS URL
+func ValidatePushURL(pushURL string) error {
+	parsed, err := url.Parse(pushURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("metrics push URL \"%s\" is not valid. Set it to the HTTP or HTTPS URL of the Pushgateway, e.g. 'http://pushgateway:9091'", pushURL)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// durationValue matches the value of the duration, which depends on when the metrics are written
+var durationValue = regexp.MustCompile(`(duration_seconds\{command="[a-z]+"\}) .*`)
+
+func TestRegistryWrite(t *testing.T) {
+	registry := NewRegistry("convert")
+	registry.Add(ReleasesConverted, 2)
+	registry.Add(ReleasesConverted, 1)
+	registry.Add(VersionsDeleted, 7)
+
+	var out bytes.Buffer
+	if err := registry.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP duration_seconds Time the command has been running for, in seconds.
+# TYPE duration_seconds gauge
+duration_seconds{command="convert"} <duration>
+# HELP releases_converted_total Number of Helm v2 releases converted to Helm v3.
+# TYPE releases_converted_total counter
+releases_converted_total{command="convert"} 3
+# HELP releases_failed_total Number of Helm v2 releases which failed to be processed.
+# TYPE releases_failed_total counter
+releases_failed_total{command="convert"} 0
+# HELP versions_deleted_total Number of Helm v2 release versions deleted.
+# TYPE versions_deleted_total counter
+versions_deleted_total{command="convert"} 7
+`
+	if actual := durationValue.ReplaceAllString(out.String(), "$1 <duration>"); actual != expected {
+		t.Errorf("expected the metrics:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestRegistryValue(t *testing.T) {
+	registry := NewRegistry("cleanup")
+	Add(registry, VersionsDeleted, 3)
+	Add(registry, VersionsDeleted, 2)
+	if value := registry.Value(VersionsDeleted); value != 5 {
+		t.Errorf("expected 5 versions deleted, got %g", value)
+	}
+	if value := registry.Value(ReleasesFailed); value != 0 {
+		t.Errorf("expected no releases failed, got %g", value)
+	}
+
+	// A nil registry records nothing, and a nil recorder is ignored
+	var disabled *Registry
+	Add(disabled, VersionsDeleted, 1)
+	if value := disabled.Value(VersionsDeleted); value != 0 {
+		t.Errorf("expected a nil registry to record nothing, got %g", value)
+	}
+	Add(nil, VersionsDeleted, 1)
+}
+
+func TestRegistryWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "helm_2to3.prom")
+
+	registry := NewRegistry("cleanup")
+	registry.Add(VersionsDeleted, 4)
+	if err := registry.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`versions_deleted_total{command="cleanup"} 4`)) {
+		t.Errorf("expected the versions deleted in the metrics file, got:\n%s", data)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be renamed, got %v", err)
+	}
+}
+
+func TestRegistryPush(t *testing.T) {
+	var method, path, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	registry := NewRegistry("convert")
+	registry.Add(ReleasesFailed, 1)
+	if err := registry.Push(context.Background(), server.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/helm_2to3/command/convert" {
+		t.Errorf("expected the metrics to be put to the group of the job and command, got %s %s", method, path)
+	}
+	if contentType != "text/plain; version=0.0.4" {
+		t.Errorf("expected the Prometheus text format, got %s", contentType)
+	}
+	if !bytes.Contains(body, []byte(`releases_failed_total{command="convert"} 1`)) {
+		t.Errorf("expected the releases failed in the metrics pushed, got:\n%s", body)
+	}
+}
+
+func TestRegistryPushFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "push rejected", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := NewRegistry("convert").Push(context.Background(), server.URL); err == nil {
+		t.Error("expected a rejected push to fail")
+	}
+}
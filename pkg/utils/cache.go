@@ -0,0 +1,157 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2v3
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// cacheProgressThreshold is the size of the chart cache over which the progress of its copy is reported
+const cacheProgressThreshold = 100 * 1024 * 1024
+
+// cacheFile is a file of the Helm v2 chart cache
+type cacheFile struct {
+	path string
+	size int64
+}
+
+// copyChartCache copies the Helm v2 chart cache, i.e. the cached repository indexes and chart
+// archives, to the repository cache of Helm v3. Its total size is logged up front, and the progress
+// of the copy is reported in MiB when it is over the threshold. The files are copied to a temporary
+// directory first, and only renamed into the Helm v3 cache once all are copied, so that a copy which
+// fails doesn't leave the Helm v3 cache half-populated. The Helm v2 cache directories copied are
+// returned.
+func copyChartCache(v2HomeDir, v3CacheDir string, moveOptions MoveOptions) ([]string, error) {
+	logger := moveOptions.logger()
+	sources := []string{}
+	files := map[string]cacheFile{}
+	var total int64
+	for _, dir := range []string{filepath.Join(v2HomeDir, "repository", "cache"), filepath.Join(v2HomeDir, "cache", "archive")} {
+		infos, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read [Helm 2] chart cache \"%s\" due to the following error: %s", dir, err)
+		}
+		sources = append(sources, dir)
+		for _, info := range infos {
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			files[info.Name()] = cacheFile{path: filepath.Join(dir, info.Name()), size: info.Size()}
+			total += info.Size()
+		}
+	}
+	v3RepoCache := filepath.Join(v3CacheDir, "repository")
+	if len(files) == 0 {
+		logger.Infof("[Helm 2] no chart cache found to copy.\n")
+		return sources, nil
+	}
+	logger.Infof("[Helm 2] chart cache (%d files, %.2fMiB) will copy to [Helm 3] cache folder \"%s\" .\n", len(files), float64(total)/(1024*1024), v3RepoCache)
+	if moveOptions.DryRun {
+		return sources, nil
+	}
+
+	if err := ensureDir(v3CacheDir); err != nil {
+		return nil, fmt.Errorf("[Helm 3] Failed to create cache folder \"%s\" due to the following error: %s", v3CacheDir, err)
+	}
+	tmpDir, err := ioutil.TempDir(v3CacheDir, ".repository-2to3-")
+	if err != nil {
+		return nil, fmt.Errorf("[Helm 3] Failed to create a temporary folder in \"%s\" due to the following error: %s", v3CacheDir, err)
+	}
+	// Nothing is left once the files are renamed into the cache, only the files of a failed copy
+	defer os.RemoveAll(tmpDir)
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var copied int64
+	progress := moveOptions.CacheProgress
+	if total <= cacheProgressThreshold {
+		progress = nil
+	}
+	for _, name := range names {
+		err := copyFileWithProgress(files[name].path, filepath.Join(tmpDir, name), func(n int64) {
+			copied += n
+			common.UpdateProgress(progress, name, int(copied/(1024*1024)), int(total/(1024*1024)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to copy [Helm 2] chart cache file \"%s\" due to the following error: %s", files[name].path, err)
+		}
+	}
+
+	if exists, _ := pathExists(v3RepoCache); !exists {
+		if err := os.Rename(tmpDir, v3RepoCache); err != nil {
+			return nil, fmt.Errorf("[Helm 3] Failed to create cache folder \"%s\" due to the following error: %s", v3RepoCache, err)
+		}
+	} else {
+		for _, name := range names {
+			if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(v3RepoCache, name)); err != nil {
+				return nil, fmt.Errorf("[Helm 3] Failed to write cache file \"%s\" due to the following error: %s", filepath.Join(v3RepoCache, name), err)
+			}
+		}
+	}
+	logger.Infof("[Helm 2] chart cache copied successfully to [Helm 3] cache folder \"%s\" .\n", v3RepoCache)
+	return sources, nil
+}
+
+// copyFileWithProgress copies the file, preserving its mode, calling the function with the number of
+// bytes of each chunk copied
+func copyFileWithProgress(srcFileName, destFileName string, copied func(n int64)) error {
+	src, err := os.Open(srcFileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dest, err := os.OpenFile(destFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 1024*1024)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dest.Write(buf[:n]); err != nil {
+				dest.Close()
+				return err
+			}
+			copied(int64(n))
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			dest.Close()
+			return readErr
+		}
+	}
+	return dest.Close()
+}
@@ -0,0 +1,121 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2v3
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// mergeRepositoryFiles merges the repositories of the Helm v2 repository file into the Helm v3 one.
+// The Helm v2 file is copied as is when there is no Helm v3 file. On a name collision, the Helm v3
+// repository is kept, unless preferring Helm v2, each decision being logged. The cached index of a
+// Helm v3 repository replaced by one of another URL is removed, so that 'helm repo update' regenerates
+// it. The Helm v3 file is backed up before being merged into when set, with a timestamp suffix.
+func mergeRepositoryFiles(v2RepoConfig, v3RepoConfig, v3CacheDir string, moveOptions MoveOptions) error {
+	logger := moveOptions.logger()
+	exists, err := pathExists(v3RepoConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to check [Helm 3] repository file \"%s\" due to the following error: %s", v3RepoConfig, err)
+	}
+	if !exists {
+		logger.Infof("[Helm 2] repositories file \"%s\" will copy to [Helm 3] config folder \"%s\" .\n", v2RepoConfig, v3RepoConfig)
+		if !moveOptions.DryRun {
+			err = copyFile(v2RepoConfig, v3RepoConfig)
+			if err != nil {
+				return fmt.Errorf("Failed to copy [Helm 2] repository file \"%s\" due to the following error: %s", v2RepoConfig, err)
+			}
+			logger.Infof("[Helm 2] repositories file \"%s\" copied successfully to [Helm 3] config folder \"%s\" .\n", v2RepoConfig, v3RepoConfig)
+		}
+		return nil
+	}
+
+	v2File, err := repo.LoadFile(v2RepoConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to read [Helm 2] repository file \"%s\" due to the following error: %s", v2RepoConfig, err)
+	}
+	v3File, err := repo.LoadFile(v3RepoConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to read [Helm 3] repository file \"%s\" due to the following error: %s", v3RepoConfig, err)
+	}
+	logger.Infof("[Helm 2] repositories file \"%s\" will be merged into [Helm 3] repositories file \"%s\" .\n", v2RepoConfig, v3RepoConfig)
+
+	staleCaches := []string{}
+	for _, v2Entry := range v2File.Repositories {
+		v3Entry := v3File.Get(v2Entry.Name)
+		switch {
+		case v3Entry == nil:
+			logger.Infof("[Helm 2] repository \"%s\" (%s) will be added to [Helm 3] repositories.\n", v2Entry.Name, v2Entry.URL)
+		case v3Entry.URL == v2Entry.URL && !moveOptions.PreferV2:
+			logger.Infof("[Helm 2] repository \"%s\" already exists in [Helm 3] repositories with the same URL, the [Helm 3] repository is kept.\n", v2Entry.Name)
+			continue
+		case !moveOptions.PreferV2:
+			logger.Infof("[Helm 2] repository \"%s\" (%s) already exists in [Helm 3] repositories as %s, the [Helm 3] repository is kept.\n", v2Entry.Name, v2Entry.URL, v3Entry.URL)
+			continue
+		default:
+			logger.Infof("[Helm 2] repository \"%s\" (%s) will replace [Helm 3] repository %s.\n", v2Entry.Name, v2Entry.URL, v3Entry.URL)
+			if v3Entry.URL != v2Entry.URL {
+				staleCaches = append(staleCaches, v2Entry.Name)
+			}
+		}
+		// The Helm v2 repositories also hold the path of their cached index, which Helm v3 doesn't use
+		v3File.Update(&repo.Entry{
+			Name:                  v2Entry.Name,
+			URL:                   v2Entry.URL,
+			Username:              v2Entry.Username,
+			Password:              v2Entry.Password,
+			CertFile:              v2Entry.CertFile,
+			KeyFile:               v2Entry.KeyFile,
+			CAFile:                v2Entry.CAFile,
+			InsecureSkipTLSverify: v2Entry.InsecureSkipTLSverify,
+		})
+	}
+
+	backupFile := ""
+	if moveOptions.Backup {
+		backupFile = fmt.Sprintf("%s.%s", v3RepoConfig, time.Now().Format("20060102150405"))
+		logger.Infof("[Helm 3] repositories file \"%s\" will be backed up to \"%s\" .\n", v3RepoConfig, backupFile)
+	}
+	if moveOptions.DryRun {
+		return nil
+	}
+	if backupFile != "" {
+		if err := copyFile(v3RepoConfig, backupFile); err != nil {
+			return fmt.Errorf("Failed to back up [Helm 3] repository file \"%s\" due to the following error: %s", v3RepoConfig, err)
+		}
+		logger.Infof("[Helm 3] repositories file \"%s\" backed up to \"%s\" .\n", v3RepoConfig, backupFile)
+	}
+	if err := v3File.WriteFile(v3RepoConfig, 0644); err != nil {
+		return fmt.Errorf("Failed to write [Helm 3] repository file \"%s\" due to the following error: %s", v3RepoConfig, err)
+	}
+	logger.Infof("[Helm 2] repositories file \"%s\" merged successfully into [Helm 3] repositories file \"%s\" .\n", v2RepoConfig, v3RepoConfig)
+
+	for _, name := range staleCaches {
+		for _, cacheFile := range []string{name + "-index.yaml", name + "-charts.txt"} {
+			path := filepath.Join(v3CacheDir, "repository", cacheFile)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Failed to remove [Helm 3] repository cache \"%s\" due to the following error: %s", path, err)
+			}
+		}
+		logger.Infof("[Helm 3] cache of repository \"%s\" removed, to be regenerated by 'helm repo update'.\n", name)
+	}
+	return nil
+}
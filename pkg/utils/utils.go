@@ -19,135 +19,478 @@ package v2v3
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	common "github.com/helm/helm-2to3/pkg/common"
 	v2 "github.com/helm/helm-2to3/pkg/v2"
 	v3 "github.com/helm/helm-2to3/pkg/v3"
 )
 
+// The scopes of the Helm v2 configuration moved to Helm v3: all of it, or some of its components
+const (
+	MoveScopeAll          = "all"
+	MoveScopePlugins      = "plugins"
+	MoveScopeRepositories = "repositories"
+	MoveScopeStarters     = "starters"
+)
+
+// v2OnlyPluginMarkers are the settings and environment variables of Helm v2 plugins which Helm v3
+// doesn't provide, a plugin referencing them in its plugin.yaml being likely to need a Helm v3 version
+var v2OnlyPluginMarkers = []string{"useTunnel", "HELM_HOME", "HELM_HOST", "TILLER_"}
+
+// ValidateMoveScopes checks that the scopes of the configuration moved are supported
+func ValidateMoveScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("scope needs to be set. It can be 'repositories', 'plugins', 'starters' or 'all'")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case MoveScopeAll, MoveScopePlugins, MoveScopeRepositories, MoveScopeStarters:
+		default:
+			return fmt.Errorf("scope \"%s\" is not supported. It can be 'repositories', 'plugins', 'starters' or 'all'", scope)
+		}
+	}
+	return nil
+}
+
+// MoveOptions are the options of the move of the Helm v2 configuration to Helm v3
+type MoveOptions struct {
+	// Backup backs up the Helm v3 repository file before merging the Helm v2 repositories into it
+	Backup bool
+	// CacheProgress reports the MiB of the chart cache copied, when it is large. It is not reported when nil.
+	CacheProgress common.Progress
+	DryRun        bool
+	// Logger logs the components moved, the default logger when not set
+	Logger common.Logger
+	// Move removes the Helm v2 components once copied to Helm v3. By default, the Helm v2 configuration
+	// is left intact, so that Helm v2 and v3 can be used side by side.
+	Move bool
+	// PreferV2 keeps the Helm v2 repository, instead of the Helm v3 one, when both have the same name
+	PreferV2 bool
+	// Progress reports the files of the plugins and starters copied. It is not reported when nil.
+	Progress common.Progress
+	// Scopes are the components moved, all of them when not set
+	Scopes []string
+	// SkipCache leaves the chart cache out of the repositories moved, Helm v3 populating it on demand
+	SkipCache bool
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (moveOptions MoveOptions) logger() common.Logger {
+	return common.LoggerOrDefault(moveOptions.Logger)
+}
+
+// inMoveScopes returns true if the component is moved as per the scopes, all components being moved
+// when no scope is set
+func inMoveScopes(scopes []string, component string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == MoveScopeAll || scope == component {
+			return true
+		}
+	}
+	return false
+}
+
 // Copyv2HomeTov3 copies the v2 home directory to the v3 home directory .
-// Note that this is not a direct 1-1 copy
-func Copyv2HomeTov3(dryRun bool) error {
+// Note that this is not a direct 1-1 copy. Only the components of the scopes are copied: the
+// repositories, plugins or starters, all of them when no scope is set. The repositories are merged
+// into the existing Helm v3 repositories, and their chart cache is copied unless skipped. The Helm v2
+// components are removed once copied in move mode only, the Helm v2 configuration being left intact
+// otherwise.
+func Copyv2HomeTov3(moveOptions MoveOptions) error {
+	scopes, dryRun := moveOptions.Scopes, moveOptions.DryRun
+	logger := moveOptions.logger()
+	// moved are the Helm v2 files and folders copied, to be removed in move mode
+	moved := []string{}
 	v2HomeDir := v2.HomeDir()
-	log.Printf("[Helm 2] Home directory: %s\n", v2HomeDir)
+	logger.Infof("[Helm 2] Home directory: %s\n", v2HomeDir)
+	warnSymlink(v2HomeDir, logger)
 	v3ConfigDir := v3.ConfigDir()
-	log.Printf("[Helm 3] Config directory: %s\n", v3ConfigDir)
+	logger.Infof("[Helm 3] Config directory: %s\n", v3ConfigDir)
 	v3DataDir := v3.DataDir()
-	log.Printf("[Helm 3] Data directory: %s\n", v3DataDir)
+	logger.Infof("[Helm 3] Data directory: %s\n", v3DataDir)
 	v3CacheDir := v3.CacheDir()
-	log.Printf("[Helm 3] Cache directory: %s\n", v3CacheDir)
+	logger.Infof("[Helm 3] Cache directory: %s\n", v3CacheDir)
 
 	// Create Helm v3 config directory if needed
-	log.Printf("[Helm 3] Create config folder \"%s\" .\n", v3ConfigDir)
+	logger.Infof("[Helm 3] Create config folder \"%s\" .\n", v3ConfigDir)
 	var err error
 	if !dryRun {
 		err = ensureDir(v3ConfigDir)
 		if err != nil {
 			return fmt.Errorf("[Helm 3] Failed to create config folder \"%s\" due to the following error: %s", v3ConfigDir, err)
 		}
-		log.Printf("[Helm 3] Config folder \"%s\" created.\n", v3ConfigDir)
+		logger.Infof("[Helm 3] Config folder \"%s\" created.\n", v3ConfigDir)
 	}
 
 	// Move repo config
-	v2RepoConfig := filepath.Join(v2HomeDir, "repository", "repositories.yaml")
-	v3RepoConfig := filepath.Join(v3ConfigDir, "repositories.yaml")
-	log.Printf("[Helm 2] repositories file \"%s\" will copy to [Helm 3] config folder \"%s\" .\n", v2RepoConfig, v3RepoConfig)
-	if !dryRun {
-		err = copyFile(v2RepoConfig, v3RepoConfig)
+	if inMoveScopes(scopes, MoveScopeRepositories) {
+		v2RepoConfig := filepath.Join(v2HomeDir, "repository", "repositories.yaml")
+		v3RepoConfig := filepath.Join(v3ConfigDir, "repositories.yaml")
+		warnSymlink(v2RepoConfig, logger)
+		err = mergeRepositoryFiles(v2RepoConfig, v3RepoConfig, v3CacheDir, moveOptions)
 		if err != nil {
-			return fmt.Errorf("Failed to copy [Helm 2] repository file \"%s\" due to the following error: %s", v2RepoConfig, err)
+			return err
 		}
-		log.Printf("[Helm 2] repositories file \"%s\" copied successfully to [Helm 3] config folder \"%s\" .\n", v2RepoConfig, v3RepoConfig)
+		moved = append(moved, v2RepoConfig)
 	}
 
-	// Not moving local repo and its cache, as it is safer to recreate: e.g. v2HomeDir/repository/local v2HomeDir/repository/cache
+	// Not moving local repo, as it is safer to recreate: e.g. v2HomeDir/repository/local
 
 	// Create Helm v3 cache directory if needed
-	log.Printf("[Helm 3] Create cache folder \"%s\" .\n", v3CacheDir)
+	logger.Infof("[Helm 3] Create cache folder \"%s\" .\n", v3CacheDir)
 	if !dryRun {
 		err = ensureDir(v3CacheDir)
 		if err != nil {
 			return fmt.Errorf("[Helm 3] Failed to create cache folder \"%s\" due to the following error: %s", v3CacheDir, err)
 		}
-		log.Printf("[Helm 3] cache folder \"%s\" created.\n", v3CacheDir)
+		logger.Infof("[Helm 3] cache folder \"%s\" created.\n", v3CacheDir)
+	}
+
+	// Move the chart cache of the repositories
+	if inMoveScopes(scopes, MoveScopeRepositories) {
+		if moveOptions.SkipCache {
+			logger.Infof("[Helm 2] chart cache is skipped, Helm v3 populating its cache on demand.\n")
+		} else {
+			cacheDirs, err := copyChartCache(v2HomeDir, v3CacheDir, moveOptions)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, cacheDirs...)
+		}
 	}
 
 	// Create Helm v3 data directory if needed
-	log.Printf("[Helm 3] Create data folder \"%s\" .\n", v3DataDir)
+	logger.Infof("[Helm 3] Create data folder \"%s\" .\n", v3DataDir)
 	if !dryRun {
 		err = ensureDir(v3DataDir)
 		if err != nil {
 			return fmt.Errorf("[Helm 3] Failed to create data folder \"%s\" due to the following error: %s", v3DataDir, err)
 		}
-		log.Printf("[Helm 3] data folder \"%s\" created.\n", v3DataDir)
+		logger.Infof("[Helm 3] data folder \"%s\" created.\n", v3DataDir)
 	}
 
-	// Handle plugins
+	// The files of the plugins and starters are counted for the progress of their copy
 	v2Plugins := filepath.Join(v2HomeDir, "cache", "plugins")
 	plugins, _ := pathExists(v2Plugins)
+	plugins = plugins && inMoveScopes(scopes, MoveScopePlugins)
+	v2Starters := filepath.Join(v2HomeDir, "starters")
+	starters := inMoveScopes(scopes, MoveScopeStarters)
+	total, copied := 0, 0
+	if plugins {
+		total += countFiles(v2Plugins)
+	}
+	if starters {
+		total += countFiles(v2Starters)
+	}
+	fileCopied := func(file string) {
+		copied++
+		common.UpdateProgress(moveOptions.Progress, file, copied, total)
+	}
+
+	// Handle plugins
 	if plugins {
+		warnV2OnlyPlugins(v2Plugins, logger)
+		warnSymlink(v2Plugins, logger)
+
 		// Move plugins
 		v2Plugins := filepath.Join(v2HomeDir, "cache", "plugins")
 		v3Plugins := filepath.Join(v3CacheDir, "plugins")
-		log.Printf("[Helm 2] plugins \"%s\" will copy to [Helm 3] cache folder \"%s\" .\n", v2Plugins, v3Plugins)
+		logger.Infof("[Helm 2] plugins \"%s\" (%d files) will copy to [Helm 3] cache folder \"%s\" .\n", v2Plugins, countFiles(v2Plugins), v3Plugins)
 		if !dryRun {
-			err = copyDir(v2Plugins, v3Plugins)
+			err = copyDir(v2Plugins, v3Plugins, fileCopied, logger)
 			if err != nil {
 				return fmt.Errorf("Failed to copy [Helm 2] plugins directory \"%s\" due to the following error: %s", v2Plugins, err)
 			}
-			log.Printf("[Helm 2] plugins \"%s\" copied successfully to [Helm 3] cache folder \"%s\" .\n", v2Plugins, v3Plugins)
+			logger.Infof("[Helm 2] plugins \"%s\" copied successfully to [Helm 3] cache folder \"%s\" .\n", v2Plugins, v3Plugins)
 		}
 
 		// Recreate the  plugin symbolic links for v3 path
 		v2Links := filepath.Join(v2HomeDir, "plugins")
-		log.Printf("[Helm 2] plugin symbolic links \"%s\" will copy to [Helm 3] data folder \"%s\" .\n", v2Links, v3DataDir)
+		logger.Infof("[Helm 2] plugin symbolic links \"%s\" will copy to [Helm 3] data folder \"%s\" .\n", v2Links, v3DataDir)
 		if !dryRun {
-			err = reCreatePluginSymLinks(v2Links, v3DataDir, v3CacheDir)
+			err = reCreatePluginSymLinks(v2Links, v3DataDir, v3CacheDir, logger)
 			if err != nil {
 				return fmt.Errorf("Failed to copy [Helm 2] plugin links \"%s\" due to the following error: %s", v2Links, err)
 			}
-			log.Printf("[Helm 2] plugin links \"%s\" copied successfully to [Helm 3] data folder \"%s\" .\n", v2Links, v3DataDir)
+			logger.Infof("[Helm 2] plugin links \"%s\" copied successfully to [Helm 3] data folder \"%s\" .\n", v2Links, v3DataDir)
 		}
+		moved = append(moved, v2Plugins, v2Links)
 	}
 
 	// Move starters
-	v2Starters := filepath.Join(v2HomeDir, "starters")
-	v3Starters := filepath.Join(v3DataDir, "starters")
-	log.Printf("[Helm 2] starters \"%s\" will copy to [Helm 3] data folder \"%s\" .\n", v2Starters, v3Starters)
-	if !dryRun {
-		err = copyDir(v2Starters, v3Starters)
-		if err != nil {
-			return fmt.Errorf("Failed to copy [Helm 2] starters \"%s\" due to the following error: %s", v2Starters, err)
+	if starters {
+		v3Starters := filepath.Join(v3DataDir, "starters")
+		warnSymlink(v2Starters, logger)
+		logger.Infof("[Helm 2] starters \"%s\" (%d files) will copy to [Helm 3] data folder \"%s\" .\n", v2Starters, countFiles(v2Starters), v3Starters)
+		if !dryRun {
+			err = copyDir(v2Starters, v3Starters, fileCopied, logger)
+			if err != nil {
+				return fmt.Errorf("Failed to copy [Helm 2] starters \"%s\" due to the following error: %s", v2Starters, err)
+			}
+			logger.Infof("[Helm 2] starters \"%s\" copied successfully to [Helm 3] data folder \"%s\" .\n", v2Starters, v3Starters)
+		}
+		moved = append(moved, v2Starters)
+	}
+
+	// Remove the Helm v2 components copied, in move mode only. They are in the target of a home folder
+	// which is a symbolic link, e.g. to a shared volume, so they are not removed through it.
+	if moveOptions.Move && isSymlink(v2HomeDir) {
+		logger.Warnf("[Helm 2] the Helm v2 configuration copied is not removed, as home directory \"%s\" is a symbolic link.\n", v2HomeDir)
+	} else if moveOptions.Move {
+		for _, path := range moved {
+			if isSymlink(path) {
+				logger.Infof("[Helm 2] \"%s\" is a symbolic link: only the link will be removed, its target being left intact.\n", path)
+			}
+			logger.Infof("[Helm 2] \"%s\" will be removed, as it was copied to [Helm 3].\n", path)
+			if !dryRun {
+				if err := os.RemoveAll(common.LongPath(path, runtime.GOOS)); err != nil {
+					return fmt.Errorf("[Helm 2] Failed to remove \"%s\" due to the following error: %s", path, err)
+				}
+				logger.Infof("[Helm 2] \"%s\" removed.\n", path)
+			}
 		}
-		log.Printf("[Helm 2] starters \"%s\" copied successfully to [Helm 3] data folder \"%s\" .\n", v2Starters, v3Starters)
 	}
 
 	return nil
 }
 
-// AskConfirmation provides a prompt for user to confirm continuation with operation
-func AskConfirmation(operation, specificMsg string) (bool, error) {
-	fmt.Printf("[%s/confirm] Are you sure you want to %s? [y/N]: ", operation, specificMsg)
+// isSymlink returns true if the path is a symbolic link
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// warnSymlink warns when the Helm v2 path copied is a symbolic link, as its target is copied
+func warnSymlink(path string, logger common.Logger) {
+	if !isSymlink(path) {
+		return
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		logger.Warnf("[Helm 2] \"%s\" is a symbolic link whose target can't be resolved: %s\n", path, err)
+		return
+	}
+	logger.Warnf("[Helm 2] \"%s\" is a symbolic link to \"%s\", which is copied from.\n", path, target)
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	if err := scanner.Err(); err != nil {
-		return false, errors.Wrap(err, "couldn't read from standard input")
+// countFiles returns the number of files in the directory and its sub-directories. Zero is returned
+// when the directory can't be read.
+func countFiles(dir string) int {
+	count := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// warnV2OnlyPlugins warns about the plugins of the directory whose plugin.yaml references Helm v2
+// only settings or environment variables, as they are likely not to work with Helm v3
+func warnV2OnlyPlugins(pluginsDir string, logger common.Logger) {
+	files, err := filepath.Glob(filepath.Join(pluginsDir, "*", "plugin.yaml"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			logger.Warnf("[Helm 2] plugin file \"%s\" failed to be read and its Helm v3 compatibility is not checked: %s\n", file, err)
+			continue
+		}
+		markers := []string{}
+		for _, marker := range v2OnlyPluginMarkers {
+			if strings.Contains(string(data), marker) {
+				markers = append(markers, marker)
+			}
+		}
+		if len(markers) > 0 {
+			logger.Warnf("[Helm 2] plugin \"%s\" may not be compatible with Helm v3, as its plugin.yaml references %s, which Helm v3 does not provide. Install a Helm v3 version of the plugin if it has one.\n", filepath.Base(filepath.Dir(file)), strings.Join(markers, ", "))
+		}
+	}
+}
+
+// ConfirmOptions are the options for prompting the user to confirm continuation with operation
+type ConfirmOptions struct {
+	// Default is the answer applied when the timeout elapses before an answer
+	Default bool
+	// FromStdin allows the answer to be read from a standard input which is not a terminal, e.g. a piped "y"
+	FromStdin bool
+	// In is where the answer is read from. Defaults to the standard input.
+	In io.Reader
+	// IsTerminal checks if the input is a terminal. Defaults to IsStdinTerminal.
+	IsTerminal func() bool
+	// Logger logs the answer applied when the timeout elapses. Defaults to the standard logger.
+	Logger common.Logger
+	// Out is where the prompt is written to. Defaults to the standard output.
+	Out io.Writer
+	// Timeout is how long the answer is waited for. Zero waits for it forever.
+	Timeout time.Duration
+}
+
+// output returns where the prompt is written to
+func (confirmOpts ConfirmOptions) output() io.Writer {
+	if confirmOpts.Out == nil {
+		return os.Stdout
+	}
+	return confirmOpts.Out
+}
+
+// input returns the input to read the answer from. An error is returned when the input is not a
+// terminal and reading the answer from it was not allowed, as no user would be there to answer.
+func (confirmOpts ConfirmOptions) input() (io.Reader, error) {
+	isTerminal := confirmOpts.IsTerminal
+	if isTerminal == nil {
+		isTerminal = IsStdinTerminal
+	}
+	if !confirmOpts.FromStdin && !isTerminal() {
+		return nil, errors.New("standard input is not a terminal to prompt for confirmation. Set the 'skip-confirmation' flag to skip the confirmation, or the 'confirm-from-stdin' flag to read the confirmation from the standard input")
+	}
+	if confirmOpts.In == nil {
+		return os.Stdin, nil
+	}
+	return confirmOpts.In, nil
+}
+
+// errConfirmTimeout is returned when the timeout of the confirmation elapses before an answer
+var errConfirmTimeout = errors.New("confirmation timed out")
+
+// readAnswer reads the answer line from the input, waiting for it until the timeout elapses when it
+// is set. False is returned when the input ends before an answer.
+func readAnswer(in io.Reader, timeout time.Duration) (string, bool, error) {
+	type result struct {
+		answer string
+		ok     bool
+		err    error
+	}
+	// Buffered, so that the read doesn't block forever once timed out
+	results := make(chan result, 1)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		ok := scanner.Scan()
+		if err := scanner.Err(); err != nil {
+			results <- result{err: errors.Wrap(err, "couldn't read from standard input")}
+			return
+		}
+		results <- result{answer: strings.TrimSpace(scanner.Text()), ok: ok}
+	}()
+	if timeout <= 0 {
+		r := <-results
+		return r.answer, r.ok, r.err
+	}
+	select {
+	case r := <-results:
+		return r.answer, r.ok, r.err
+	case <-time.After(timeout):
+		return "", false, errConfirmTimeout
+	}
+}
+
+// timeoutHint returns the hint of the prompt about the default answer applied once the timeout elapses
+func (confirmOpts ConfirmOptions) timeoutHint() string {
+	if confirmOpts.Timeout <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s in %s)", yesNo(confirmOpts.Default), confirmOpts.Timeout)
+}
+
+// yesNo returns the answer as "yes" or "no"
+func yesNo(answer bool) string {
+	if answer {
+		return "yes"
+	}
+	return "no"
+}
+
+// AskConfirmation provides a prompt for user to confirm continuation with operation. When the
+// timeout of the options elapses before an answer, the default answer is applied and logged.
+func AskConfirmation(operation, specificMsg string, confirmOpts ConfirmOptions) (bool, error) {
+	in, err := confirmOpts.input()
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(confirmOpts.output(), "[%s/confirm] Are you sure you want to %s? [y/N]%s: ", operation, specificMsg, confirmOpts.timeoutHint())
+
+	answer, _, err := readAnswer(in, confirmOpts.Timeout)
+	if err == errConfirmTimeout {
+		fmt.Fprintln(confirmOpts.output())
+		common.LoggerOrDefault(confirmOpts.Logger).Infof("No answer within %s, the default answer \"%s\" is applied.\n", confirmOpts.Timeout, yesNo(confirmOpts.Default))
+		return confirmOpts.Default, nil
+	}
+	if err != nil {
+		return false, err
 	}
-	answer := scanner.Text()
 	if strings.ToLower(answer) == "y" || strings.ToLower(answer) == "yes" {
 		return true, nil
 	}
 	return false, nil
 }
 
+// AskTypedConfirmation provides a prompt for user to confirm continuation with operation by typing
+// the expected text, e.g. the name of the release the operation is performed on.
+// An empty answer, a mismatching answer or the end of the input do not confirm the operation. Nor
+// does the timeout of the options elapsing before an answer, whatever the default answer, as the point
+// of the typed confirmation is that the text is typed.
+func AskTypedConfirmation(operation, specificMsg, expected string, confirmOpts ConfirmOptions) (bool, error) {
+	in, err := confirmOpts.input()
+	if err != nil {
+		return false, err
+	}
+	hint := ""
+	if confirmOpts.Timeout > 0 {
+		hint = fmt.Sprintf(" (no in %s)", confirmOpts.Timeout)
+	}
+	fmt.Fprintf(confirmOpts.output(), "[%s/confirm] Are you sure you want to %s? Type \"%s\" to confirm%s: ", operation, specificMsg, expected, hint)
+
+	answer, ok, err := readAnswer(in, confirmOpts.Timeout)
+	if err == errConfirmTimeout {
+		fmt.Fprintln(confirmOpts.output())
+		common.LoggerOrDefault(confirmOpts.Logger).Infof("No answer within %s, the typed confirmation is not confirmed.\n", confirmOpts.Timeout)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// End of the input before an answer
+		fmt.Fprintln(confirmOpts.output())
+		return false, nil
+	}
+	if expected != "" && answer == expected {
+		return true, nil
+	}
+	return false, nil
+}
+
+// IsStdinTerminal returns true if the standard input is a terminal, i.e. a user can be prompted
+func IsStdinTerminal() bool {
+	return IsTerminal(os.Stdin)
+}
+
+// IsTerminal returns true if the file is a terminal
+func IsTerminal(file *os.File) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
 func copyFile(srcFileName, destFileName string) error {
+	srcFileName, destFileName = common.LongPath(srcFileName, runtime.GOOS), common.LongPath(destFileName, runtime.GOOS)
 	input, err := ioutil.ReadFile(srcFileName)
 	if err != nil {
 		return err
@@ -164,13 +507,20 @@ func copyFile(srcFileName, destFileName string) error {
 	return nil
 }
 
-func copyDir(srcDirName, destDirName string) error {
+// copyDir copies the directory recursively, preserving the modes of its files and sub-directories.
+// The function, when set, is called with each file copied.
+func copyDir(srcDirName, destDirName string, fileCopied func(file string), logger common.Logger) error {
 	err := ensureDir(destDirName)
 	if err != nil {
 		return fmt.Errorf("Failed to create folder \"%s\" due to the following error: %s", destDirName, err)
 	}
+	if srcInfo, err := os.Stat(srcDirName); err == nil {
+		if err := os.Chmod(destDirName, srcInfo.Mode().Perm()); err != nil {
+			return fmt.Errorf("Failed to set the mode of folder \"%s\" due to the following error: %s", destDirName, err)
+		}
+	}
 
-	directory, _ := os.Open(srcDirName)
+	directory, _ := os.Open(common.LongPath(srcDirName, runtime.GOOS))
 	objects, err := directory.Readdir(-1)
 	if err != nil {
 		return fmt.Errorf("Failed to copy directory  due to the following error: %s", err)
@@ -180,7 +530,7 @@ func copyDir(srcDirName, destDirName string) error {
 		destFileName := filepath.Join(destDirName, obj.Name())
 		if obj.IsDir() {
 			// create sub-directories - recursively
-			err = copyDir(srcFileName, destFileName)
+			err = copyDir(srcFileName, destFileName, fileCopied, logger)
 			if err != nil {
 				return fmt.Errorf("Failed to copy folder \"%s\" to folder \"%s\" due to the following error: %s", srcFileName, destFileName, err)
 			}
@@ -190,7 +540,7 @@ func copyDir(srcDirName, destDirName string) error {
 				return fmt.Errorf("Failed to check file \"%s\" stats  due to the following error: %s", srcFileName, err)
 			}
 			if fileInfo.Mode()&os.ModeSymlink != 0 {
-				err = copySymLink(obj, srcDirName, destDirName)
+				err = copySymLink(obj, srcDirName, destDirName, logger)
 				if err != nil {
 					return fmt.Errorf("Failed to create symlink for  \"%s\" due to the following error: %s", obj.Name(), err)
 				}
@@ -200,6 +550,9 @@ func copyDir(srcDirName, destDirName string) error {
 					return fmt.Errorf("Failed to copy file  \"%s\" to \"%s\" due to the following error: %s", srcFileName, destFileName, err)
 				}
 			}
+			if fileCopied != nil {
+				fileCopied(srcFileName)
+			}
 		}
 	}
 
@@ -207,7 +560,7 @@ func copyDir(srcDirName, destDirName string) error {
 }
 
 func ensureDir(dirName string) error {
-	err := os.MkdirAll(dirName, os.ModePerm)
+	err := os.MkdirAll(common.LongPath(dirName, runtime.GOOS), os.ModePerm)
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
@@ -225,20 +578,38 @@ func pathExists(path string) (bool, error) {
 	return true, err
 }
 
-func copySymLink(fileInfo os.FileInfo, srcDirName, destDirName string) error {
+func copySymLink(fileInfo os.FileInfo, srcDirName, destDirName string, logger common.Logger) error {
 	originFileName, err := os.Readlink(filepath.Join(srcDirName, fileInfo.Name()))
 	if err != nil {
 		return err
 	}
 	newSymLinkName := filepath.Join(destDirName, fileInfo.Name())
-	err = os.Symlink(originFileName, newSymLinkName)
-	if err != nil && !os.IsExist(err) {
+	return symlinkOrCopy(originFileName, filepath.Join(srcDirName, fileInfo.Name()), newSymLinkName, logger)
+}
+
+// symlinkOrCopy creates the symbolic link to the target. On Windows, where creating symbolic links
+// needs a privilege or the developer mode, the source the link is the copy of, i.e. the original link
+// or its target, is copied in place of the link when it can't be created.
+func symlinkOrCopy(target, source, link string, logger common.Logger) error {
+	err := os.Symlink(target, link)
+	if err == nil || os.IsExist(err) {
+		return nil
+	}
+	if runtime.GOOS != "windows" {
 		return err
 	}
-	return nil
+	logger.Warnf("symbolic link \"%s\" can't be created (%s), \"%s\" is copied in its place.\n", link, err, source)
+	info, statErr := os.Stat(source)
+	if statErr != nil {
+		return statErr
+	}
+	if info.IsDir() {
+		return copyDir(source, link, nil, logger)
+	}
+	return copyFile(source, link)
 }
 
-func reCreatePluginSymLinks(srcDirName, v3DataDir, v3CacheDir string) error {
+func reCreatePluginSymLinks(srcDirName, v3DataDir, v3CacheDir string, logger common.Logger) error {
 	v3PluginDataDir := filepath.Join(v3DataDir, "plugins")
 	err := ensureDir(v3PluginDataDir)
 	if err != nil {
@@ -260,12 +631,17 @@ func reCreatePluginSymLinks(srcDirName, v3DataDir, v3CacheDir string) error {
 				symLinkName := obj.Name()
 				newFullSymLinkName := filepath.Join(v3PluginDataDir, symLinkName)
 				origFullFileName, err := os.Readlink(filepath.Join(srcDirName, fileInfo.Name()))
+				// A junction, which plugin installs can create on Windows, may not be readable as a link
+				if err != nil && runtime.GOOS == "windows" {
+					logger.Warnf("plugin link \"%s\" can't be read (%s) and is not re-created. Re-install the plugin with Helm v3.\n", srcFileName, err)
+					continue
+				}
 				if err != nil {
 					return fmt.Errorf("Failed to re-create symlink for \"%s\" due to the following error: %s", symLinkName, err)
 				}
 				newFullFileName := filepath.Join(v3CacheDir, "plugins", filepath.Base(origFullFileName))
-				err = os.Symlink(newFullFileName, newFullSymLinkName)
-				if err != nil && !os.IsExist(err) {
+				err = symlinkOrCopy(newFullFileName, newFullFileName, newFullSymLinkName, logger)
+				if err != nil {
 					return fmt.Errorf("Failed to re-create symlink for \"%s\" due to the following error: %s", newFullSymLinkName, err)
 				}
 			}
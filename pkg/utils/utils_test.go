@@ -0,0 +1,373 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2v3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+	v2 "github.com/helm/helm-2to3/pkg/v2"
+	v3 "github.com/helm/helm-2to3/pkg/v3"
+)
+
+// captureStdout runs the function and returns what it wrote to the standard output
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	out, err := ioutil.TempFile("", "helm-2to3-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+	run()
+
+	written, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(written)
+}
+
+// terminal is the TTY check of an input which is a terminal
+func terminal() bool { return true }
+
+func TestAskTypedConfirmation(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		confirmed bool
+	}{
+		{name: "match", input: "rel\n", confirmed: true},
+		{name: "match without newline", input: "rel", confirmed: true},
+		{name: "match with spaces", input: "  rel  \n", confirmed: true},
+		{name: "mismatch", input: "rel2\n", confirmed: false},
+		{name: "case mismatch", input: "REL\n", confirmed: false},
+		{name: "yes", input: "y\n", confirmed: false},
+		{name: "empty", input: "\n", confirmed: false},
+		{name: "EOF", input: "", confirmed: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var confirmed bool
+			var err error
+			confirmOpts := ConfirmOptions{In: strings.NewReader(test.input), IsTerminal: terminal}
+			out := captureStdout(t, func() {
+				confirmed, err = AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", "rel", confirmOpts)
+			})
+			if err != nil {
+				t.Fatalf("confirmation failed with error: %s", err)
+			}
+			if confirmed != test.confirmed {
+				t.Errorf("expected confirmed %t for input %q, got %t", test.confirmed, test.input, confirmed)
+			}
+			if !strings.Contains(out, `Type "rel" to confirm`) {
+				t.Errorf("expected the prompt to ask for the release name, got %q", out)
+			}
+		})
+	}
+}
+
+func TestAskTypedConfirmationEmptyExpected(t *testing.T) {
+	var confirmed bool
+	var err error
+	confirmOpts := ConfirmOptions{In: strings.NewReader("\n"), IsTerminal: terminal}
+	captureStdout(t, func() {
+		confirmed, err = AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", "", confirmOpts)
+	})
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if confirmed {
+		t.Error("expected an empty answer not to confirm an empty expected text")
+	}
+}
+
+// notTerminal is the TTY check of an input which is not a terminal, e.g. in CI
+func notTerminal() bool { return false }
+
+func TestAskConfirmation(t *testing.T) {
+	tests := []struct {
+		input     string
+		confirmed bool
+	}{
+		{input: "y\n", confirmed: true},
+		{input: "Y\n", confirmed: true},
+		{input: "yes\n", confirmed: true},
+		{input: "YES", confirmed: true},
+		{input: " y \n", confirmed: true},
+		{input: "n\n", confirmed: false},
+		{input: "no\n", confirmed: false},
+		{input: "yess\n", confirmed: false},
+		{input: "\n", confirmed: false},
+		{input: "", confirmed: false},
+	}
+	for _, test := range tests {
+		var confirmed bool
+		var err error
+		confirmOpts := ConfirmOptions{In: strings.NewReader(test.input), IsTerminal: terminal}
+		out := captureStdout(t, func() {
+			confirmed, err = AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOpts)
+		})
+		if err != nil {
+			t.Fatalf("confirmation of input %q failed with error: %s", test.input, err)
+		}
+		if confirmed != test.confirmed {
+			t.Errorf("expected confirmed %t for input %q, got %t", test.confirmed, test.input, confirmed)
+		}
+		if expected := "[Cleanup/confirm] Are you sure you want to cleanup Helm v2 data? [y/N]: "; out != expected {
+			t.Errorf("expected the prompt %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestAskConfirmationNotTerminal(t *testing.T) {
+	in := strings.NewReader("y\n")
+	var confirmed bool
+	var err error
+	out := captureStdout(t, func() {
+		confirmed, err = AskConfirmation("Cleanup", "cleanup Helm v2 data", ConfirmOptions{In: in, IsTerminal: notTerminal})
+	})
+	if err == nil || !strings.Contains(err.Error(), "skip-confirmation") {
+		t.Errorf("expected an error telling to skip the confirmation, got %v", err)
+	}
+	if confirmed {
+		t.Error("expected the confirmation to fail")
+	}
+	if in.Len() != len("y\n") || out != "" {
+		t.Errorf("expected nothing to be prompted nor read, got prompt %q and %d bytes left", out, in.Len())
+	}
+
+	// A piped answer is read once allowed
+	confirmOpts := ConfirmOptions{FromStdin: true, In: strings.NewReader("y\n"), IsTerminal: notTerminal}
+	captureStdout(t, func() {
+		confirmed, err = AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOpts)
+	})
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if !confirmed {
+		t.Error("expected the piped answer to confirm")
+	}
+
+	// The typed confirmation needs a terminal too
+	_, err = AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", "rel", ConfirmOptions{In: strings.NewReader("rel\n"), IsTerminal: notTerminal})
+	if err == nil {
+		t.Error("expected the typed confirmation to fail when the input is not a terminal")
+	}
+}
+
+func TestAskConfirmationTimeout(t *testing.T) {
+	for _, defaultAnswer := range []bool{true, false} {
+		// Nothing is ever written to the input, as when nobody is at the terminal
+		in, writer := io.Pipe()
+		defer writer.Close()
+		var out bytes.Buffer
+		logger := &commontest.RecordingLogger{}
+		confirmOpts := ConfirmOptions{Default: defaultAnswer, In: in, IsTerminal: terminal, Logger: logger, Out: &out, Timeout: 50 * time.Millisecond}
+
+		confirmed, err := AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOpts)
+		if err != nil {
+			t.Fatalf("confirmation failed with error: %s", err)
+		}
+		if confirmed != defaultAnswer {
+			t.Errorf("expected the default answer %t once timed out, got %t", defaultAnswer, confirmed)
+		}
+		if hint := fmt.Sprintf("[y/N] (%s in 50ms): ", yesNo(defaultAnswer)); !strings.Contains(out.String(), hint) {
+			t.Errorf("expected the prompt to hint %q, got %q", hint, out.String())
+		}
+		expected := fmt.Sprintf("No answer within 50ms, the default answer \"%s\" is applied.\n", yesNo(defaultAnswer))
+		if len(logger.Lines()) != 1 || logger.Lines()[0] != expected {
+			t.Errorf("expected the default answer applied to be logged, got %q", logger.Lines())
+		}
+	}
+}
+
+func TestAskTypedConfirmationTimeout(t *testing.T) {
+	in, writer := io.Pipe()
+	defer writer.Close()
+	logger := &commontest.RecordingLogger{}
+	confirmOpts := ConfirmOptions{Default: true, In: in, IsTerminal: terminal, Logger: logger, Out: &bytes.Buffer{}, Timeout: 50 * time.Millisecond}
+
+	confirmed, err := AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", "rel", confirmOpts)
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if confirmed {
+		t.Error("expected the typed confirmation not to be confirmed once timed out, whatever the default answer")
+	}
+	if len(logger.Lines()) != 1 {
+		t.Errorf("expected the timeout to be logged, got %q", logger.Lines())
+	}
+}
+
+func TestAskConfirmationAnswerBeforeTimeout(t *testing.T) {
+	in, writer := io.Pipe()
+	defer writer.Close()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintln(writer, "y")
+	}()
+	logger := &commontest.RecordingLogger{}
+	confirmOpts := ConfirmOptions{Default: false, In: in, IsTerminal: terminal, Logger: logger, Out: &bytes.Buffer{}, Timeout: 10 * time.Second}
+
+	confirmed, err := AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOpts)
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if !confirmed {
+		t.Error("expected the answer given before the timeout to confirm")
+	}
+	if len(logger.Lines()) != 0 {
+		t.Errorf("expected no default answer to be logged, got %q", logger.Lines())
+	}
+}
+
+func TestAskConfirmationEOFBeforeTimeout(t *testing.T) {
+	logger := &commontest.RecordingLogger{}
+	confirmOpts := ConfirmOptions{Default: true, In: strings.NewReader(""), IsTerminal: terminal, Logger: logger, Out: &bytes.Buffer{}, Timeout: 10 * time.Second}
+
+	confirmed, err := AskConfirmation("Cleanup", "cleanup Helm v2 data", confirmOpts)
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if confirmed {
+		t.Error("expected the end of the input not to confirm, the default answer only applying once timed out")
+	}
+	if len(logger.Lines()) != 0 {
+		t.Errorf("expected no default answer to be logged, got %q", logger.Lines())
+	}
+
+	confirmOpts.In = strings.NewReader("")
+	confirmed, err = AskTypedConfirmation("Cleanup", "cleanup Helm v2 data", "rel", confirmOpts)
+	if err != nil {
+		t.Fatalf("confirmation failed with error: %s", err)
+	}
+	if confirmed {
+		t.Error("expected the end of the input not to confirm the typed confirmation")
+	}
+}
+
+// writeFile writes the file, creating its directory
+func writeFile(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// symlink creates the symbolic link to the target, skipping the test where symbolic links can't be created
+func symlink(t *testing.T, target, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symbolic links can't be created: %s", err)
+	}
+}
+
+// moveDirs returns a temp dir, with the Helm v2 home folder set to its 'home' folder and the Helm v3
+// directories to its 'v3' folder
+func moveDirs(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "helm-2to3-move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2.SetHomeDir(filepath.Join(dir, "home"))
+	v3.SetDirs(filepath.Join(dir, "v3", "config"), filepath.Join(dir, "v3", "data"), filepath.Join(dir, "v3", "cache"))
+	return dir, func() {
+		v2.SetHomeDir("")
+		v3.SetDirs("", "", "")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMoveSymlinkedHome(t *testing.T) {
+	dir, cleanup := moveDirs(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "shared", "starters", "starter", "Chart.yaml"))
+	symlink(t, filepath.Join(dir, "shared"), filepath.Join(dir, "home"))
+
+	logger := &commontest.RecordingLogger{}
+	if err := Copyv2HomeTov3(MoveOptions{Logger: logger, Move: true, Scopes: []string{MoveScopeStarters}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "v3", "data", "starters", "starter", "Chart.yaml")); err != nil {
+		t.Errorf("expected the starters to be copied from the target of the home folder: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shared", "starters", "starter", "Chart.yaml")); err != nil {
+		t.Errorf("expected the starters in the target of the home folder to survive the move: %s", err)
+	}
+	if !logger.Logged("is a symbolic link to") || !logger.Logged("is not removed, as home directory") {
+		t.Errorf("expected the home folder which is a symbolic link to be warned about, got %q", logger.Lines())
+	}
+}
+
+func TestMoveSymlinkedStarters(t *testing.T) {
+	dir, cleanup := moveDirs(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "home", "repository", "repositories.yaml"))
+	writeFile(t, filepath.Join(dir, "volume", "starters", "starter", "Chart.yaml"))
+	symlink(t, filepath.Join(dir, "volume", "starters"), filepath.Join(dir, "home", "starters"))
+
+	logger := &commontest.RecordingLogger{}
+	if err := Copyv2HomeTov3(MoveOptions{Logger: logger, Move: true, Scopes: []string{MoveScopeStarters}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "v3", "data", "starters", "starter", "Chart.yaml")); err != nil {
+		t.Errorf("expected the starters to be copied from the target of the link: %s", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "home", "starters")); !os.IsNotExist(err) {
+		t.Errorf("expected the link to the starters to be removed once moved, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "volume", "starters", "starter", "Chart.yaml")); err != nil {
+		t.Errorf("expected the target of the link to the starters to survive the move: %s", err)
+	}
+	if !logger.Logged("only the link will be removed") {
+		t.Errorf("expected the link to be logged as removed alone, got %q", logger.Lines())
+	}
+}
+
+func TestMoveSymlinkInStarters(t *testing.T) {
+	dir, cleanup := moveDirs(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "volume", "values.yaml"))
+	writeFile(t, filepath.Join(dir, "home", "starters", "starter", "Chart.yaml"))
+	target := filepath.Join(dir, "volume", "values.yaml")
+	symlink(t, target, filepath.Join(dir, "home", "starters", "starter", "values.yaml"))
+
+	if err := Copyv2HomeTov3(MoveOptions{Logger: &commontest.RecordingLogger{}, Scopes: []string{MoveScopeStarters}}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Readlink(filepath.Join(dir, "v3", "data", "starters", "starter", "values.yaml"))
+	if err != nil {
+		t.Fatalf("expected the symbolic link of the starter to be copied as a link: %s", err)
+	}
+	if link != target {
+		t.Errorf("expected the link copied to point to %q, got %q", target, link)
+	}
+}
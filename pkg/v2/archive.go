@@ -0,0 +1,185 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+// A release archive is a gzipped tar file which contains:
+//   - manifest.json: the ArchiveManifest listing the release versions in the archive
+//   - releases/<release>.v<version>: the release version record, as encoded by Tiller in the
+//     "release" key of the ConfigMap or Secret storing it (base64 encoded gzipped protobuf)
+const (
+	// ArchiveFormatVersion is the version of the release archive format
+	ArchiveFormatVersion = 1
+
+	archiveManifestFile = "manifest.json"
+	archiveReleasesDir  = "releases"
+)
+
+// ArchiveManifest lists the release versions in a release archive
+type ArchiveManifest struct {
+	FormatVersion int            `json:"formatVersion"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Releases      []ArchiveEntry `json:"releases"`
+}
+
+// ArchiveEntry describes a release version in a release archive
+type ArchiveEntry struct {
+	Name         string            `json:"name"`
+	Version      int32             `json:"version"`
+	Namespace    string            `json:"namespace"`
+	Status       string            `json:"status,omitempty"`
+	Chart        string            `json:"chart,omitempty"`
+	ChartVersion string            `json:"chartVersion,omitempty"`
+	Storage      string            `json:"storage"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// File is the path of the release version record in the archive
+	File string `json:"file"`
+}
+
+// WriteArchive writes the release records to w as a release archive
+func WriteArchive(w io.Writer, records []ReleaseRecord) error {
+	now := time.Now().UTC()
+	manifest := ArchiveManifest{
+		FormatVersion: ArchiveFormatVersion,
+		CreatedAt:     now,
+		Releases:      []ArchiveEntry{},
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, record := range records {
+		release := record.Release
+		entry := ArchiveEntry{
+			Name:      release.Name,
+			Version:   release.Version,
+			Namespace: release.Namespace,
+			Storage:   record.Storage,
+			Labels:    record.Labels,
+			File:      path.Join(archiveReleasesDir, GetReleaseVersionName(release.Name, release.Version)),
+		}
+		if release.Info != nil && release.Info.Status != nil {
+			entry.Status = release.Info.Status.Code.String()
+		}
+		if release.Chart != nil && release.Chart.Metadata != nil {
+			entry.Chart = release.Chart.Metadata.Name
+			entry.ChartVersion = release.Chart.Metadata.Version
+		}
+		if err := writeArchiveFile(tarWriter, entry.File, []byte(record.Data), now); err != nil {
+			return err
+		}
+		manifest.Releases = append(manifest.Releases, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveFile(tarWriter, archiveManifestFile, data, now); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// ReadArchive reads the release records from the release archive in r, in the order of its manifest
+func ReadArchive(r io.Reader) ([]ReleaseRecord, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	files := map[string][]byte{}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = data
+	}
+
+	data, ok := files[archiveManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the archive", archiveManifestFile)
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s in the archive: %s", archiveManifestFile, err)
+	}
+	if manifest.FormatVersion != ArchiveFormatVersion {
+		return nil, fmt.Errorf("archive format version %d is not supported. It can be %d", manifest.FormatVersion, ArchiveFormatVersion)
+	}
+
+	records := []ReleaseRecord{}
+	for _, entry := range manifest.Releases {
+		relVerName := GetReleaseVersionName(entry.Name, entry.Version)
+		data, ok := files[entry.File]
+		if !ok {
+			return nil, fmt.Errorf("record \"%s\" of ReleaseVersion \"%s\" not found in the archive", entry.File, relVerName)
+		}
+		release, err := decodeRelease(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: record \"%s\" of ReleaseVersion \"%s\" in the archive: %s", ErrCorruptRecord, entry.File, relVerName, err)
+		}
+		records = append(records, ReleaseRecord{
+			Data:    string(data),
+			Labels:  entry.Labels,
+			Name:    relVerName,
+			Release: release,
+			Storage: entry.Storage,
+		})
+	}
+	return records, nil
+}
+
+func writeArchiveFile(tarWriter *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tarWriter.Write(data)
+	return err
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"path/filepath"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// storageAuditEntry returns the audit entry of the operation on the storage object of a release version
+// of the Helm v2 storage of the type: a Secret or ConfigMap of the Tiller namespace, a row of the SQL
+// storage, or a file of the storage directory
+func storageAuditEntry(verb, storage string, retOpts RetrieveOptions, releaseVersionName string) common.AuditEntry {
+	releaseName, _, _ := parseReleaseVersionName(releaseVersionName)
+	entry := common.AuditEntry{
+		Verb:      verb,
+		Kind:      storage,
+		Namespace: retOpts.TillerNamespace,
+		Name:      releaseVersionName,
+		Release:   releaseName,
+	}
+	switch storage {
+	case "secrets":
+		entry.Version, entry.Kind = "v1", "Secret"
+	case "configmaps":
+		entry.Version, entry.Kind = "v1", "ConfigMap"
+	case "sql":
+		entry.Kind, entry.Namespace = "SQL record", ""
+	case "dir":
+		entry.Kind, entry.Namespace, entry.Name = "file", "", filepath.Join(retOpts.StorageDir, releaseVersionName)
+	}
+	return entry
+}
+
+// auditStorage returns the storage of the release versions whose deletion is planned in dry-run, for
+// their audit entries: it is detected, as the dry-run does not detect it, and only when there is an
+// audit log, as detecting it reads the cluster
+func auditStorage(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) string {
+	if !common.AuditEnabled() {
+		return ""
+	}
+	storage, err := getStorageType(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return retOpts.StorageType
+	}
+	return storage
+}
+
+// auditPlannedDeletion records the deletion of the storage object of a release version in the audit
+// log, as planned in dry-run
+func auditPlannedDeletion(retOpts RetrieveOptions, storage, releaseVersionName string) {
+	entry := storageAuditEntry("delete", storage, retOpts, releaseVersionName)
+	entry.DryRun = true
+	common.RecordAudit(entry, nil)
+}
+
+// auditEntry returns the audit entry of the deletion of the Tiller object
+func (obj tillerObject) auditEntry() common.AuditEntry {
+	entry := common.AuditEntry{
+		Verb:      "delete",
+		Version:   "v1",
+		Kind:      obj.kind,
+		Namespace: obj.namespace,
+		Name:      obj.name,
+	}
+	switch obj.kind {
+	case "Deployment", "ReplicaSet":
+		entry.Group = "apps"
+	case "ClusterRoleBinding", "RoleBinding":
+		entry.Group = "rbac.authorization.k8s.io"
+	}
+	return entry
+}
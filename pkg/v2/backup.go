@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// backupIndexFile is the name of the file listing the release versions in a backup folder
+const backupIndexFile = "index.json"
+
+// backupIndex lists the release versions in a backup folder
+type backupIndex struct {
+	Releases []backupIndexEntry `json:"releases"`
+}
+
+// backupIndexEntry describes a release version in a backup folder
+type backupIndexEntry struct {
+	Name      string `json:"name"`
+	Version   int32  `json:"version"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status,omitempty"`
+	File      string `json:"file"`
+}
+
+// BackupReleaseVersions writes the release versions to the backup folder, before they are removed.
+// Each release version is written as a gzipped protobuf file named <release>.v<version>.gz, and an
+// index.json file lists the release versions written.
+func BackupReleaseVersions(releases []*rls.Release, backupDir string, dryRun bool, logger common.Logger) error {
+	logger.Infof("[Helm 2] %d release versions will be backed up to folder \"%s\".\n", len(releases), backupDir)
+	if dryRun {
+		return nil
+	}
+
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return fmt.Errorf("[Helm 2] Failed to create backup folder \"%s\" due to the following error: %s", backupDir, err)
+	}
+
+	index := backupIndex{
+		Releases: []backupIndexEntry{},
+	}
+	for _, release := range releases {
+		relVerName := GetReleaseVersionName(release.Name, release.Version)
+		fileName := relVerName + ".gz"
+		if err := writeReleaseBackup(release, filepath.Join(backupDir, fileName)); err != nil {
+			return fmt.Errorf("[Helm 2] Failed to back up ReleaseVersion \"%s\" due to the following error: %s", relVerName, err)
+		}
+		entry := backupIndexEntry{
+			Name:      release.Name,
+			Version:   release.Version,
+			Namespace: release.Namespace,
+			File:      fileName,
+		}
+		if release.Info != nil && release.Info.Status != nil {
+			entry.Status = release.Info.Status.Code.String()
+		}
+		index.Releases = append(index.Releases, entry)
+		logger.Infof("[Helm 2] ReleaseVersion \"%s\" backed up.\n", relVerName)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[Helm 2] Failed to create backup index due to the following error: %s", err)
+	}
+	indexFile := filepath.Join(backupDir, backupIndexFile)
+	if err := ioutil.WriteFile(indexFile, data, 0600); err != nil {
+		return fmt.Errorf("[Helm 2] Failed to write backup index \"%s\" due to the following error: %s", indexFile, err)
+	}
+	logger.Infof("[Helm 2] Release versions backed up to folder \"%s\".\n", backupDir)
+	return nil
+}
+
+// BackupReleaseRecords writes the release versions of the records to the backup folder, as per
+// BackupReleaseVersions, so that the release versions backed up are the ones of the records deleted
+func BackupReleaseRecords(records []ReleaseRecord, backupDir string, dryRun bool, logger common.Logger) error {
+	releases := []*rls.Release{}
+	for _, record := range records {
+		releases = append(releases, record.Release)
+	}
+	return BackupReleaseVersions(releases, backupDir, dryRun, logger)
+}
+
+func writeReleaseBackup(release *rls.Release, fileName string) error {
+	data, err := proto.Marshal(release)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// minTillerMinorVersion is the minor version of the oldest Tiller v2 whose release records are
+// supported. The records written by older Tillers lack fields the conversion relies on, e.g. the
+// description of the release version, and are mis-converted.
+const minTillerMinorVersion = 7
+
+// ErrUnsupportedStorageFormat is returned when the release records were written by a Tiller older than 2.7
+var ErrUnsupportedStorageFormat = errors.New("unsupported Helm v2 storage format")
+
+// CheckTillerVersion checks that the Tiller version, as per the tag of its image, writes release records
+// which are supported. Versions which can't be parsed, e.g. 'canary' or a digest, are not checked.
+func CheckTillerVersion(version string) error {
+	major, minor, ok := parseTillerVersion(version)
+	if !ok || major != 2 || minor >= minTillerMinorVersion {
+		return nil
+	}
+	return fmt.Errorf("%w: Tiller %s writes release records which are not supported. Upgrade Tiller to v2.%d or later first, so that it rewrites the release records on the next upgrade of each release", ErrUnsupportedStorageFormat, version, minTillerMinorVersion)
+}
+
+// parseTillerVersion returns the major and minor version of a Tiller version, e.g. 'v2.16.10'
+func parseTillerVersion(version string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// CheckReleaseFormat checks that the release versions were written by a supported Tiller: at least one
+// version has the description that Tiller sets on every release version since v2.7
+func CheckReleaseFormat(releaseName string, v2Releases []*rls.Release) error {
+	for _, v2Release := range v2Releases {
+		if v2Release.Info != nil && v2Release.Info.Description != "" {
+			return nil
+		}
+	}
+	if len(v2Releases) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: no version of release \"%s\" has a description, so its release records were written by a Tiller older than v2.%d. Upgrade Tiller to v2.%d or later and upgrade the release with it first", ErrUnsupportedStorageFormat, releaseName, minTillerMinorVersion, minTillerMinorVersion)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// ErrCorruptRecord is returned when the release of a Helm v2 storage object can't be decoded, e.g. when
+// its payload was truncated by a backup and restore
+var ErrCorruptRecord = errors.New("release record can't be decoded")
+
+// CorruptRecord is a Helm v2 storage object whose release can't be decoded. The release name and version
+// are taken from the labels or the name of the object, as its release can't be read.
+type CorruptRecord struct {
+	Name string `json:"name"`
+	// Namespace is the Tiller namespace of the ConfigMap or Secret. It is empty for the other storages.
+	Namespace   string `json:"namespace,omitempty"`
+	Storage     string `json:"storage"`
+	ReleaseName string `json:"releaseName,omitempty"`
+	Version     int32  `json:"version,omitempty"`
+	Error       string `json:"error"`
+}
+
+// String returns the storage object of the record, as it can be looked up to be inspected
+func (record CorruptRecord) String() string {
+	switch record.Storage {
+	case "configmaps":
+		return fmt.Sprintf("ConfigMap \"%s\" in namespace \"%s\"", record.Name, record.Namespace)
+	case "secrets":
+		return fmt.Sprintf("Secret \"%s\" in namespace \"%s\"", record.Name, record.Namespace)
+	case "sql":
+		return fmt.Sprintf("SQL row \"%s\"", record.Name)
+	}
+	return fmt.Sprintf("file \"%s\" of the Tiller storage directory", record.Name)
+}
+
+// CorruptRecordsError is returned when some release versions of a release can't be decoded, with their
+// storage objects
+type CorruptRecordsError struct {
+	ReleaseName string
+	Records     []CorruptRecord
+}
+
+func (e *CorruptRecordsError) Error() string {
+	records := []string{}
+	for _, record := range e.Records {
+		records = append(records, fmt.Sprintf("%s (%s)", record, record.Error))
+	}
+	return fmt.Sprintf("[Helm 2] release \"%s\" has %d release records which can't be decoded: %s", e.ReleaseName, len(e.Records), strings.Join(records, ", "))
+}
+
+func (e *CorruptRecordsError) Unwrap() error {
+	return ErrCorruptRecord
+}
+
+// GetReleaseVersionsWithCorrupt returns the release versions of a release from Helm v2 storage which can
+// be decoded, sorted by version, and the records of the ones which can't be. ErrNoVersionsFound is
+// returned if there are neither, or if none match the selector.
+func GetReleaseVersionsWithCorrupt(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, []CorruptRecord, error) {
+	records, corrupt, err := listReleaseRecords(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 && len(corrupt) == 0 {
+		return nil, nil, noVersionsError(retOpts)
+	}
+	var releases []*rls.Release
+	for _, record := range records {
+		releases = append(releases, record.Release)
+	}
+	return releases, corrupt, nil
+}
+
+// GetCorruptRecords returns the records of Helm v2 storage whose release can't be decoded, of all
+// releases unless the release name of the options is set.
+// It is based on Tiller namespace and labels like owner of storage.
+func GetCorruptRecords(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]CorruptRecord, error) {
+	_, corrupt, err := listReleaseRecords(ctx, retOpts, kubeConfig)
+	return corrupt, err
+}
+
+// DeleteCorruptRecords deletes the storage objects of the corrupt records from Helm v2 storage, leaving the
+// other release versions of their releases as they are. The records deleted are returned, which are the
+// records deleted before the failure when an error is returned.
+func DeleteCorruptRecords(ctx context.Context, retOpts RetrieveOptions, records []CorruptRecord, dryRun bool, kubeConfig common.KubeConfig) ([]CorruptRecord, error) {
+	deleted := []CorruptRecord{}
+	for _, record := range records {
+		retOpts.logger().Infof("[Helm 2] %s will be deleted.\n", record)
+		if record.Namespace != "" {
+			retOpts.TillerNamespace = record.Namespace
+		}
+		if dryRun {
+			auditPlannedDeletion(retOpts, record.Storage, record.Name)
+			continue
+		}
+		if err := deleteReleaseObject(ctx, retOpts, record.Storage, record.Name, ObjectDeletion{}.deleteOptions(""), kubeConfig); err != nil {
+			return deleted, fmt.Errorf("[Helm 2] %s failed to delete with error: %w", record, err)
+		}
+		retOpts.logger().Infof("[Helm 2] %s deleted.\n", record)
+		deleted = append(deleted, record)
+	}
+	return deleted, nil
+}
+
+// decodeRelease decodes the release of a storage object, as per decodeReleasePayload. A panic of the
+// decoder on a malformed payload is returned as an error too, so that one record can't abort a run.
+func decodeRelease(data string) (release *rls.Release, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			release, err = nil, fmt.Errorf("invalid payload: %v", r)
+		}
+	}()
+	return decodeReleasePayload([]byte(data))
+}
+
+// newCorruptRecord returns the record of a storage object whose release can't be decoded, its release
+// name and version being taken from the labels Tiller sets
+func newCorruptRecord(name, namespace, storage string, recordLabels map[string]string, err error) CorruptRecord {
+	version, _ := strconv.Atoi(recordLabels["VERSION"])
+	return CorruptRecord{
+		Name:        name,
+		Namespace:   namespace,
+		Storage:     storage,
+		ReleaseName: recordLabels["NAME"],
+		Version:     int32(version),
+		Error:       err.Error(),
+	}
+}
+
+// logCorruptRecords warns about the records skipped as their release can't be decoded
+func logCorruptRecords(corrupt []CorruptRecord, logger common.Logger) {
+	for _, record := range corrupt {
+		logger.Warnf("[Helm 2] %s can't be decoded and is skipped: %s\n", record, record.Error)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// The release payload of a storage object is a protobuf release, gzip compressed by Tiller v2.7 and
+// later, and base64 encoded once by the ConfigMaps storage, and once more by the Secrets storage before
+// the client decodes the data of the Secret. Records copied between the storages by scripts can be
+// base64 encoded once too often, or not compressed, so that the layers are detected rather than
+// assumed as per the storage.
+
+// maxPayloadLayers is the number of base64 and gzip layers a release payload is unwrapped of at most
+const maxPayloadLayers = 4
+
+// gzipMagic is the header of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// decodeReleasePayload decodes the release of a storage object, unwrapping the base64 and gzip layers
+// it finds in any order until the protobuf release. An error describing the layers unwrapped is
+// returned when no release is found under them.
+func decodeReleasePayload(data []byte) (*rls.Release, error) {
+	payload := bytes.TrimSpace(data)
+	if len(payload) == 0 {
+		return nil, errors.New("empty payload")
+	}
+	layers := []string{}
+	for len(layers) <= maxPayloadLayers {
+		if bytes.HasPrefix(payload, gzipMagic) {
+			reader, err := gzip.NewReader(bytes.NewReader(payload))
+			if err == nil {
+				payload, err = ioutil.ReadAll(reader)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("payload is %s but can't be decompressed: %s", describeLayers(append(layers, "gzip")), err)
+			}
+			layers = append(layers, "gzip")
+			continue
+		}
+		if decoded, ok := decodeBase64(payload); ok {
+			payload = decoded
+			layers = append(layers, "base64")
+			continue
+		}
+		release := &rls.Release{}
+		if err := proto.Unmarshal(payload, release); err != nil {
+			return nil, fmt.Errorf("payload is %s but doesn't hold a protobuf release: %s", describeLayers(layers), err)
+		}
+		if release.Name == "" {
+			return nil, fmt.Errorf("payload is %s but doesn't hold a protobuf release: the release has no name", describeLayers(layers))
+		}
+		return release, nil
+	}
+	return nil, fmt.Errorf("payload is %s, over the %d layers a release is unwrapped of", describeLayers(layers), maxPayloadLayers)
+}
+
+// decodeBase64 returns the data decoded if it is base64 encoded, ignoring the line breaks some encoders
+// wrap the lines at. The protobuf and gzip data never are, as they start with bytes out of the base64
+// alphabet.
+func decodeBase64(data []byte) ([]byte, bool) {
+	encoded := strings.NewReplacer("\r", "", "\n", "").Replace(string(data))
+	for _, c := range encoded {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '=') {
+			return nil, false
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(encoded)
+	}
+	return decoded, err == nil && len(decoded) > 0
+}
+
+// describeLayers describes the layers of a payload from the outermost one, e.g. "base64 encoded twice,
+// gzip compressed"
+func describeLayers(layers []string) string {
+	if len(layers) == 0 {
+		return "neither base64 encoded nor gzip compressed"
+	}
+	descriptions := []string{}
+	for i := 0; i < len(layers); {
+		j := i
+		for j < len(layers) && layers[j] == layers[i] {
+			j++
+		}
+		description := "base64 encoded"
+		if layers[i] == "gzip" {
+			description = "gzip compressed"
+		}
+		switch j - i {
+		case 1:
+		case 2:
+			description += " twice"
+		default:
+			description += fmt.Sprintf(" %d times", j-i)
+		}
+		descriptions = append(descriptions, description)
+		i = j
+	}
+	return strings.Join(descriptions, ", then ")
+}
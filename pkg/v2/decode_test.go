@@ -0,0 +1,166 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// releasePayload returns the payload of the release version, gzip compressed if set, then base64
+// encoded the times given
+func releasePayload(t *testing.T, release *rls.Release, compressed bool, encodings int) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		payload = buffer.Bytes()
+	}
+	for i := 0; i < encodings; i++ {
+		payload = []byte(base64.StdEncoding.EncodeToString(payload))
+	}
+	return payload
+}
+
+// storageObject returns the ConfigMap or Secret of the storage storing the payload of the release version
+// in the "kube-system" namespace. The data of a Secret is the payload as the client decodes it.
+func storageObject(storage, name string, version int32, payload []byte) runtime.Object {
+	objectMeta := metav1.ObjectMeta{
+		Name:      GetReleaseVersionName(name, version),
+		Namespace: "kube-system",
+		Labels:    map[string]string{"NAME": name, "OWNER": "TILLER", "STATUS": "DEPLOYED", "VERSION": strconv.Itoa(int(version))},
+	}
+	if storage == "secrets" {
+		return &corev1.Secret{ObjectMeta: objectMeta, Data: map[string][]byte{"release": payload}}
+	}
+	return &corev1.ConfigMap{ObjectMeta: objectMeta, Data: map[string]string{"release": string(payload)}}
+}
+
+func TestDecodeReleasePayloadLayouts(t *testing.T) {
+	for _, storage := range []string{"configmaps", "secrets"} {
+		for _, compressed := range []bool{true, false} {
+			for _, encodings := range []int{1, 2} {
+				layout := "raw"
+				if compressed {
+					layout = "gzip"
+				}
+				t.Run(storage+"/"+layout+"/base64x"+strconv.Itoa(encodings), func(t *testing.T) {
+					release := &rls.Release{Name: "rel", Namespace: "default", Version: 3, Info: &rls.Info{Status: &rls.Status{Code: rls.Status_DEPLOYED}}}
+					client := newRecordingClientset(storageObject(storage, "rel", 3, releasePayload(t, release, compressed, encodings)))
+					retOpts := outClusterRetrieveOptions("rel")
+					retOpts.StorageType = storage
+
+					releases, err := GetReleaseVersions(context.Background(), retOpts, common.KubeConfig{Client: client})
+					if err != nil {
+						t.Fatalf("release version failed to be decoded with error: %s", err)
+					}
+					if len(releases) != 1 || !proto.Equal(releases[0], release) {
+						t.Errorf("expected the release version %v, got %v", release, releases)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDecodeReleasePayloadWrapped(t *testing.T) {
+	release := &rls.Release{Name: "rel", Version: 1}
+	// Some encoders wrap the lines of the base64 encoding, and the payloads copied by scripts can have
+	// trailing line breaks
+	encoded := string(releasePayload(t, release, true, 1))
+	wrapped := []string{}
+	for len(encoded) > 8 {
+		wrapped = append(wrapped, encoded[:8])
+		encoded = encoded[8:]
+	}
+	wrapped = append(wrapped, encoded)
+	for name, payload := range map[string][]byte{
+		"line wrapped":   []byte(strings.Join(wrapped, "\n")),
+		"trailing space": append(releasePayload(t, release, true, 2), '\n', '\n'),
+		"gzip only":      releasePayload(t, release, true, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := decodeReleasePayload(payload)
+			if err != nil {
+				t.Fatalf("release failed to be decoded with error: %s", err)
+			}
+			if !proto.Equal(decoded, release) {
+				t.Errorf("expected the release %v, got %v", release, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeReleasePayloadErrors(t *testing.T) {
+	release := &rls.Release{Name: "rel", Version: 1}
+	tests := []struct {
+		name    string
+		payload []byte
+		message string
+	}{
+		{name: "empty", payload: []byte(" \n"), message: "empty payload"},
+		{name: "not a release", payload: []byte(base64.StdEncoding.EncodeToString([]byte("not a release"))), message: "payload is base64 encoded but doesn't hold a protobuf release"},
+		{name: "truncated gzip", payload: []byte(base64.StdEncoding.EncodeToString(releasePayload(t, release, true, 0)[:12])), message: "payload is base64 encoded, then gzip compressed but can't be decompressed"},
+		{name: "too many layers", payload: releasePayload(t, release, true, 5), message: "payload is base64 encoded 5 times, over the 4 layers"},
+	}
+	for _, storage := range []string{"configmaps", "secrets"} {
+		object := map[string]string{"configmaps": "ConfigMap", "secrets": "Secret"}[storage]
+		for _, test := range tests {
+			t.Run(storage+"/"+test.name, func(t *testing.T) {
+				client := newRecordingClientset(storageObject(storage, "rel", 1, test.payload))
+				retOpts := outClusterRetrieveOptions("rel")
+				retOpts.StorageType = storage
+
+				_, err := GetReleaseVersions(context.Background(), retOpts, common.KubeConfig{Client: client})
+				var corruptErr *CorruptRecordsError
+				if !errors.As(err, &corruptErr) {
+					t.Fatalf("expected a CorruptRecordsError, got %v", err)
+				}
+				if expected := object + ` "rel.v1" in namespace "kube-system"`; !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected the error to name the %s, as %s, got %q", object, expected, err)
+				}
+				if !strings.Contains(err.Error(), test.message) {
+					t.Errorf("expected the error to describe the payload, as %q, got %q", test.message, err)
+				}
+			})
+		}
+	}
+}
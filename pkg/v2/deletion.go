@@ -0,0 +1,73 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The propagation policies of the deletions of the Kubernetes objects
+const (
+	PropagationBackground = "background"
+	PropagationForeground = "foreground"
+	PropagationOrphan     = "orphan"
+)
+
+// ObjectDeletion is the propagation policy and grace period of the deletions of the Kubernetes objects:
+// the storage objects of the release versions, and the objects of Tiller
+type ObjectDeletion struct {
+	// Propagation is how the dependents of the objects are deleted: background, foreground or orphan.
+	// The default of the deletion is used when it is not set.
+	Propagation string
+	// GracePeriod is the number of seconds the objects are given to terminate. The default of the
+	// objects is used when it is nil.
+	GracePeriod *int64
+}
+
+// ValidateObjectDeletion checks the propagation policy and grace period of the deletions
+func ValidateObjectDeletion(deletion ObjectDeletion) error {
+	switch deletion.Propagation {
+	case "", PropagationBackground, PropagationForeground, PropagationOrphan:
+	default:
+		return fmt.Errorf("propagation policy \"%s\" is not supported. It can be '%s', '%s' or '%s'", deletion.Propagation, PropagationBackground, PropagationForeground, PropagationOrphan)
+	}
+	if deletion.GracePeriod != nil && *deletion.GracePeriod < 0 {
+		return fmt.Errorf("grace period %d can not be negative", *deletion.GracePeriod)
+	}
+	return nil
+}
+
+// deleteOptions returns the delete options of the API requests, with the propagation policy of the
+// deletion, or the default policy when it is not set. No policy is set when neither is.
+func (deletion ObjectDeletion) deleteOptions(defaultPropagation metav1.DeletionPropagation) metav1.DeleteOptions {
+	propagation := defaultPropagation
+	switch deletion.Propagation {
+	case PropagationBackground:
+		propagation = metav1.DeletePropagationBackground
+	case PropagationForeground:
+		propagation = metav1.DeletePropagationForeground
+	case PropagationOrphan:
+		propagation = metav1.DeletePropagationOrphan
+	}
+	options := metav1.DeleteOptions{GracePeriodSeconds: deletion.GracePeriod}
+	if propagation != "" {
+		options.PropagationPolicy = &propagation
+	}
+	return options
+}
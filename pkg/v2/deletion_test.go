@@ -0,0 +1,362 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	appsv1client "k8s.io/client-go/kubernetes/typed/apps/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// recordedRequest is a request sent to the recording clientset, with its options
+type recordedRequest struct {
+	verb          string
+	resource      string
+	name          string
+	listOptions   metav1.ListOptions
+	deleteOptions metav1.DeleteOptions
+}
+
+// recordingClientset is a fake clientset which records the options of the List and Delete requests of
+// the ConfigMaps, Secrets, Services and Deployments, as the fake clientset doesn't keep them. The
+// ConfigMaps and Secrets are listed in chunks as per the limit, and deleted as collections, as the
+// API server does.
+type recordingClientset struct {
+	*fake.Clientset
+	mu       sync.Mutex
+	recorded []recordedRequest
+}
+
+func newRecordingClientset(objects ...runtime.Object) *recordingClientset {
+	return &recordingClientset{Clientset: fake.NewSimpleClientset(objects...)}
+}
+
+func (c *recordingClientset) CoreV1() corev1client.CoreV1Interface {
+	return recordingCoreV1{c.Clientset.CoreV1(), c}
+}
+
+func (c *recordingClientset) AppsV1() appsv1client.AppsV1Interface {
+	return recordingAppsV1{c.Clientset.AppsV1(), c}
+}
+
+func (c *recordingClientset) record(request recordedRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorded = append(c.recorded, request)
+}
+
+// requests returns the requests of the verb sent for the resource, in order
+func (c *recordingClientset) requests(verb, resource string) []recordedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := []recordedRequest{}
+	for _, request := range c.recorded {
+		if request.verb == verb && request.resource == resource {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// page returns the bounds of the chunk of the items the list options select, and the continue token
+// of the next chunk if any
+func page(count int, listOptions metav1.ListOptions) (int, int, string) {
+	start, _ := strconv.Atoi(listOptions.Continue)
+	if start > count {
+		start = count
+	}
+	if listOptions.Limit <= 0 || start+int(listOptions.Limit) >= count {
+		return start, count, ""
+	}
+	end := start + int(listOptions.Limit)
+	return start, end, strconv.Itoa(end)
+}
+
+type recordingCoreV1 struct {
+	corev1client.CoreV1Interface
+	clientset *recordingClientset
+}
+
+func (c recordingCoreV1) ConfigMaps(namespace string) corev1client.ConfigMapInterface {
+	return recordingConfigMaps{c.CoreV1Interface.ConfigMaps(namespace), c.clientset}
+}
+
+func (c recordingCoreV1) Secrets(namespace string) corev1client.SecretInterface {
+	return recordingSecrets{c.CoreV1Interface.Secrets(namespace), c.clientset}
+}
+
+func (c recordingCoreV1) Services(namespace string) corev1client.ServiceInterface {
+	return recordingServices{c.CoreV1Interface.Services(namespace), c.clientset}
+}
+
+type recordingConfigMaps struct {
+	corev1client.ConfigMapInterface
+	clientset *recordingClientset
+}
+
+func (c recordingConfigMaps) List(ctx context.Context, opts metav1.ListOptions) (*corev1.ConfigMapList, error) {
+	c.clientset.record(recordedRequest{verb: "list", resource: "configmaps", listOptions: opts})
+	list, err := c.ConfigMapInterface.List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	start, end, next := page(len(list.Items), opts)
+	list.Items = list.Items[start:end]
+	list.Continue = next
+	return list, nil
+}
+
+func (c recordingConfigMaps) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	c.clientset.record(recordedRequest{verb: "delete", resource: "configmaps", name: name, deleteOptions: opts})
+	return c.ConfigMapInterface.Delete(ctx, name, opts)
+}
+
+func (c recordingConfigMaps) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	c.clientset.record(recordedRequest{verb: "deletecollection", resource: "configmaps", listOptions: listOpts, deleteOptions: opts})
+	list, err := c.ConfigMapInterface.List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		if err := c.ConfigMapInterface.Delete(ctx, item.Name, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type recordingSecrets struct {
+	corev1client.SecretInterface
+	clientset *recordingClientset
+}
+
+func (c recordingSecrets) List(ctx context.Context, opts metav1.ListOptions) (*corev1.SecretList, error) {
+	c.clientset.record(recordedRequest{verb: "list", resource: "secrets", listOptions: opts})
+	list, err := c.SecretInterface.List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	start, end, next := page(len(list.Items), opts)
+	list.Items = list.Items[start:end]
+	list.Continue = next
+	return list, nil
+}
+
+func (c recordingSecrets) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	c.clientset.record(recordedRequest{verb: "delete", resource: "secrets", name: name, deleteOptions: opts})
+	return c.SecretInterface.Delete(ctx, name, opts)
+}
+
+type recordingServices struct {
+	corev1client.ServiceInterface
+	clientset *recordingClientset
+}
+
+func (c recordingServices) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	c.clientset.record(recordedRequest{verb: "delete", resource: "services", name: name, deleteOptions: opts})
+	return c.ServiceInterface.Delete(ctx, name, opts)
+}
+
+type recordingAppsV1 struct {
+	appsv1client.AppsV1Interface
+	clientset *recordingClientset
+}
+
+func (c recordingAppsV1) Deployments(namespace string) appsv1client.DeploymentInterface {
+	return recordingDeployments{c.AppsV1Interface.Deployments(namespace), c.clientset}
+}
+
+type recordingDeployments struct {
+	appsv1client.DeploymentInterface
+	clientset *recordingClientset
+}
+
+func (c recordingDeployments) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	c.clientset.record(recordedRequest{verb: "delete", resource: "deployments", name: name, deleteOptions: opts})
+	return c.DeploymentInterface.Delete(ctx, name, opts)
+}
+
+// encodeRelease encodes the release as Tiller stores it in a ConfigMap: gzip compressed and base64 encoded
+func encodeRelease(t *testing.T, release *rls.Release) string {
+	t.Helper()
+	data, err := proto.Marshal(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(compressed.Bytes())
+}
+
+// releaseConfigMap returns the ConfigMap Tiller stores the version of the release in, in the
+// "kube-system" namespace
+func releaseConfigMap(t *testing.T, name string, version int32) *corev1.ConfigMap {
+	t.Helper()
+	release := &rls.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   version,
+		Info:      &rls.Info{Status: &rls.Status{Code: rls.Status_DEPLOYED}, Description: "Install complete"},
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetReleaseVersionName(name, version),
+			Namespace: "kube-system",
+			Labels:    map[string]string{"NAME": name, "OWNER": "TILLER", "STATUS": "DEPLOYED", "VERSION": strconv.Itoa(int(version))},
+		},
+		Data: map[string]string{"release": encodeRelease(t, release)},
+	}
+}
+
+// outClusterRetrieveOptions returns the options of the retrieval of the release from the ConfigMaps of
+// Tiller out of the cluster
+func outClusterRetrieveOptions(releaseName string) RetrieveOptions {
+	return RetrieveOptions{
+		Logger:           common.NewLogger(""),
+		ReleaseName:      releaseName,
+		StorageType:      "configmaps",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+}
+
+// checkDeleteOptions checks the propagation policy and grace period of the delete options of the requests
+func checkDeleteOptions(t *testing.T, requests []recordedRequest, propagation *metav1.DeletionPropagation, gracePeriod *int64) {
+	t.Helper()
+	if len(requests) == 0 {
+		t.Fatal("expected delete requests, got none")
+	}
+	for _, request := range requests {
+		options := request.deleteOptions
+		if (options.PropagationPolicy == nil) != (propagation == nil) || (propagation != nil && *options.PropagationPolicy != *propagation) {
+			t.Errorf("expected propagation policy %v of the delete of %s \"%s\", got %v", describePointer(propagation), request.resource, request.name, describePointer(options.PropagationPolicy))
+		}
+		if (options.GracePeriodSeconds == nil) != (gracePeriod == nil) || (gracePeriod != nil && *options.GracePeriodSeconds != *gracePeriod) {
+			t.Errorf("expected grace period %v of the delete of %s \"%s\", got %v", describePointer(gracePeriod), request.resource, request.name, describePointer(options.GracePeriodSeconds))
+		}
+	}
+}
+
+// describePointer returns the value a pointer points to, or "unset" when it is nil
+func describePointer(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *metav1.DeletionPropagation:
+		if value != nil {
+			return *value
+		}
+	case *int64:
+		if value != nil {
+			return *value
+		}
+	}
+	return "unset"
+}
+
+func TestDeleteReleaseVersionsObjectDeletion(t *testing.T) {
+	foreground := metav1.DeletePropagationForeground
+	gracePeriod := int64(30)
+	zero := int64(0)
+	tests := []struct {
+		name        string
+		deletion    ObjectDeletion
+		propagation *metav1.DeletionPropagation
+		gracePeriod *int64
+	}{
+		{name: "defaults", deletion: ObjectDeletion{}},
+		{name: "foreground with grace period", deletion: ObjectDeletion{Propagation: PropagationForeground, GracePeriod: &gracePeriod}, propagation: &foreground, gracePeriod: &gracePeriod},
+		{name: "zero grace period", deletion: ObjectDeletion{GracePeriod: &zero}, gracePeriod: &zero},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newRecordingClientset(releaseConfigMap(t, "rel", 1), releaseConfigMap(t, "rel", 2), releaseConfigMap(t, "other", 1))
+			kubeConfig := common.KubeConfig{Client: client}
+
+			delOpts := DeleteOptions{Logger: common.NewLogger(""), ObjectDeletion: test.deletion, Versions: []int32{1, 2}}
+			if _, err := DeleteReleaseVersions(context.Background(), outClusterRetrieveOptions("rel"), delOpts, kubeConfig); err != nil {
+				t.Fatalf("release versions failed to be deleted with error: %s", err)
+			}
+			checkDeleteOptions(t, client.requests("delete", "configmaps"), test.propagation, test.gracePeriod)
+
+			delOpts = DeleteOptions{Logger: common.NewLogger(""), ObjectDeletion: test.deletion}
+			if _, err := DeleteAllReleaseVersions(context.Background(), outClusterRetrieveOptions(""), delOpts, kubeConfig); err != nil {
+				t.Fatalf("release versions failed to be deleted with error: %s", err)
+			}
+			checkDeleteOptions(t, client.requests("deletecollection", "configmaps"), test.propagation, test.gracePeriod)
+		})
+	}
+}
+
+func TestRemoveTillerObjectDeletion(t *testing.T) {
+	background := metav1.DeletePropagationBackground
+	orphan := metav1.DeletePropagationOrphan
+	gracePeriod := int64(10)
+	tests := []struct {
+		name                string
+		deletion            ObjectDeletion
+		workloadPropagation *metav1.DeletionPropagation
+		objectPropagation   *metav1.DeletionPropagation
+		gracePeriod         *int64
+	}{
+		{name: "defaults", deletion: ObjectDeletion{}, workloadPropagation: &background},
+		{name: "orphan with grace period", deletion: ObjectDeletion{Propagation: PropagationOrphan, GracePeriod: &gracePeriod}, workloadPropagation: &orphan, objectPropagation: &orphan, gracePeriod: &gracePeriod},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tillerLabels := map[string]string{"app": "helm", "name": "tiller"}
+			client := newRecordingClientset(
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "tiller-deploy", Namespace: "kube-system", Labels: tillerLabels}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "tiller-deploy", Namespace: "kube-system", Labels: tillerLabels}},
+			)
+			tillerOpts := RemoveTillerOptions{Logger: common.NewLogger(""), ObjectDeletion: test.deletion, TillerNamespace: "kube-system"}
+
+			found, err := RemoveTiller(context.Background(), tillerOpts, common.KubeConfig{Client: client})
+			if err != nil {
+				t.Fatalf("Tiller failed to be removed with error: %s", err)
+			}
+			if !found {
+				t.Fatal("expected the Tiller Deployment to be found")
+			}
+			checkDeleteOptions(t, client.requests("delete", "deployments"), test.workloadPropagation, test.gracePeriod)
+			checkDeleteOptions(t, client.requests("delete", "services"), test.objectPropagation, test.gracePeriod)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// A local storage directory holds the release version records exported from Tiller, one file per
+// release version named as the release version (<release>.v<version>). Each file contains the
+// release as encoded by Tiller in the "release" key of the ConfigMap or Secret storing it.
+// The labels Tiller sets on the storage objects are taken from the decoded release.
+
+// getDirReleaseRecords returns the records of the release versions in the local storage directory
+// whose labels match the Tiller label of the options, and the records of the files named as release
+// versions whose release can't be decoded, their labels being taken from the file name
+func getDirReleaseRecords(retOpts RetrieveOptions) ([]ReleaseRecord, []CorruptRecord, error) {
+	selector, err := labels.Parse(retOpts.TillerLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := ioutil.ReadDir(retOpts.StorageDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the Tiller storage directory \"%s\" with error: %s", retOpts.StorageDir, err)
+	}
+
+	var records []ReleaseRecord
+	var corrupt []CorruptRecord
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(retOpts.StorageDir, file.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		release, err := decodeRelease(string(data))
+		if err != nil {
+			name, version, ok := parseReleaseVersionName(file.Name())
+			recordLabels := map[string]string{
+				"NAME":    name,
+				"OWNER":   "TILLER",
+				"VERSION": strconv.Itoa(int(version)),
+			}
+			if ok && selector.Matches(labels.Set(recordLabels)) {
+				corrupt = append(corrupt, newCorruptRecord(file.Name(), "", "dir", recordLabels, err))
+			}
+			continue
+		}
+		if GetReleaseVersionName(release.Name, release.Version) != file.Name() {
+			continue
+		}
+		recordLabels := map[string]string{
+			"NAME":    release.Name,
+			"OWNER":   "TILLER",
+			"VERSION": strconv.Itoa(int(release.Version)),
+		}
+		if release.Info != nil && release.Info.Status != nil {
+			recordLabels["STATUS"] = release.Info.Status.Code.String()
+		}
+		if !selector.Matches(labels.Set(recordLabels)) {
+			continue
+		}
+		records = append(records, ReleaseRecord{
+			Data:    string(data),
+			Labels:  recordLabels,
+			Name:    file.Name(),
+			Release: release,
+			Storage: "dir",
+		})
+	}
+	return records, corrupt, nil
+}
+
+// createDirReleaseRecord writes the file of a release version record in the local storage directory.
+// An existing file of the same name is only replaced if overwrite is set.
+func createDirReleaseRecord(retOpts RetrieveOptions, record ReleaseRecord, overwrite bool) error {
+	if err := os.MkdirAll(retOpts.StorageDir, 0700); err != nil {
+		return err
+	}
+	file := filepath.Join(retOpts.StorageDir, record.Name)
+	if _, err := os.Stat(file); err == nil && !overwrite {
+		return ErrReleaseRecordExists
+	}
+	return ioutil.WriteFile(file, []byte(record.Data), 0600)
+}
+
+// deleteDirRelease deletes the file of a release version from the local storage directory
+func deleteDirRelease(retOpts RetrieveOptions, releaseVersionName string) error {
+	err := os.Remove(filepath.Join(retOpts.StorageDir, releaseVersionName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: release version \"%s\" not found in the Tiller storage directory \"%s\"", ErrNoVersionsFound, releaseVersionName, retOpts.StorageDir)
+	}
+	return err
+}
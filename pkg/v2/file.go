@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// An export file holds the ConfigMaps or Secrets storing release versions, as output in YAML or JSON
+// by 'kubectl get configmaps -l OWNER=TILLER -o yaml' (or secrets): either a List of the objects or
+// a single object.
+
+// exportObject holds the kind of an object of an export file, and its items when it is a List
+type exportObject struct {
+	Kind  string            `json:"kind"`
+	Items []json.RawMessage `json:"items"`
+}
+
+// ReadExportFile returns the records of the release versions in the export file. An error is
+// returned for an object which is not a ConfigMap nor a Secret, or which doesn't carry the OWNER,
+// NAME and VERSION labels Tiller sets, matching the release it stores.
+func ReadExportFile(file string) ([]ReleaseRecord, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export file \"%s\" with error: %s", file, err)
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse export file \"%s\" with error: %s", file, err)
+	}
+	var object exportObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to parse export file \"%s\" with error: %s", file, err)
+	}
+	items := object.Items
+	if object.Kind != "List" && object.Kind != "ConfigMapList" && object.Kind != "SecretList" {
+		items = []json.RawMessage{data}
+	}
+
+	records := []ReleaseRecord{}
+	for i, item := range items {
+		record, err := readExportObject(item)
+		if err != nil {
+			return nil, fmt.Errorf("object %d of export file \"%s\" is invalid: %w", i+1, file, err)
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func readExportObject(item json.RawMessage) (*ReleaseRecord, error) {
+	var object exportObject
+	if err := json.Unmarshal(item, &object); err != nil {
+		return nil, err
+	}
+
+	var name, namespace, data, storage string
+	var objectLabels map[string]string
+	switch object.Kind {
+	case "ConfigMap":
+		var configMap corev1.ConfigMap
+		if err := json.Unmarshal(item, &configMap); err != nil {
+			return nil, err
+		}
+		name, namespace, data, objectLabels, storage = configMap.Name, configMap.Namespace, configMap.Data["release"], configMap.Labels, "configmaps"
+	case "Secret":
+		var secret corev1.Secret
+		if err := json.Unmarshal(item, &secret); err != nil {
+			return nil, err
+		}
+		name, namespace, data, objectLabels, storage = secret.Name, secret.Namespace, string(secret.Data["release"]), secret.Labels, "secrets"
+	default:
+		return nil, fmt.Errorf("kind \"%s\" is not supported. It can be ConfigMap or Secret", object.Kind)
+	}
+
+	for _, label := range []string{"OWNER", "NAME", "VERSION"} {
+		if objectLabels[label] == "" {
+			return nil, fmt.Errorf("%s \"%s\" doesn't have the %s label Tiller sets on the release version storage objects", object.Kind, name, label)
+		}
+	}
+	release, err := decodeRelease(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: release of %s \"%s\" in namespace \"%s\": %s", ErrCorruptRecord, object.Kind, name, namespace, err)
+	}
+	if objectLabels["NAME"] != release.Name || objectLabels["VERSION"] != strconv.Itoa(int(release.Version)) {
+		return nil, fmt.Errorf("labels of %s \"%s\" don't match its release \"%s\"", object.Kind, name, GetReleaseVersionName(release.Name, release.Version))
+	}
+	return &ReleaseRecord{
+		Data:    data,
+		Labels:  objectLabels,
+		Name:    name,
+		Release: release,
+		Storage: storage,
+	}, nil
+}
+
+// getFileReleaseRecords returns the records of the release versions in the export file whose
+// labels match the Tiller label of the options
+func getFileReleaseRecords(retOpts RetrieveOptions) ([]ReleaseRecord, error) {
+	selector, err := labels.Parse(retOpts.TillerLabel)
+	if err != nil {
+		return nil, err
+	}
+	all, err := ReadExportFile(retOpts.File)
+	if err != nil {
+		return nil, err
+	}
+	var records []ReleaseRecord
+	for _, record := range all {
+		if selector.Matches(labels.Set(record.Labels)) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,230 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// listChunkSize is the number of storage objects listed per request when all releases are listed
+const listChunkSize = 500
+
+// ReleaseSummary describes a Helm v2 release as per its latest version
+type ReleaseSummary struct {
+	Name      string `json:"name"`
+	Version   int32  `json:"version"`
+	Versions  int    `json:"versions"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Chart     string `json:"chart"`
+	// Corrupt is the number of the storage objects of the release whose release can't be decoded
+	Corrupt int `json:"corrupt,omitempty"`
+}
+
+// ReleaseList is a page of Helm v2 release summaries
+type ReleaseList struct {
+	Releases []ReleaseSummary
+	// Continue is the token to pass in the retrieve options to list the next page. It is empty
+	// when there are no more storage objects to list.
+	Continue string
+}
+
+// ListReleases returns the summaries of the Helm v2 releases in storage, sorted by name.
+// It is based on Tiller namespace and labels like owner of storage.
+//
+// When the limit of the retrieve options is set, at most that many storage objects are listed,
+// starting from the continue token, and the token of the next page is returned. As the versions of
+// a release can span pages, each summary of a page describes the latest version in that page.
+// Otherwise all storage objects are listed, in chunks. Only the summaries are kept in memory, not
+// the release versions, so memory stays flat however many release versions are stored.
+// The SQL storage of Tiller, the local storage directory and export files are not paged, all of
+// their release versions being listed in one page.
+func ListReleases(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) (*ReleaseList, error) {
+	if retOpts.TillerNamespace == "" {
+		retOpts.TillerNamespace = "kube-system"
+	}
+	if retOpts.TillerLabel == "" {
+		retOpts.TillerLabel = "OWNER=TILLER"
+	}
+	if retOpts.StorageType == "" {
+		retOpts.StorageType = "configmaps"
+	}
+	// The storage is detected with the Tiller label only, so that it is the same for all releases
+	storage, err := getStorageType(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if retOpts.ReleaseName != "" {
+		retOpts.TillerLabel += fmt.Sprintf(",NAME=%s", retOpts.ReleaseName)
+	}
+	if retOpts.Selector != "" {
+		retOpts.TillerLabel += "," + retOpts.Selector
+	}
+	// Only the storage objects of Tiller are listed from the cluster, so that the other storages are
+	// listed without cluster access
+	var clientSet kubernetes.Interface
+	if storage == "secrets" || storage == "configmaps" {
+		if clientSet, err = kubeConfig.ClientSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	summaries := map[string]*ReleaseSummary{}
+	getSummary := func(name string) *ReleaseSummary {
+		summary, ok := summaries[name]
+		if !ok {
+			summary = &ReleaseSummary{Name: name}
+			summaries[name] = summary
+		}
+		return summary
+	}
+	countCorrupt := func(corrupt []CorruptRecord) {
+		for _, record := range corrupt {
+			if record.ReleaseName != "" {
+				getSummary(record.ReleaseName).Corrupt++
+			}
+		}
+	}
+	summarize := func(data string, objectMeta metav1.ObjectMeta) {
+		release, err := decodeRelease(data)
+		if err != nil {
+			countCorrupt([]CorruptRecord{newCorruptRecord(objectMeta.Name, retOpts.TillerNamespace, storage, objectMeta.Labels, err)})
+			return
+		}
+		summary := getSummary(release.Name)
+		summary.Versions++
+		if release.Version > summary.Version {
+			setReleaseSummary(summary, release)
+		}
+	}
+
+	limit := retOpts.Limit
+	if limit <= 0 {
+		limit = listChunkSize
+	}
+	cont := retOpts.Continue
+	for {
+		var next string
+		switch storage {
+		case "secrets", "configmaps":
+			next, err = forEachStorageObject(ctx, clientSet, retOpts, storage, limit, cont, summarize)
+			if err != nil {
+				return nil, err
+			}
+		case "sql":
+			records, corrupt, err := getSQLReleaseRecords(ctx, retOpts)
+			if err != nil {
+				return nil, err
+			}
+			for _, record := range records {
+				summarize(record.Data, metav1.ObjectMeta{Name: record.Name, Labels: record.Labels})
+			}
+			countCorrupt(corrupt)
+		case "dir", "file":
+			var records []ReleaseRecord
+			var corrupt []CorruptRecord
+			if storage == "dir" {
+				records, corrupt, err = getDirReleaseRecords(retOpts)
+			} else {
+				records, err = getFileReleaseRecords(retOpts)
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, record := range records {
+				summarize(record.Data, metav1.ObjectMeta{Name: record.Name, Labels: record.Labels})
+			}
+			countCorrupt(corrupt)
+		default:
+			return nil, fmt.Errorf("release storage \"%s\" is not supported", storage)
+		}
+		if retOpts.Limit > 0 || next == "" {
+			list := &ReleaseList{
+				Releases: []ReleaseSummary{},
+				Continue: next,
+			}
+			for _, summary := range summaries {
+				list.Releases = append(list.Releases, *summary)
+			}
+			sort.Slice(list.Releases, func(i, j int) bool {
+				return list.Releases[i].Name < list.Releases[j].Name
+			})
+			return list, nil
+		}
+		cont = next
+	}
+}
+
+// forEachStorageObject lists a page of the ConfigMaps or Secrets storage objects of Tiller selected by the
+// Tiller label, at most limit of them starting from the continue token, and calls the function with the
+// release data and metadata of each. The token of the next page is returned, empty when there are no
+// more storage objects to list. The storage objects are filtered by the API server as per their labels,
+// so that the other objects of the Tiller namespace don't have to be listed.
+func forEachStorageObject(ctx context.Context, clientSet kubernetes.Interface, retOpts RetrieveOptions, storage string, limit int64, cont string, fn func(data string, objectMeta metav1.ObjectMeta)) (string, error) {
+	checkObjects := !ownerFiltered(retOpts.TillerLabel)
+	listOptions := metav1.ListOptions{
+		LabelSelector: retOpts.TillerLabel,
+		Limit:         limit,
+		Continue:      cont,
+	}
+	if storage == "secrets" {
+		secrets, err := clientSet.CoreV1().Secrets(retOpts.TillerNamespace).List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range secrets.Items {
+			if _, ok := item.Data["release"]; checkObjects && !isTillerObject(item.ObjectMeta, ok) {
+				continue
+			}
+			fn(string(item.Data["release"]), item.ObjectMeta)
+		}
+		return secrets.Continue, nil
+	}
+	configMaps, err := clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).List(ctx, listOptions)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range configMaps.Items {
+		if _, ok := item.Data["release"]; checkObjects && !isTillerObject(item.ObjectMeta, ok) {
+			continue
+		}
+		fn(item.Data["release"], item.ObjectMeta)
+	}
+	return configMaps.Continue, nil
+}
+
+func setReleaseSummary(summary *ReleaseSummary, release *rls.Release) {
+	summary.Version = release.Version
+	summary.Namespace = release.Namespace
+	summary.Status = ""
+	if release.Info != nil && release.Info.Status != nil {
+		summary.Status = release.Info.Status.Code.String()
+	}
+	summary.Chart = ""
+	if release.Chart != nil && release.Chart.Metadata != nil {
+		summary.Chart = fmt.Sprintf("%s-%s", release.Chart.Metadata.Name, release.Chart.Metadata.Version)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// releaseSecret returns the Secret Tiller stores the version of the release in, in the "kube-system"
+// namespace
+func releaseSecret(t *testing.T, name string, version int32) *corev1.Secret {
+	t.Helper()
+	configMap := releaseConfigMap(t, name, version)
+	return &corev1.Secret{
+		ObjectMeta: configMap.ObjectMeta,
+		Data:       map[string][]byte{"release": []byte(configMap.Data["release"])},
+	}
+}
+
+func TestListReleasesPages(t *testing.T) {
+	// The storage objects are listed sorted by name: a.v1, a.v2, b.v1 and c.v1
+	versions := []struct {
+		name    string
+		version int32
+	}{{"a", 1}, {"a", 2}, {"b", 1}, {"c", 1}}
+	summary := func(name string, version int32, versions int) ReleaseSummary {
+		return ReleaseSummary{Name: name, Version: version, Versions: versions, Namespace: "default", Status: "DEPLOYED"}
+	}
+	tests := []struct {
+		name   string
+		limit  int64
+		token  string
+		pages  [][]ReleaseSummary
+		tokens []string
+	}{
+		{
+			name:   "page smaller than the releases",
+			limit:  3,
+			pages:  [][]ReleaseSummary{{summary("a", 2, 2), summary("b", 1, 1)}, {summary("c", 1, 1)}},
+			tokens: []string{"3", ""},
+		},
+		{
+			name:   "versions of a release split across pages",
+			limit:  1,
+			pages:  [][]ReleaseSummary{{summary("a", 1, 1)}, {summary("a", 2, 1)}, {summary("b", 1, 1)}, {summary("c", 1, 1)}},
+			tokens: []string{"1", "2", "3", ""},
+		},
+		{
+			name:   "continue token passed through",
+			limit:  1,
+			token:  "2",
+			pages:  [][]ReleaseSummary{{summary("b", 1, 1)}, {summary("c", 1, 1)}},
+			tokens: []string{"3", ""},
+		},
+		{
+			name:   "page larger than the releases",
+			limit:  10,
+			pages:  [][]ReleaseSummary{{summary("a", 2, 2), summary("b", 1, 1), summary("c", 1, 1)}},
+			tokens: []string{""},
+		},
+	}
+	for _, storage := range []string{"configmaps", "secrets"} {
+		objects := []runtime.Object{}
+		for _, version := range versions {
+			if storage == "secrets" {
+				objects = append(objects, releaseSecret(t, version.name, version.version))
+			} else {
+				objects = append(objects, releaseConfigMap(t, version.name, version.version))
+			}
+		}
+		for _, test := range tests {
+			t.Run(storage+"/"+test.name, func(t *testing.T) {
+				client := newRecordingClientset(objects...)
+				retOpts := outClusterRetrieveOptions("")
+				retOpts.StorageType = storage
+				retOpts.Limit = test.limit
+				retOpts.Continue = test.token
+
+				pages := [][]ReleaseSummary{}
+				tokens := []string{}
+				for {
+					list, err := ListReleases(context.Background(), retOpts, common.KubeConfig{Client: client})
+					if err != nil {
+						t.Fatalf("releases failed to be listed with error: %s", err)
+					}
+					pages = append(pages, list.Releases)
+					tokens = append(tokens, list.Continue)
+					if list.Continue == "" || len(pages) > len(test.pages) {
+						break
+					}
+					retOpts.Continue = list.Continue
+				}
+				if !reflect.DeepEqual(pages, test.pages) {
+					t.Errorf("expected the pages %+v, got %+v", test.pages, pages)
+				}
+				if !reflect.DeepEqual(tokens, test.tokens) {
+					t.Errorf("expected the continue tokens %q, got %q", test.tokens, tokens)
+				}
+				// Each page is one List request of the limit, from the continue token of the previous page
+				continues := append([]string{test.token}, tokens[:len(tokens)-1]...)
+				requests := client.requests("list", storage)
+				if len(requests) != len(continues) {
+					t.Fatalf("expected %d List requests, got %d", len(continues), len(requests))
+				}
+				for i, request := range requests {
+					expected := metav1.ListOptions{LabelSelector: "OWNER=TILLER", Limit: test.limit, Continue: continues[i]}
+					if !reflect.DeepEqual(request.listOptions, expected) {
+						t.Errorf("expected page %d to be listed with %+v, got %+v", i+1, expected, request.listOptions)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// ErrTillerNamespaceNotFound is returned when the Tiller namespace does not exist in the cluster
+var ErrTillerNamespaceNotFound = errors.New("Tiller namespace not found")
+
+// CheckTillerNamespace returns ErrTillerNamespaceNotFound if the Tiller namespace does not exist in the
+// cluster, so that a typo in its name fails instead of being taken for a namespace without releases. It
+// is not checked when the releases are not stored in the cluster: in a storage directory, or in the SQL
+// storage of a Tiller not running in the cluster. A namespace which can't be read, e.g. when getting the
+// namespaces is not allowed by RBAC, is taken as existing, its releases being read as before.
+func CheckTillerNamespace(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) error {
+	if retOpts.TillerNamespace == "" {
+		retOpts.TillerNamespace = "kube-system"
+	}
+	if retOpts.StorageDir != "" || retOpts.File != "" || (retOpts.TillerOutCluster && retOpts.StorageType == "sql") {
+		return nil
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().Namespaces().Get(ctx, retOpts.TillerNamespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: namespace \"%s\" not found — check --tiller-ns. Set the 'skip-namespace-check' flag if the releases are not stored in it", ErrTillerNamespaceNotFound, retOpts.TillerNamespace)
+	}
+	if err != nil {
+		common.Debugf("Tiller namespace \"%s\" failed to be checked with error: %s", retOpts.TillerNamespace, err)
+	}
+	return nil
+}
@@ -18,12 +18,17 @@ package v2
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	utils "github.com/maorfr/helm-plugin-utils/pkg"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
 	rls "k8s.io/helm/pkg/proto/hapi/release"
 
@@ -31,16 +36,95 @@ import (
 )
 
 type RetrieveOptions struct {
-	ReleaseName      string
+	// File is the export file the release versions are read from, in place of the storage of Tiller
+	File string
+	// Continue and Limit page the storage objects listed by ListReleases
+	Continue    string
+	Limit       int64
+	ReleaseName string
+	Selector    string
+	// SQLConnection is the connection string of the database of the SQL storage of Tiller
+	SQLConnection string
+	// StorageDir is the local directory the release version records exported from Tiller are stored
+	// in, which is used in place of the storage type when Tiller is not running in the cluster
+	StorageDir       string
 	StorageType      string
 	TillerLabel      string
 	TillerNamespace  string
 	TillerOutCluster bool
+	// Logger logs the retrieval and deletion of the release versions, the default logger when not set
+	Logger common.Logger
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (retOpts RetrieveOptions) logger() common.Logger {
+	return common.LoggerOrDefault(retOpts.Logger)
 }
 
 type DeleteOptions struct {
-	DryRun   bool
-	Versions []int32
+	// BatchSize is the number of release versions deleted per batch by DeleteAllReleaseVersions, and
+	// BatchInterval the delay between batches. The release versions are deleted in one batch when the
+	// size is not set.
+	BatchInterval time.Duration
+	BatchSize     int
+	DryRun        bool
+	// Logger logs the deletion of the release versions, the default logger when not set
+	Logger common.Logger
+	// ObjectDeletion is the propagation policy and grace period of the deletions of the ConfigMaps and
+	// Secrets storage objects
+	ObjectDeletion
+	// Progress, if any, is notified of the release versions deleted by DeleteAllReleaseVersions
+	Progress common.Progress
+	// Records are the records of the release versions to delete as retrieved, e.g. by
+	// GetReleaseVersionRecords, in place of the versions. Their storage objects are deleted from the
+	// storage they were retrieved from, without Helm v2 storage being looked up again.
+	Records []ReleaseRecord
+	// Releases are the release versions deleted by DeleteAllReleaseVersions as retrieved, e.g. by
+	// GetAllReleaseVersions, in place of all the release versions of the storage when UseReleases is
+	// set, so that the release versions deleted are the ones which were confirmed. None are deleted
+	// when it is set and there are no releases.
+	Releases    []*rls.Release
+	UseReleases bool
+	Versions    []int32
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (delOpts DeleteOptions) logger() common.Logger {
+	return common.LoggerOrDefault(delOpts.Logger)
+}
+
+// ErrNoVersionsFound is returned when no storage object of Helm v2 storage has the name label of a
+// release, when its release versions are filtered out by the selector, or when a release version to
+// delete is not found in Helm v2 storage
+var ErrNoVersionsFound = errors.New("no release versions found")
+
+// ErrReleaseRecordExists is returned when a release version record is created which already exists in storage
+var ErrReleaseRecordExists = errors.New("release version already exists in storage")
+
+// DeleteError is returned by the deletions of release versions which failed midway, with the release
+// versions which were not deleted
+type DeleteError struct {
+	// Remaining holds the versions not deleted per release name
+	Remaining map[string][]int32
+	Err       error
+}
+
+func (e *DeleteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeleteError) Unwrap() error {
+	return e.Err
+}
+
+// ReleaseRecord is a release version as stored by Tiller in a ConfigMap, Secret or SQL row
+type ReleaseRecord struct {
+	// Data is the release as encoded by Tiller in the storage object
+	Data    string
+	Labels  map[string]string
+	Name    string
+	Release *rls.Release
+	Storage string
 }
 
 // ByReleaseVersion implements sort.Interface based on the rls.Release Version field
@@ -52,41 +136,287 @@ func (releases ByReleaseVersion) Less(i, j int) bool {
 }
 func (releases ByReleaseVersion) Swap(i, j int) { releases[i], releases[j] = releases[j], releases[i] }
 
+// ValidateSelector checks that the selector used to filter release storage objects
+// is a valid Kubernetes label selector
+func ValidateSelector(selector string) error {
+	if selector == "" {
+		return nil
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return fmt.Errorf("invalid selector \"%s\": %s", selector, err)
+	}
+	return nil
+}
+
 // GetReleaseVersions returns all release versions from Helm v2 storage for a specified release..
+// It is based on Tiller namespace and labels like owner of storage. ErrNoVersionsFound is returned
+// if there are none, or if none match the selector.
+func GetReleaseVersions(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
+	records, err := GetReleaseVersionRecords(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	var releases []*rls.Release
+	for _, record := range records {
+		releases = append(releases, record.Release)
+	}
+	return releases, nil
+}
+
+// GetReleaseVersionRecords returns the storage records of all release versions from Helm v2 storage
+// for a specified release, sorted by version, as per GetReleaseVersions.
+func GetReleaseVersionRecords(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]ReleaseRecord, error) {
+	records, err := getReleaseRecords(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) <= 0 {
+		return nil, noVersionsError(retOpts)
+	}
+	return records, nil
+}
+
+// noVersionsError returns the error of a release which has no release versions in Helm v2 storage
+func noVersionsError(retOpts RetrieveOptions) error {
+	if retOpts.Selector != "" {
+		return fmt.Errorf("%w: release \"%s\" has no release versions matching selector \"%s\"", ErrNoVersionsFound, retOpts.ReleaseName, retOpts.Selector)
+	}
+	return fmt.Errorf("%w: release \"%s\" has no release versions, as no storage object %s has its name", ErrNoVersionsFound, retOpts.ReleaseName, storageLocation(retOpts))
+}
+
+// storageLocation describes where the storage objects of the release versions are looked up in the
+// error messages
+func storageLocation(retOpts RetrieveOptions) string {
+	switch {
+	case retOpts.File != "":
+		return fmt.Sprintf("of the export file \"%s\"", retOpts.File)
+	case retOpts.StorageDir != "":
+		return fmt.Sprintf("of the Tiller storage directory \"%s\"", retOpts.StorageDir)
+	case retOpts.StorageType == "sql":
+		return "of the SQL storage of Tiller"
+	}
+	storage := "ConfigMaps or Secrets"
+	if retOpts.StorageType == "configmaps" || retOpts.StorageType == "secrets" {
+		storage = retOpts.StorageType
+	}
+	label := retOpts.TillerLabel
+	if label == "" {
+		label = "OWNER=TILLER"
+	}
+	return fmt.Sprintf("in the %s of \"%s\" namespace with label \"%s\"", storage, retOpts.TillerNamespace, label)
+}
+
+// GetAllReleaseVersions returns the release versions of all releases from Helm v2 storage,
+// sorted by version, and by release name for the same version. No error is returned if there are no releases.
+// It is based on Tiller namespace and labels like owner of storage.
+func GetAllReleaseVersions(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
+	retOpts.ReleaseName = ""
+	return getReleases(ctx, retOpts, kubeConfig)
+}
+
+// GetReleaseNames returns the names of all releases in Helm v2 storage, sorted by name. The releases
+// of the records which can't be decoded are included, so that their retrieval fails with the records.
 // It is based on Tiller namespace and labels like owner of storage.
-func GetReleaseVersions(retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
-	releases, err := getReleases(retOpts, kubeConfig)
+func GetReleaseNames(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]string, error) {
+	retOpts.ReleaseName = ""
+	records, corrupt, err := listReleaseRecords(ctx, retOpts, kubeConfig)
 	if err != nil {
 		return nil, err
 	}
-	if len(releases) <= 0 {
-		return nil, fmt.Errorf("%s has no deployed releases\n", retOpts.ReleaseName)
+
+	names := []string{}
+	seen := map[string]bool{}
+	addName := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	for _, record := range records {
+		addName(record.Release.Name)
+	}
+	for _, record := range corrupt {
+		addName(record.ReleaseName)
 	}
+	sort.Strings(names)
 
-	return releases, nil
+	return names, nil
+}
+
+// GetReleaseRecords returns the storage records of all release versions from Helm v2 storage,
+// sorted by version, and by release name for the same version. No error is returned if there are no releases.
+// It is based on Tiller namespace and labels like owner of storage.
+func GetReleaseRecords(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]ReleaseRecord, error) {
+	retOpts.ReleaseName = ""
+	return getReleaseRecords(ctx, retOpts, kubeConfig)
+}
+
+// CreateReleaseRecord creates the storage object of a release version record in Helm v2 storage.
+// The record is stored as per the storage type of Tiller, which can differ from the storage it was
+// retrieved from. An existing storage object of the same name is only replaced if overwrite is set.
+// It is based on Tiller namespace and storage type.
+func CreateReleaseRecord(ctx context.Context, retOpts RetrieveOptions, record ReleaseRecord, overwrite bool, kubeConfig common.KubeConfig) (err error) {
+	if retOpts.TillerNamespace == "" {
+		retOpts.TillerNamespace = "kube-system"
+	}
+	if retOpts.StorageType == "" {
+		retOpts.StorageType = "configmaps"
+	}
+	storage := retOpts.StorageType
+	defer func() {
+		common.RecordAudit(storageAuditEntry("create", storage, retOpts, record.Name), err)
+	}()
+	labels := record.Labels
+	if len(labels) == 0 {
+		// The labels Tiller sets on the storage objects
+		labels = map[string]string{
+			"NAME":    record.Release.Name,
+			"OWNER":   "TILLER",
+			"VERSION": strconv.Itoa(int(record.Release.Version)),
+		}
+		if record.Release.Info != nil && record.Release.Info.Status != nil {
+			labels["STATUS"] = record.Release.Info.Status.Code.String()
+		}
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      record.Name,
+		Namespace: retOpts.TillerNamespace,
+		Labels:    labels,
+	}
+
+	storage, err = getStorageType(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return err
+	}
+	switch storage {
+	case "sql":
+		return createSQLReleaseRecord(ctx, retOpts, record, labels, overwrite)
+	case "dir":
+		return createDirReleaseRecord(retOpts, record, overwrite)
+	case "file":
+		return errors.New("release versions can't be created in an export file")
+	}
+	if storage != "secrets" && storage != "configmaps" {
+		return fmt.Errorf("release storage \"%s\" is not supported", storage)
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	err = common.Retry(ctx, fmt.Sprintf("[Helm 2] create of ReleaseVersion \"%s\"", record.Name), func() error {
+		var err error
+		switch storage {
+		case "secrets":
+			secret := &corev1.Secret{
+				ObjectMeta: objectMeta,
+				Data: map[string][]byte{
+					"release": []byte(record.Data),
+				},
+			}
+			_, err = clientSet.CoreV1().Secrets(retOpts.TillerNamespace).Create(ctx, secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) && overwrite {
+				_, err = clientSet.CoreV1().Secrets(retOpts.TillerNamespace).Update(ctx, secret, metav1.UpdateOptions{})
+			}
+		case "configmaps":
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: objectMeta,
+				Data: map[string]string{
+					"release": record.Data,
+				},
+			}
+			_, err = clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).Create(ctx, configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) && overwrite {
+				_, err = clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+			}
+		}
+		return err
+	})
+	if apierrors.IsAlreadyExists(err) {
+		return ErrReleaseRecordExists
+	}
+	return err
+}
 
+// GetStorageType returns the storage type of Helm v2 release data. It is the storage used by Tiller,
+// or the storage type of the options when Tiller is not running in the cluster, detected as per the
+// storage objects of the Tiller namespace when it is StorageAuto. When no Tiller is found in the Tiller
+// namespace, it is the storage of the releases of Tillerless Helm v2 found there, if any.
+func GetStorageType(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) (string, error) {
+	if retOpts.TillerNamespace == "" {
+		retOpts.TillerNamespace = "kube-system"
+	}
+	if retOpts.StorageType == "" {
+		retOpts.StorageType = "configmaps"
+	}
+	return getStorageType(ctx, retOpts, kubeConfig)
 }
 
 // DeleteReleaseVersions deletes all release data from Helm v2 storage for a specified release.
+// It returns the versions deleted, which are the versions deleted before the failure when an error is returned,
+// the error being a DeleteError with the versions not deleted.
 // It is based on Tiller namespace and labels like owner of storage.
-func DeleteReleaseVersions(retOpts RetrieveOptions, delOpts DeleteOptions, kubeConfig common.KubeConfig) error {
-	for _, ver := range delOpts.Versions {
-		relVerName := fmt.Sprintf("%s.v%d", retOpts.ReleaseName, ver)
-		log.Printf("[Helm 2] ReleaseVersion \"%s\" will be deleted.\n", relVerName)
-		if !delOpts.DryRun {
-			if err := deleteRelease(retOpts, relVerName, kubeConfig); err != nil {
-				return fmt.Errorf("[Helm 2] ReleaseVersion \"%s\" failed to delete with error: %s.\n", relVerName, err)
+func DeleteReleaseVersions(ctx context.Context, retOpts RetrieveOptions, delOpts DeleteOptions, kubeConfig common.KubeConfig) ([]int32, error) {
+	deleted := []int32{}
+	versions := delOpts.Versions
+	// The storage objects of the records are deleted by the name and from the storage they were retrieved with
+	records := map[int32]ReleaseRecord{}
+	if len(delOpts.Records) > 0 {
+		versions = []int32{}
+		for _, record := range delOpts.Records {
+			versions = append(versions, record.Release.Version)
+			records[record.Release.Version] = record
+		}
+	}
+	plannedStorage := ""
+	if delOpts.DryRun {
+		plannedStorage = auditStorage(ctx, retOpts, kubeConfig)
+	}
+	for i, ver := range versions {
+		relVerName := GetReleaseVersionName(retOpts.ReleaseName, ver)
+		record, fromRecord := records[ver]
+		if fromRecord && record.Name != "" {
+			relVerName = record.Name
+		}
+		delOpts.logger().Infof("[Helm 2] ReleaseVersion \"%s\" will be deleted.\n", relVerName)
+		if delOpts.DryRun {
+			storage := plannedStorage
+			if fromRecord {
+				storage = record.Storage
+			}
+			auditPlannedDeletion(retOpts, storage, relVerName)
+		} else {
+			var err error
+			if fromRecord {
+				err = deleteReleaseObject(ctx, retOpts, record.Storage, relVerName, delOpts.deleteOptions(""), kubeConfig)
+			} else {
+				err = deleteRelease(ctx, retOpts, relVerName, delOpts.deleteOptions(""), kubeConfig)
+			}
+			if err != nil {
+				return deleted, &DeleteError{
+					Remaining: map[string][]int32{retOpts.ReleaseName: append([]int32{}, versions[i:]...)},
+					Err:       fmt.Errorf("[Helm 2] ReleaseVersion \"%s\" failed to delete with error: %w", relVerName, err),
+				}
 			}
-			log.Printf("[Helm 2] ReleaseVersion \"%s\" deleted.\n", relVerName)
+			delOpts.logger().Infof("[Helm 2] ReleaseVersion \"%s\" deleted.\n", relVerName)
+			deleted = append(deleted, ver)
 		}
 	}
 
-	return nil
+	return deleted, nil
 }
 
-// DeleteReleaseVersions deletes all release data from Helm v2 storage.
+// DeleteAllReleaseVersions deletes all release data from Helm v2 storage.
+// It returns the versions deleted per release name, which are the versions deleted before the failure
+// when an error is returned, the error being a DeleteError with the versions not deleted. The release versions are deleted in batches of the batch size, waiting
+// for the batch interval between batches, and the progress, if any, is notified after each batch.
+// The release versions of a release in a batch are deleted in one request from the ConfigMaps or
+// Secrets storage, unless the deletion of collections is not allowed or supported, in which case
+// they are deleted one by one.
 // It is based on Tiller namespace and labels like owner of storage.
-func DeleteAllReleaseVersions(retOpts RetrieveOptions, kubeConfig common.KubeConfig, dryRun bool) error {
+func DeleteAllReleaseVersions(ctx context.Context, retOpts RetrieveOptions, delOpts DeleteOptions, kubeConfig common.KubeConfig) (map[string][]int32, error) {
+	deleted := map[string][]int32{}
+
 	if retOpts.TillerNamespace == "" {
 		retOpts.TillerNamespace = "kube-system"
 	}
@@ -97,113 +427,383 @@ func DeleteAllReleaseVersions(retOpts RetrieveOptions, kubeConfig common.KubeCon
 		retOpts.StorageType = "configmaps"
 	}
 
-	// Get all release versions stored for that namespace and owner
-	releases, err := getReleases(retOpts, kubeConfig)
-	if err != nil {
-		return err
+	// Get all release versions stored for that namespace and owner, unless retrieved already
+	releases := append([]*rls.Release{}, delOpts.Releases...)
+	if !delOpts.UseReleases {
+		var err error
+		releases, err = getReleases(ctx, retOpts, kubeConfig)
+		if err != nil {
+			return deleted, err
+		}
 	}
 	releaseLen := len(releases)
 	if releaseLen <= 0 {
-		log.Printf("[Helm 2] no deployed releases for namespace: %s, owner: %s\n", retOpts.TillerNamespace, retOpts.TillerLabel)
-		return nil
+		retOpts.logger().Infof("[Helm 2] no deployed releases for namespace: %s, owner: %s\n", retOpts.TillerNamespace, retOpts.TillerLabel)
+		return deleted, nil
 	}
+	// The versions of a release are contiguous, so that a batch holds as few releases as possible
+	sort.SliceStable(releases, func(i, j int) bool {
+		if releases[i].Name != releases[j].Name {
+			return releases[i].Name < releases[j].Name
+		}
+		return releases[i].Version < releases[j].Version
+	})
 
-	// Delete each release version from storage
-	for i := 0; i < releaseLen; i++ {
-		release := releases[i]
-		relVerName := GetReleaseVersionName(release.Name, release.Version)
-		log.Printf("[Helm 2] ReleaseVersion \"%s\" will be deleted.\n", relVerName)
-		if !dryRun {
-			if err := deleteRelease(retOpts, relVerName, kubeConfig); err != nil {
-				return fmt.Errorf("[Helm 2] ReleaseVersion \"%s\" failed to delete with error: %s.\n", relVerName, err)
+	// The release versions not deleted are the ones after the deleted ones, as they are deleted in order
+	failed := func(err error) (map[string][]int32, error) {
+		remaining := map[string][]int32{}
+		for _, release := range releases {
+			if !containsVersion(deleted[release.Name], release.Version) {
+				remaining[release.Name] = append(remaining[release.Name], release.Version)
 			}
-			log.Printf("[Helm 2] ReleaseVersion \"%s\" deleted.\n", relVerName)
 		}
+		return deleted, &DeleteError{Remaining: remaining, Err: err}
 	}
-	return nil
+
+	collection := false
+	plannedStorage := ""
+	if delOpts.DryRun {
+		plannedStorage = auditStorage(ctx, retOpts, kubeConfig)
+	} else {
+		storage, err := getStorageType(ctx, retOpts, kubeConfig)
+		if err != nil {
+			return failed(err)
+		}
+		// Only the objects of Tiller are deleted, so that they are not selected by labels other objects can have
+		collection = (storage == "configmaps" || storage == "secrets") && ownerFiltered(retOpts.TillerLabel)
+		retOpts.StorageType = storage
+	}
+
+	batchSize := delOpts.BatchSize
+	if batchSize <= 0 {
+		batchSize = releaseLen
+	}
+	batches := (releaseLen + batchSize - 1) / batchSize
+	for start := 0; start < releaseLen; start += batchSize {
+		if start > 0 && delOpts.BatchInterval > 0 && !delOpts.DryRun {
+			select {
+			case <-ctx.Done():
+				return failed(ctx.Err())
+			case <-time.After(delOpts.BatchInterval):
+			}
+		}
+		end := start + batchSize
+		if end > releaseLen {
+			end = releaseLen
+		}
+		batch := releases[start:end]
+		for _, release := range batch {
+			delOpts.logger().Infof("[Helm 2] ReleaseVersion \"%s\" will be deleted.\n", GetReleaseVersionName(release.Name, release.Version))
+			if delOpts.DryRun {
+				auditPlannedDeletion(retOpts, plannedStorage, GetReleaseVersionName(release.Name, release.Version))
+			}
+		}
+		if !delOpts.DryRun {
+			// Delete the versions of each release of the batch
+			for i := 0; i < len(batch); {
+				j := i
+				versions := []int32{}
+				for ; j < len(batch) && batch[j].Name == batch[i].Name; j++ {
+					versions = append(versions, batch[j].Version)
+				}
+				releaseName := batch[i].Name
+				i = j
+
+				if collection {
+					err := deleteReleaseCollection(ctx, retOpts, releaseName, versions, delOpts.deleteOptions(""), kubeConfig)
+					if err == nil {
+						deleted[releaseName] = append(deleted[releaseName], versions...)
+						continue
+					}
+					if !apierrors.IsForbidden(err) && !apierrors.IsMethodNotSupported(err) {
+						return failed(fmt.Errorf("[Helm 2] ReleaseVersions of release \"%s\" failed to delete with error: %w", releaseName, err))
+					}
+					delOpts.logger().Infof("[Helm 2] The %s can't be deleted as a collection, so the release versions are deleted one by one: %s\n", retOpts.StorageType, err)
+					collection = false
+				}
+				for _, version := range versions {
+					relVerName := GetReleaseVersionName(releaseName, version)
+					if err := deleteReleaseObject(ctx, retOpts, retOpts.StorageType, relVerName, delOpts.deleteOptions(""), kubeConfig); err != nil {
+						return failed(fmt.Errorf("[Helm 2] ReleaseVersion \"%s\" failed to delete with error: %w", relVerName, err))
+					}
+					deleted[releaseName] = append(deleted[releaseName], version)
+				}
+			}
+			if batches > 1 {
+				delOpts.logger().Infof("[Helm 2] Batch %d/%d: %d ReleaseVersions deleted.\n", start/batchSize+1, batches, len(batch))
+			} else {
+				delOpts.logger().Infof("[Helm 2] %d ReleaseVersions deleted.\n", len(batch))
+			}
+		}
+		last := batch[len(batch)-1]
+		common.UpdateProgress(delOpts.Progress, GetReleaseVersionName(last.Name, last.Version), end, releaseLen)
+	}
+	return deleted, nil
+}
+
+// containsVersion returns true if the version is one of the versions
+func containsVersion(versions []int32, version int32) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
 }
 
-func getReleases(retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
+func getReleases(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]*rls.Release, error) {
+	records, err := getReleaseRecords(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	var releases []*rls.Release
+	for _, record := range records {
+		releases = append(releases, record.Release)
+	}
+	return releases, nil
+}
+
+// getReleaseRecords returns the release version records of Helm v2 storage, sorted by version. The
+// records which can't be decoded fail the retrieval of a release with a CorruptRecordsError, and are
+// skipped with a warning when all releases are retrieved.
+func getReleaseRecords(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]ReleaseRecord, error) {
+	records, corrupt, err := listReleaseRecords(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(corrupt) > 0 && retOpts.ReleaseName != "" {
+		return nil, &CorruptRecordsError{ReleaseName: retOpts.ReleaseName, Records: corrupt}
+	}
+	logCorruptRecords(corrupt, retOpts.logger())
+	return records, nil
+}
+
+// listReleaseRecords returns the release version records of Helm v2 storage, sorted by version and
+// release name, and the records of the storage objects whose release can't be decoded, sorted by
+// storage object name, so that the order doesn't depend on the storage. The Tiller label, the release name
+// and the selector of the options are combined into the label selector the storage objects are listed
+// with.
+func listReleaseRecords(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) ([]ReleaseRecord, []CorruptRecord, error) {
 	if retOpts.TillerNamespace == "" {
 		retOpts.TillerNamespace = "kube-system"
 	}
 	if retOpts.TillerLabel == "" {
 		retOpts.TillerLabel = "OWNER=TILLER"
 	}
+	if retOpts.StorageType == "" {
+		retOpts.StorageType = "configmaps"
+	}
+	// The storage is detected with the Tiller label only, so that it is the same for all releases
+	storage, err := getStorageType(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
 	if retOpts.ReleaseName != "" {
 		retOpts.TillerLabel += fmt.Sprintf(",NAME=%s", retOpts.ReleaseName)
 	}
-	if retOpts.StorageType == "" {
-		retOpts.StorageType = "configmaps"
+	if retOpts.Selector != "" {
+		retOpts.TillerLabel += "," + retOpts.Selector
 	}
-	storage := getStorageType(retOpts, kubeConfig)
-	clientSet := utils.GetClientSetWithKubeConfig(kubeConfig.File, kubeConfig.Context)
-	var releases []*rls.Release
+	var records []ReleaseRecord
+	var corrupt []CorruptRecord
 	switch storage {
-	case "secrets":
-		secrets, err := clientSet.CoreV1().Secrets(retOpts.TillerNamespace).List(context.Background(), metav1.ListOptions{
-			LabelSelector: retOpts.TillerLabel,
-		})
+	case "sql":
+		records, corrupt, err = getSQLReleaseRecords(ctx, retOpts)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		for _, item := range secrets.Items {
-			release := getRelease((string)(item.Data["release"]))
-			if release == nil {
-				continue
-			}
-			releases = append(releases, release)
+	case "dir":
+		records, corrupt, err = getDirReleaseRecords(retOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+	case "file":
+		records, err = getFileReleaseRecords(retOpts)
+		if err != nil {
+			return nil, nil, err
 		}
-	case "configmaps":
-		configMaps, err := clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).List(context.Background(), metav1.ListOptions{
-			LabelSelector: retOpts.TillerLabel,
-		})
+	case "secrets", "configmaps":
+		// Only the storage objects of Tiller are read from the cluster, so that the other storages are
+		// read without cluster access
+		clientSet, err := kubeConfig.ClientSet()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		for _, item := range configMaps.Items {
-			release := getRelease(item.Data["release"])
-			if release == nil {
-				continue
+		appendRecord := func(data string, objectMeta metav1.ObjectMeta) {
+			records, corrupt = appendReleaseRecord(records, corrupt, data, objectMeta, storage, retOpts.TillerNamespace)
+		}
+		// The storage objects are listed in chunks
+		cont := ""
+		for {
+			cont, err = forEachStorageObject(ctx, clientSet, retOpts, storage, listChunkSize, cont, appendRecord)
+			if err != nil {
+				return nil, nil, err
+			}
+			if cont == "" {
+				break
 			}
-			releases = append(releases, release)
 		}
 	}
 
-	sort.Sort(ByReleaseVersion(releases))
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Release.Version != records[j].Release.Version {
+			return records[i].Release.Version < records[j].Release.Version
+		}
+		if records[i].Release.Name != records[j].Release.Name {
+			return records[i].Release.Name < records[j].Release.Name
+		}
+		return records[i].Name < records[j].Name
+	})
+	sort.SliceStable(corrupt, func(i, j int) bool {
+		if corrupt[i].Namespace != corrupt[j].Namespace {
+			return corrupt[i].Namespace < corrupt[j].Namespace
+		}
+		return corrupt[i].Name < corrupt[j].Name
+	})
 
-	return releases, nil
+	return records, corrupt, nil
 }
 
-func getStorageType(retOpts RetrieveOptions, kubeConfig common.KubeConfig) string {
-	var storage string
-	if !retOpts.TillerOutCluster {
-		storage = utils.GetTillerStorageWithKubeConfig(retOpts.TillerNamespace, kubeConfig.File, kubeConfig.Context)
+// appendReleaseRecord appends the record of the release version of a storage object, or its corrupt
+// record if its release can't be decoded
+func appendReleaseRecord(records []ReleaseRecord, corrupt []CorruptRecord, data string, objectMeta metav1.ObjectMeta, storage, namespace string) ([]ReleaseRecord, []CorruptRecord) {
+	release, err := decodeRelease(data)
+	if err != nil {
+		return records, append(corrupt, newCorruptRecord(objectMeta.Name, namespace, storage, objectMeta.Labels, err))
+	}
+	return append(records, ReleaseRecord{
+		Data:    data,
+		Labels:  objectMeta.Labels,
+		Name:    objectMeta.Name,
+		Release: release,
+		Storage: storage,
+	}), corrupt
+}
+
+// getStorageType returns the storage of the releases: the storage of the Tiller Deployment when Tiller
+// is running in the cluster, otherwise the storage type of the options, which is detected when it is
+// StorageAuto.
+func getStorageType(ctx context.Context, retOpts RetrieveOptions, kubeConfig common.KubeConfig) (string, error) {
+	if retOpts.File != "" {
+		return "file", nil
+	}
+	if retOpts.TillerOutCluster {
+		if retOpts.StorageDir != "" {
+			return "dir", nil
+		}
+		if retOpts.StorageType != StorageAuto {
+			return retOpts.StorageType, nil
+		}
+		clientSet, err := kubeConfig.ClientSet()
+		if err != nil {
+			return "", err
+		}
+		return detectStorage(ctx, clientSet, retOpts)
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return "", err
+	}
+	storage, err := getTillerStorage(ctx, clientSet, retOpts.TillerNamespace)
+	if !errors.Is(err, errNoTillerDeployment) {
+		return storage, err
+	}
+	// With no Tiller running, the releases may be stored by Tillerless Helm v2
+	var tillerlessStorage string
+	var tillerlessErr error
+	if retOpts.StorageType == StorageAuto {
+		tillerlessStorage, tillerlessErr = detectReleaseStorage(ctx, clientSet, retOpts)
+		if errors.Is(tillerlessErr, ErrAmbiguousStorage) {
+			return "", tillerlessErr
+		}
 	} else {
-		storage = retOpts.StorageType
+		tillerlessStorage, tillerlessErr = getTillerlessStorage(ctx, clientSet, retOpts)
 	}
-	return storage
+	if tillerlessErr != nil || tillerlessStorage == "" {
+		return "", err
+	}
+	logTillerlessOnce.Do(func() {
+		retOpts.logger().Infof("[Helm 2] No Tiller Deployment found in \"%s\" namespace. The releases are read from the %s of Tillerless Helm v2.\n", retOpts.TillerNamespace, tillerlessStorage)
+	})
+	return tillerlessStorage, nil
 }
 
-func getRelease(itemReleaseData string) *rls.Release {
-	data, _ := utils.DecodeRelease(itemReleaseData)
-	return data
+// deleteReleaseCollection deletes the release versions of a release from the ConfigMaps or Secrets
+// storage in one request, selecting them by the labels Tiller sets
+func deleteReleaseCollection(ctx context.Context, retOpts RetrieveOptions, releaseName string, versions []int32, deleteOptions metav1.DeleteOptions, kubeConfig common.KubeConfig) error {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	values := []string{}
+	for _, version := range versions {
+		values = append(values, strconv.Itoa(int(version)))
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s,NAME=%s,VERSION in (%s)", retOpts.TillerLabel, releaseName, strings.Join(values, ",")),
+	}
+	err = common.Retry(ctx, fmt.Sprintf("[Helm 2] delete of %d ReleaseVersions of release \"%s\"", len(versions), releaseName), func() error {
+		switch retOpts.StorageType {
+		case "secrets":
+			return clientSet.CoreV1().Secrets(retOpts.TillerNamespace).DeleteCollection(ctx, deleteOptions, listOptions)
+		case "configmaps":
+			return clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).DeleteCollection(ctx, deleteOptions, listOptions)
+		}
+		return nil
+	})
+	// The objects deleted in one request are recorded one by one, as the objects deleted one by one
+	for _, version := range versions {
+		common.RecordAudit(storageAuditEntry("delete", retOpts.StorageType, retOpts, GetReleaseVersionName(releaseName, version)), err)
+	}
+	return err
 }
 
-func deleteRelease(retOpts RetrieveOptions, releaseVersionName string, kubeConfig common.KubeConfig) error {
+func deleteRelease(ctx context.Context, retOpts RetrieveOptions, releaseVersionName string, deleteOptions metav1.DeleteOptions, kubeConfig common.KubeConfig) error {
 	if retOpts.TillerNamespace == "" {
 		retOpts.TillerNamespace = "kube-system"
 	}
 	if retOpts.StorageType == "" {
 		retOpts.StorageType = "configmaps"
 	}
-	storage := getStorageType(retOpts, kubeConfig)
-	clientSet := utils.GetClientSetWithKubeConfig(kubeConfig.File, kubeConfig.Context)
+	storage, err := getStorageType(ctx, retOpts, kubeConfig)
+	if err != nil {
+		return err
+	}
+	return deleteReleaseObject(ctx, retOpts, storage, releaseVersionName, deleteOptions, kubeConfig)
+}
+
+// deleteReleaseObject deletes the storage object of a release version from the Helm v2 storage of the type,
+// with the delete options when it is a ConfigMap or Secret
+func deleteReleaseObject(ctx context.Context, retOpts RetrieveOptions, storage, releaseVersionName string, deleteOptions metav1.DeleteOptions, kubeConfig common.KubeConfig) (err error) {
+	if retOpts.TillerNamespace == "" {
+		retOpts.TillerNamespace = "kube-system"
+	}
+	defer func() {
+		common.RecordAudit(storageAuditEntry("delete", storage, retOpts, releaseVersionName), err)
+	}()
 	switch storage {
-	case "secrets":
-		return clientSet.CoreV1().Secrets(retOpts.TillerNamespace).Delete(context.Background(), releaseVersionName, metav1.DeleteOptions{})
-	case "configmaps":
-		return clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).Delete(context.Background(), releaseVersionName, metav1.DeleteOptions{})
+	case "sql":
+		return deleteSQLRelease(ctx, retOpts, releaseVersionName)
+	case "dir":
+		return deleteDirRelease(retOpts, releaseVersionName)
+	case "file":
+		return errors.New("release versions can't be deleted from an export file")
 	}
-	return nil
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	err = common.Retry(ctx, fmt.Sprintf("[Helm 2] delete of ReleaseVersion \"%s\"", releaseVersionName), func() error {
+		switch storage {
+		case "secrets":
+			return clientSet.CoreV1().Secrets(retOpts.TillerNamespace).Delete(ctx, releaseVersionName, deleteOptions)
+		case "configmaps":
+			return clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).Delete(ctx, releaseVersionName, deleteOptions)
+		}
+		return nil
+	})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: release version \"%s\" not found in the %s of \"%s\" namespace", ErrNoVersionsFound, releaseVersionName, storage, retOpts.TillerNamespace)
+	}
+	return err
 }
@@ -0,0 +1,249 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+func TestDeleteReleaseVersionsRecords(t *testing.T) {
+	// A record copied by a script under another name than Tiller's
+	copied := releaseConfigMap(t, "rel", 2)
+	copied.Name = "rel.v2-copy"
+	client := newRecordingClientset(releaseConfigMap(t, "rel", 1), copied)
+	kubeConfig := common.KubeConfig{Client: client}
+
+	records, err := GetReleaseVersionRecords(context.Background(), outClusterRetrieveOptions("rel"), kubeConfig)
+	if err != nil {
+		t.Fatalf("release version records failed to be retrieved with error: %s", err)
+	}
+	lists := len(client.requests("list", "configmaps"))
+
+	delOpts := DeleteOptions{Logger: common.NewLogger(""), Records: records}
+	deleted, err := DeleteReleaseVersions(context.Background(), outClusterRetrieveOptions("rel"), delOpts, kubeConfig)
+	if err != nil {
+		t.Fatalf("release versions failed to be deleted with error: %s", err)
+	}
+	if !reflect.DeepEqual(deleted, []int32{1, 2}) {
+		t.Errorf("expected versions 1 and 2 deleted, got %v", deleted)
+	}
+	if after := len(client.requests("list", "configmaps")); after != lists {
+		t.Errorf("expected no List request once the records are retrieved, got %d", after-lists)
+	}
+	names := []string{}
+	for _, request := range client.requests("delete", "configmaps") {
+		names = append(names, request.name)
+	}
+	if !reflect.DeepEqual(names, []string{"rel.v1", "rel.v2-copy"}) {
+		t.Errorf("expected the storage objects of the records to be deleted, got %v", names)
+	}
+	if _, err := client.Clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "rel.v2-copy", metav1.GetOptions{}); err == nil {
+		t.Error("expected the storage object of the copied record to be deleted")
+	}
+}
+
+func TestGetReleaseRecordsChunks(t *testing.T) {
+	objects := []runtime.Object{}
+	for i := 0; i < 2*listChunkSize+100; i++ {
+		objects = append(objects, releaseConfigMap(t, fmt.Sprintf("rel-%04d", i), 1))
+	}
+	client := newRecordingClientset(objects...)
+	kubeConfig := common.KubeConfig{Client: client}
+
+	records, err := getReleaseRecords(context.Background(), outClusterRetrieveOptions(""), kubeConfig)
+	if err != nil {
+		t.Fatalf("release version records failed to be listed with error: %s", err)
+	}
+	if len(records) != len(objects) {
+		t.Fatalf("expected %d records, got %d", len(objects), len(records))
+	}
+	expected := []metav1.ListOptions{
+		{LabelSelector: "OWNER=TILLER", Limit: listChunkSize},
+		{LabelSelector: "OWNER=TILLER", Limit: listChunkSize, Continue: fmt.Sprint(listChunkSize)},
+		{LabelSelector: "OWNER=TILLER", Limit: listChunkSize, Continue: fmt.Sprint(2 * listChunkSize)},
+	}
+	listOptions := []metav1.ListOptions{}
+	for _, request := range client.requests("list", "configmaps") {
+		listOptions = append(listOptions, request.listOptions)
+	}
+	if !reflect.DeepEqual(listOptions, expected) {
+		t.Errorf("expected the storage objects listed in chunks with %+v, got %+v", expected, listOptions)
+	}
+}
+
+func TestGetReleaseRecordsLabelSelector(t *testing.T) {
+	client := newRecordingClientset(releaseConfigMap(t, "rel", 1), releaseConfigMap(t, "other", 1))
+	retOpts := outClusterRetrieveOptions("rel")
+	retOpts.Selector = "STATUS=DEPLOYED"
+
+	records, err := getReleaseRecords(context.Background(), retOpts, common.KubeConfig{Client: client})
+	if err != nil {
+		t.Fatalf("release version records failed to be listed with error: %s", err)
+	}
+	if len(records) != 1 || records[0].Name != "rel.v1" {
+		t.Errorf("expected the record of rel.v1 only, got %v", records)
+	}
+	requests := client.requests("list", "configmaps")
+	if len(requests) != 1 {
+		t.Fatalf("expected one List request, got %d", len(requests))
+	}
+	expected := metav1.ListOptions{LabelSelector: "OWNER=TILLER,NAME=rel,STATUS=DEPLOYED", Limit: listChunkSize}
+	if !reflect.DeepEqual(requests[0].listOptions, expected) {
+		t.Errorf("expected the storage objects listed with %+v, got %+v", expected, requests[0].listOptions)
+	}
+}
+
+// mixedConfigMaps returns the ConfigMaps of a Tiller namespace shared with other applications: the
+// versions of "rel" written by Tiller, the version of "forked" written without the OWNER label, and
+// ConfigMaps with the NAME and VERSION labels which are not release storage objects
+func mixedConfigMaps(t *testing.T) []runtime.Object {
+	t.Helper()
+	forked := releaseConfigMap(t, "forked", 1)
+	delete(forked.Labels, "OWNER")
+	// The settings of an application named as the release, with no release
+	settings := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rel.v3", Namespace: "kube-system", Labels: map[string]string{"NAME": "rel", "VERSION": "3"}},
+		Data:       map[string]string{"config": "enabled: true"},
+	}
+	// A ConfigMap not named as a release version, whose data can't be decoded
+	dashboard := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rel-dashboard", Namespace: "kube-system", Labels: map[string]string{"NAME": "rel", "VERSION": "1"}},
+		Data:       map[string]string{"release": "not a release"},
+	}
+	// A ConfigMap named as a release version whose labels are of another version
+	mislabelled := releaseConfigMap(t, "rel", 4)
+	mislabelled.Labels = map[string]string{"NAME": "rel", "VERSION": "5"}
+	// A ConfigMap named as a release version with none of the labels Tiller sets
+	unlabelled := releaseConfigMap(t, "rel", 6)
+	unlabelled.Labels = nil
+	return []runtime.Object{releaseConfigMap(t, "rel", 1), releaseConfigMap(t, "rel", 2), forked, settings, dashboard, mislabelled, unlabelled}
+}
+
+func TestGetReleaseVersionsAnyOwner(t *testing.T) {
+	kubeConfig := common.KubeConfig{Client: newRecordingClientset(mixedConfigMaps(t)...)}
+	for _, test := range []struct {
+		name     string
+		label    string
+		release  string
+		versions []int32
+	}{
+		{name: "any owner", label: AnyOwnerLabel, release: "rel", versions: []int32{1, 2}},
+		{name: "any owner without OWNER label", label: AnyOwnerLabel, release: "forked", versions: []int32{1}},
+		{name: "tiller owner", label: "OWNER=TILLER", release: "rel", versions: []int32{1, 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			retOpts := outClusterRetrieveOptions(test.release)
+			retOpts.TillerLabel = test.label
+			releases, err := GetReleaseVersions(context.Background(), retOpts, kubeConfig)
+			if err != nil {
+				t.Fatalf("release versions failed to be retrieved with error: %s", err)
+			}
+			versions := []int32{}
+			for _, release := range releases {
+				versions = append(versions, release.Version)
+			}
+			if !reflect.DeepEqual(versions, test.versions) {
+				t.Errorf("expected the versions %v, got %v", test.versions, versions)
+			}
+		})
+	}
+
+	retOpts := outClusterRetrieveOptions("forked")
+	if _, err := GetReleaseVersions(context.Background(), retOpts, kubeConfig); !errors.Is(err, ErrNoVersionsFound) {
+		t.Errorf("expected ErrNoVersionsFound for the release without OWNER label with the Tiller label of the owner, got %v", err)
+	}
+}
+
+func TestListReleasesAnyOwner(t *testing.T) {
+	kubeConfig := common.KubeConfig{Client: newRecordingClientset(mixedConfigMaps(t)...)}
+	for _, test := range []struct {
+		name     string
+		label    string
+		expected []ReleaseSummary
+	}{
+		{
+			name:  "any owner",
+			label: AnyOwnerLabel,
+			expected: []ReleaseSummary{
+				{Name: "forked", Version: 1, Versions: 1, Namespace: "default", Status: "DEPLOYED"},
+				{Name: "rel", Version: 2, Versions: 2, Namespace: "default", Status: "DEPLOYED"},
+			},
+		},
+		{
+			name:  "tiller owner",
+			label: "OWNER=TILLER",
+			expected: []ReleaseSummary{
+				{Name: "rel", Version: 2, Versions: 2, Namespace: "default", Status: "DEPLOYED"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			retOpts := outClusterRetrieveOptions("")
+			retOpts.TillerLabel = test.label
+			list, err := ListReleases(context.Background(), retOpts, kubeConfig)
+			if err != nil {
+				t.Fatalf("releases failed to be listed with error: %s", err)
+			}
+			if !reflect.DeepEqual(list.Releases, test.expected) {
+				t.Errorf("expected the releases %+v, got %+v", test.expected, list.Releases)
+			}
+		})
+	}
+}
+
+func TestDeleteReleaseVersionsAnyOwner(t *testing.T) {
+	client := newRecordingClientset(mixedConfigMaps(t)...)
+	retOpts := outClusterRetrieveOptions("rel")
+	retOpts.TillerLabel = AnyOwnerLabel
+	kubeConfig := common.KubeConfig{Client: client}
+
+	records, err := GetReleaseVersionRecords(context.Background(), retOpts, kubeConfig)
+	if err != nil {
+		t.Fatalf("release versions failed to be retrieved with error: %s", err)
+	}
+	deleted, err := DeleteReleaseVersions(context.Background(), retOpts, DeleteOptions{Logger: common.NewLogger(""), Records: records}, kubeConfig)
+	if err != nil {
+		t.Fatalf("release versions failed to be deleted with error: %s", err)
+	}
+	if !reflect.DeepEqual(deleted, []int32{1, 2}) {
+		t.Errorf("expected versions 1 and 2 deleted, got %v", deleted)
+	}
+	configMaps, err := client.Clientset.CoreV1().ConfigMaps("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, configMap := range configMaps.Items {
+		names = append(names, configMap.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"forked.v1", "rel-dashboard", "rel.v3", "rel.v4", "rel.v6"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected only the storage objects of the release to be deleted, leaving %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Tiller started with --storage=sql stores each release version as a row of the releases table of a
+// PostgreSQL database, keyed by the release version name. The name, version, status and owner
+// columns are the labels Tiller sets on the ConfigMaps and Secrets storing release versions.
+const (
+	sqlSelectReleases = `SELECT key, body, name, version, status, owner FROM releases`
+	sqlInsertRelease  = `INSERT INTO releases (key, body, name, version, status, owner, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	sqlUpdateRelease  = `UPDATE releases SET body = $2, name = $3, version = $4, status = $5, owner = $6, modified_at = $7 WHERE key = $1`
+	sqlDeleteRelease  = `DELETE FROM releases WHERE key = $1`
+
+	// sqlUniqueViolation is the PostgreSQL error code of a duplicate key
+	sqlUniqueViolation = "23505"
+)
+
+// sqlDriverName is the database/sql driver the SQL storage of Tiller is opened with
+var sqlDriverName = "postgres"
+
+// openSQL opens the database of the SQL storage of Tiller
+func openSQL(retOpts RetrieveOptions) (*sql.DB, error) {
+	if retOpts.SQLConnection == "" {
+		return nil, errors.New("the connection string of the SQL storage of Tiller is not set. Set the 'tiller-sql-connection' flag")
+	}
+	return sql.Open(sqlDriverName, retOpts.SQLConnection)
+}
+
+// getSQLReleaseRecords returns the records of the release versions stored in the SQL storage of
+// Tiller whose labels match the Tiller label of the options, and the records of the rows whose release
+// can't be decoded
+func getSQLReleaseRecords(ctx context.Context, retOpts RetrieveOptions) ([]ReleaseRecord, []CorruptRecord, error) {
+	selector, err := labels.Parse(retOpts.TillerLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := openSQL(retOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	var rows *sql.Rows
+	if retOpts.ReleaseName != "" {
+		rows, err = db.QueryContext(ctx, sqlSelectReleases+` WHERE name = $1`, retOpts.ReleaseName)
+	} else {
+		rows, err = db.QueryContext(ctx, sqlSelectReleases)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query the SQL storage of Tiller with error: %s", err)
+	}
+	defer rows.Close()
+
+	var records []ReleaseRecord
+	var corrupt []CorruptRecord
+	for rows.Next() {
+		var key, body, name, status, owner string
+		var version int
+		if err := rows.Scan(&key, &body, &name, &version, &status, &owner); err != nil {
+			return nil, nil, err
+		}
+		recordLabels := map[string]string{
+			"NAME":    name,
+			"OWNER":   owner,
+			"STATUS":  status,
+			"VERSION": strconv.Itoa(version),
+		}
+		if !selector.Matches(labels.Set(recordLabels)) {
+			continue
+		}
+		release, err := decodeRelease(body)
+		if err != nil {
+			corrupt = append(corrupt, newCorruptRecord(key, "", "sql", recordLabels, err))
+			continue
+		}
+		records = append(records, ReleaseRecord{
+			Data:    body,
+			Labels:  recordLabels,
+			Name:    key,
+			Release: release,
+			Storage: "sql",
+		})
+	}
+	return records, corrupt, rows.Err()
+}
+
+// createSQLReleaseRecord inserts the row of a release version record in the SQL storage of Tiller.
+// An existing row of the same key is only replaced if overwrite is set.
+func createSQLReleaseRecord(ctx context.Context, retOpts RetrieveOptions, record ReleaseRecord, recordLabels map[string]string, overwrite bool) error {
+	db, err := openSQL(retOpts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	owner := recordLabels["OWNER"]
+	if owner == "" {
+		owner = "TILLER"
+	}
+	now := time.Now().Unix()
+	args := []interface{}{record.Name, record.Data, record.Release.Name, record.Release.Version, recordLabels["STATUS"], owner, now}
+	_, err = db.ExecContext(ctx, sqlInsertRelease, args...)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == sqlUniqueViolation {
+		if !overwrite {
+			return ErrReleaseRecordExists
+		}
+		_, err = db.ExecContext(ctx, sqlUpdateRelease, args...)
+	}
+	return err
+}
+
+// deleteSQLRelease deletes the row of a release version from the SQL storage of Tiller
+func deleteSQLRelease(ctx context.Context, retOpts RetrieveOptions, releaseVersionName string) error {
+	db, err := openSQL(retOpts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.ExecContext(ctx, sqlDeleteRelease, releaseVersionName)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: release version \"%s\" not found in the SQL storage of Tiller", ErrNoVersionsFound, releaseVersionName)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"k8s.io/client-go/kubernetes/fake"
+	rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// memorySQLDriverName is the database/sql driver of the in-memory releases tables of the tests
+const memorySQLDriverName = "helm-2to3-memory"
+
+func init() {
+	sql.Register(memorySQLDriverName, memorySQLDriver{})
+}
+
+// sqlRow is a row of the releases table of Tiller
+type sqlRow struct {
+	key, body, name, status, owner string
+	version                        int64
+}
+
+// memorySQLTables are the in-memory releases tables, per connection string, and the queries run on them
+var memorySQLTables = struct {
+	sync.Mutex
+	rows    map[string]map[string]sqlRow
+	queries map[string][]string
+}{rows: map[string]map[string]sqlRow{}, queries: map[string][]string{}}
+
+// memorySQLDriver runs the statements of the SQL storage of Tiller on the in-memory releases tables,
+// as PostgreSQL would
+type memorySQLDriver struct{}
+
+func (memorySQLDriver) Open(dsn string) (driver.Conn, error) {
+	return memorySQLConn{dsn: dsn}, nil
+}
+
+type memorySQLConn struct {
+	dsn string
+}
+
+func (c memorySQLConn) Prepare(query string) (driver.Stmt, error) {
+	return memorySQLStmt{dsn: c.dsn, query: query}, nil
+}
+
+func (c memorySQLConn) Close() error { return nil }
+
+func (c memorySQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type memorySQLStmt struct {
+	dsn, query string
+}
+
+func (s memorySQLStmt) Close() error  { return nil }
+func (s memorySQLStmt) NumInput() int { return -1 }
+
+func (s memorySQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	memorySQLTables.Lock()
+	defer memorySQLTables.Unlock()
+	memorySQLTables.queries[s.dsn] = append(memorySQLTables.queries[s.dsn], s.query)
+	rows := memorySQLTables.rows[s.dsn]
+	if rows == nil {
+		rows = map[string]sqlRow{}
+		memorySQLTables.rows[s.dsn] = rows
+	}
+	key := args[0].(string)
+	switch s.query {
+	case sqlInsertRelease, sqlUpdateRelease:
+		_, exists := rows[key]
+		if s.query == sqlInsertRelease && exists {
+			return nil, &pq.Error{Code: sqlUniqueViolation, Message: "duplicate key value violates unique constraint \"releases_pkey\""}
+		}
+		if s.query == sqlUpdateRelease && !exists {
+			return driver.RowsAffected(0), nil
+		}
+		rows[key] = sqlRow{key: key, body: args[1].(string), name: args[2].(string), version: args[3].(int64), status: args[4].(string), owner: args[5].(string)}
+		return driver.RowsAffected(1), nil
+	case sqlDeleteRelease:
+		if _, exists := rows[key]; !exists {
+			return driver.RowsAffected(0), nil
+		}
+		delete(rows, key)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("statement not supported: %s", s.query)
+}
+
+func (s memorySQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	memorySQLTables.Lock()
+	defer memorySQLTables.Unlock()
+	memorySQLTables.queries[s.dsn] = append(memorySQLTables.queries[s.dsn], s.query)
+	var name string
+	switch s.query {
+	case sqlSelectReleases:
+	case sqlSelectReleases + ` WHERE name = $1`:
+		name = args[0].(string)
+	default:
+		return nil, fmt.Errorf("query not supported: %s", s.query)
+	}
+	result := &memorySQLRows{}
+	for _, row := range memorySQLTables.rows[s.dsn] {
+		if name == "" || row.name == name {
+			result.rows = append(result.rows, row)
+		}
+	}
+	sort.Slice(result.rows, func(i, j int) bool { return result.rows[i].key < result.rows[j].key })
+	return result, nil
+}
+
+type memorySQLRows struct {
+	rows []sqlRow
+}
+
+func (r *memorySQLRows) Columns() []string {
+	return []string{"key", "body", "name", "version", "status", "owner"}
+}
+
+func (r *memorySQLRows) Close() error { return nil }
+
+func (r *memorySQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	dest[0], dest[1], dest[2], dest[3], dest[4], dest[5] = row.key, row.body, row.name, row.version, row.status, row.owner
+	return nil
+}
+
+// useMemorySQL opens the SQL storage of Tiller with the in-memory driver, with a releases table for
+// the test holding the rows, and returns the options of the retrieval of the release from it
+func useMemorySQL(t *testing.T, releaseName string, rows ...sqlRow) (RetrieveOptions, func()) {
+	previous := sqlDriverName
+	sqlDriverName = memorySQLDriverName
+	dsn := "memory://" + t.Name()
+	memorySQLTables.Lock()
+	memorySQLTables.rows[dsn] = map[string]sqlRow{}
+	for _, row := range rows {
+		memorySQLTables.rows[dsn][row.key] = row
+	}
+	delete(memorySQLTables.queries, dsn)
+	memorySQLTables.Unlock()
+	retOpts := RetrieveOptions{
+		ReleaseName:      releaseName,
+		SQLConnection:    dsn,
+		StorageType:      "sql",
+		TillerLabel:      "OWNER=TILLER",
+		TillerNamespace:  "kube-system",
+		TillerOutCluster: true,
+	}
+	return retOpts, func() {
+		sqlDriverName = previous
+		memorySQLTables.Lock()
+		delete(memorySQLTables.rows, dsn)
+		delete(memorySQLTables.queries, dsn)
+		memorySQLTables.Unlock()
+	}
+}
+
+// releaseRow returns the row Tiller stores the version of the release in with the status
+func releaseRow(t *testing.T, name string, version int32, status rls.Status_Code) sqlRow {
+	release := &rls.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   version,
+		Info:      &rls.Info{Status: &rls.Status{Code: status}},
+	}
+	return sqlRow{
+		key:     GetReleaseVersionName(name, version),
+		body:    encodeRelease(t, release),
+		name:    name,
+		version: int64(version),
+		status:  status.String(),
+		owner:   "TILLER",
+	}
+}
+
+// sqlTable returns the rows of the releases table of the options, and the queries run on it
+func sqlTable(retOpts RetrieveOptions) (map[string]sqlRow, []string) {
+	memorySQLTables.Lock()
+	defer memorySQLTables.Unlock()
+	rows := map[string]sqlRow{}
+	for key, row := range memorySQLTables.rows[retOpts.SQLConnection] {
+		rows[key] = row
+	}
+	return rows, append([]string{}, memorySQLTables.queries[retOpts.SQLConnection]...)
+}
+
+func TestGetReleaseVersionsSQL(t *testing.T) {
+	foreign := releaseRow(t, "rel", 9, rls.Status_DEPLOYED)
+	foreign.key, foreign.owner = "rel.v9-other", "OTHER"
+	retOpts, cleanup := useMemorySQL(t, "rel",
+		releaseRow(t, "rel", 2, rls.Status_DEPLOYED),
+		releaseRow(t, "rel", 1, rls.Status_SUPERSEDED),
+		releaseRow(t, "other", 1, rls.Status_DEPLOYED),
+		foreign,
+	)
+	defer cleanup()
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+
+	releases, err := GetReleaseVersions(context.Background(), retOpts, kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []int32{}
+	for _, release := range releases {
+		if release.Name != "rel" {
+			t.Errorf("expected the versions of release \"rel\" only, got release \"%s\"", release.Name)
+		}
+		versions = append(versions, release.Version)
+	}
+	if !reflect.DeepEqual(versions, []int32{1, 2}) {
+		t.Errorf("expected versions 1 and 2 of the Tiller owner, sorted, got %v", versions)
+	}
+	if _, queries := sqlTable(retOpts); len(queries) != 1 || !strings.HasSuffix(queries[0], "WHERE name = $1") {
+		t.Errorf("expected the rows of the release to be selected by name, got queries %q", queries)
+	}
+
+	retOpts.ReleaseName = "missing"
+	if _, err := GetReleaseVersions(context.Background(), retOpts, kubeConfig); !errors.Is(err, ErrNoVersionsFound) {
+		t.Errorf("expected ErrNoVersionsFound for a release with no rows, got %v", err)
+	}
+}
+
+func TestGetAllReleaseVersionsSQLCorruptRows(t *testing.T) {
+	corrupt := releaseRow(t, "broken", 1, rls.Status_DEPLOYED)
+	corrupt.body = "not a release"
+	retOpts, cleanup := useMemorySQL(t, "",
+		releaseRow(t, "rel", 1, rls.Status_DEPLOYED),
+		corrupt,
+	)
+	defer cleanup()
+
+	records, corruptRecords, err := getSQLReleaseRecords(context.Background(), retOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "rel.v1" || records[0].Storage != "sql" {
+		t.Errorf("expected the record of \"rel.v1\" from the SQL storage, got %v", records)
+	}
+	if len(corruptRecords) != 1 || corruptRecords[0].Name != "broken.v1" || corruptRecords[0].ReleaseName != "broken" || corruptRecords[0].Version != 1 {
+		t.Errorf("expected the row of \"broken.v1\" to be a corrupt record, got %v", corruptRecords)
+	}
+	if _, queries := sqlTable(retOpts); len(queries) != 1 || queries[0] != sqlSelectReleases {
+		t.Errorf("expected all the rows to be selected, got queries %q", queries)
+	}
+}
+
+func TestCreateReleaseRecordSQL(t *testing.T) {
+	existing := releaseRow(t, "rel", 1, rls.Status_SUPERSEDED)
+	retOpts, cleanup := useMemorySQL(t, "rel", existing)
+	defer cleanup()
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+	record := func(version int32) ReleaseRecord {
+		row := releaseRow(t, "rel", version, rls.Status_DEPLOYED)
+		release, err := decodeRelease(row.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReleaseRecord{Name: row.key, Data: row.body, Release: release}
+	}
+
+	if err := CreateReleaseRecord(context.Background(), retOpts, record(2), false, kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateReleaseRecord(context.Background(), retOpts, record(1), false, kubeConfig); !errors.Is(err, ErrReleaseRecordExists) {
+		t.Errorf("expected ErrReleaseRecordExists for an existing row, got %v", err)
+	}
+	if rows, _ := sqlTable(retOpts); rows["rel.v1"] != existing {
+		t.Errorf("expected the existing row not to be replaced, got %v", rows["rel.v1"])
+	}
+	if err := CreateReleaseRecord(context.Background(), retOpts, record(1), true, kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ := sqlTable(retOpts)
+	for _, key := range []string{"rel.v1", "rel.v2"} {
+		row := rows[key]
+		if row.name != "rel" || row.status != "DEPLOYED" || row.owner != "TILLER" {
+			t.Errorf("expected row \"%s\" of the deployed release \"rel\" owned by Tiller, got %v", key, row)
+		}
+	}
+	if rows["rel.v1"].body == existing.body {
+		t.Error("expected the existing row to be replaced with overwrite")
+	}
+}
+
+func TestDeleteReleaseVersionsSQL(t *testing.T) {
+	retOpts, cleanup := useMemorySQL(t, "rel",
+		releaseRow(t, "rel", 1, rls.Status_SUPERSEDED),
+		releaseRow(t, "rel", 2, rls.Status_DEPLOYED),
+		releaseRow(t, "other", 1, rls.Status_DEPLOYED),
+	)
+	defer cleanup()
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+	delOpts := DeleteOptions{Versions: []int32{1, 2}}
+
+	deleted, err := DeleteReleaseVersions(context.Background(), retOpts, delOpts, kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, []int32{1, 2}) {
+		t.Errorf("expected versions 1 and 2 to be deleted, got %v", deleted)
+	}
+	rows, _ := sqlTable(retOpts)
+	if _, exists := rows["other.v1"]; len(rows) != 1 || !exists {
+		t.Errorf("expected only the row of the other release to be left, got %v", rows)
+	}
+
+	_, err = DeleteReleaseVersions(context.Background(), retOpts, DeleteOptions{Versions: []int32{3}}, kubeConfig)
+	var deleteErr *DeleteError
+	if !errors.As(err, &deleteErr) || !errors.Is(err, ErrNoVersionsFound) {
+		t.Errorf("expected a DeleteError wrapping ErrNoVersionsFound for a row which does not exist, got %v", err)
+	}
+}
+
+func TestOpenSQLNoConnection(t *testing.T) {
+	retOpts := RetrieveOptions{StorageType: "sql", TillerOutCluster: true}
+	if _, _, err := getSQLReleaseRecords(context.Background(), retOpts); err == nil || !strings.Contains(err.Error(), "tiller-sql-connection") {
+		t.Errorf("expected the connection string to be required, got %v", err)
+	}
+}
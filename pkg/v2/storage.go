@@ -0,0 +1,131 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StorageAuto is the storage type of the options detecting whether the releases are stored in the
+// ConfigMaps or Secrets of the Tiller namespace, when Tiller is not running in the cluster to tell
+const StorageAuto = "auto"
+
+// AnyOwnerLabel is the Tiller label matching the release storage objects whatever their OWNER label, or
+// with none, as written by some Tiller forks and Tillerless setups: the objects are matched by the NAME and
+// VERSION labels Tiller sets, and only kept when they are named and hold a release as Tiller writes them.
+const AnyOwnerLabel = "NAME,VERSION"
+
+// ErrAmbiguousStorage is returned when the storage is detected and release storage objects are found
+// in both the ConfigMaps and Secrets of the Tiller namespace
+var ErrAmbiguousStorage = errors.New("release storage is ambiguous")
+
+// detectedStorages caches the storage detected per Tiller namespace and label, so that it is probed and
+// logged once per run however many releases are retrieved
+var detectedStorages = struct {
+	sync.Mutex
+	storages map[string]string
+}{storages: map[string]string{}}
+
+var logDefaultStorageOnce sync.Once
+
+// detectStorage returns the storage of the releases of the Tiller namespace as per detectReleaseStorage,
+// or "configmaps", the default of Tiller, when no release storage objects are found
+func detectStorage(ctx context.Context, clientSet kubernetes.Interface, retOpts RetrieveOptions) (string, error) {
+	storage, err := detectReleaseStorage(ctx, clientSet, retOpts)
+	if err != nil || storage != "" {
+		return storage, err
+	}
+	logDefaultStorageOnce.Do(func() {
+		retOpts.logger().Infof("[Helm 2] Release storage detected: configmaps, the default of Tiller, as no release ConfigMaps or Secrets labelled \"%s\" are found in \"%s\" namespace.\n", retOpts.TillerLabel, retOpts.TillerNamespace)
+	})
+	return "configmaps", nil
+}
+
+// detectReleaseStorage returns the storage of the releases of the Tiller namespace, as per the storage
+// objects found with the Tiller label: "secrets" or "configmaps" when only one of them has some, and
+// an empty storage when neither has. ErrAmbiguousStorage is returned when both have some.
+func detectReleaseStorage(ctx context.Context, clientSet kubernetes.Interface, retOpts RetrieveOptions) (string, error) {
+	if retOpts.TillerLabel == "" {
+		retOpts.TillerLabel = "OWNER=TILLER"
+	}
+	key := retOpts.TillerNamespace + "/" + retOpts.TillerLabel
+	detectedStorages.Lock()
+	defer detectedStorages.Unlock()
+	if storage, ok := detectedStorages.storages[key]; ok {
+		return storage, nil
+	}
+
+	listOptions := metav1.ListOptions{
+		LabelSelector: retOpts.TillerLabel,
+		Limit:         1,
+	}
+	secrets, err := clientSet.CoreV1().Secrets(retOpts.TillerNamespace).List(ctx, listOptions)
+	if err != nil {
+		return "", fmt.Errorf("[Helm 2] release storage failed to be detected, as the Secrets of \"%s\" namespace failed to be listed with error: %s. Set the 'release-storage' flag", retOpts.TillerNamespace, err)
+	}
+	configMaps, err := clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).List(ctx, listOptions)
+	if err != nil {
+		return "", fmt.Errorf("[Helm 2] release storage failed to be detected, as the ConfigMaps of \"%s\" namespace failed to be listed with error: %s. Set the 'release-storage' flag", retOpts.TillerNamespace, err)
+	}
+
+	storage := ""
+	switch {
+	case len(secrets.Items) > 0 && len(configMaps.Items) > 0:
+		return "", fmt.Errorf("%w: release storage objects labelled \"%s\" are found in both the ConfigMaps and Secrets of \"%s\" namespace, e.g. ConfigMap \"%s\" and Secret \"%s\". Set the 'release-storage' flag to 'configmaps' or 'secrets'", ErrAmbiguousStorage, retOpts.TillerLabel, retOpts.TillerNamespace, configMaps.Items[0].Name, secrets.Items[0].Name)
+	case len(secrets.Items) > 0:
+		storage = "secrets"
+		retOpts.logger().Infof("[Helm 2] Release storage detected: secrets, as release Secrets labelled \"%s\" are found in \"%s\" namespace and no ConfigMaps.\n", retOpts.TillerLabel, retOpts.TillerNamespace)
+	case len(configMaps.Items) > 0:
+		storage = "configmaps"
+		retOpts.logger().Infof("[Helm 2] Release storage detected: configmaps, as release ConfigMaps labelled \"%s\" are found in \"%s\" namespace and no Secrets.\n", retOpts.TillerLabel, retOpts.TillerNamespace)
+	}
+	detectedStorages.storages[key] = storage
+	return storage, nil
+}
+
+// ownerFiltered returns true if the Tiller label selects the storage objects by their OWNER label, in
+// which case the objects it matches are Tiller's. Otherwise the objects have to be checked with
+// isTillerObject, as other objects can have the NAME and VERSION labels.
+func ownerFiltered(tillerLabel string) bool {
+	selector, err := labels.Parse(tillerLabel)
+	if err != nil {
+		return true
+	}
+	requirements, _ := selector.Requirements()
+	for _, requirement := range requirements {
+		if requirement.Key() == "OWNER" {
+			return true
+		}
+	}
+	return false
+}
+
+// isTillerObject returns true if the ConfigMap or Secret is a release storage object as Tiller writes
+// them: named <release>.v<version> as per its NAME and VERSION labels, with the release in its
+// "release" key
+func isTillerObject(objectMeta metav1.ObjectMeta, hasRelease bool) bool {
+	name, version, ok := parseReleaseVersionName(objectMeta.Name)
+	return ok && hasRelease && objectMeta.Labels["NAME"] == name && objectMeta.Labels["VERSION"] == strconv.Itoa(int(version))
+}
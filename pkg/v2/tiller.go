@@ -0,0 +1,555 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+const (
+	// defaultTillerDeploymentName is the name of the Tiller Deployment created by 'helm init'
+	defaultTillerDeploymentName = "tiller-deploy"
+	// tillerSelector selects the objects created by 'helm init' for Tiller
+	tillerSelector = "app=helm,name=tiller"
+	// tillerWaitInterval is the interval the removal of the Tiller workloads is checked at, and
+	// tillerWaitLogInterval the interval its progress is logged at
+	tillerWaitInterval    = time.Second
+	tillerWaitLogInterval = 5 * time.Second
+)
+
+// errNoTillerDeployment is returned when no Tiller Deployment is found in the Tiller namespace
+var errNoTillerDeployment = errors.New("no Tiller Deployment found")
+
+var logTillerlessOnce sync.Once
+
+// RemoveTillerOptions are the options for removing Tiller from the cluster
+type RemoveTillerOptions struct {
+	DryRun               bool
+	RBACCleanup          bool
+	TillerDeploymentName string
+	TillerNamespace      string
+	// Wait waits until the Tiller workloads and their pods are removed, not only until their deletion is
+	// accepted, for up to WaitTimeout. Zero waits with no limit other than the context.
+	Wait        bool
+	WaitTimeout time.Duration
+	// ObjectDeletion is the propagation policy and grace period of the deletions of the Tiller objects
+	ObjectDeletion
+	// Logger logs the removal of the Tiller objects, the default logger when not set
+	Logger common.Logger
+}
+
+// logger returns the logger of the options, or the default logger when not set
+func (tillerOpts RemoveTillerOptions) logger() common.Logger {
+	return common.LoggerOrDefault(tillerOpts.Logger)
+}
+
+// tillerObject is a Kubernetes object which is part of a Tiller install
+type tillerObject struct {
+	kind      string
+	name      string
+	namespace string
+	delete    func(ctx context.Context) error
+}
+
+func (obj tillerObject) String() string {
+	if obj.namespace == "" {
+		return fmt.Sprintf("%s \"%s\"", obj.kind, obj.name)
+	}
+	return fmt.Sprintf("%s \"%s\" in \"%s\" namespace", obj.kind, obj.name, obj.namespace)
+}
+
+// RemoveTiller removes Tiller in a particular namespace from the cluster. It removes the Tiller
+// workload, Service and TLS Secret. Unless RBAC cleanup is disabled, it also removes the Tiller
+// ServiceAccount and the role bindings which bind only that ServiceAccount.
+// It returns false if no Tiller workload was found in the namespace.
+func RemoveTiller(ctx context.Context, tillerOpts RemoveTillerOptions, kubeConfig common.KubeConfig) (bool, error) {
+	if tillerOpts.TillerNamespace == "" {
+		tillerOpts.TillerNamespace = "kube-system"
+	}
+	if tillerOpts.TillerDeploymentName == "" {
+		tillerOpts.TillerDeploymentName = defaultTillerDeploymentName
+	}
+
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return false, err
+	}
+	serviceAccounts := map[string]bool{}
+	workloads, err := getTillerWorkloads(ctx, clientSet, tillerOpts, serviceAccounts)
+	if err != nil {
+		return false, fmt.Errorf("[Helm 2] Failed to get Tiller workload in \"%s\" namespace due to the following error: %s", tillerOpts.TillerNamespace, err)
+	}
+	if len(workloads) == 0 {
+		tillerOpts.logger().Infof("[Helm 2] no Tiller workload found in \"%s\" namespace.\n", tillerOpts.TillerNamespace)
+	}
+	for _, obj := range workloads {
+		tillerOpts.logger().Infof("[Helm 2] Tiller %s found.\n", obj)
+	}
+
+	objects, err := getTillerObjects(ctx, clientSet, tillerOpts, serviceAccounts)
+	if err != nil {
+		return false, fmt.Errorf("[Helm 2] Failed to get Tiller objects in \"%s\" namespace due to the following error: %s", tillerOpts.TillerNamespace, err)
+	}
+
+	for _, obj := range append(workloads, objects...) {
+		tillerOpts.logger().Infof("[Helm 2] Tiller %s will be removed.\n", obj)
+		if tillerOpts.DryRun {
+			entry := obj.auditEntry()
+			entry.DryRun = true
+			common.RecordAudit(entry, nil)
+			continue
+		}
+		err := obj.delete(ctx)
+		common.RecordAudit(obj.auditEntry(), err)
+		if apierrors.IsNotFound(err) {
+			tillerOpts.logger().Infof("[Helm 2] Tiller %s does not exist.\n", obj)
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("[Helm 2] Failed to remove Tiller %s due to the following error: %s", obj, err)
+		}
+		tillerOpts.logger().Infof("[Helm 2] Tiller %s was removed successfully.\n", obj)
+	}
+	if tillerOpts.Wait && !tillerOpts.DryRun && len(workloads) > 0 {
+		if err := waitForTillerRemoval(ctx, clientSet, tillerOpts, workloads); err != nil {
+			return true, err
+		}
+	}
+	return len(workloads) > 0, nil
+}
+
+// waitForTillerRemoval waits until the Tiller workloads, and the ReplicaSets and pods with the Tiller
+// labels, are removed from the Tiller namespace. The objects left are logged periodically, and listed
+// in the error when the wait timeout is reached.
+func waitForTillerRemoval(ctx context.Context, clientSet kubernetes.Interface, tillerOpts RemoveTillerOptions, workloads []tillerObject) error {
+	if tillerOpts.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tillerOpts.WaitTimeout)
+		defer cancel()
+	}
+	tillerOpts.logger().Infof("[Helm 2] Waiting for Tiller in \"%s\" namespace to be removed.\n", tillerOpts.TillerNamespace)
+	started := time.Now()
+	logged := started
+	// The objects left are the ones last found, when the last check is interrupted by the timeout
+	left := []string{}
+	for _, obj := range workloads {
+		left = append(left, fmt.Sprintf("%s \"%s\"", obj.kind, obj.name))
+	}
+	for {
+		current, err := getTillerObjectsLeft(ctx, clientSet, tillerOpts.TillerNamespace, workloads)
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("[Helm 2] Failed to check the removal of Tiller in \"%s\" namespace due to the following error: %s", tillerOpts.TillerNamespace, err)
+		}
+		if err == nil {
+			left = current
+		}
+		if err == nil && len(left) == 0 {
+			tillerOpts.logger().Infof("[Helm 2] Tiller in \"%s\" namespace was removed after %s.\n", tillerOpts.TillerNamespace, time.Since(started).Round(time.Second))
+			return nil
+		}
+		if time.Since(logged) >= tillerWaitLogInterval {
+			logged = time.Now()
+			tillerOpts.logger().Infof("[Helm 2] Waiting for Tiller in \"%s\" namespace to be removed: %s left.\n", tillerOpts.TillerNamespace, strings.Join(left, ", "))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("[Helm 2] Tiller in \"%s\" namespace was not removed after %s, as the following objects are still present: %s: %w", tillerOpts.TillerNamespace, time.Since(started).Round(time.Second), strings.Join(left, ", "), ctx.Err())
+		case <-time.After(tillerWaitInterval):
+		}
+	}
+}
+
+// getTillerObjectsLeft returns the Tiller workloads which still exist, and the ReplicaSets and pods with
+// the Tiller labels left in the namespace
+func getTillerObjectsLeft(ctx context.Context, clientSet kubernetes.Interface, namespace string, workloads []tillerObject) ([]string, error) {
+	left := []string{}
+	seen := map[string]bool{}
+	for _, obj := range workloads {
+		var err error
+		switch obj.kind {
+		case "Deployment":
+			_, err = clientSet.AppsV1().Deployments(namespace).Get(ctx, obj.name, metav1.GetOptions{})
+		case "ReplicaSet":
+			_, err = clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, obj.name, metav1.GetOptions{})
+		case "ReplicationController":
+			_, err = clientSet.CoreV1().ReplicationControllers(namespace).Get(ctx, obj.name, metav1.GetOptions{})
+		}
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return left, err
+		}
+		seen[obj.kind+"/"+obj.name] = true
+		left = append(left, fmt.Sprintf("%s \"%s\"", obj.kind, obj.name))
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: tillerSelector,
+	}
+	replicaSets, err := clientSet.AppsV1().ReplicaSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return left, err
+	}
+	for _, item := range replicaSets.Items {
+		if !seen["ReplicaSet/"+item.Name] {
+			left = append(left, fmt.Sprintf("ReplicaSet \"%s\"", item.Name))
+		}
+	}
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, listOptions)
+	if err != nil {
+		return left, err
+	}
+	for _, item := range pods.Items {
+		left = append(left, fmt.Sprintf("Pod \"%s\"", item.Name))
+	}
+	return left, nil
+}
+
+// getTillerWorkloads returns the workloads running Tiller in the Tiller namespace, and adds the service
+// accounts they run as to serviceAccounts. The Deployment with the Tiller deployment name is looked up
+// first, followed by the Deployments, ReplicaSets and ReplicationControllers with the Tiller labels, as
+// some installers deploy Tiller differently than 'helm init'.
+func getTillerWorkloads(ctx context.Context, clientSet kubernetes.Interface, tillerOpts RemoveTillerOptions, serviceAccounts map[string]bool) ([]tillerObject, error) {
+	namespace := tillerOpts.TillerNamespace
+	listOptions := metav1.ListOptions{
+		LabelSelector: tillerSelector,
+	}
+	// Remove the pods of a workload in the background, once the workload is removed, unless the removal is
+	// waited for, so that the workload is only removed once its pods are. The propagation policy of the
+	// options takes precedence.
+	propagationPolicy := metav1.DeletePropagationBackground
+	if tillerOpts.Wait {
+		propagationPolicy = metav1.DeletePropagationForeground
+	}
+	deleteOptions := tillerOpts.deleteOptions(propagationPolicy)
+	workloads := []tillerObject{}
+	seen := map[string]bool{}
+	addWorkload := func(obj tillerObject, serviceAccount string) {
+		key := obj.kind + "/" + obj.name
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		workloads = append(workloads, obj)
+		if serviceAccount != "" && serviceAccount != "default" {
+			serviceAccounts[serviceAccount] = true
+		}
+	}
+	addDeployment := func(deployment appsv1.Deployment) {
+		name := deployment.Name
+		addWorkload(tillerObject{"Deployment", name, namespace, func(ctx context.Context) error {
+			return clientSet.AppsV1().Deployments(namespace).Delete(ctx, name, deleteOptions)
+		}}, deployment.Spec.Template.Spec.ServiceAccountName)
+	}
+
+	deployment, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, tillerOpts.TillerDeploymentName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		addDeployment(*deployment)
+	}
+
+	deployments, err := clientSet.AppsV1().Deployments(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range deployments.Items {
+		addDeployment(item)
+	}
+
+	replicaSets, err := clientSet.AppsV1().ReplicaSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range replicaSets.Items {
+		// A ReplicaSet controlled by a Deployment is removed with the Deployment
+		if metav1.GetControllerOf(&item) != nil {
+			continue
+		}
+		name := item.Name
+		addWorkload(tillerObject{"ReplicaSet", name, namespace, func(ctx context.Context) error {
+			return clientSet.AppsV1().ReplicaSets(namespace).Delete(ctx, name, deleteOptions)
+		}}, item.Spec.Template.Spec.ServiceAccountName)
+	}
+
+	replicationControllers, err := clientSet.CoreV1().ReplicationControllers(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range replicationControllers.Items {
+		name := item.Name
+		serviceAccount := ""
+		if item.Spec.Template != nil {
+			serviceAccount = item.Spec.Template.Spec.ServiceAccountName
+		}
+		addWorkload(tillerObject{"ReplicationController", name, namespace, func(ctx context.Context) error {
+			return clientSet.CoreV1().ReplicationControllers(namespace).Delete(ctx, name, deleteOptions)
+		}}, serviceAccount)
+	}
+
+	return workloads, nil
+}
+
+// getTillerObjects returns the objects other than the workload of the Tiller install in the Tiller namespace
+func getTillerObjects(ctx context.Context, clientSet kubernetes.Interface, tillerOpts RemoveTillerOptions, serviceAccounts map[string]bool) ([]tillerObject, error) {
+	namespace := tillerOpts.TillerNamespace
+	listOptions := metav1.ListOptions{
+		LabelSelector: tillerSelector,
+	}
+	objects := []tillerObject{}
+
+	services, err := clientSet.CoreV1().Services(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range services.Items {
+		name := item.Name
+		objects = append(objects, tillerObject{"Service", name, namespace, func(ctx context.Context) error {
+			return clientSet.CoreV1().Services(namespace).Delete(ctx, name, tillerOpts.deleteOptions(""))
+		}})
+	}
+
+	// The TLS secret created by 'helm init --tiller-tls'
+	secrets, err := clientSet.CoreV1().Secrets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range secrets.Items {
+		name := item.Name
+		objects = append(objects, tillerObject{"Secret", name, namespace, func(ctx context.Context) error {
+			return clientSet.CoreV1().Secrets(namespace).Delete(ctx, name, tillerOpts.deleteOptions(""))
+		}})
+	}
+
+	if !tillerOpts.RBACCleanup || len(serviceAccounts) == 0 {
+		return objects, nil
+	}
+
+	serviceAccountNames := []string{}
+	for name := range serviceAccounts {
+		serviceAccountNames = append(serviceAccountNames, name)
+	}
+	sort.Strings(serviceAccountNames)
+	for _, serviceAccountName := range serviceAccountNames {
+		name := serviceAccountName
+		objects = append(objects, tillerObject{"ServiceAccount", name, namespace, func(ctx context.Context) error {
+			return clientSet.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, tillerOpts.deleteOptions(""))
+		}})
+	}
+
+	clusterRoleBindings, err := clientSet.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range clusterRoleBindings.Items {
+		name := item.Name
+		obj := tillerObject{"ClusterRoleBinding", name, "", func(ctx context.Context) error {
+			return clientSet.RbacV1().ClusterRoleBindings().Delete(ctx, name, tillerOpts.deleteOptions(""))
+		}}
+		if bindsTillerOnly(obj, item.Subjects, namespace, serviceAccounts, tillerOpts.logger()) {
+			objects = append(objects, obj)
+		}
+	}
+
+	roleBindings, err := clientSet.RbacV1().RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range roleBindings.Items {
+		name := item.Name
+		obj := tillerObject{"RoleBinding", name, namespace, func(ctx context.Context) error {
+			return clientSet.RbacV1().RoleBindings(namespace).Delete(ctx, name, tillerOpts.deleteOptions(""))
+		}}
+		if bindsTillerOnly(obj, item.Subjects, namespace, serviceAccounts, tillerOpts.logger()) {
+			objects = append(objects, obj)
+		}
+	}
+
+	return objects, nil
+}
+
+// bindsTillerOnly returns true if the subjects of a role binding are all Tiller service accounts.
+// A role binding which also binds other subjects is kept, as removing it would revoke their access.
+func bindsTillerOnly(obj tillerObject, subjects []rbacv1.Subject, namespace string, serviceAccounts map[string]bool, logger common.Logger) bool {
+	tillerSubjects := 0
+	for _, subject := range subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == namespace && serviceAccounts[subject.Name] {
+			tillerSubjects++
+		}
+	}
+	if tillerSubjects == 0 {
+		return false
+	}
+	if tillerSubjects < len(subjects) {
+		logger.Infof("[Helm 2] Tiller %s will not be removed as it also binds subjects other than Tiller.\n", obj)
+		return false
+	}
+	return true
+}
+
+// getTillerStorage returns the storage type of the Tiller running in the namespace, as per the
+// --storage flag of its container
+func getTillerStorage(ctx context.Context, clientSet kubernetes.Interface, tillerNamespace string) (string, error) {
+	deployments, err := clientSet.AppsV1().Deployments(tillerNamespace).List(ctx, metav1.ListOptions{LabelSelector: tillerSelector})
+	if err != nil {
+		return "", err
+	}
+	if len(deployments.Items) == 0 {
+		return "", fmt.Errorf("%w in \"%s\" namespace. Set the 'tiller-out-cluster' flag when Tiller is not running in the cluster", errNoTillerDeployment, tillerNamespace)
+	}
+	return deploymentStorage(deployments.Items[0]), nil
+}
+
+// getTillerlessStorage returns the storage of the releases of Tillerless Helm v2, e.g. as run by the
+// helm-tiller plugin, which stores them in the Tiller namespace with no Tiller running in the cluster:
+// "secrets" or "configmaps" as per the storage objects found with the Tiller label, Secrets first as
+// they are the default of the helm-tiller plugin. An empty storage is returned when none are found.
+func getTillerlessStorage(ctx context.Context, clientSet kubernetes.Interface, retOpts RetrieveOptions) (string, error) {
+	if retOpts.TillerLabel == "" {
+		retOpts.TillerLabel = "OWNER=TILLER"
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: retOpts.TillerLabel,
+		Limit:         1,
+	}
+	secrets, err := clientSet.CoreV1().Secrets(retOpts.TillerNamespace).List(ctx, listOptions)
+	if err != nil {
+		return "", err
+	}
+	if len(secrets.Items) > 0 {
+		return "secrets", nil
+	}
+	configMaps, err := clientSet.CoreV1().ConfigMaps(retOpts.TillerNamespace).List(ctx, listOptions)
+	if err != nil {
+		return "", err
+	}
+	if len(configMaps.Items) > 0 {
+		return "configmaps", nil
+	}
+	return "", nil
+}
+
+// deploymentStorage returns the storage type of a Tiller Deployment as per the --storage flag of its
+// container: "secrets" when set to secret, "sql" when set to sql, otherwise "configmaps"
+func deploymentStorage(deployment appsv1.Deployment) string {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		args := append(append([]string{}, container.Command...), container.Args...)
+		for i, arg := range args {
+			arg = strings.TrimLeft(arg, "-")
+			if arg == "storage=secret" || (arg == "storage" && i+1 < len(args) && args[i+1] == "secret") {
+				return "secrets"
+			}
+			if arg == "storage=sql" || (arg == "storage" && i+1 < len(args) && args[i+1] == "sql") {
+				return "sql"
+			}
+		}
+	}
+	return "configmaps"
+}
+
+// TillerInstance describes a Tiller Deployment found in the cluster
+type TillerInstance struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Image         string `json:"image"`
+	Version       string `json:"version"`
+	Replicas      int32  `json:"replicas"`
+	ReadyReplicas int32  `json:"readyReplicas"`
+	Storage       string `json:"storage"`
+}
+
+// FindTillers returns the Tiller Deployments with the labels set by 'helm init' in all namespaces,
+// sorted by namespace and name
+func FindTillers(ctx context.Context, kubeConfig common.KubeConfig) ([]TillerInstance, error) {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := clientSet.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: tillerSelector})
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list Deployments in all namespaces. Permission to list deployments cluster wide needs to be granted by RBAC: %s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tillers := []TillerInstance{}
+	for _, deployment := range deployments.Items {
+		tillers = append(tillers, tillerInstance(deployment))
+	}
+	sort.Slice(tillers, func(i, j int) bool {
+		if tillers[i].Namespace != tillers[j].Namespace {
+			return tillers[i].Namespace < tillers[j].Namespace
+		}
+		return tillers[i].Name < tillers[j].Name
+	})
+	return tillers, nil
+}
+
+// GetTiller returns the Tiller Deployment with the labels set by 'helm init' in the namespace, or
+// nil if there is none
+func GetTiller(ctx context.Context, tillerNamespace string, kubeConfig common.KubeConfig) (*TillerInstance, error) {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := clientSet.AppsV1().Deployments(tillerNamespace).List(ctx, metav1.ListOptions{LabelSelector: tillerSelector})
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments.Items) == 0 {
+		return nil, nil
+	}
+	tiller := tillerInstance(deployments.Items[0])
+	return &tiller, nil
+}
+
+// tillerInstance describes the Tiller Deployment
+func tillerInstance(deployment appsv1.Deployment) TillerInstance {
+	tiller := TillerInstance{
+		Namespace:     deployment.Namespace,
+		Name:          deployment.Name,
+		ReadyReplicas: deployment.Status.ReadyReplicas,
+		Storage:       deploymentStorage(deployment),
+	}
+	if deployment.Spec.Replicas != nil {
+		tiller.Replicas = *deployment.Spec.Replicas
+	}
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		tiller.Image = containers[0].Image
+		// The version of Tiller is the tag of its image, e.g. gcr.io/kubernetes-helm/tiller:v2.16.10
+		if i := strings.LastIndex(tiller.Image, ":"); i > strings.LastIndex(tiller.Image, "/") {
+			tiller.Version = tiller.Image[i+1:]
+		}
+	}
+	return tiller
+}
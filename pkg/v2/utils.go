@@ -18,76 +18,245 @@ package v2
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 
-	utils "github.com/maorfr/helm-plugin-utils/pkg"
 	"github.com/mitchellh/go-homedir"
+
+	common "github.com/helm/helm-2to3/pkg/common"
 )
 
-const sep = string(filepath.Separator)
+// homeDirOverride is the Helm v2 home folder set by SetHomeDir, in place of the one set by the environment
+var homeDirOverride string
 
-// RemoveHomeFolder removes the v2 Helm home folder
-func RemoveHomeFolder(dryRun bool) error {
-	homeDir := HomeDir()
-	log.Printf("[Helm 2] Home folder \"%s\" will be deleted.\n", homeDir)
-	if !dryRun {
-		if err := os.RemoveAll(homeDir); err != nil {
-			return fmt.Errorf("[Helm 2] Failed to delete \"%s\" due to the following error: %s.\n", homeDir, err)
+// The scopes of the Helm v2 configuration cleanup: the whole home folder, or some of its directories
+const (
+	ConfigScopeAll          = "all"
+	ConfigScopeCache        = "cache"
+	ConfigScopePlugins      = "plugins"
+	ConfigScopeRepositories = "repositories"
+	ConfigScopeStarters     = "starters"
+)
+
+// configScopeDirs are the directories of the home folder removed by the cleanup of each scope
+var configScopeDirs = map[string][]string{
+	ConfigScopeCache:        {"cache", filepath.Join("repository", "cache")},
+	ConfigScopePlugins:      {"plugins"},
+	ConfigScopeRepositories: {"repository"},
+	ConfigScopeStarters:     {"starters"},
+}
+
+// ValidateConfigScopes checks that the scopes of the configuration cleanup are supported
+func ValidateConfigScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("config cleanup scope needs to be set. It can be 'cache', 'plugins', 'repositories', 'starters' or 'all'")
+	}
+	for _, scope := range scopes {
+		if _, ok := configScopeDirs[scope]; !ok && scope != ConfigScopeAll {
+			return fmt.Errorf("config cleanup scope \"%s\" is not supported. It can be 'cache', 'plugins', 'repositories', 'starters' or 'all'", scope)
 		}
-		log.Printf("[Helm 2] Home folder \"%s\" deleted.\n", homeDir)
 	}
 	return nil
-
 }
 
-// RemoveTiller removes Tiller service in a particular namespace from the cluster
-func RemoveTiller(tillerNamespace string, dryRun bool) error {
-	if tillerNamespace == "" {
-		tillerNamespace = "kube-system"
+// ConfigScopePaths returns the paths the configuration cleanup of the scopes removes, sorted: the home
+// folder when the scopes include all, otherwise the directories of the scopes in the home folder.
+// A directory inside another one removed is left out.
+func ConfigScopePaths(scopes []string) []string {
+	homeDir := HomeDir()
+	dirs := []string{}
+	for _, scope := range scopes {
+		if scope == ConfigScopeAll {
+			return []string{homeDir}
+		}
+		dirs = append(dirs, configScopeDirs[scope]...)
 	}
-	if !dryRun {
-		log.Printf("[Helm 2] Tiller \"%s\" in \"%s\" namespace will be removed.\n", "deploy", tillerNamespace)
-		err := executeKubsDeleteTillerCmd(tillerNamespace, "deploy")
+	sort.Strings(dirs)
+	paths := []string{}
+	for _, dir := range dirs {
+		path := filepath.Join(homeDir, dir)
+		if len(paths) > 0 && inDir(paths[len(paths)-1], path) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// RemoveConfigScopes removes the directories of the scopes from the Helm v2 home folder, or the whole
+// home folder when the scopes include all. The path and size of each directory is logged, also in
+// dry-run. A directory which does not exist is skipped with a warning. Symbolic links are not followed
+// unless set: a directory which is a symbolic link is removed as a link, its target being left intact,
+// and a directory of a home folder which is a symbolic link is skipped, as it is in the target. The
+// paths removed, or which would be in dry-run, are returned.
+func RemoveConfigScopes(scopes []string, dryRun, followSymlinks bool, logger common.Logger) ([]string, error) {
+	removed := []string{}
+	homeDir := HomeDir()
+	for _, path := range ConfigScopePaths(scopes) {
+		folder := "Folder"
+		if path == homeDir {
+			folder = "Home folder"
+		}
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			logger.Warnf("[Helm 2] %s \"%s\" does not exist and is skipped.\n", folder, path)
+			continue
+		}
 		if err != nil {
-			return err
+			return removed, fmt.Errorf("[Helm 2] Failed to read \"%s\" due to the following error: %s", path, err)
 		}
-		log.Printf("[Helm 2] Tiller \"%s\" in \"%s\" namespace was removed successfully.\n", "deploy", tillerNamespace)
 
-		log.Printf("[Helm 2] Tiller \"%s\" in \"%s\" namespace will be removed.\n", "service", tillerNamespace)
-		err = executeKubsDeleteTillerCmd(tillerNamespace, "service")
+		// The directories deleted: the target of the symbolic link too when it is followed
+		deleted := []string{path}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(path)
+			if !followSymlinks || err != nil {
+				logger.Warnf("[Helm 2] %s \"%s\" is a symbolic link to \"%s\". Only the link will be deleted, its target being left intact. Set the 'follow-symlinks' flag to delete the target too.\n", folder, path, target)
+				if !dryRun {
+					if err := os.Remove(path); err != nil {
+						return removed, fmt.Errorf("[Helm 2] Failed to delete \"%s\" due to the following error: %s", path, err)
+					}
+					logger.Infof("[Helm 2] Symbolic link \"%s\" deleted.\n", path)
+				}
+				removed = append(removed, path)
+				continue
+			}
+			logger.Infof("[Helm 2] %s \"%s\" is a symbolic link to \"%s\", which will be deleted too.\n", folder, path, target)
+			deleted = []string{target, path}
+		} else if path != homeDir && !followSymlinks && isSymlink(homeDir) {
+			logger.Warnf("[Helm 2] %s \"%s\" is skipped, as the home folder \"%s\" is a symbolic link and it is in its target. Set the 'follow-symlinks' flag to delete it.\n", folder, path, homeDir)
+			continue
+		}
+
+		size, err := dirSize(deleted[0])
 		if err != nil {
-			return err
+			return removed, fmt.Errorf("[Helm 2] Failed to read \"%s\" due to the following error: %s", deleted[0], err)
 		}
-		log.Printf("[Helm 2] Tiller \"%s\" in \"%s\" namespace was removed successfully.\n", "service", tillerNamespace)
+		logger.Infof("[Helm 2] %s \"%s\" (%.2fMiB) will be deleted.\n", folder, path, float64(size)/(1024*1024))
+		if !dryRun {
+			for _, dir := range deleted {
+				if err := os.RemoveAll(common.LongPath(dir, runtime.GOOS)); err != nil {
+					return removed, fmt.Errorf("[Helm 2] Failed to delete \"%s\" due to the following error: %s", dir, err)
+				}
+			}
+			logger.Infof("[Helm 2] %s \"%s\" deleted.\n", folder, path)
+		}
+		removed = append(removed, path)
 	}
-	return nil
+	return removed, nil
+}
+
+// isSymlink returns true if the path is a symbolic link
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// dirSize returns the total size of the files in the directory
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// RemoveHomeFolder removes the v2 Helm home folder, logging its removal to the logger. When it is a
+// symbolic link, only the link is removed.
+func RemoveHomeFolder(dryRun bool, logger common.Logger) error {
+	_, err := RemoveConfigScopes([]string{ConfigScopeAll}, dryRun, false, logger)
+	return err
+}
+
+// SetHomeDir sets the Helm v2 home folder, in place of the one set by the environment. The home folder
+// set by the environment is used when the directory is empty.
+func SetHomeDir(dir string) {
+	homeDirOverride = dir
 }
 
-// HomeDir return the Helm home folder
+// HomeDir return the Helm home folder: the one set by SetHomeDir, otherwise the one set by the
+// HELM_V2_HOME or HELM_HOME environment variables, in this order, otherwise the default '~/.helm'
 func HomeDir() string {
-	if homeDir, exists := os.LookupEnv("HELM_V2_HOME"); exists {
-		return homeDir
+	if homeDirOverride != "" {
+		return homeDirOverride
+	}
+	if env := HomeDirEnv(); env != "" {
+		return os.Getenv(env)
 	}
 
+	// Helm v2 uses '~/.helm' on all platforms, e.g. '%USERPROFILE%\.helm' on Windows
 	homeDir, _ := homedir.Dir()
-	defaultDir := homeDir + sep + ".helm"
+	defaultDir := filepath.Join(homeDir, ".helm")
 	return defaultDir
 }
 
+// HomeDirEnv returns the environment variable the Helm home folder is set by, HELM_V2_HOME or HELM_HOME,
+// or an empty string when neither is set
+func HomeDirEnv() string {
+	for _, env := range []string{"HELM_V2_HOME", "HELM_HOME"} {
+		if homeDir, exists := os.LookupEnv(env); exists && homeDir != "" {
+			return env
+		}
+	}
+	return ""
+}
+
+// IsHomeDir returns true if the directory looks like a Helm v2 home folder, i.e. it has a 'repository'
+// or 'plugins' folder, so that an arbitrary directory is not taken for one
+func IsHomeDir(dir string) bool {
+	for _, subDir := range []string{"repository", "plugins"} {
+		if info, err := os.Stat(filepath.Join(dir, subDir)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// InHomeFolder returns true if the path is the Helm home folder or is inside it
+func InHomeFolder(path string) bool {
+	return inDir(HomeDir(), path)
+}
+
+// InConfigScopes returns true if the path is removed by the configuration cleanup of the scopes
+func InConfigScopes(path string, scopes []string) bool {
+	for _, scopePath := range ConfigScopePaths(scopes) {
+		if inDir(scopePath, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// inDir returns true if the path is the directory or is inside it
+func inDir(dir, path string) bool {
+	return common.InDir(dir, path, runtime.GOOS)
+}
+
 // GetReleaseVersionName returns release version name
 func GetReleaseVersionName(releaseName string, releaseVersion int32) string {
 	return fmt.Sprintf("%s.v%d", releaseName, releaseVersion)
 }
 
-func executeKubsDeleteTillerCmd(tillerNamespace, label string) error {
-	delLabel := label + "/tiller-deploy"
-	applyCmd := []string{"kubectl", "delete", "--namespace", tillerNamespace, delLabel}
-	output := utils.Execute(applyCmd)
-	if !strings.Contains(string(output), "\"tiller-deploy\" deleted") {
-		return fmt.Errorf("[Helm 2] Failed to remove Tiller \"%s\" in \"%s\" namespace due to the following error: %s", label, tillerNamespace, string(output))
+// parseReleaseVersionName returns the release name and version of a release version name
+// (<release>.v<version>), false if the name is not one
+func parseReleaseVersionName(releaseVersionName string) (string, int32, bool) {
+	i := strings.LastIndex(releaseVersionName, ".v")
+	if i <= 0 {
+		return "", 0, false
 	}
-	return nil
+	version, err := strconv.Atoi(releaseVersionName[i+2:])
+	if err != nil || version <= 0 {
+		return "", 0, false
+	}
+	return releaseVersionName[:i], int32(version), true
 }
@@ -0,0 +1,159 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// writeFile writes the file, creating its directory
+func writeFile(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// symlink creates the symbolic link to the target, skipping the test where symbolic links can't be created
+func symlink(t *testing.T, target, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symbolic links can't be created: %s", err)
+	}
+}
+
+// exists returns true if the path exists, without following a symbolic link
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// symlinkedHome returns a temp dir holding a Helm v2 home folder in 'shared', and the home folder set
+// to the 'home' symbolic link to it
+func symlinkedHome(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "helm-2to3-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "shared", "repository", "repositories.yaml"))
+	writeFile(t, filepath.Join(dir, "shared", "plugins", "plugin", "plugin.yaml"))
+	symlink(t, filepath.Join(dir, "shared"), filepath.Join(dir, "home"))
+	SetHomeDir(filepath.Join(dir, "home"))
+	return dir, func() {
+		SetHomeDir("")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRemoveConfigScopesSymlinkedHome(t *testing.T) {
+	tests := []struct {
+		name           string
+		dryRun         bool
+		followSymlinks bool
+		linkRemoved    bool
+		targetRemoved  bool
+	}{
+		{name: "link only", linkRemoved: true},
+		{name: "link only in dry-run", dryRun: true},
+		{name: "follow symlinks", followSymlinks: true, linkRemoved: true, targetRemoved: true},
+		{name: "follow symlinks in dry-run", dryRun: true, followSymlinks: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := symlinkedHome(t)
+			defer cleanup()
+			home := filepath.Join(dir, "home")
+			removed, err := RemoveConfigScopes([]string{ConfigScopeAll}, test.dryRun, test.followSymlinks, common.NewLogger(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(removed, []string{home}) {
+				t.Errorf("expected the home folder to be removed, got %q", removed)
+			}
+			if exists(home) == test.linkRemoved {
+				t.Errorf("expected the link to be removed %t", test.linkRemoved)
+			}
+			if exists(filepath.Join(dir, "shared", "plugins", "plugin", "plugin.yaml")) == test.targetRemoved {
+				t.Errorf("expected the target to be removed %t", test.targetRemoved)
+			}
+		})
+	}
+}
+
+func TestRemoveConfigScopesInSymlinkedHome(t *testing.T) {
+	dir, cleanup := symlinkedHome(t)
+	defer cleanup()
+	removed, err := RemoveConfigScopes([]string{ConfigScopePlugins}, false, false, common.NewLogger(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected the plugins of the home folder which is a symbolic link to be skipped, got %q removed", removed)
+	}
+	if !exists(filepath.Join(dir, "shared", "plugins", "plugin", "plugin.yaml")) {
+		t.Error("expected the plugins in the target of the home folder to survive")
+	}
+
+	removed, err = RemoveConfigScopes([]string{ConfigScopePlugins}, false, true, common.NewLogger(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{filepath.Join(dir, "home", "plugins")}) {
+		t.Errorf("expected the plugins to be removed when the symbolic links are followed, got %q", removed)
+	}
+	if exists(filepath.Join(dir, "shared", "plugins")) {
+		t.Error("expected the plugins in the target of the home folder to be removed when the symbolic links are followed")
+	}
+	if !exists(filepath.Join(dir, "shared", "repository", "repositories.yaml")) {
+		t.Error("expected the repositories out of the scope to survive")
+	}
+}
+
+func TestRemoveConfigScopesSymlinkedScopeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home := filepath.Join(dir, "home")
+	writeFile(t, filepath.Join(home, "repository", "repositories.yaml"))
+	writeFile(t, filepath.Join(dir, "volume", "plugins", "plugin", "plugin.yaml"))
+	symlink(t, filepath.Join(dir, "volume", "plugins"), filepath.Join(home, "plugins"))
+	SetHomeDir(home)
+	defer SetHomeDir("")
+
+	removed, err := RemoveConfigScopes([]string{ConfigScopeAll}, false, false, common.NewLogger(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{home}) {
+		t.Errorf("expected the home folder to be removed, got %q", removed)
+	}
+	if exists(home) {
+		t.Error("expected the home folder to be removed")
+	}
+	if !exists(filepath.Join(dir, "volume", "plugins", "plugin", "plugin.yaml")) {
+		t.Error("expected the target of the symbolic link in the home folder to survive")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+	"sigs.k8s.io/yaml"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// Helm v3 only adopts the existing resources of a release, e.g. on upgrade, when they carry its
+// ownership metadata: the managed-by label, and the release name and namespace annotations.
+const (
+	// ManagedByLabel is the label set to "Helm" on the resources managed by Helm v3
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ReleaseNameAnnotation is the annotation set to the name of the release of a resource
+	ReleaseNameAnnotation = "meta.helm.sh/release-name"
+	// ReleaseNamespaceAnnotation is the annotation set to the namespace of the release of a resource
+	ReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// AdoptedResource describes a resource of a release and the patch of its ownership metadata. The
+// error is set when the resource couldn't be patched, e.g. when it no longer exists.
+type AdoptedResource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Patch     string
+	Missing   bool
+	Err       error
+}
+
+// String returns the kind, namespace and name of the resource
+func (adopted AdoptedResource) String() string {
+	if adopted.Namespace == "" {
+		return fmt.Sprintf("%s \"%s\"", adopted.Kind, adopted.Name)
+	}
+	return fmt.Sprintf("%s \"%s/%s\"", adopted.Kind, adopted.Namespace, adopted.Name)
+}
+
+// AdoptResources patches the live objects of the manifest of the release version with the Helm v3
+// ownership metadata, so that Helm v3 adopts them. Cluster-scoped objects are patched too. An object
+// which no longer exists, or whose kind is unknown to the cluster, is reported as missing, and one which
+// fails to be patched is reported with its error, the other objects being patched all the same. The
+// patches are not applied in dry-run.
+func AdoptResources(ctx context.Context, rel *release.Release, dryRun bool, kubeConfig common.KubeConfig) ([]AdoptedResource, error) {
+	cfg, err := GetActionConfig(rel.Namespace, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, ok := cfg.KubeClient.(*kube.Client)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Helm v3 kube client %T", cfg.KubeClient)
+	}
+	// The objects without a namespace are in the namespace of the release
+	kubeClient.Namespace = rel.Namespace
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				ManagedByLabel: "Helm",
+			},
+			"annotations": map[string]string{
+				ReleaseNameAnnotation:      rel.Name,
+				ReleaseNamespaceAnnotation: rel.Namespace,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := releaseutil.SplitManifests(rel.Manifest)
+	keys := []string{}
+	for key := range manifests {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	adopted := []AdoptedResource{}
+	for _, key := range keys {
+		manifest := manifests[key]
+		var object manifestObject
+		if err := yaml.Unmarshal([]byte(manifest), &object); err != nil || object.Kind == "" {
+			continue
+		}
+		resources, err := kubeClient.Build(strings.NewReader(manifest), false)
+		if err != nil {
+			adopted = append(adopted, AdoptedResource{
+				Kind:      object.Kind,
+				Namespace: object.Metadata.Namespace,
+				Name:      object.Metadata.Name,
+				Patch:     string(patch),
+				Missing:   true,
+				Err:       err,
+			})
+			continue
+		}
+		for _, info := range resources {
+			resourceAdopted := AdoptedResource{
+				Kind:      info.Mapping.GroupVersionKind.Kind,
+				Namespace: info.Namespace,
+				Name:      info.Name,
+				Patch:     string(patch),
+			}
+			if !info.Namespaced() {
+				resourceAdopted.Namespace = ""
+			}
+			entry := common.AuditEntry{
+				Verb:      "patch",
+				Group:     info.Mapping.GroupVersionKind.Group,
+				Version:   info.Mapping.GroupVersionKind.Version,
+				Kind:      resourceAdopted.Kind,
+				Namespace: resourceAdopted.Namespace,
+				Name:      resourceAdopted.Name,
+				Release:   rel.Name,
+				DryRun:    dryRun,
+			}
+			if !dryRun {
+				helper := resource.NewHelper(info.Client, info.Mapping)
+				err := common.Retry(ctx, fmt.Sprintf("[Helm 3] patch of %s", resourceAdopted), func() error {
+					_, err := helper.Patch(info.Namespace, info.Name, types.MergePatchType, patch, &metav1.PatchOptions{})
+					return err
+				})
+				resourceAdopted.Missing = apierrors.IsNotFound(err)
+				resourceAdopted.Err = err
+			}
+			common.RecordAudit(entry, resourceAdopted.Err)
+			adopted = append(adopted, resourceAdopted)
+		}
+	}
+	return adopted, nil
+}
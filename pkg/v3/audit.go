@@ -0,0 +1,70 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// auditDriver records the creates, updates and deletes of the Helm v3 storage driver in the audit log
+type auditDriver struct {
+	driver.Driver
+	namespace string
+}
+
+func (d auditDriver) Create(key string, rls *release.Release) error {
+	err := d.Driver.Create(key, rls)
+	common.RecordAudit(d.entry("create", key, rls.Name), err)
+	return err
+}
+
+func (d auditDriver) Update(key string, rls *release.Release) error {
+	err := d.Driver.Update(key, rls)
+	common.RecordAudit(d.entry("update", key, rls.Name), err)
+	return err
+}
+
+func (d auditDriver) Delete(key string) (*release.Release, error) {
+	rls, err := d.Driver.Delete(key)
+	name := ""
+	if rls != nil {
+		name = rls.Name
+	}
+	common.RecordAudit(d.entry("delete", key, name), err)
+	return rls, err
+}
+
+// entry returns the audit entry of the operation on the storage object of the key
+func (d auditDriver) entry(verb, key, releaseName string) common.AuditEntry {
+	entry := common.AuditEntry{
+		Verb:      verb,
+		Kind:      "SQL record",
+		Namespace: d.namespace,
+		Name:      key,
+		Release:   releaseName,
+	}
+	switch d.Driver.Name() {
+	case driver.SecretsDriverName:
+		entry.Version, entry.Kind = "v1", "Secret"
+	case driver.ConfigMapsDriverName:
+		entry.Version, entry.Kind = "v1", "ConfigMap"
+	}
+	return entry
+}
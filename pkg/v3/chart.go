@@ -0,0 +1,179 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"sigs.k8s.io/yaml"
+)
+
+// requirementsFile is the file of the dependencies of the Helm v2 charts, which Helm v3 declares in Chart.yaml
+const requirementsFile = "requirements.yaml"
+
+// The severities of the template findings
+const (
+	// FindingWillError is the severity of the constructs which fail the rendering of the templates by Helm v3
+	FindingWillError = "will-error"
+	// FindingBehavesDifferently is the severity of the constructs which Helm v3 renders or runs differently
+	FindingBehavesDifferently = "behaves-differently"
+)
+
+// templateConstruct is a construct of the Helm v2 templates which breaks under Helm v3
+type templateConstruct struct {
+	name     string
+	expr     *regexp.Regexp
+	severity string
+	message  string
+	// builtin is set for the built-in objects Helm v3 removed
+	builtin bool
+}
+
+// templateConstructs are the constructs the templates are scanned for, one line at a time
+var templateConstructs = []templateConstruct{
+	{
+		name:     ".Capabilities.TillerVersion",
+		expr:     regexp.MustCompile(`\.Capabilities\.TillerVersion\b`),
+		severity: FindingWillError,
+		message:  "uses .Capabilities.TillerVersion, which Helm v3 removed: the rendering fails as the field does not exist",
+		builtin:  true,
+	},
+	{
+		name:     ".Release.Time",
+		expr:     regexp.MustCompile(`\.Release\.Time\b`),
+		severity: FindingBehavesDifferently,
+		message:  "uses .Release.Time, which Helm v3 removed: it renders as no value",
+		builtin:  true,
+	},
+	{
+		name:     "crd-install",
+		expr:     regexp.MustCompile(`helm\.sh/hook"?\s*:.*\bcrd-install\b`),
+		severity: FindingBehavesDifferently,
+		message:  "is a crd-install hook, which Helm v3 does not run: move the CRD to the crds/ directory of the chart",
+	},
+}
+
+// TemplateFinding is a construct of a chart template which breaks under Helm v3, as per LintTemplates
+type TemplateFinding struct {
+	// File is the path of the template in the chart, the subcharts being under their parent chart
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Construct string `json:"construct"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+// String returns the finding as a line of the verification output
+func (f TemplateFinding) String() string {
+	return fmt.Sprintf("%s: %s:%d: %s", f.Severity, f.File, f.Line, f.Message)
+}
+
+// LintTemplates scans the templates of the chart and its subcharts for the constructs which break the
+// first Helm v3 upgrade, and returns their occurrences: the built-in objects Helm v3 removed and the
+// crd-install hooks. Each finding either fails the rendering, or is rendered or run differently.
+func LintTemplates(chrt *chart.Chart) []TemplateFinding {
+	if chrt == nil {
+		return nil
+	}
+	return lintTemplates(chrt, chrt.Name())
+}
+
+func lintTemplates(chrt *chart.Chart, path string) []TemplateFinding {
+	findings := []TemplateFinding{}
+	for _, template := range chrt.Templates {
+		for i, line := range strings.Split(string(template.Data), "\n") {
+			for _, construct := range templateConstructs {
+				if construct.expr.MatchString(line) {
+					findings = append(findings, TemplateFinding{
+						File:      path + "/" + template.Name,
+						Line:      i + 1,
+						Construct: construct.name,
+						Severity:  construct.severity,
+						Message:   construct.message,
+					})
+				}
+			}
+		}
+	}
+	for _, dependency := range chrt.Dependencies() {
+		findings = append(findings, lintTemplates(dependency, path+"/charts/"+dependency.Name())...)
+	}
+	return findings
+}
+
+// CheckChart returns the warnings about the chart of a release version which may fail its first Helm v3
+// upgrade or rollback: a missing apiVersion, dependencies of requirements.yaml not in the Chart.yaml
+// metadata, and templates using the built-in objects Helm v3 removed. The subcharts are checked too.
+// The chart is not changed, the checks being for information only.
+func CheckChart(chrt *chart.Chart) []string {
+	if chrt == nil {
+		return nil
+	}
+	name := chrt.Name()
+	if chrt.Metadata != nil && chrt.Metadata.Version != "" {
+		name += "-" + chrt.Metadata.Version
+	}
+	warnings := []string{}
+	if chrt.Metadata == nil || chrt.Metadata.APIVersion == "" {
+		warnings = append(warnings, fmt.Sprintf("chart \"%s\": Chart.yaml has no apiVersion, which Helm v3 requires, e.g. to roll back to this release version", name))
+	}
+	if missing := missingRequirements(chrt); len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf("chart \"%s\": dependencies of %s are not in the dependencies of Chart.yaml: %s. Declare them in Chart.yaml with apiVersion v2", name, requirementsFile, strings.Join(missing, ", ")))
+	}
+	for _, template := range chrt.Templates {
+		for _, construct := range templateConstructs {
+			if construct.builtin && construct.expr.Match(template.Data) {
+				warnings = append(warnings, fmt.Sprintf("chart \"%s\": template \"%s\" uses %s, which Helm v3 removed", name, template.Name, construct.name))
+			}
+		}
+	}
+	for _, dependency := range chrt.Dependencies() {
+		warnings = append(warnings, CheckChart(dependency)...)
+	}
+	return warnings
+}
+
+// missingRequirements returns the names of the dependencies of the requirements.yaml file of the chart
+// which are not in the dependencies of its metadata. A file which can't be parsed declares none.
+func missingRequirements(chrt *chart.Chart) []string {
+	var requirements struct {
+		Dependencies []*chart.Dependency `json:"dependencies"`
+	}
+	for _, file := range chrt.Files {
+		if file.Name == requirementsFile {
+			if err := yaml.Unmarshal(file.Data, &requirements); err != nil {
+				return nil
+			}
+		}
+	}
+	declared := map[string]bool{}
+	if chrt.Metadata != nil {
+		for _, dependency := range chrt.Metadata.Dependencies {
+			declared[dependency.Name] = true
+		}
+	}
+	missing := []string{}
+	for _, dependency := range requirements.Dependencies {
+		if dependency != nil && !declared[dependency.Name] {
+			missing = append(missing, dependency.Name)
+		}
+	}
+	return missing
+}
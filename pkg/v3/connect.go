@@ -17,39 +17,183 @@ limitations under the License.
 package v3
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sync"
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 
 	common "github.com/helm/helm-2to3/pkg/common"
 )
 
 var (
-	settings = cli.New()
+	// storageDriver and sqlConnectionString select the Helm v3 storage driver. They are set from the
+	// HELM_DRIVER and HELM_DRIVER_SQL_CONNECTION_STRING environment variables, unless set by SetStorage.
+	storageDriver       = os.Getenv("HELM_DRIVER")
+	sqlConnectionString = os.Getenv("HELM_DRIVER_SQL_CONNECTION_STRING")
+
+	// sqlDrivers are the SQL drivers created, by connection string and namespace. A driver opens a pool
+	// of connections to the database and migrates its schema, so that it is created once and reused by
+	// all the storage calls, instead of a pool being opened by each of them.
+	sqlDrivers     = map[sqlDriverKey]driver.Driver{}
+	sqlDriversLock sync.Mutex
+	// debugLogger logs the debug messages of the Helm v3 actions and storage drivers, the default logger
+	// when not set
+	debugLogger common.Logger
+	// newSQLDriver creates the SQL driver of the namespace
+	newSQLDriver = func(connectionString, namespace string) (driver.Driver, error) {
+		return driver.NewSQL(connectionString, debug, namespace)
+	}
 )
 
+// sqlDriverKey identifies the SQL driver of a namespace of a database
+type sqlDriverKey struct {
+	connectionString string
+	namespace        string
+}
+
+// getSQLDriver returns the SQL driver of the namespace of the database of the connection string,
+// creating it on first use
+func getSQLDriver(connectionString, namespace string) (driver.Driver, error) {
+	sqlDriversLock.Lock()
+	defer sqlDriversLock.Unlock()
+	key := sqlDriverKey{connectionString: connectionString, namespace: namespace}
+	if sqlDriver, ok := sqlDrivers[key]; ok {
+		return sqlDriver, nil
+	}
+	sqlDriver, err := newSQLDriver(connectionString, namespace)
+	if err != nil {
+		return nil, err
+	}
+	sqlDrivers[key] = sqlDriver
+	return sqlDriver, nil
+}
+
+// SetStorage sets the Helm v3 storage driver the releases are stored in and looked up from. It can be
+// 'secret', 'configmap' or 'sql', the connection string being required by the SQL driver only.
+// The storage driver set by the environment is used when the driver is empty.
+func SetStorage(storageType, sqlConnection string) error {
+	switch storageType {
+	case "":
+		if sqlConnection != "" {
+			return errors.New("the SQL connection string can only be set with the 'sql' Helm v3 storage")
+		}
+		return nil
+	case "secret", "configmap":
+		if sqlConnection != "" {
+			return errors.New("the SQL connection string can only be set with the 'sql' Helm v3 storage")
+		}
+	case "sql":
+		if sqlConnection == "" {
+			return errors.New("the SQL connection string needs to be set with the 'sql' Helm v3 storage")
+		}
+	default:
+		return fmt.Errorf("Helm v3 storage \"%s\" is not supported. It can be 'secret', 'configmap' or 'sql'", storageType)
+	}
+	storageDriver = storageType
+	sqlConnectionString = sqlConnection
+	return nil
+}
+
 // GetActionConfig returns action configuration based on Helm env
 func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
 	actionConfig := new(action.Configuration)
 
-	// Add kube config settings passed by user
-	settings.KubeConfig = kubeConfig.File
-	settings.KubeContext = kubeConfig.Context
+	// The kube config settings passed by user are set on settings of the call, so that the conversions
+	// of concurrent workers, e.g. to another cluster, don't share them
+	envSettings := cli.New()
+	envSettings.KubeConfig = kubeConfig.File
+	envSettings.KubeContext = kubeConfig.Context
 
-	err := actionConfig.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), debug)
+	getter := restClientGetter{
+		RESTClientGetter: envSettings.RESTClientGetter(),
+		kubeConfig:       kubeConfig,
+	}
+	// The SQL driver is created here, as Helm reads its connection string from the environment only
+	initDriver := storageDriver
+	if initDriver == "sql" {
+		initDriver = ""
+	}
+	err := actionConfig.Init(getter, namespace, initDriver, debug)
 	if err != nil {
 		return nil, err
 	}
+	if storageDriver == "sql" {
+		if sqlConnectionString == "" {
+			return nil, errors.New("the SQL connection string of the 'sql' Helm v3 storage is not set")
+		}
+		sqlDriver, err := getSQLDriver(sqlConnectionString, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("[Helm 3] failed to connect to the SQL storage with error: %s", err)
+		}
+		actionConfig.Releases = storage.Init(sqlDriver)
+	}
+	// The Secrets or ConfigMaps of the storage are accessed through the client of the kube config, if any
+	if kubeConfig.Client != nil {
+		switch storageKind() {
+		case "Secret":
+			actionConfig.Releases = storage.Init(driver.NewSecrets(kubeConfig.Client.CoreV1().Secrets(namespace)))
+		case "ConfigMap":
+			actionConfig.Releases = storage.Init(driver.NewConfigMaps(kubeConfig.Client.CoreV1().ConfigMaps(namespace)))
+		}
+	}
+	actionConfig.Releases.Driver = auditDriver{Driver: actionConfig.Releases.Driver, namespace: namespace}
 
 	return actionConfig, err
 }
 
-func debug(format string, v ...interface{}) {
-	if settings.Debug {
-		format = fmt.Sprintf("[debug] %s\n", format)
-		log.Output(2, fmt.Sprintf(format, v...))
+// restClientGetter applies the client rate limits and impersonation of the kube config to the REST
+// config of Helm, and uses the API server of the kube config or the in-cluster configuration in place
+// of it when required
+type restClientGetter struct {
+	genericclioptions.RESTClientGetter
+	kubeConfig common.KubeConfig
+}
+
+func (g restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	if g.kubeConfig.UsesAPIServer() || g.kubeConfig.UsesInClusterConfig() {
+		return g.kubeConfig.RESTConfig()
 	}
+	config, err := g.RESTClientGetter.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	g.kubeConfig.Apply(config)
+	return config, nil
+}
+
+func (g restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := g.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(discoveryClient), nil
+}
+
+func (g restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	discoveryClient, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+	return restmapper.NewShortcutExpander(mapper, discoveryClient), nil
+}
+
+// debug logs the messages of the Helm v3 actions and storage drivers at debug level
+func debug(format string, v ...interface{}) {
+	common.LoggerOrDefault(debugLogger).Debugf(format+"\n", v...)
 }
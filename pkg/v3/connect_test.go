@@ -0,0 +1,188 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/kubernetes/fake"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/common/commontest"
+)
+
+// TestGetActionConfigConcurrent checks that the action configurations of concurrent conversions use
+// their own kube config. Run it with -race to detect the kube config settings being shared.
+func TestGetActionConfigConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const workers = 8
+	servers := []string{}
+	for i := 0; i < workers; i++ {
+		servers = append(servers, fmt.Sprintf("https://cluster-%d.example.com", i))
+	}
+	file := commontest.WriteKubeConfig(t, dir, servers...)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				actionConfig, err := GetActionConfig("default", common.KubeConfig{Context: fmt.Sprintf("cluster-%d", i), File: file})
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				config, err := actionConfig.RESTClientGetter.ToRESTConfig()
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				if config.Host != server {
+					errs[i] = fmt.Errorf("worker %d: REST config of API server %q, expected %q", i, config.Host, server)
+					return
+				}
+			}
+		}(i, server)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestGetActionConfigImpersonation checks that the impersonation of the kube config is set on the REST
+// config of the Helm v3 clients
+func TestGetActionConfigImpersonation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-2to3-connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, kubeConfig := range []common.KubeConfig{
+		{File: commontest.WriteKubeConfig(t, dir, "https://cluster.example.com")},
+		{APIServer: "https://api.example.com", Token: "token"},
+	} {
+		kubeConfig.Impersonate = "system:serviceaccount:kube-system:migrator"
+		kubeConfig.ImpersonateGroups = []string{"system:masters", "migrators"}
+		actionConfig, err := GetActionConfig("default", kubeConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := actionConfig.RESTClientGetter.ToRESTConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Impersonate.UserName != kubeConfig.Impersonate || !reflect.DeepEqual(config.Impersonate.Groups, kubeConfig.ImpersonateGroups) {
+			t.Errorf("expected the REST config of %s to impersonate %s %v, got %+v", config.Host, kubeConfig.Impersonate, kubeConfig.ImpersonateGroups, config.Impersonate)
+		}
+	}
+}
+
+// TestGetActionConfigSQLDriverReused checks that the SQL driver of a namespace of a database is created
+// once, and reused by the action configurations of concurrent storage calls
+func TestGetActionConfigSQLDriverReused(t *testing.T) {
+	defer useStorage("sql")()
+	previousConnection, previousNew, previousDrivers := sqlConnectionString, newSQLDriver, sqlDrivers
+	defer func() {
+		sqlConnectionString, newSQLDriver, sqlDrivers = previousConnection, previousNew, previousDrivers
+	}()
+	sqlDrivers = map[sqlDriverKey]driver.Driver{}
+	var mu sync.Mutex
+	created := map[sqlDriverKey]int{}
+	newSQLDriver = func(connectionString, namespace string) (driver.Driver, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created[sqlDriverKey{connectionString: connectionString, namespace: namespace}]++
+		return driver.NewMemory(), nil
+	}
+
+	// The connection string is only changed between the calls, as it is shared by them
+	getDriver := func(namespace string) (driver.Driver, error) {
+		actionConfig, err := GetActionConfig(namespace, common.KubeConfig{Client: fake.NewSimpleClientset()})
+		if err != nil {
+			return nil, err
+		}
+		return actionConfig.Releases.Driver.(auditDriver).Driver, nil
+	}
+	sqlConnectionString = "postgres://db"
+	const workers = 8
+	drivers := make([]driver.Driver, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			drivers[i], errs[i] = getDriver("apps")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drivers[i] != drivers[0] {
+			t.Errorf("expected the SQL driver of the namespace to be reused by worker %d", i)
+		}
+	}
+	other, err := getDriver("tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == drivers[0] {
+		t.Error("expected another SQL driver for another namespace")
+	}
+	sqlConnectionString = "postgres://other-db"
+	if _, err := getDriver("apps"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[sqlDriverKey]int{
+		{connectionString: "postgres://db", namespace: "apps"}:       1,
+		{connectionString: "postgres://db", namespace: "tools"}:      1,
+		{connectionString: "postgres://other-db", namespace: "apps"}: 1,
+	}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("expected the SQL drivers created %v, got %v", expected, created)
+	}
+}
+
+// TestDebugLogger checks that the debug messages of Helm v3 are logged to the logger of the plugin
+func TestDebugLogger(t *testing.T) {
+	defer func(logger common.Logger) { debugLogger = logger }(debugLogger)
+	logger := &commontest.RecordingLogger{}
+	debugLogger = logger
+
+	debug("release %s stored", "rel")
+	if !logger.Logged("release rel stored") {
+		t.Errorf("expected the debug message to be logged, got %v", logger.Lines())
+	}
+}
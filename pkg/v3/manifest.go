@@ -0,0 +1,259 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gosuri/uitable"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestIndexFile is the name of the index file summarizing the manifest files of a directory. It
+// is a text file, so that it is not taken as a manifest by 'kubectl apply'.
+const ManifestIndexFile = "index.txt"
+
+// manifestIndexLock serializes the writes of the index files, as releases are converted concurrently
+var manifestIndexLock sync.Mutex
+
+// manifestIndexEntry describes the release version of a manifest file. The file is relative to the directory.
+type manifestIndexEntry struct {
+	Namespace string
+	Name      string
+	Version   int
+	Status    string
+	File      string
+}
+
+// manifestObject holds the fields of a manifest file the index is built from
+type manifestObject struct {
+	Kind     string            `json:"kind"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+}
+
+// WriteReleaseManifest writes the Helm v3 storage object of the release version to a manifest file
+// in the directory, which can be applied with 'kubectl apply -f' to store the release version.
+// The object is encoded as by the Helm v3 storage driver, and labelled and annotated with the
+// provenance when set. The manifest files are laid out by namespace, one per release version
+// (<namespace>/sh.helm.release.v1.<name>.v<version>.yaml). The content only depends on the release
+// version and provenance, and a file of identical content is left untouched, so that writing the
+// same release version again doesn't change the directory. The path of the manifest file is returned.
+func WriteReleaseManifest(dir string, rel *release.Release, provenance *Provenance) (string, error) {
+	kind := storageKind()
+	if kind == "" {
+		return "", fmt.Errorf("Helm v3 storage \"%s\" can't be written to manifest files. It can be 'secret' or 'configmap'", storageDriver)
+	}
+	data, err := encodeRelease(rel)
+	if err != nil {
+		return "", err
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name:      storageObjectName(rel.Name, rel.Version),
+		Namespace: rel.Namespace,
+		// The labels the Helm v3 storage driver sets
+		Labels: map[string]string{
+			"name":    rel.Name,
+			"owner":   "helm",
+			"status":  rel.Info.Status.String(),
+			"version": strconv.Itoa(rel.Version),
+		},
+	}
+	if provenance != nil {
+		objectMeta.Labels[ConvertedLabel] = "true"
+		objectMeta.Annotations = provenance.annotations()
+	}
+	var object interface{}
+	if kind == "ConfigMap" {
+		object = &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: kind},
+			ObjectMeta: objectMeta,
+			Data:       map[string]string{"release": data},
+		}
+	} else {
+		object = &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: kind},
+			ObjectMeta: objectMeta,
+			Type:       "helm.sh/release.v1",
+			Data:       map[string][]byte{"release": []byte(data)},
+		}
+	}
+	manifest, err := yaml.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	namespaceDir := filepath.Join(dir, rel.Namespace)
+	if err := os.MkdirAll(namespaceDir, 0700); err != nil {
+		return "", err
+	}
+	file := filepath.Join(namespaceDir, objectMeta.Name+".yaml")
+	if existing, err := ioutil.ReadFile(file); err == nil && bytes.Equal(existing, manifest) {
+		return file, nil
+	}
+	if err := ioutil.WriteFile(file, manifest, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// WriteManifestIndex writes the index file of the directory, summarizing the release versions of
+// all its manifest files sorted by namespace, name and version. Files which are not Helm v3 storage
+// objects are left out. The path of the index file is returned.
+func WriteManifestIndex(dir string) (string, error) {
+	manifestIndexLock.Lock()
+	defer manifestIndexLock.Unlock()
+
+	namespaceDirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	entries := []manifestIndexEntry{}
+	for _, namespaceDir := range namespaceDirs {
+		if !namespaceDir.IsDir() {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, namespaceDir.Name(), "sh.helm.release.v1.*.yaml"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return "", err
+			}
+			var object manifestObject
+			if err := yaml.Unmarshal(data, &object); err != nil {
+				return "", fmt.Errorf("failed to parse manifest file \"%s\" with error: %s", file, err)
+			}
+			version, err := strconv.Atoi(object.Metadata.Labels["version"])
+			if err != nil || object.Metadata.Labels["owner"] != "helm" {
+				continue
+			}
+			relFile, err := filepath.Rel(dir, file)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, manifestIndexEntry{
+				Namespace: object.Metadata.Namespace,
+				Name:      object.Metadata.Labels["name"],
+				Version:   version,
+				Status:    object.Metadata.Labels["status"],
+				File:      filepath.ToSlash(relFile),
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+
+	table := uitable.New()
+	table.AddRow("NAMESPACE", "NAME", "VERSION", "STATUS", "FILE")
+	for _, entry := range entries {
+		table.AddRow(entry.Namespace, entry.Name, entry.Version, entry.Status, entry.File)
+	}
+	data := []byte(table.String() + "\n")
+	file := filepath.Join(dir, ManifestIndexFile)
+	if existing, err := ioutil.ReadFile(file); err == nil && bytes.Equal(existing, data) {
+		return file, nil
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// MaxReleaseSize is the maximum size of the encoded release of the Secret or ConfigMap storing a
+// release version, as Kubernetes limits the size of their data to 1MiB
+const MaxReleaseSize = 1024 * 1024
+
+// OversizedRelease returns the size of the release version once encoded by the Helm v3 storage
+// driver, and true if it is over the maximum size of the Kubernetes objects storing it. It is never
+// oversized for the storage drivers which don't store Kubernetes objects.
+func OversizedRelease(rel *release.Release) (int, bool, error) {
+	data, err := encodeRelease(rel)
+	if err != nil {
+		return 0, false, err
+	}
+	return len(data), storageKind() != "" && len(data) > MaxReleaseSize, nil
+}
+
+// StorageObject describes the Helm v3 storage object a release version is stored in
+type StorageObject struct {
+	// Kind is the kind of the Kubernetes object, empty for the storage drivers which don't store Kubernetes objects
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Size is the size of the release version once encoded by the storage driver, in bytes
+	Size int `json:"size"`
+}
+
+// ReleaseStorageObject returns the Helm v3 storage object the release version is stored in by the
+// storage driver, without storing it. The release version is encoded, so that an error is returned
+// for a release version which can't be stored.
+func ReleaseStorageObject(rel *release.Release) (StorageObject, error) {
+	data, err := encodeRelease(rel)
+	if err != nil {
+		return StorageObject{}, err
+	}
+	return StorageObject{
+		Kind:      storageKind(),
+		Name:      storageObjectName(rel.Name, rel.Version),
+		Namespace: rel.Namespace,
+		Size:      len(data),
+	}, nil
+}
+
+// encodeRelease encodes the release as the Helm v3 storage driver does: gzipped JSON, base64 encoded
+func encodeRelease(rel *release.Release) (string, error) {
+	data, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// CreatedByLabel is the label set on the namespaces created by the plugin
+const CreatedByLabel = "app.kubernetes.io/created-by"
+
+// NamespaceExists returns true if the namespace exists in the cluster
+func NamespaceExists(ctx context.Context, namespace string, kubeConfig common.KubeConfig) (bool, error) {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return false, err
+	}
+	_, err = clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if apierrors.IsForbidden(err) {
+		return false, fmt.Errorf("not allowed to get namespace \"%s\". Permission to get namespaces needs to be granted by RBAC: %s", namespace, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateNamespace creates the namespace in the cluster, labelled as created by the plugin.
+// No error is returned if it already exists.
+func CreateNamespace(ctx context.Context, namespace string, kubeConfig common.KubeConfig) error {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+			Labels: map[string]string{
+				CreatedByLabel: "helm-2to3",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	common.RecordAudit(common.AuditEntry{Verb: "create", Version: "v1", Kind: "Namespace", Name: namespace}, err)
+	if apierrors.IsForbidden(err) {
+		return fmt.Errorf("not allowed to create namespace \"%s\". Either permission to create namespaces needs to be granted by RBAC, or the namespace needs to be created beforehand: %s", namespace, err)
+	}
+	return err
+}
+
+// DeleteNamespace deletes the namespace from the cluster if it is labelled as created by the plugin, so
+// that a namespace created by others in the meantime is left in place.
+// No error is returned if it does not exist.
+func DeleteNamespace(ctx context.Context, namespace string, kubeConfig common.KubeConfig) error {
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Labels[CreatedByLabel] != "helm-2to3" {
+		return nil
+	}
+	err = clientSet.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	common.RecordAudit(common.AuditEntry{Verb: "delete", Version: "v1", Kind: "Namespace", Name: namespace}, err)
+	if apierrors.IsForbidden(err) {
+		return fmt.Errorf("not allowed to delete namespace \"%s\". Permission to delete namespaces needs to be granted by RBAC: %s", namespace, err)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+func TestDeleteNamespaceCreatedOnly(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}})
+	kubeConfig := common.KubeConfig{Client: client}
+	if err := CreateNamespace(context.Background(), "apps", kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, namespace := range []string{"apps", "other", "missing"} {
+		if err := DeleteNamespace(context.Background(), namespace, kubeConfig); err != nil {
+			t.Fatalf("namespace %s failed to be deleted with error: %s", namespace, err)
+		}
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "apps", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected namespace apps created by the plugin to be deleted, got %v", err)
+	}
+	// A namespace not labelled as created by the plugin is left in place
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "other", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected namespace other to be left in place, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	stdtime "time"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/version"
+)
+
+// The provenance of a converted release version is recorded on the Helm v3 storage object of the
+// release version. Only the converted label can be selected on, the other values being annotations.
+const (
+	// ConvertedLabel is the label set to "true" on the storage objects of converted release versions
+	ConvertedLabel = "helm.sh/2to3-converted"
+	// ConvertedAtAnnotation is the annotation set to the time of the conversion, in RFC 3339 format
+	ConvertedAtAnnotation = "helm.sh/2to3-converted-at"
+	// TillerNamespaceAnnotation is the annotation set to the namespace of the Tiller the release was read from
+	TillerNamespaceAnnotation = "helm.sh/2to3-tiller-namespace"
+	// PluginVersionAnnotation is the annotation set to the version of the plugin which converted the release
+	PluginVersionAnnotation = "helm.sh/2to3-version"
+)
+
+// Provenance describes the conversion of a Helm v2 release version
+type Provenance struct {
+	ConvertedAt     stdtime.Time
+	TillerNamespace string
+}
+
+// annotations returns the annotations recording the provenance. The conversion time is left out
+// when it is not set.
+func (provenance Provenance) annotations() map[string]string {
+	annotations := map[string]string{
+		TillerNamespaceAnnotation: provenance.TillerNamespace,
+		PluginVersionAnnotation:   version.Version,
+	}
+	if !provenance.ConvertedAt.IsZero() {
+		annotations[ConvertedAtAnnotation] = provenance.ConvertedAt.UTC().Format(stdtime.RFC3339)
+	}
+	return annotations
+}
+
+// ConvertedRelease identifies a Helm v3 release which has converted release versions
+type ConvertedRelease struct {
+	Name      string
+	Namespace string
+}
+
+// SetProvenance labels and annotates the Helm v3 storage object of the release version with the
+// provenance of its conversion. It only applies to the Secrets and ConfigMaps storage drivers, and
+// is a no-op for the others, as they don't store Kubernetes objects.
+func SetProvenance(ctx context.Context, rel *release.Release, provenance Provenance, kubeConfig common.KubeConfig) error {
+	kind := storageKind()
+	if kind == "" {
+		return nil
+	}
+	return patchProvenance(ctx, rel, kind, map[string]string{ConvertedLabel: "true"}, provenance.annotations(), kubeConfig)
+}
+
+// patchProvenance patches the labels and annotations of the provenance onto the Helm v3 storage object
+// of the kind of the release version
+func patchProvenance(ctx context.Context, rel *release.Release, kind string, labels, annotations map[string]string, kubeConfig common.KubeConfig) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	name := storageObjectName(rel.Name, rel.Version)
+	err = common.Retry(ctx, fmt.Sprintf("[Helm 3] labelling of ReleaseVersion \"%s.v%d\"", rel.Name, rel.Version), func() error {
+		if kind == "ConfigMap" {
+			_, err = clientSet.CoreV1().ConfigMaps(rel.Namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		} else {
+			_, err = clientSet.CoreV1().Secrets(rel.Namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+		return err
+	})
+	common.RecordAudit(common.AuditEntry{
+		Verb:      "patch",
+		Version:   "v1",
+		Kind:      kind,
+		Namespace: rel.Namespace,
+		Name:      name,
+		Release:   rel.Name,
+	}, err)
+	return err
+}
+
+// IsConverted returns true if the Helm v3 storage object of the release version is labelled as
+// converted by the plugin. It is always false for the storage drivers which don't store Kubernetes objects.
+func IsConverted(ctx context.Context, rel *release.Release, kubeConfig common.KubeConfig) (bool, error) {
+	kind := storageKind()
+	if kind == "" {
+		return false, nil
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return false, err
+	}
+
+	objectMeta, err := storageObjectMeta(ctx, rel, kind, clientSet)
+	if err != nil {
+		return false, err
+	}
+	return objectMeta.Labels[ConvertedLabel] == "true", nil
+}
+
+// storageObjectMeta returns the metadata of the Helm v3 storage object of the kind of the release version
+func storageObjectMeta(ctx context.Context, rel *release.Release, kind string, clientSet kubernetes.Interface) (metav1.ObjectMeta, error) {
+	name := storageObjectName(rel.Name, rel.Version)
+	if kind == "ConfigMap" {
+		configMap, err := clientSet.CoreV1().ConfigMaps(rel.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return metav1.ObjectMeta{}, err
+		}
+		return configMap.ObjectMeta, nil
+	}
+	secret, err := clientSet.CoreV1().Secrets(rel.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, err
+	}
+	return secret.ObjectMeta, nil
+}
+
+// preserveProvenance runs the update of the release version in Helm v3 storage, and sets the provenance
+// of the storage object back once updated, as the storage drivers replace the labels and annotations
+// of the object on an update. The update is run as is for the storage drivers which don't store
+// Kubernetes objects, or when the object has no provenance.
+func preserveProvenance(ctx context.Context, rel *release.Release, kubeConfig common.KubeConfig, update func() error) error {
+	kind := storageKind()
+	if kind == "" {
+		return update()
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return err
+	}
+	objectMeta, err := storageObjectMeta(ctx, rel, kind, clientSet)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	labels, annotations := map[string]string{}, map[string]string{}
+	if value, ok := objectMeta.Labels[ConvertedLabel]; ok {
+		labels[ConvertedLabel] = value
+	}
+	for _, key := range []string{ConvertedAtAnnotation, TillerNamespaceAnnotation, PluginVersionAnnotation} {
+		if value, ok := objectMeta.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+
+	if err := update(); err != nil {
+		return err
+	}
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+	return patchProvenance(ctx, rel, kind, labels, annotations, kubeConfig)
+}
+
+// ListConvertedReleases returns the Helm v3 releases which have release versions labelled as converted
+// by the plugin, in all namespaces, sorted by namespace and name. None are returned for the storage
+// drivers which don't store Kubernetes objects.
+func ListConvertedReleases(ctx context.Context, kubeConfig common.KubeConfig) ([]ConvertedRelease, error) {
+	kind := storageKind()
+	if kind == "" {
+		return []ConvertedRelease{}, nil
+	}
+	clientSet, err := kubeConfig.ClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	listOptions := metav1.ListOptions{
+		LabelSelector: ConvertedLabel + "=true",
+	}
+	var objects []metav1.ObjectMeta
+	if kind == "ConfigMap" {
+		var list *corev1.ConfigMapList
+		list, err = clientSet.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, listOptions)
+		if list != nil {
+			for _, item := range list.Items {
+				objects = append(objects, item.ObjectMeta)
+			}
+		}
+	} else {
+		var list *corev1.SecretList
+		list, err = clientSet.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, listOptions)
+		if list != nil {
+			for _, item := range list.Items {
+				objects = append(objects, item.ObjectMeta)
+			}
+		}
+	}
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list %ss in all namespaces. Permission to list them needs to be granted by RBAC: %s", kind, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[ConvertedRelease]bool{}
+	releases := []ConvertedRelease{}
+	for _, object := range objects {
+		// The name label is set by the Helm v3 storage driver
+		convertedRelease := ConvertedRelease{
+			Name:      object.Labels["name"],
+			Namespace: object.Namespace,
+		}
+		if convertedRelease.Name == "" || found[convertedRelease] {
+			continue
+		}
+		found[convertedRelease] = true
+		releases = append(releases, convertedRelease)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Namespace != releases[j].Namespace {
+			return releases[i].Namespace < releases[j].Namespace
+		}
+		return releases[i].Name < releases[j].Name
+	})
+	return releases, nil
+}
+
+// storageKind returns the kind of the Kubernetes objects the Helm v3 storage driver stores the
+// release versions in. It is empty for the drivers which don't store Kubernetes objects.
+func storageKind() string {
+	switch storageDriver {
+	case "", "secret", "secrets":
+		return "Secret"
+	case "configmap", "configmaps":
+		return "ConfigMap"
+	}
+	return ""
+}
+
+// storageObjectName returns the name of the Helm v3 storage object of the release version, as
+// named by the Helm v3 storage driver
+func storageObjectName(name string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	stdtime "time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/client-go/kubernetes/fake"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+	"github.com/helm/helm-2to3/pkg/version"
+)
+
+// checkProvenance checks the provenance of the Helm v3 storage object of the release version
+func checkProvenance(t *testing.T, rel *release.Release, provenance Provenance, kubeConfig common.KubeConfig) {
+	t.Helper()
+	converted, err := IsConverted(context.Background(), rel, kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !converted {
+		t.Errorf("expected ReleaseVersion \"%s.v%d\" to be labelled as converted", rel.Name, rel.Version)
+	}
+	objectMeta, err := storageObjectMeta(context.Background(), rel, storageKind(), kubeConfig.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The labels of the storage driver are kept along the converted label
+	for _, label := range []string{"name", "owner", "status", "version"} {
+		if objectMeta.Labels[label] == "" {
+			t.Errorf("expected the storage driver label %q to be set, got labels %v", label, objectMeta.Labels)
+		}
+	}
+	expected := map[string]string{
+		ConvertedAtAnnotation:     provenance.ConvertedAt.UTC().Format(stdtime.RFC3339),
+		TillerNamespaceAnnotation: provenance.TillerNamespace,
+		PluginVersionAnnotation:   version.Version,
+	}
+	if !reflect.DeepEqual(objectMeta.Annotations, expected) {
+		t.Errorf("expected the annotations %v, got %v", expected, objectMeta.Annotations)
+	}
+}
+
+func TestProvenanceRoundTrip(t *testing.T) {
+	for _, driver := range []string{"secret", "configmap"} {
+		t.Run(driver, func(t *testing.T) {
+			defer useStorage(driver)()
+			ctx := context.Background()
+			kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+			provenance := Provenance{
+				ConvertedAt:     stdtime.Date(2020, 8, 9, 10, 11, 12, 0, stdtime.FixedZone("CEST", 2*60*60)),
+				TillerNamespace: "tiller",
+			}
+			storeReleases(t, "apps", kubeConfig, "rel", "native")
+			releases, err := GetReleaseHistory("rel", "apps", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := SetProvenance(ctx, releases[0], provenance, kubeConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			// The release version labelled is read back by the storage driver
+			releases, err = GetReleaseHistory("rel", "apps", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(releases) != 1 || releases[0].Version != 1 || releases[0].Info.Status != release.StatusDeployed {
+				t.Fatalf("expected the deployed version 1 to be read back, got %v", releases)
+			}
+			checkProvenance(t, releases[0], provenance, kubeConfig)
+
+			// The provenance survives the update of the release version by the storage driver
+			if err := SupersedeReleaseVersions(ctx, releases, kubeConfig); err != nil {
+				t.Fatal(err)
+			}
+			releases, err = GetReleaseHistory("rel", "apps", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if releases[0].Info.Status != release.StatusSuperseded {
+				t.Errorf("expected the release version to be superseded, got %s", releases[0].Info.Status)
+			}
+			checkProvenance(t, releases[0], provenance, kubeConfig)
+
+			// The release versions which were not converted are left unlabelled, also once updated
+			native, err := GetReleaseHistory("native", "apps", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := SupersedeReleaseVersions(ctx, native, kubeConfig); err != nil {
+				t.Fatal(err)
+			}
+			if converted, err := IsConverted(ctx, native[0], kubeConfig); err != nil || converted {
+				t.Errorf("expected the native release version not to be labelled as converted, got %t with error %v", converted, err)
+			}
+
+			converted, err := ListConvertedReleases(ctx, kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := []ConvertedRelease{{Name: "rel", Namespace: "apps"}}; !reflect.DeepEqual(converted, expected) {
+				t.Errorf("expected the converted releases %v, got %v", expected, converted)
+			}
+		})
+	}
+}
+
+func TestProvenanceSQLStorage(t *testing.T) {
+	defer useStorage("sql")()
+	ctx := context.Background()
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+	rel := &release.Release{Name: "rel", Namespace: "apps", Version: 1}
+	if err := SetProvenance(ctx, rel, Provenance{TillerNamespace: "tiller"}, kubeConfig); err != nil {
+		t.Errorf("expected the provenance to be a no-op for the SQL storage, got error: %s", err)
+	}
+	if converted, err := IsConverted(ctx, rel, kubeConfig); err != nil || converted {
+		t.Errorf("expected the release versions of the SQL storage never to be converted, got %t with error %v", converted, err)
+	}
+}
@@ -17,9 +17,14 @@ limitations under the License.
 package v3
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
-	stdtime "time"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
@@ -27,6 +32,7 @@ import (
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	"helm.sh/helm/v3/pkg/time"
 
 	v2chrtutil "k8s.io/helm/pkg/chartutil"
@@ -36,6 +42,14 @@ import (
 	common "github.com/helm/helm-2to3/pkg/common"
 )
 
+// ErrReleaseNotFound is returned when no release versions of a release are found in Helm v3 storage.
+// It is the error of the Helm v3 storage drivers.
+var ErrReleaseNotFound = driver.ErrReleaseNotFound
+
+// ErrReleaseAlreadyExists is returned when a release version is stored which already exists in Helm v3
+// storage, or a release is converted whose name is already taken. It is the error of the Helm v3 storage drivers.
+var ErrReleaseAlreadyExists = driver.ErrReleaseExists
+
 // CreateRelease create a v3 release object from v3 release object
 func CreateRelease(v2Rel *v2rls.Release) (*release.Release, error) {
 	if v2Rel.Chart == nil || v2Rel.Info == nil {
@@ -49,11 +63,7 @@ func CreateRelease(v2Rel *v2rls.Release) (*release.Release, error) {
 	if err != nil {
 		return nil, err
 	}
-	first, err := mapTimestampToTime(v2Rel.Info.FirstDeployed)
-	if err != nil {
-		return nil, err
-	}
-	last, err := mapTimestampToTime(v2Rel.Info.LastDeployed)
+	first, last, err := mapDeployedTimes(v2Rel.Info)
 	if err != nil {
 		return nil, err
 	}
@@ -91,13 +101,136 @@ func CreateRelease(v2Rel *v2rls.Release) (*release.Release, error) {
 }
 
 // StoreRelease stores a release object in Helm v3 storage
-func StoreRelease(rel *release.Release, kubeConfig common.KubeConfig) error {
+func StoreRelease(ctx context.Context, rel *release.Release, kubeConfig common.KubeConfig) error {
 	cfg, err := GetActionConfig(rel.Namespace, kubeConfig)
 	if err != nil {
 		return err
 	}
 
-	return cfg.Releases.Create(rel)
+	return common.Retry(ctx, fmt.Sprintf("[Helm 3] create of ReleaseVersion \"%s.v%d\"", rel.Name, rel.Version), func() error {
+		// The Helm v3 storage does not take a context, so check it has not been cancelled before storing
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return cfg.Releases.Create(rel)
+	})
+}
+
+// ReleaseExists returns true if a release of the specified name exists in Helm v3 storage
+// of the specified namespace
+func ReleaseExists(name, namespace string, kubeConfig common.KubeConfig) (bool, error) {
+	cfg, err := GetActionConfig(namespace, kubeConfig)
+	if err != nil {
+		return false, err
+	}
+
+	releases, err := cfg.Releases.History(name)
+	if err != nil {
+		if errors.Is(err, ErrReleaseNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(releases) > 0, nil
+}
+
+// GetReleaseHistory returns the release versions of a release from Helm v3 storage of the
+// specified namespace, sorted by version. ErrReleaseNotFound is returned if there are none.
+func GetReleaseHistory(name, namespace string, kubeConfig common.KubeConfig) ([]*release.Release, error) {
+	cfg, err := GetActionConfig(namespace, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := cfg.Releases.History(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version < releases[j].Version
+	})
+	return releases, nil
+}
+
+// DeleteReleaseHistory deletes the release versions of a release from Helm v3 storage
+func DeleteReleaseHistory(ctx context.Context, releases []*release.Release, kubeConfig common.KubeConfig) error {
+	for _, rel := range releases {
+		cfg, err := GetActionConfig(rel.Namespace, kubeConfig)
+		if err != nil {
+			return err
+		}
+		err = common.Retry(ctx, fmt.Sprintf("[Helm 3] delete of ReleaseVersion \"%s.v%d\"", rel.Name, rel.Version), func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_, err := cfg.Releases.Delete(rel.Name, rel.Version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreReleaseHistory re-creates the release versions of a release deleted from Helm v3 storage, e.g.
+// when the conversion which replaced them failed. The release versions which still exist are skipped.
+func RestoreReleaseHistory(ctx context.Context, releases []*release.Release, kubeConfig common.KubeConfig) error {
+	for _, rel := range releases {
+		if err := StoreRelease(ctx, rel, kubeConfig); err != nil && !errors.Is(err, driver.ErrReleaseExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SupersedeReleaseVersions updates the release versions in Helm v3 storage to the superseded status
+func SupersedeReleaseVersions(ctx context.Context, releases []*release.Release, kubeConfig common.KubeConfig) error {
+	return updateReleaseStatus(ctx, releases, release.StatusSuperseded, kubeConfig)
+}
+
+// DeployReleaseVersions updates the release versions in Helm v3 storage back to the deployed status,
+// e.g. when the conversion which superseded them failed
+func DeployReleaseVersions(ctx context.Context, releases []*release.Release, kubeConfig common.KubeConfig) error {
+	return updateReleaseStatus(ctx, releases, release.StatusDeployed, kubeConfig)
+}
+
+// updateReleaseStatus updates the release versions in Helm v3 storage to the status, keeping the
+// provenance of the release versions converted
+func updateReleaseStatus(ctx context.Context, releases []*release.Release, status release.Status, kubeConfig common.KubeConfig) error {
+	for _, rel := range releases {
+		cfg, err := GetActionConfig(rel.Namespace, kubeConfig)
+		if err != nil {
+			return err
+		}
+		rel.Info.Status = status
+		err = preserveProvenance(ctx, rel, kubeConfig, func() error {
+			return common.Retry(ctx, fmt.Sprintf("[Helm 3] update of ReleaseVersion \"%s.v%d\"", rel.Name, rel.Version), func() error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return cfg.Releases.Update(rel)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseChecksum returns the SHA-256 checksum of the release version as encoded in JSON by the Helm
+// v3 storage, so that a release version created by a conversion can be compared with one stored
+func ReleaseChecksum(rel *release.Release) (string, error) {
+	data, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
 func mapv2ChartTov3Chart(v2Chrt *v2chart.Chart) (*chart.Chart, error) {
@@ -203,7 +336,7 @@ func mapConfig(v2Config *v2chart.Config) (map[string]interface{}, error) {
 }
 
 func mapFiles(v2Files []*any.Any) []*chart.File {
-	if mapFiles == nil {
+	if v2Files == nil {
 		return nil
 	}
 	files := []*chart.File{}
@@ -216,21 +349,28 @@ func mapFiles(v2Files []*any.Any) []*chart.File {
 	return files
 }
 
+// statusMapping maps the Helm v2 release status codes to the Helm v3 release statuses
+var statusMapping = map[v2rls.Status_Code]release.Status{
+	v2rls.Status_UNKNOWN:          release.StatusUnknown,
+	v2rls.Status_DEPLOYED:         release.StatusDeployed,
+	v2rls.Status_DELETED:          release.StatusUninstalled,
+	v2rls.Status_SUPERSEDED:       release.StatusSuperseded,
+	v2rls.Status_FAILED:           release.StatusFailed,
+	v2rls.Status_DELETING:         release.StatusUninstalling,
+	v2rls.Status_PENDING_INSTALL:  release.StatusPendingInstall,
+	v2rls.Status_PENDING_UPGRADE:  release.StatusPendingUpgrade,
+	v2rls.Status_PENDING_ROLLBACK: release.StatusPendingRollback,
+}
+
 func mapStatus(v2Info *v2rls.Info) (string, error) {
-	v2StatusStr, ok := v2rls.Status_Code_name[int32(v2Info.Status.Code)]
-	if !ok {
+	if v2Info.Status == nil {
 		return "", fmt.Errorf("Failed to get v2 status")
 	}
-	// map to v3 status
-	lowerCaseStr := strings.ToLower(v2StatusStr)
-	v3StatusStr := strings.ReplaceAll(lowerCaseStr, "_", "-")
-	if v3StatusStr == "deleted" {
-		v3StatusStr = "uninstalled"
-	}
-	if v3StatusStr == "deleting" {
-		v3StatusStr = "uninstalling"
+	v3Status, ok := statusMapping[v2Info.Status.Code]
+	if !ok {
+		return "", fmt.Errorf("Failed to map v2 status \"%s\"", v2Info.Status.Code)
 	}
-	return v3StatusStr, nil
+	return v3Status.String(), nil
 }
 
 func mapHooks(v2Hooks []*v2rls.Hook, v2LastTestSuiteRun *v2rls.TestSuite) ([]*release.Hook, error) {
@@ -263,6 +403,18 @@ func mapHooks(v2Hooks []*v2rls.Hook, v2LastTestSuiteRun *v2rls.TestSuite) ([]*re
 		if err != nil {
 			return nil, err
 		}
+		if lastRun == nil && val.LastRun != nil {
+			// Helm v2 only records when the hooks which are not tests were last run, not their outcome
+			runAt, err := mapTimestampToTime(val.LastRun)
+			if err != nil {
+				return nil, err
+			}
+			lastRun = &release.HookExecution{
+				StartedAt:   runAt,
+				CompletedAt: runAt,
+				Phase:       release.HookPhaseUnknown,
+			}
+		}
 		if lastRun != nil {
 			hook.LastRun = *lastRun
 		}
@@ -271,6 +423,43 @@ func mapHooks(v2Hooks []*v2rls.Hook, v2LastTestSuiteRun *v2rls.TestSuite) ([]*re
 	return hooks, nil
 }
 
+// HookCRDInstall is the Helm v2 crd-install hook event, which Helm v3 does not support as it installs
+// the CRDs of the crds directory of charts instead
+const HookCRDInstall release.HookEvent = "crd-install"
+
+// ConvertCRDHooks removes the crd-install event from the hooks of the release version, and the hooks
+// left without events. The manifests of the crd-install hooks are moved to the manifest of the release
+// version when set, so that Helm v3 keeps track of the CRDs, otherwise they are dropped. The names of
+// the crd-install hooks are returned.
+func ConvertCRDHooks(rel *release.Release, toManifest bool) []string {
+	names := []string{}
+	hooks := []*release.Hook{}
+	for _, hook := range rel.Hooks {
+		events := []release.HookEvent{}
+		for _, event := range hook.Events {
+			if event != HookCRDInstall {
+				events = append(events, event)
+			}
+		}
+		if len(events) == len(hook.Events) {
+			hooks = append(hooks, hook)
+			continue
+		}
+		names = append(names, hook.Name)
+		if toManifest {
+			rel.Manifest = strings.TrimRight(rel.Manifest, "\n") + fmt.Sprintf("\n---\n# Source: %s\n%s\n", hook.Path, strings.TrimSpace(hook.Manifest))
+		}
+		if len(events) > 0 {
+			hook.Events = events
+			hooks = append(hooks, hook)
+		}
+	}
+	if rel.Hooks != nil {
+		rel.Hooks = hooks
+	}
+	return names
+}
+
 func mapHookEvents(v2HookEvents []v2rls.Hook_Event) ([]release.HookEvent, error) {
 	if v2HookEvents == nil {
 		return nil, nil
@@ -319,14 +508,38 @@ func mapHookDeletePolicies(v2HookDelPolicies []v2rls.Hook_DeletePolicy) ([]relea
 	return hookDelPolicies, nil
 }
 
+// mapDeployedTimes maps the first and last deployed timestamps of the release version. A timestamp
+// which is not set falls back to the other one, and to the current time if neither is set.
+func mapDeployedTimes(v2Info *v2rls.Info) (time.Time, time.Time, error) {
+	first, err := mapTimestampToTime(v2Info.FirstDeployed)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	last, err := mapTimestampToTime(v2Info.LastDeployed)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	switch {
+	case first.IsZero() && last.IsZero():
+		first = time.Now()
+		last = first
+	case first.IsZero():
+		first = last
+	case last.IsZero():
+		last = first
+	}
+	return first, last, nil
+}
+
+// mapTimestampToTime maps a protobuf timestamp to a time. A timestamp which is not set, or is the
+// Unix epoch, maps to the zero time.
 func mapTimestampToTime(ts *timestamp.Timestamp) (time.Time, error) {
-	var mappedTime stdtime.Time
-	var err error
-	if ts != nil {
-		mappedTime, err = ptypes.Timestamp(ts)
-		if err != nil {
-			return time.Time{Time: mappedTime}, err
-		}
+	if ts == nil || (ts.Seconds == 0 && ts.Nanos == 0) {
+		return time.Time{}, nil
+	}
+	mappedTime, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}, err
 	}
 	return time.Time{Time: mappedTime}, nil
 }
@@ -0,0 +1,419 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	stdtime "time"
+
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/client-go/kubernetes/fake"
+	v2chart "k8s.io/helm/pkg/proto/hapi/chart"
+	v2rls "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-2to3/pkg/common"
+)
+
+// useStorage sets the Helm v3 storage driver for the test, and returns the function restoring it
+func useStorage(driver string) func() {
+	previous := storageDriver
+	storageDriver = driver
+	return func() { storageDriver = previous }
+}
+
+// storeReleases stores the version 1 of the releases of the names in the namespace in Helm v3 storage
+func storeReleases(t *testing.T, namespace string, kubeConfig common.KubeConfig, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		rel := &release.Release{Name: name, Namespace: namespace, Version: 1, Info: &release.Info{Status: release.StatusDeployed}}
+		if err := StoreRelease(context.Background(), rel, kubeConfig); err != nil {
+			t.Fatalf("release \"%s\" failed to be stored in namespace \"%s\" with error: %s", name, namespace, err)
+		}
+	}
+}
+
+func TestReleaseExists(t *testing.T) {
+	for _, driver := range []string{"secret", "configmap"} {
+		t.Run(driver, func(t *testing.T) {
+			defer useStorage(driver)()
+			kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+			// "rel" is converted in namespace "apps" and "other" in namespace "tools", while "legacy" is
+			// only in Helm v2 storage
+			storeReleases(t, "apps", kubeConfig, "rel")
+			storeReleases(t, "tools", kubeConfig, "other")
+
+			tests := []struct {
+				name      string
+				namespace string
+				exists    bool
+			}{
+				{name: "rel", namespace: "apps", exists: true},
+				{name: "other", namespace: "tools", exists: true},
+				{name: "legacy", namespace: "apps", exists: false},
+				// The same names in other namespaces are other releases
+				{name: "rel", namespace: "tools", exists: false},
+				{name: "other", namespace: "apps", exists: false},
+				{name: "rel", namespace: "default", exists: false},
+			}
+			for _, test := range tests {
+				exists, err := ReleaseExists(test.name, test.namespace, kubeConfig)
+				if err != nil {
+					t.Fatalf("release \"%s\" failed to be looked up in namespace \"%s\" with error: %s", test.name, test.namespace, err)
+				}
+				if exists != test.exists {
+					t.Errorf("expected release \"%s\" to exist in namespace \"%s\": %t, got %t", test.name, test.namespace, test.exists, exists)
+				}
+			}
+		})
+	}
+}
+
+func TestMapStatus(t *testing.T) {
+	tests := map[v2rls.Status_Code]release.Status{
+		v2rls.Status_UNKNOWN:          release.StatusUnknown,
+		v2rls.Status_DEPLOYED:         release.StatusDeployed,
+		v2rls.Status_DELETED:          release.StatusUninstalled,
+		v2rls.Status_SUPERSEDED:       release.StatusSuperseded,
+		v2rls.Status_FAILED:           release.StatusFailed,
+		v2rls.Status_DELETING:         release.StatusUninstalling,
+		v2rls.Status_PENDING_INSTALL:  release.StatusPendingInstall,
+		v2rls.Status_PENDING_UPGRADE:  release.StatusPendingUpgrade,
+		v2rls.Status_PENDING_ROLLBACK: release.StatusPendingRollback,
+	}
+	// Every status code of Helm v2 is mapped
+	for code, name := range v2rls.Status_Code_name {
+		if _, ok := tests[v2rls.Status_Code(code)]; !ok {
+			t.Errorf("expected the mapping of the Helm v2 status %s to be tested", name)
+		}
+	}
+	for code, expected := range tests {
+		t.Run(code.String(), func(t *testing.T) {
+			status, err := mapStatus(&v2rls.Info{Status: &v2rls.Status{Code: code}})
+			if err != nil {
+				t.Fatalf("status failed to be mapped with error: %s", err)
+			}
+			if status != expected.String() {
+				t.Errorf("expected the Helm v3 status %s, got %s", expected, status)
+			}
+		})
+	}
+
+	// A status which can't be mapped is an error, not a default status
+	if status, err := mapStatus(&v2rls.Info{Status: &v2rls.Status{Code: v2rls.Status_Code(99)}}); err == nil {
+		t.Errorf("expected an error for an unknown status code, got status %q", status)
+	}
+	if status, err := mapStatus(&v2rls.Info{}); err == nil {
+		t.Errorf("expected an error for a release with no status, got status %q", status)
+	}
+}
+
+// v2Release returns the version of the Helm v2 release of the name in namespace "default", with the info
+func v2Release(name string, version int32, info *v2rls.Info) *v2rls.Release {
+	return &v2rls.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   version,
+		Chart:     &v2chart.Chart{Metadata: &v2chart.Metadata{Name: "chart", Version: "1.0.0"}},
+		Info:      info,
+	}
+}
+
+// convertAndStore converts the Helm v2 release version, stores it in the Helm v3 Secrets storage of
+// a fake cluster, and returns it as read back from the storage
+func convertAndStore(t *testing.T, v2Rel *v2rls.Release) *release.Release {
+	t.Helper()
+	defer useStorage("secret")()
+	kubeConfig := common.KubeConfig{Client: fake.NewSimpleClientset()}
+	rel, err := CreateRelease(v2Rel)
+	if err != nil {
+		t.Fatalf("release \"%s\" failed to be converted with error: %s", v2Rel.Name, err)
+	}
+	if err := StoreRelease(context.Background(), rel, kubeConfig); err != nil {
+		t.Fatalf("release \"%s\" failed to be stored with error: %s", v2Rel.Name, err)
+	}
+	releases, err := GetReleaseHistory(v2Rel.Name, v2Rel.Namespace, kubeConfig)
+	if err != nil {
+		t.Fatalf("release \"%s\" failed to be read back with error: %s", v2Rel.Name, err)
+	}
+	if len(releases) != 1 {
+		t.Fatalf("expected 1 version of release \"%s\" to be read back, got %d", v2Rel.Name, len(releases))
+	}
+	return releases[0]
+}
+
+func TestCreateReleaseTimestampsRoundTrip(t *testing.T) {
+	first := stdtime.Date(2019, 3, 4, 5, 6, 7, 0, stdtime.UTC)
+	last := stdtime.Date(2019, 11, 12, 13, 14, 15, 0, stdtime.UTC)
+	deleted := stdtime.Date(2020, 1, 2, 3, 4, 5, 0, stdtime.UTC)
+	v2Rel := v2Release("rel", 3, &v2rls.Info{
+		Status:        &v2rls.Status{Code: v2rls.Status_DELETED},
+		FirstDeployed: &timestamp.Timestamp{Seconds: first.Unix(), Nanos: 250000000},
+		LastDeployed:  &timestamp.Timestamp{Seconds: last.Unix()},
+		Deleted:       &timestamp.Timestamp{Seconds: deleted.Unix()},
+	})
+
+	rel := convertAndStore(t, v2Rel)
+	times := []struct {
+		name     string
+		mapped   stdtime.Time
+		expected stdtime.Time
+	}{
+		{"first deployed", rel.Info.FirstDeployed.Time, first},
+		{"last deployed", rel.Info.LastDeployed.Time, last},
+		{"deleted", rel.Info.Deleted.Time, deleted},
+	}
+	for _, mapping := range times {
+		if mapping.mapped.Unix() != mapping.expected.Unix() {
+			t.Errorf("expected the %s time %s, got %s", mapping.name, mapping.expected, mapping.mapped)
+		}
+	}
+	if rel.Info.Status != release.StatusUninstalled {
+		t.Errorf("expected status %s, got %s", release.StatusUninstalled, rel.Info.Status)
+	}
+}
+
+func TestCreateReleaseZeroTimestamps(t *testing.T) {
+	known := stdtime.Date(2019, 3, 4, 5, 6, 7, 0, stdtime.UTC)
+	tests := []struct {
+		name          string
+		firstDeployed *timestamp.Timestamp
+		lastDeployed  *timestamp.Timestamp
+		// expected is the time both deployed times are mapped to, the current time when zero
+		expected stdtime.Time
+	}{
+		{name: "not set"},
+		{name: "unix epoch", firstDeployed: &timestamp.Timestamp{}, lastDeployed: &timestamp.Timestamp{}},
+		{name: "first deployed not set", lastDeployed: &timestamp.Timestamp{Seconds: known.Unix()}, expected: known},
+		{name: "last deployed not set", firstDeployed: &timestamp.Timestamp{Seconds: known.Unix()}, expected: known},
+		{name: "last deployed unix epoch", firstDeployed: &timestamp.Timestamp{Seconds: known.Unix()}, lastDeployed: &timestamp.Timestamp{}, expected: known},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := stdtime.Now().Add(-stdtime.Second)
+			rel := convertAndStore(t, v2Release("rel", 1, &v2rls.Info{
+				Status:        &v2rls.Status{Code: v2rls.Status_DEPLOYED},
+				FirstDeployed: test.firstDeployed,
+				LastDeployed:  test.lastDeployed,
+			}))
+			first, last := rel.Info.FirstDeployed.Time, rel.Info.LastDeployed.Time
+			if test.expected.IsZero() {
+				if first.Before(before) || first.After(stdtime.Now().Add(stdtime.Second)) {
+					t.Errorf("expected the first deployed time to fall back to the current time, got %s", first)
+				}
+			} else if first.Unix() != test.expected.Unix() {
+				t.Errorf("expected the first deployed time %s, got %s", test.expected, first)
+			}
+			if last.Unix() != first.Unix() {
+				t.Errorf("expected the last deployed time %s to be the first deployed one %s", last, first)
+			}
+			if !rel.Info.Deleted.IsZero() {
+				t.Errorf("expected the deleted time of a release which is not deleted to be zero, got %s", rel.Info.Deleted)
+			}
+		})
+	}
+}
+
+// fixtureNotes are the notes of a release, as rendered by Tiller from a NOTES.txt with templated output,
+// on several lines and with non-ASCII characters
+const fixtureNotes = `1. Get the application URL by running these commands:
+  export POD_NAME=$(kubectl get pods --namespace default -l "app.kubernetes.io/name=café,app.kubernetes.io/instance=rel" -o jsonpath="{.items[0].metadata.name}")
+  echo "Visit http://127.0.0.1:8080 to use your application"
+  kubectl --namespace default port-forward $POD_NAME 8080:80
+
+2. Résumé: {{ not rendered }} — 日本語のメモ ✓
+
+	Tabulated line, and a trailing blank line
+`
+
+func TestCreateReleaseDescriptionNotes(t *testing.T) {
+	descriptions := []string{"Install complete", "Upgrade complete", "Rollback to 1: «défaut»"}
+	for i, description := range descriptions {
+		version := int32(i + 1)
+		rel := convertAndStore(t, v2Release("rel", version, &v2rls.Info{
+			Status:      &v2rls.Status{Code: v2rls.Status_DEPLOYED, Notes: fixtureNotes},
+			Description: description,
+		}))
+		if rel.Version != int(version) {
+			t.Errorf("expected version %d, got %d", version, rel.Version)
+		}
+		if rel.Info.Description != description {
+			t.Errorf("expected the description %q of version %d, got %q", description, version, rel.Info.Description)
+		}
+		if rel.Info.Notes != fixtureNotes {
+			t.Errorf("expected the notes of version %d to be kept as is, got %q", version, rel.Info.Notes)
+		}
+	}
+}
+
+// fixtureHooks returns the hooks of the Helm v2 release version of the hook fixtures: a job run on
+// several events with several delete policies, tests which were run, and a crd-install hook
+func fixtureHooks(lastRun stdtime.Time) []*v2rls.Hook {
+	return []*v2rls.Hook{
+		{
+			Name:           "migrate",
+			Kind:           "Job",
+			Path:           "chart/templates/migrate.yaml",
+			Manifest:       "kind: Job\nmetadata:\n  name: migrate\n",
+			Events:         []v2rls.Hook_Event{v2rls.Hook_PRE_INSTALL, v2rls.Hook_PRE_UPGRADE, v2rls.Hook_POST_ROLLBACK},
+			Weight:         -5,
+			DeletePolicies: []v2rls.Hook_DeletePolicy{v2rls.Hook_SUCCEEDED, v2rls.Hook_FAILED, v2rls.Hook_BEFORE_HOOK_CREATION},
+			LastRun:        &timestamp.Timestamp{Seconds: lastRun.Unix()},
+		},
+		{
+			Name:     "test-connection",
+			Kind:     "Pod",
+			Path:     "chart/templates/tests/test-connection.yaml",
+			Manifest: "kind: Pod\nmetadata:\n  name: test-connection\n",
+			Events:   []v2rls.Hook_Event{v2rls.Hook_RELEASE_TEST_SUCCESS},
+		},
+		{
+			Name:     "test-failure",
+			Kind:     "Pod",
+			Path:     "chart/templates/tests/test-failure.yaml",
+			Manifest: "kind: Pod\nmetadata:\n  name: test-failure\n",
+			Events:   []v2rls.Hook_Event{v2rls.Hook_RELEASE_TEST_FAILURE},
+		},
+		{
+			Name:     "crds",
+			Kind:     "CustomResourceDefinition",
+			Path:     "chart/templates/crds.yaml",
+			Manifest: "kind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\n",
+			Events:   []v2rls.Hook_Event{v2rls.Hook_CRD_INSTALL},
+		},
+	}
+}
+
+func TestCreateReleaseHooks(t *testing.T) {
+	lastRun := stdtime.Date(2020, 2, 3, 4, 5, 6, 0, stdtime.UTC)
+	testStarted := stdtime.Date(2020, 2, 4, 0, 0, 0, 0, stdtime.UTC)
+	testCompleted := testStarted.Add(30 * stdtime.Second)
+	v2Rel := v2Release("rel", 1, &v2rls.Info{
+		Status: &v2rls.Status{
+			Code: v2rls.Status_DEPLOYED,
+			LastTestSuiteRun: &v2rls.TestSuite{
+				Results: []*v2rls.TestRun{
+					{Name: "test-connection", Status: v2rls.TestRun_SUCCESS, StartedAt: &timestamp.Timestamp{Seconds: testStarted.Unix()}, CompletedAt: &timestamp.Timestamp{Seconds: testCompleted.Unix()}},
+					{Name: "test-failure", Status: v2rls.TestRun_FAILURE, StartedAt: &timestamp.Timestamp{Seconds: testStarted.Unix()}, CompletedAt: &timestamp.Timestamp{Seconds: testCompleted.Unix()}},
+				},
+			},
+		},
+	})
+	v2Rel.Hooks = fixtureHooks(lastRun)
+
+	rel := convertAndStore(t, v2Rel)
+	if len(rel.Hooks) != 4 {
+		t.Fatalf("expected the 4 hooks to be converted, got %d", len(rel.Hooks))
+	}
+	tests := []struct {
+		events    []release.HookEvent
+		policies  []release.HookDeletePolicy
+		weight    int
+		phase     release.HookPhase
+		started   stdtime.Time
+		completed stdtime.Time
+	}{
+		{
+			events:    []release.HookEvent{release.HookPreInstall, release.HookPreUpgrade, release.HookPostRollback},
+			policies:  []release.HookDeletePolicy{release.HookSucceeded, release.HookFailed, release.HookBeforeHookCreation},
+			weight:    -5,
+			phase:     release.HookPhaseUnknown,
+			started:   lastRun,
+			completed: lastRun,
+		},
+		{events: []release.HookEvent{release.HookTest}, phase: release.HookPhaseSucceeded, started: testStarted, completed: testCompleted},
+		{events: []release.HookEvent{release.HookTest}, phase: release.HookPhaseFailed, started: testStarted, completed: testCompleted},
+		{events: []release.HookEvent{HookCRDInstall}},
+	}
+	for i, expected := range tests {
+		hook, v2Hook := rel.Hooks[i], v2Rel.Hooks[i]
+		if hook.Name != v2Hook.Name || hook.Kind != v2Hook.Kind || hook.Path != v2Hook.Path || hook.Manifest != v2Hook.Manifest {
+			t.Errorf("expected hook %s to keep its name, kind, path and manifest, got %+v", v2Hook.Name, hook)
+		}
+		if !reflect.DeepEqual(hook.Events, expected.events) {
+			t.Errorf("expected the events %v of hook %s, got %v", expected.events, v2Hook.Name, hook.Events)
+		}
+		if len(hook.DeletePolicies) > 0 || len(expected.policies) > 0 {
+			if !reflect.DeepEqual(hook.DeletePolicies, expected.policies) {
+				t.Errorf("expected the delete policies %v of hook %s, got %v", expected.policies, v2Hook.Name, hook.DeletePolicies)
+			}
+		}
+		if hook.Weight != expected.weight {
+			t.Errorf("expected the weight %d of hook %s, got %d", expected.weight, v2Hook.Name, hook.Weight)
+		}
+		if hook.LastRun.Phase != expected.phase {
+			t.Errorf("expected the last run phase %q of hook %s, got %q", expected.phase, v2Hook.Name, hook.LastRun.Phase)
+		}
+		if !hook.LastRun.StartedAt.Time.Equal(expected.started) || !hook.LastRun.CompletedAt.Time.Equal(expected.completed) {
+			t.Errorf("expected hook %s to be last run from %s to %s, got %s to %s", v2Hook.Name, expected.started, expected.completed, hook.LastRun.StartedAt, hook.LastRun.CompletedAt)
+		}
+	}
+}
+
+func TestConvertCRDHooks(t *testing.T) {
+	const manifest = "---\n# Source: chart/templates/deployment.yaml\nkind: Deployment\n"
+	newRelease := func() *release.Release {
+		return &release.Release{
+			Name:     "rel",
+			Manifest: manifest,
+			Hooks: []*release.Hook{
+				{Name: "crds", Path: "chart/templates/crds.yaml", Manifest: "kind: CustomResourceDefinition\n", Events: []release.HookEvent{HookCRDInstall}},
+				{Name: "setup", Path: "chart/templates/setup.yaml", Manifest: "kind: CustomResourceDefinition\nmetadata:\n  name: setups.example.com\n", Events: []release.HookEvent{HookCRDInstall, release.HookPreInstall}},
+				{Name: "migrate", Path: "chart/templates/migrate.yaml", Manifest: "kind: Job\n", Events: []release.HookEvent{release.HookPreInstall, release.HookPreUpgrade}},
+			},
+		}
+	}
+	for _, test := range []struct {
+		name       string
+		toManifest bool
+		manifest   string
+	}{
+		{name: "dropped", manifest: manifest},
+		{
+			name:       "moved to the manifest",
+			toManifest: true,
+			manifest: "---\n# Source: chart/templates/deployment.yaml\nkind: Deployment\n" +
+				"---\n# Source: chart/templates/crds.yaml\nkind: CustomResourceDefinition\n" +
+				"---\n# Source: chart/templates/setup.yaml\nkind: CustomResourceDefinition\nmetadata:\n  name: setups.example.com\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rel := newRelease()
+			names := ConvertCRDHooks(rel, test.toManifest)
+			if !reflect.DeepEqual(names, []string{"crds", "setup"}) {
+				t.Errorf("expected the crd-install hooks crds and setup, got %v", names)
+			}
+			if rel.Manifest != test.manifest {
+				t.Errorf("expected the manifest %q, got %q", test.manifest, rel.Manifest)
+			}
+			// The hooks left keep their other events
+			events := map[string][]release.HookEvent{}
+			for _, hook := range rel.Hooks {
+				events[hook.Name] = hook.Events
+			}
+			expected := map[string][]release.HookEvent{
+				"setup":   {release.HookPreInstall},
+				"migrate": {release.HookPreInstall, release.HookPreUpgrade},
+			}
+			if !reflect.DeepEqual(events, expected) {
+				t.Errorf("expected the hooks %v, got %v", expected, events)
+			}
+		})
+	}
+}
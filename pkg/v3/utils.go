@@ -18,36 +18,50 @@ package v3
 
 import (
 	"os"
+	"runtime"
 
-	"helm.sh/helm/v3/pkg/helmpath"
+	"github.com/mitchellh/go-homedir"
+
+	common "github.com/helm/helm-2to3/pkg/common"
 )
 
-// ConfigDir returns the v2 config directory
-func ConfigDir() string {
-	if homeDir, exists := os.LookupEnv("HELM_V3_CONFIG"); exists {
-		return homeDir
+// dirOverrides are the Helm v3 directories set by SetDirs, in place of the ones set by the environment
+var dirOverrides = map[string]string{}
+
+// SetDirs sets the Helm v3 config, data and cache directories, in place of the ones set by the
+// environment. The directory set by the environment is used for each directory which is empty.
+func SetDirs(configDir, dataDir, cacheDir string) {
+	dirOverrides = map[string]string{
+		common.HelmConfigDir: configDir,
+		common.HelmDataDir:   dataDir,
+		common.HelmCacheDir:  cacheDir,
 	}
+}
 
-	defaultDir := helmpath.ConfigPath()
-	return defaultDir
+// ConfigDir returns the v2 config directory
+func ConfigDir() string {
+	return helmDir(common.HelmConfigDir, "HELM_V3_CONFIG")
 }
 
 // DataDir returns the v3 data directory
 func DataDir() string {
-	if homeDir, exists := os.LookupEnv("HELM_V3_DATA"); exists {
-		return homeDir
-	}
-
-	defaultDir := helmpath.DataPath()
-	return defaultDir
+	return helmDir(common.HelmDataDir, "HELM_V3_DATA")
 }
 
 // CacheDir returns the v3 data directory
 func CacheDir() string {
-	if homeDir, exists := os.LookupEnv("HELM_V3_CACHE"); exists {
-		return homeDir
-	}
+	return helmDir(common.HelmCacheDir, "HELM_V3_CACHE")
+}
 
-	defaultDir := helmpath.CachePath()
-	return defaultDir
+// helmDir returns the Helm v3 directory of the kind: the one set by SetDirs, otherwise the one set by
+// the plugin environment variable, otherwise the one Helm v3 uses
+func helmDir(kind, pluginEnv string) string {
+	if dir := dirOverrides[kind]; dir != "" {
+		return dir
+	}
+	if dir, exists := os.LookupEnv(pluginEnv); exists {
+		return dir
+	}
+	home, _ := homedir.Dir()
+	return common.HelmDir(kind, runtime.GOOS, os.Getenv, home)
 }
@@ -0,0 +1,51 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHelmDirPrecedence(t *testing.T) {
+	for _, env := range []string{"HELM_V3_CONFIG", "HELM_CONFIG_HOME"} {
+		value, set := os.LookupEnv(env)
+		if set {
+			defer os.Setenv(env, value)
+		} else {
+			defer os.Unsetenv(env)
+		}
+	}
+	defer SetDirs("", "", "")
+
+	os.Unsetenv("HELM_V3_CONFIG")
+	os.Setenv("HELM_CONFIG_HOME", "/helm/config")
+	SetDirs("", "", "")
+	if dir := ConfigDir(); dir != "/helm/config" {
+		t.Errorf("expected the Helm v3 environment variable to be used, got %q", dir)
+	}
+
+	os.Setenv("HELM_V3_CONFIG", "/plugin/config")
+	if dir := ConfigDir(); dir != "/plugin/config" {
+		t.Errorf("expected the plugin environment variable to take precedence over the Helm v3 one, got %q", dir)
+	}
+
+	SetDirs("/flag/config", "", "")
+	if dir := ConfigDir(); dir != "/flag/config" {
+		t.Errorf("expected the flag to take precedence over the environment variables, got %q", dir)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// The build info of the plugin, set at build time with -ldflags, e.g.
+// "-X github.com/helm/helm-2to3/pkg/version.Version=<version>"
+var (
+	// Version is the semantic version of the plugin
+	Version = "dev"
+	// GitCommit is the git commit the plugin is built from
+	GitCommit = ""
+	// BuildDate is the date the plugin is built at, in RFC 3339 format
+	BuildDate = ""
+)
+
+// The modules of the Helm libraries the plugin is built with
+const (
+	helmV2Module = "k8s.io/helm"
+	helmV3Module = "helm.sh/helm/v3"
+)
+
+// BuildInfo describes the build of the plugin and the versions of the Helm libraries compiled in
+type BuildInfo struct {
+	Version       string `json:"version"`
+	GitCommit     string `json:"gitCommit"`
+	BuildDate     string `json:"buildDate"`
+	GoVersion     string `json:"goVersion"`
+	Platform      string `json:"platform"`
+	HelmV2Version string `json:"helmV2Version"`
+	HelmV3Version string `json:"helmV3Version"`
+}
+
+// Get returns the build info of the plugin. The versions of the Helm libraries are read from the
+// module build info embedded in the binary, and are unknown when it is not, e.g. in tests.
+func Get() BuildInfo {
+	info := BuildInfo{
+		Version:       Version,
+		GitCommit:     GitCommit,
+		BuildDate:     BuildDate,
+		GoVersion:     runtime.Version(),
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+		HelmV2Version: "unknown",
+		HelmV3Version: "unknown",
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range buildInfo.Deps {
+			depVersion := dep.Version
+			if dep.Replace != nil {
+				depVersion = dep.Replace.Version
+			}
+			switch dep.Path {
+			case helmV2Module:
+				info.HelmV2Version = depVersion
+			case helmV3Module:
+				info.HelmV3Version = depVersion
+			}
+		}
+	}
+	return info
+}
+
+// String returns the build info on one line, e.g. for the 'version' flag
+func (info BuildInfo) String() string {
+	return fmt.Sprintf("%s (commit: %s, built: %s, %s %s, Helm v2 %s, Helm v3 %s)", info.Version, orUnknown(info.GitCommit), orUnknown(info.BuildDate), info.GoVersion, info.Platform, info.HelmV2Version, info.HelmV3Version)
+}
+
+// orUnknown returns the value, or "unknown" when it is not set
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}